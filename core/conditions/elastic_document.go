@@ -0,0 +1,55 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package conditions
+
+import (
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/util"
+)
+
+func init() {
+	elastic.RegisterDocumentConditionBuilder(newDocumentCondition)
+}
+
+// newDocumentCondition builds the drop_when check of the bulk document transforms
+func newDocumentCondition(cfg *config.Config) (elastic.DocumentCondition, error) {
+	condConfig := Config{}
+	if err := cfg.Unpack(&condConfig); err != nil {
+		return nil, err
+	}
+
+	cond, err := NewCondition(&condConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(event util.MapStr) bool {
+		return cond.Check(event)
+	}, nil
+}
@@ -48,6 +48,7 @@ import (
 
 type API interface {
 	ScrollAPI
+	PointInTimeAPI
 	MappingAPI
 	TemplateAPI
 	ReplicationAPI
@@ -158,6 +159,17 @@ type ScrollAPI interface {
 	ClearScroll(scrollId string) error
 }
 
+// PointInTimeAPI iterates documents with point in time and search_after,
+// clusters without point in time return ErrPointInTimeNotSupported
+type PointInTimeAPI interface {
+	SupportPointInTime() bool
+	OpenPointInTime(indexNames string, keepAlive string) (string, error)
+	ClosePointInTime(pitID string) error
+	// SearchAfter fetches the next page after the sort values of the last hit,
+	// the query is sorted by the shard doc tiebreaker if no sort was specified
+	SearchAfter(ctx *APIContext, pitID string, keepAlive string, query *SearchRequest, searchAfter []interface{}) ([]byte, error)
+}
+
 type ScriptAPI interface {
 	ScriptExists(scriptName string) (bool, error)
 	PutScript(scriptName string, script []byte) ([]byte, error)
@@ -0,0 +1,286 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastic
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/stats"
+)
+
+// AdaptiveBulkConfig tunes the batch size and the concurrency of each node by feedback,
+// the limits shrink multiplicatively on rejections or slow responses and grow additively otherwise
+type AdaptiveBulkConfig struct {
+	Enabled bool `config:"enabled"`
+
+	//default to 1/16 and 4x of the configured batch size
+	MinBatchSizeInKb   int `config:"min_batch_size_in_kb"`
+	MaxBatchSizeInKb   int `config:"max_batch_size_in_kb"`
+	MinBatchSizeInDocs int `config:"min_batch_size_in_docs"`
+	MaxBatchSizeInDocs int `config:"max_batch_size_in_docs"`
+
+	//concurrent bulk requests to each node
+	MinConcurrency int `config:"min_concurrency"`
+	MaxConcurrency int `config:"max_concurrency"`
+
+	//ratio of rejected documents in a request to be treated as overloaded, 0 for any rejection
+	RejectionThreshold   float64 `config:"rejection_threshold"`
+	LatencyThresholdInMs int     `config:"latency_threshold_in_ms"`
+
+	DecreaseRatio float64 `config:"decrease_ratio"`
+	//min interval between two adjustments, so that the responses of the same round only count once
+	AdjustIntervalInMs int `config:"adjust_interval_in_ms"`
+}
+
+type AdaptiveBulkStats struct {
+	BatchSizeInBytes int   `json:"batch_size_in_bytes"`
+	BatchSizeInDocs  int   `json:"batch_size_in_docs"`
+	Concurrency      int   `json:"concurrency"`
+	InFlight         int   `json:"in_flight"`
+	Rejections       int64 `json:"rejections"`
+	SlowResponses    int64 `json:"slow_responses"`
+	Decreases        int64 `json:"decreases"`
+	Increases        int64 `json:"increases"`
+}
+
+// AdaptiveBulkController keeps the effective bulk limits of one node, shared by all the bulk workers
+type AdaptiveBulkController struct {
+	lock sync.Mutex
+
+	minBytes, maxBytes, stepBytes int
+	minDocs, maxDocs, stepDocs    int
+	minConcurrency                int
+	maxConcurrency                int
+	rejectionThreshold            float64
+	latencyThreshold              time.Duration
+	decreaseRatio                 float64
+	adjustInterval                time.Duration
+
+	stats        AdaptiveBulkStats
+	lastAdjust   time.Time
+	lastDecrease time.Time
+	released     chan struct{}
+}
+
+var adaptiveControllers = sync.Map{}
+
+func init() {
+	stats.RegisterStats("bulk_adaptive", GetAdaptiveBulkStats)
+}
+
+// GetAdaptiveBulkStats returns the effective settings of each node, keyed by cluster id and host
+func GetAdaptiveBulkStats() interface{} {
+	result := map[string]AdaptiveBulkStats{}
+	adaptiveControllers.Range(func(key, value interface{}) bool {
+		result[key.(string)] = value.(*AdaptiveBulkController).Stats()
+		return true
+	})
+	return result
+}
+
+// GetAdaptiveBulkController returns the controller of the node, the config of the first caller is used
+func GetAdaptiveBulkController(clusterID, host string, cfg *BulkProcessorConfig) *AdaptiveBulkController {
+	key := clusterID + ":" + host
+	v, ok := adaptiveControllers.Load(key)
+	if ok {
+		return v.(*AdaptiveBulkController)
+	}
+	v, _ = adaptiveControllers.LoadOrStore(key, NewAdaptiveBulkController(cfg))
+	return v.(*AdaptiveBulkController)
+}
+
+func NewAdaptiveBulkController(cfg *BulkProcessorConfig) *AdaptiveBulkController {
+	adaptive := cfg.Adaptive
+	initBytes := cfg.GetBulkSizeInBytes()
+	initDocs := cfg.BulkMaxDocsCount
+	if initDocs <= 0 {
+		initDocs = 1000
+	}
+
+	c := &AdaptiveBulkController{
+		minBytes:           adaptive.MinBatchSizeInKb * 1024,
+		maxBytes:           adaptive.MaxBatchSizeInKb * 1024,
+		minDocs:            adaptive.MinBatchSizeInDocs,
+		maxDocs:            adaptive.MaxBatchSizeInDocs,
+		minConcurrency:     adaptive.MinConcurrency,
+		maxConcurrency:     adaptive.MaxConcurrency,
+		rejectionThreshold: adaptive.RejectionThreshold,
+		latencyThreshold:   time.Duration(adaptive.LatencyThresholdInMs) * time.Millisecond,
+		decreaseRatio:      adaptive.DecreaseRatio,
+		adjustInterval:     time.Duration(adaptive.AdjustIntervalInMs) * time.Millisecond,
+		released:           make(chan struct{}),
+	}
+
+	if c.minBytes <= 0 {
+		c.minBytes = initBytes / 16
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = initBytes * 4
+	}
+	if c.minDocs <= 0 {
+		c.minDocs = initDocs / 16
+	}
+	if c.minDocs <= 0 {
+		c.minDocs = 1
+	}
+	if c.maxDocs <= 0 {
+		c.maxDocs = initDocs * 4
+	}
+	if c.minConcurrency <= 0 {
+		c.minConcurrency = 1
+	}
+	if c.maxConcurrency <= 0 {
+		c.maxConcurrency = 10
+	}
+	if c.maxConcurrency < c.minConcurrency {
+		c.maxConcurrency = c.minConcurrency
+	}
+	if c.decreaseRatio <= 0 || c.decreaseRatio >= 1 {
+		c.decreaseRatio = 0.5
+	}
+	if c.adjustInterval <= 0 {
+		c.adjustInterval = time.Second
+	}
+
+	//reach the max from the min in about 20 rounds
+	c.stepBytes = (c.maxBytes - c.minBytes) / 20
+	if c.stepBytes <= 0 {
+		c.stepBytes = 1
+	}
+	c.stepDocs = (c.maxDocs - c.minDocs) / 20
+	if c.stepDocs <= 0 {
+		c.stepDocs = 1
+	}
+
+	c.stats.BatchSizeInBytes = clamp(initBytes, c.minBytes, c.maxBytes)
+	c.stats.BatchSizeInDocs = clamp(initDocs, c.minDocs, c.maxDocs)
+	c.stats.Concurrency = c.maxConcurrency
+	return c
+}
+
+func (c *AdaptiveBulkController) BatchLimits() (sizeInBytes, docs int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stats.BatchSizeInBytes, c.stats.BatchSizeInDocs
+}
+
+func (c *AdaptiveBulkController) Stats() AdaptiveBulkStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stats
+}
+
+// Acquire waits for a free slot of the node's concurrency
+func (c *AdaptiveBulkController) Acquire(ctx context.Context) error {
+	for {
+		c.lock.Lock()
+		if c.stats.InFlight < c.stats.Concurrency {
+			c.stats.InFlight++
+			c.lock.Unlock()
+			return nil
+		}
+		released := c.released
+		c.lock.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *AdaptiveBulkController) Release() {
+	c.lock.Lock()
+	c.stats.InFlight--
+	c.wakeup()
+	c.lock.Unlock()
+}
+
+func (c *AdaptiveBulkController) wakeup() {
+	close(c.released)
+	c.released = make(chan struct{})
+}
+
+// Feedback records the result of a bulk request, rejected is the count of documents rejected by the node
+func (c *AdaptiveBulkController) Feedback(docs, rejected int, latency time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	overloaded := false
+	if rejected > 0 {
+		c.stats.Rejections += int64(rejected)
+		overloaded = docs <= 0 || float64(rejected)/float64(docs) >= c.rejectionThreshold
+	}
+	if c.latencyThreshold > 0 && latency > c.latencyThreshold {
+		c.stats.SlowResponses++
+		overloaded = true
+	}
+
+	if overloaded {
+		if time.Since(c.lastDecrease) < c.adjustInterval {
+			return
+		}
+		c.lastDecrease = time.Now()
+		c.lastAdjust = c.lastDecrease
+		c.stats.Decreases++
+		c.stats.BatchSizeInBytes = clamp(int(float64(c.stats.BatchSizeInBytes)*c.decreaseRatio), c.minBytes, c.maxBytes)
+		c.stats.BatchSizeInDocs = clamp(int(float64(c.stats.BatchSizeInDocs)*c.decreaseRatio), c.minDocs, c.maxDocs)
+		c.stats.Concurrency = clamp(int(float64(c.stats.Concurrency)*c.decreaseRatio), c.minConcurrency, c.maxConcurrency)
+		log.Debugf("node overloaded, rejected: %v/%v, latency: %v, decrease bulk limits to %+v", rejected, docs, latency, c.stats)
+		return
+	}
+
+	if time.Since(c.lastAdjust) < c.adjustInterval {
+		return
+	}
+	if c.stats.BatchSizeInBytes >= c.maxBytes && c.stats.BatchSizeInDocs >= c.maxDocs && c.stats.Concurrency >= c.maxConcurrency {
+		return
+	}
+	c.lastAdjust = time.Now()
+	c.stats.Increases++
+	c.stats.BatchSizeInBytes = clamp(c.stats.BatchSizeInBytes+c.stepBytes, c.minBytes, c.maxBytes)
+	c.stats.BatchSizeInDocs = clamp(c.stats.BatchSizeInDocs+c.stepDocs, c.minDocs, c.maxDocs)
+	if c.stats.Concurrency < c.maxConcurrency {
+		c.stats.Concurrency++
+		c.wakeup()
+	}
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,130 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAdaptiveController() *AdaptiveBulkController {
+	return NewAdaptiveBulkController(&BulkProcessorConfig{
+		BulkSizeInKb:     1024,
+		BulkMaxDocsCount: 1000,
+		Adaptive: AdaptiveBulkConfig{
+			Enabled:              true,
+			MaxConcurrency:       4,
+			LatencyThresholdInMs: 1000,
+		},
+	})
+}
+
+// nextRound lets the next feedback adjust the limits without waiting for the interval
+func nextRound(c *AdaptiveBulkController) {
+	c.lastAdjust = time.Time{}
+	c.lastDecrease = time.Time{}
+}
+
+func TestAdaptiveBulkController(t *testing.T) {
+	c := newTestAdaptiveController()
+	size, docs := c.BatchLimits()
+	assert.Equal(t, 1024*1024, size)
+	assert.Equal(t, 1000, docs)
+	assert.Equal(t, 4, c.Stats().Concurrency)
+
+	//multiplicative decrease on rejections
+	c.Feedback(100, 10, time.Millisecond)
+	size, docs = c.BatchLimits()
+	assert.Equal(t, 512*1024, size)
+	assert.Equal(t, 500, docs)
+	assert.Equal(t, 2, c.Stats().Concurrency)
+	assert.Equal(t, int64(10), c.Stats().Rejections)
+
+	//only one decrease in the same round
+	c.Feedback(100, 100, time.Millisecond)
+	assert.Equal(t, 500, c.Stats().BatchSizeInDocs)
+
+	//slow responses count as overloaded
+	nextRound(c)
+	c.Feedback(100, 0, 2*time.Second)
+	assert.Equal(t, 250, c.Stats().BatchSizeInDocs)
+	assert.Equal(t, 1, c.Stats().Concurrency)
+	assert.Equal(t, int64(1), c.Stats().SlowResponses)
+
+	//never below the min
+	for i := 0; i < 10; i++ {
+		nextRound(c)
+		c.Feedback(100, 100, time.Millisecond)
+	}
+	assert.Equal(t, 1000/16, c.Stats().BatchSizeInDocs)
+	assert.Equal(t, 1024*1024/16, c.Stats().BatchSizeInBytes)
+
+	//additive increase, up to the max
+	nextRound(c)
+	c.Feedback(100, 0, time.Millisecond)
+	assert.Equal(t, 1000/16+(4000-1000/16)/20, c.Stats().BatchSizeInDocs)
+	assert.Equal(t, 2, c.Stats().Concurrency)
+	for i := 0; i < 30; i++ {
+		nextRound(c)
+		c.Feedback(100, 0, time.Millisecond)
+	}
+	assert.Equal(t, 4000, c.Stats().BatchSizeInDocs)
+	assert.Equal(t, 4096*1024, c.Stats().BatchSizeInBytes)
+	assert.Equal(t, 4, c.Stats().Concurrency)
+}
+
+func TestAdaptiveBulkControllerConcurrency(t *testing.T) {
+	c := newTestAdaptiveController()
+	c.Feedback(100, 100, time.Millisecond)
+	assert.Equal(t, 2, c.Stats().Concurrency)
+
+	ctx := context.Background()
+	assert.NoError(t, c.Acquire(ctx))
+	assert.NoError(t, c.Acquire(ctx))
+	assert.Equal(t, 2, c.Stats().InFlight)
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, c.Acquire(timeout))
+
+	acquired := make(chan struct{})
+	go func() {
+		c.Acquire(ctx)
+		close(acquired)
+	}()
+	c.Release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("waiting request was not woken up")
+	}
+	assert.Equal(t, 2, c.Stats().InFlight)
+}
@@ -34,6 +34,8 @@ type BulkBuffer struct {
 	bytesBuffer *bytebufferpool.ByteBuffer
 	MessageIDs  []string
 	Reason      []string
+	//end of the data of each message, only tracked for the messages added with their data
+	messageEnds []int
 }
 
 type BulkBufferPool struct {
@@ -131,9 +133,34 @@ func SafetyAddNewlineBetweenData(buffer *bytebufferpool.ByteBuffer, data []byte)
 
 func (receiver *BulkBuffer) Add(id string, data []byte) {
 	if data != nil && len(data) > 0 && len(id) != 0 {
-		receiver.MessageIDs = append(receiver.MessageIDs, id)
 		SafetyAddNewlineBetweenData(receiver.bytesBuffer, data)
+		receiver.endMessage(id)
+	}
+}
+
+// Append add the messages of the other buffer, their ids are kept
+func (receiver *BulkBuffer) Append(other *BulkBuffer) {
+	data := other.GetMessageBytes()
+	if len(data) == 0 {
+		return
 	}
+	tracked := len(receiver.messageEnds) == len(receiver.MessageIDs) && len(other.messageEnds) == len(other.MessageIDs)
+	SafetyAddNewlineBetweenData(receiver.bytesBuffer, data)
+	receiver.MessageIDs = append(receiver.MessageIDs, other.MessageIDs...)
+	if !tracked {
+		receiver.messageEnds = receiver.messageEnds[:0]
+		return
+	}
+	start := receiver.bytesBuffer.Len() - len(data)
+	for _, end := range other.messageEnds {
+		receiver.messageEnds = append(receiver.messageEnds, start+end)
+	}
+}
+
+// endMessage add the message whose data was just written
+func (receiver *BulkBuffer) endMessage(id string) {
+	receiver.MessageIDs = append(receiver.MessageIDs, id)
+	receiver.messageEnds = append(receiver.messageEnds, receiver.bytesBuffer.Len())
 }
 
 func (receiver *BulkBuffer) GetMessageCount() int {
@@ -174,4 +201,5 @@ func (receiver *BulkBuffer) ResetData() {
 	}
 	receiver.MessageIDs = receiver.MessageIDs[:0]
 	receiver.Reason = receiver.Reason[:0]
+	receiver.messageEnds = receiver.messageEnds[:0]
 }
@@ -322,6 +322,12 @@ type BulkProcessorConfig struct {
 	BulkResponseParseConfig BulkResponseParseConfig `config:"response_handle"`
 
 	RemoveDuplicatedNewlines bool `config:"remove_duplicated_newlines"`
+
+	//applied to each document in order, before the request is sent
+	Transforms []DocumentTransformConfig `config:"transforms"`
+
+	//adjust the batch size and concurrency of each node by the rejections and latency
+	Adaptive AdaptiveBulkConfig `config:"adaptive"`
 }
 
 type BulkResponseParseConfig struct {
@@ -457,6 +463,8 @@ type BulkProcessor struct {
 	Config         BulkProcessorConfig
 	BulkBufferPool *BulkBufferPool
 	HttpPool       *fasthttp.RequestResponsePool
+
+	transformer *documentTransformer
 }
 
 func NewBulkProcessor(tag, esClusterID string, cfg BulkProcessorConfig) BulkProcessor {
@@ -469,9 +477,108 @@ func NewBulkProcessor(tag, esClusterID string, cfg BulkProcessorConfig) BulkProc
 		bulkProcessor.Config.DeadletterRequestsQueue = fmt.Sprintf("%v-bulk-dead_letter-items", esClusterID)
 	}
 
+	transformer, err := newDocumentTransformer(cfg.Transforms)
+	if err != nil {
+		panic(err)
+	}
+	bulkProcessor.transformer = transformer
+
 	return bulkProcessor
 }
 
+// GetBatchLimits returns the batch size in bytes and docs for the host, follows the adaptive controller if enabled
+func (joint *BulkProcessor) GetBatchLimits(metadata *ElasticsearchMetadata, host string) (sizeInBytes, docs int) {
+	if joint.Config.Adaptive.Enabled && metadata != nil {
+		//the same host as Bulk, which feeds the controller
+		host = metadata.GetActivePreferredHost(host)
+		return GetAdaptiveBulkController(metadata.Config.ID, host, &joint.Config).BatchLimits()
+	}
+	return joint.Config.GetBulkSizeInBytes(), joint.Config.BulkMaxDocsCount
+}
+
+// BulkRejectedError is returned when the bulk request was rejected with code 429,
+// Data holds the documents of the rejected request, documents accepted in previous retries are not included,
+// the documents are the ones before the transforms
+type BulkRejectedError struct {
+	IDs  []string
+	Data []byte
+	msg  string
+}
+
+func (e *BulkRejectedError) Error() string {
+	return e.msg
+}
+
+// Merge add the documents rejected by another request
+func (e *BulkRejectedError) Merge(other *BulkRejectedError) {
+	e.IDs = append(e.IDs, other.IDs...)
+	e.Data = appendBulkData(e.Data, other.Data)
+}
+
+// GetBulkRejectedError returns the rejected documents if the bulk request was rejected with code 429
+func GetBulkRejectedError(err error) (*BulkRejectedError, bool) {
+	if err == nil {
+		return nil, false
+	}
+	e, ok := err.(*BulkRejectedError)
+	return e, ok
+}
+
+// IsBulkRejectedError returns true if the bulk request was rejected with code 429, the same documents should be retried later
+func IsBulkRejectedError(err error) bool {
+	_, ok := GetBulkRejectedError(err)
+	return ok
+}
+
+// BulkDocumentsError is returned when some documents failed with non-retryable errors,
+// Data holds those documents in bulk format before the transforms, the other documents were accepted
+type BulkDocumentsError struct {
+	IDs     []string
+	Reasons []string
+	Data    []byte
+	msg     string
+}
+
+func (e *BulkDocumentsError) Error() string {
+	return e.msg
+}
+
+// Merge add the documents failed in another request
+func (e *BulkDocumentsError) Merge(other *BulkDocumentsError) {
+	e.IDs = append(e.IDs, other.IDs...)
+	e.Reasons = append(e.Reasons, other.Reasons...)
+	e.Data = appendBulkData(e.Data, other.Data)
+}
+
+func appendBulkData(data, other []byte) []byte {
+	if len(data) > 0 && len(other) > 0 && !util.BytesHasSuffix(data, NEWLINEBYTES) {
+		data = append(data, NEWLINEBYTES...)
+	}
+	return append(data, other...)
+}
+
+// GetBulkDocumentsError returns the failed documents if the error was caused by them
+func GetBulkDocumentsError(err error) (*BulkDocumentsError, bool) {
+	if err == nil {
+		return nil, false
+	}
+	e, ok := err.(*BulkDocumentsError)
+	return e, ok
+}
+
+func newBulkDocumentsError(items *BulkBuffer, err error) error {
+	if items.GetMessageCount() == 0 {
+		return err
+	}
+	items.SafetyEndWithNewline()
+	return &BulkDocumentsError{
+		IDs:     append([]string{}, items.MessageIDs...),
+		Reasons: append([]string{}, items.Reason...),
+		Data:    append([]byte{}, items.GetMessageBytes()...),
+		msg:     err.Error(),
+	}
+}
+
 // bulkResult is valid only if max_reject_retry_times == 0
 func (joint *BulkProcessor) Bulk(ctx context.Context, tag string, metadata *ElasticsearchMetadata, host string, buffer *BulkBuffer) (continueNext bool, statsRet map[int]int, bulkResult *BulkResult, err error) {
 
@@ -527,6 +634,27 @@ func (joint *BulkProcessor) Bulk(ctx context.Context, tag string, metadata *Elas
 		data = bytes.ReplaceAll(data, []byte("\n\n"), []byte("\n"))
 	}
 
+	//documents before the transforms, they are the ones to retry, reschedule or dead letter
+	original := data
+	if joint.transformer != nil {
+		var dropped int
+		data, original, dropped, err = joint.transformer.Transform(data)
+		if err != nil {
+			if joint.Config.InvalidRequestsQueue != "" {
+				queue.Push(queue.GetOrInitConfig(joint.Config.InvalidRequestsQueue), buffer.GetMessageBytes())
+				return true, statsRet, nil, errors.Errorf("failed to transform bulk requests: %v", err)
+			}
+			return false, statsRet, nil, errors.Errorf("failed to transform bulk requests: %v", err)
+		}
+		if dropped > 0 {
+			stats.IncrementBy("elasticsearch.bulk", "transform_dropped", int64(dropped))
+		}
+		//all documents were dropped
+		if len(data) == 0 {
+			return true, statsRet, nil, nil
+		}
+	}
+
 	if !req.IsGzipped() && joint.Config.Compress {
 
 		_, err := fasthttp.WriteGzipLevel(req.BodyWriter(), data, fasthttp.CompressBestSpeed)
@@ -555,14 +683,34 @@ func (joint *BulkProcessor) Bulk(ctx context.Context, tag string, metadata *Elas
 		clonedURI.SetScheme(metadata.GetSchema())
 	}
 
+	var controller *AdaptiveBulkController
+	if joint.Config.Adaptive.Enabled {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		controller = GetAdaptiveBulkController(metadata.Config.ID, host, &joint.Config)
+		err = controller.Acquire(ctx)
+		if err != nil {
+			return false, statsRet, nil, err
+		}
+		defer controller.Release()
+	}
+
 	retryTimes := 0
+	requestDocs := buffer.GetMessageCount()
+	//documents of the current request, only retryable items are sent again
+	requestData := original
+	requestIDs := buffer.MessageIDs
 	nonRetryableItems := joint.BulkBufferPool.AcquireBulkBuffer()
 	retryableItems := joint.BulkBufferPool.AcquireBulkBuffer()
 	successItems := joint.BulkBufferPool.AcquireBulkBuffer()
+	//non-retryable items of all the rounds
+	invalidItems := joint.BulkBufferPool.AcquireBulkBuffer()
 
 	defer joint.BulkBufferPool.ReturnBulkBuffer(nonRetryableItems)
 	defer joint.BulkBufferPool.ReturnBulkBuffer(retryableItems)
 	defer joint.BulkBufferPool.ReturnBulkBuffer(successItems)
+	defer joint.BulkBufferPool.ReturnBulkBuffer(invalidItems)
 
 DO:
 
@@ -576,7 +724,9 @@ DO:
 
 	req.SetURI(clonedURI)
 	//execute
+	start := time.Now()
 	err = httpClient.DoTimeout(req, resp, time.Duration(joint.Config.RequestTimeoutInSecond)*time.Second)
+	latency := time.Since(start)
 	//restore schema
 	clonedURI.SetScheme(orignalSchema)
 	req.SetURI(clonedURI)
@@ -631,7 +781,17 @@ DO:
 		//如果是部分失败，应该将可以重试的做完，然后记录失败的消息再返回不继续
 		if util.ContainStr(string(req.Header.RequestURI()), "_bulk") {
 
-			containError, statsCodeStats, bulkResult := HandleBulkResponse(req, resp, labels, data, resbody, successItems, nonRetryableItems, retryableItems, joint.Config.BulkResponseParseConfig, joint.Config.RetryRules)
+			containError, statsCodeStats, bulkResult := HandleBulkResponse(req, resp, labels, requestData, resbody, successItems, nonRetryableItems, retryableItems, joint.Config.BulkResponseParseConfig, joint.Config.RetryRules)
+
+			if controller != nil {
+				controller.Feedback(requestDocs, statsCodeStats[429], latency)
+			}
+
+			if nonRetryableItems.GetMessageCount() > 0 && nonRetryableItems.GetMessageSize() > 0 {
+				invalidItems.WriteByteBuffer(nonRetryableItems.GetMessageBytes())
+				invalidItems.MessageIDs = append(invalidItems.MessageIDs, nonRetryableItems.MessageIDs...)
+				invalidItems.Reason = append(invalidItems.Reason, nonRetryableItems.Reason...)
+			}
 
 			for k, v := range statsCodeStats {
 				if global.Env().IsDebug {
@@ -651,7 +811,16 @@ DO:
 					log.Debugf("%v, retry item: %v", tag, count)
 					retryableItems.SafetyEndWithNewline()
 					bodyBytes := retryableItems.GetMessageBytes()
-					req.SetRawBody(bodyBytes)
+					if joint.transformer != nil {
+						//transform the original documents again
+						body, _, _, err := joint.transformer.Transform(bodyBytes)
+						if err != nil {
+							return false, statsRet, bulkResult, errors.Errorf("failed to transform bulk requests: %v", err)
+						}
+						req.SetRawBody(body)
+					} else {
+						req.SetRawBody(bodyBytes)
+					}
 					delayTime := joint.Config.RejectDelayInSeconds
 
 					if delayTime <= 0 {
@@ -673,10 +842,13 @@ DO:
 
 						data := req.OverrideBodyEncode(bodyBytes, true)
 						queue.Push(queue.GetOrInitConfig(metadata.Config.ID+"_dead_letter_queue"), data)
-						return true, statsRet, bulkResult, errors.Errorf("bulk partial failure, retried %v times, quit retry", retryTimes)
+						return true, statsRet, bulkResult, newBulkDocumentsError(invalidItems, errors.Errorf("bulk partial failure, retried %v times, quit retry", retryTimes))
 					}
 					log.Infof("%v, bulk partial failure, #%v retry, %v items left, size: %v, stats:%v", tag, retryTimes, retryableItems.GetMessageCount(), retryableItems.GetMessageSize(), statsCodeStats)
 					retryTimes++
+					requestDocs = count
+					requestData = append([]byte{}, bodyBytes...)
+					requestIDs = append([]string{}, retryableItems.MessageIDs...)
 					stats.Increment("elasticsearch."+tag+"."+metadata.Config.Name+".bulk", "retry")
 
 					goto DO
@@ -691,10 +863,10 @@ DO:
 				if nonRetryableItems.GetMessageCount() > 0 {
 					////handle 400 error
 					if joint.Config.InvalidRequestsQueue != "" {
-						queue.Push(queue.GetOrInitConfig(joint.Config.InvalidRequestsQueue), data)
+						queue.Push(queue.GetOrInitConfig(joint.Config.InvalidRequestsQueue), requestData)
 					}
 				}
-				return continueNext, statsRet, bulkResult, errors.Errorf("bulk response contains error, config: %v, non-retryable docs: %v, retryable docs:%v", metadata.Config.Name, nonRetryableItems.GetMessageCount(), retryableItems.GetMessageCount())
+				return continueNext, statsRet, bulkResult, newBulkDocumentsError(invalidItems, errors.Errorf("bulk response contains error, config: %v, non-retryable docs: %v, retryable docs:%v", metadata.Config.Name, nonRetryableItems.GetMessageCount(), retryableItems.GetMessageCount()))
 			}
 			return true, statsRet, bulkResult, nil
 		}
@@ -702,18 +874,30 @@ DO:
 	} else {
 		statsRet[resp.StatusCode()] = statsRet[resp.StatusCode()] + buffer.GetMessageCount()
 
+		if controller != nil {
+			rejected := 0
+			if resp.StatusCode() == 429 || bytes.Contains(resbody, []byte("es_rejected_execution_exception")) {
+				rejected = requestDocs
+			}
+			controller.Feedback(requestDocs, rejected, latency)
+		}
+
 		var bulkResult *BulkResult
 
 		if util.ContainStr(string(req.Header.RequestURI()), "_bulk") {
-			_, _, bulkResult = HandleBulkResponse(req, resp, labels, data, resbody, successItems, nonRetryableItems, retryableItems, joint.Config.BulkResponseParseConfig, joint.Config.RetryRules)
+			_, _, bulkResult = HandleBulkResponse(req, resp, labels, requestData, resbody, successItems, nonRetryableItems, retryableItems, joint.Config.BulkResponseParseConfig, joint.Config.RetryRules)
 		}
 
 		if resp.StatusCode() == 429 {
-			return false, statsRet, bulkResult, errors.Errorf("code 429, [%v] is too busy", metadata.Config.Name)
+			return false, statsRet, bulkResult, &BulkRejectedError{
+				IDs:  append([]string{}, requestIDs...),
+				Data: append([]byte{}, requestData...),
+				msg:  fmt.Sprintf("code 429, [%v] is too busy", metadata.Config.Name),
+			}
 		} else if resp.StatusCode() >= 400 && resp.StatusCode() < 500 {
 			////handle 400 error
 			if joint.Config.InvalidRequestsQueue != "" {
-				queue.Push(queue.GetOrInitConfig(joint.Config.InvalidRequestsQueue), data)
+				queue.Push(queue.GetOrInitConfig(joint.Config.InvalidRequestsQueue), requestData)
 				return true, statsRet, bulkResult, nil
 			}
 			return false, statsRet, bulkResult, errors.Errorf("invalid requests, code: %v", resp.StatusCode())
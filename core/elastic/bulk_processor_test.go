@@ -0,0 +1,240 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastic
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/elastic/elastictest"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func newMockBulkProcessor(t *testing.T, server *elastictest.Server) (*BulkProcessor, *ElasticsearchMetadata) {
+	cfg := ElasticsearchConfig{Name: t.Name(), Enabled: true, Endpoint: server.URL()}
+	cfg.ID = t.Name()
+	metadata := InitMetadata(&cfg, true)
+	processor := NewBulkProcessor(t.Name(), cfg.ID, BulkProcessorConfig{
+		RequestTimeoutInSecond: 10,
+		RetryRules:             RetryRules{Retry429: true, Default: true},
+		BulkResponseParseConfig: BulkResponseParseConfig{
+			OutputBulkStats:    true,
+			IncludeIndexStats:  true,
+			IncludeActionStats: true,
+		},
+	})
+	return &processor, metadata
+}
+
+func TestBulkProcessorBulkWithMockServer(t *testing.T) {
+	server := elastictest.NewServer("7.10.2")
+	defer server.Close()
+	processor, metadata := newMockBulkProcessor(t, server)
+
+	buffer := processor.BulkBufferPool.AcquireBulkBuffer()
+	defer processor.BulkBufferPool.ReturnBulkBuffer(buffer)
+	buffer.Add("1", []byte("{\"index\":{\"_index\":\"test\",\"_id\":\"1\"}}\n{\"name\":\"a\"}\n"))
+	buffer.Add("2", []byte("{\"index\":{\"_index\":\"test\",\"_id\":\"2\"}}\n{\"name\":\"b\"}\n"))
+	buffer.Add("3", []byte("{\"delete\":{\"_index\":\"test\",\"_id\":\"2\"}}\n"))
+
+	continueNext, status, result, err := processor.Bulk(context.Background(), "test", metadata, server.Host(), buffer)
+	assert.Nil(t, err)
+	assert.True(t, continueNext)
+	assert.Equal(t, 2, status[201])
+	assert.Equal(t, 1, status[200])
+	assert.Equal(t, 3, result.Summary.Success.Count)
+	assert.Equal(t, 1, server.Count("test"))
+}
+
+func TestBulkProcessorBulkRejected(t *testing.T) {
+	server := elastictest.NewServer("7.10.2")
+	defer server.Close()
+	server.Intercept(func(ctx *fasthttp.RequestCtx) bool {
+		ctx.SetStatusCode(429)
+		ctx.SetBody([]byte(`{"error":{"type":"es_rejected_execution_exception","reason":"rejected execution"},"status":429}`))
+		return true
+	})
+	processor, metadata := newMockBulkProcessor(t, server)
+
+	buffer := processor.BulkBufferPool.AcquireBulkBuffer()
+	defer processor.BulkBufferPool.ReturnBulkBuffer(buffer)
+	buffer.Add("1", []byte("{\"index\":{\"_index\":\"test\",\"_id\":\"1\"}}\n{\"name\":\"a\"}\n"))
+
+	continueNext, status, _, err := processor.Bulk(context.Background(), "test", metadata, server.Host(), buffer)
+	assert.NotNil(t, err)
+	assert.True(t, IsBulkRejectedError(err))
+	assert.False(t, continueNext)
+	assert.Equal(t, 1, status[429])
+	assert.Equal(t, -1, server.Count("test"))
+}
+
+func TestBulkProcessorAdaptiveWithMockServer(t *testing.T) {
+	server := elastictest.NewServer("7.10.2")
+	defer server.Close()
+	server.Intercept(func(ctx *fasthttp.RequestCtx) bool {
+		ctx.SetStatusCode(429)
+		ctx.SetBody([]byte(`{"error":{"type":"es_rejected_execution_exception","reason":"rejected execution"},"status":429}`))
+		return true
+	})
+	processor, metadata := newMockBulkProcessor(t, server)
+	processor.Config.BulkMaxDocsCount = 1000
+	processor.Config.Adaptive = AdaptiveBulkConfig{Enabled: true, MaxConcurrency: 4}
+
+	size, docs := processor.GetBatchLimits(metadata, server.Host())
+	assert.Equal(t, 10*1024*1024, size)
+	assert.Equal(t, 1000, docs)
+
+	buffer := processor.BulkBufferPool.AcquireBulkBuffer()
+	defer processor.BulkBufferPool.ReturnBulkBuffer(buffer)
+	buffer.Add("1", []byte("{\"index\":{\"_index\":\"test\",\"_id\":\"1\"}}\n{\"name\":\"a\"}\n"))
+
+	_, _, _, err := processor.Bulk(context.Background(), "test", metadata, server.Host(), buffer)
+	assert.NotNil(t, err)
+
+	size, docs = processor.GetBatchLimits(metadata, server.Host())
+	assert.Equal(t, 5*1024*1024, size)
+	assert.Equal(t, 500, docs)
+
+	//workers without a host follow the controller of the host the requests were sent to
+	size, docs = processor.GetBatchLimits(metadata, "")
+	assert.Equal(t, 5*1024*1024, size)
+	assert.Equal(t, 500, docs)
+
+	adaptiveStats := GetAdaptiveBulkController(metadata.Config.ID, server.Host(), &processor.Config).Stats()
+	assert.Equal(t, int64(1), adaptiveStats.Rejections)
+	assert.Equal(t, 2, adaptiveStats.Concurrency)
+	assert.Equal(t, 0, adaptiveStats.InFlight)
+}
+
+func TestBulkProcessorNonRetryableDocuments(t *testing.T) {
+	server := elastictest.NewServer("7.10.2")
+	defer server.Close()
+	processor, metadata := newMockBulkProcessor(t, server)
+
+	buffer := processor.BulkBufferPool.AcquireBulkBuffer()
+	defer processor.BulkBufferPool.ReturnBulkBuffer(buffer)
+	buffer.Add("1", []byte("{\"index\":{\"_index\":\"test\",\"_id\":\"1\"}}\n{\"name\":\"a\"}\n"))
+	buffer.Add("2", []byte("{\"create\":{\"_index\":\"test\",\"_id\":\"1\"}}\n{\"name\":\"b\"}\n"))
+	buffer.Add("3", []byte("{\"index\":{\"_index\":\"test\",\"_id\":\"3\"}}\n{\"name\":\"c\"}\n"))
+
+	continueNext, status, _, err := processor.Bulk(context.Background(), "test", metadata, server.Host(), buffer)
+	assert.True(t, continueNext)
+	assert.Equal(t, 1, status[409])
+	assert.False(t, IsBulkRejectedError(err))
+
+	//only the conflicted document is returned
+	docErr, ok := GetBulkDocumentsError(err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"1"}, docErr.IDs)
+	assert.Equal(t, "{\"create\":{\"_index\":\"test\",\"_id\":\"1\"}}\n{\"name\":\"b\"}\n", string(docErr.Data))
+	assert.Equal(t, 2, server.Count("test"))
+}
+
+func TestBulkProcessorPartialRejected(t *testing.T) {
+	server := elastictest.NewServer("7.10.2")
+	defer server.Close()
+	requests := 0
+	server.Intercept(func(ctx *fasthttp.RequestCtx) bool {
+		requests++
+		if requests == 1 {
+			ctx.SetStatusCode(200)
+			ctx.SetBody([]byte(`{"took":1,"errors":true,"items":[{"index":{"_index":"test","_id":"1","status":201,"result":"created"}},{"index":{"_index":"test","_id":"2","status":429,"error":{"type":"es_rejected_execution_exception","reason":"rejected execution"}}}]}`))
+			return true
+		}
+		ctx.SetStatusCode(429)
+		ctx.SetBody([]byte(`{"error":{"type":"es_rejected_execution_exception","reason":"rejected execution"},"status":429}`))
+		return true
+	})
+	processor, metadata := newMockBulkProcessor(t, server)
+	processor.Config.MaxRejectRetryTimes = 3
+	processor.Config.RejectDelayInSeconds = 1
+
+	buffer := processor.BulkBufferPool.AcquireBulkBuffer()
+	defer processor.BulkBufferPool.ReturnBulkBuffer(buffer)
+	buffer.Add("1", []byte("{\"index\":{\"_index\":\"test\",\"_id\":\"1\"}}\n{\"name\":\"a\"}\n"))
+	buffer.Add("2", []byte("{\"index\":{\"_index\":\"test\",\"_id\":\"2\"}}\n{\"name\":\"b\"}\n"))
+
+	continueNext, _, _, err := processor.Bulk(context.Background(), "test", metadata, server.Host(), buffer)
+	assert.False(t, continueNext)
+	assert.Equal(t, 2, requests)
+
+	//the accepted document is not returned
+	rejectedErr, ok := GetBulkRejectedError(err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"2"}, rejectedErr.IDs)
+	assert.Equal(t, "{\"index\":{\"_index\":\"test\",\"_id\":\"2\"}}\n{\"name\":\"b\"}\n", string(rejectedErr.Data))
+}
+
+func TestBulkProcessorTransformRetry(t *testing.T) {
+	server := elastictest.NewServer("7.10.2")
+	defer server.Close()
+	bodies := []string{}
+	server.Intercept(func(ctx *fasthttp.RequestCtx) bool {
+		bodies = append(bodies, string(ctx.PostBody()))
+		if len(bodies) > 1 {
+			return false
+		}
+		ctx.SetStatusCode(429)
+		ctx.SetBody([]byte(`{"error":{"type":"es_rejected_execution_exception","reason":"rejected execution"},"status":429}`))
+		return true
+	})
+	processor, metadata := newMockBulkProcessor(t, server)
+	transformer, err := newDocumentTransformer([]DocumentTransformConfig{{
+		Rename: []RenameFieldConfig{{From: "user", To: "owner"}},
+		Hash:   []HashFieldConfig{{Fields: []string{"secret"}}},
+		Index:  "logs-$[[_source.owner]]",
+	}})
+	assert.NoError(t, err)
+	processor.transformer = transformer
+
+	data := "{\"index\":{\"_index\":\"test\",\"_id\":\"1\"}}\n{\"user\":\"u1\",\"secret\":\"s\"}\n"
+	buffer := processor.BulkBufferPool.AcquireBulkBuffer()
+	defer processor.BulkBufferPool.ReturnBulkBuffer(buffer)
+	buffer.Add("1", []byte(data))
+
+	_, _, _, err = processor.Bulk(context.Background(), "test", metadata, server.Host(), buffer)
+	rejectedErr, ok := GetBulkRejectedError(err)
+	assert.True(t, ok)
+	//the rejected documents are the originals, so that they are transformed only once when sent again
+	assert.Equal(t, data, string(rejectedErr.Data))
+
+	buffer.ResetData()
+	buffer.Add("1", rejectedErr.Data)
+	continueNext, _, _, err := processor.Bulk(context.Background(), "test", metadata, server.Host(), buffer)
+	assert.NoError(t, err)
+	assert.True(t, continueNext)
+
+	assert.Equal(t, 2, len(bodies))
+	assert.Equal(t, bodies[0], bodies[1])
+	assert.Equal(t, 1, server.Count("logs-u1"))
+	doc, ok := server.Document("logs-u1", "1")
+	assert.True(t, ok)
+	assert.Equal(t, hashValues(sha256.New(), "s"), doc["secret"])
+}
@@ -0,0 +1,271 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastic
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/util"
+)
+
+// BulkShardRouter splits bulk requests by the primary shard of each document,
+// so that each part can be sent to the node holding the primary directly
+type BulkShardRouter struct {
+	metadata *ElasticsearchMetadata
+	lock     sync.Mutex
+	tables   map[string]*indexShardRouting
+
+	//routing tables are reloaded when the cluster state changes, or expired if no state was collected
+	stateVersion int64
+	ttl          time.Duration
+}
+
+type indexShardRouting struct {
+	loaded           time.Time
+	numberOfShards   int
+	routingNumShards int
+	partitioned      bool
+	//shard id to the http host of the primary
+	primaries map[int]string
+}
+
+var shardRouters = sync.Map{}
+
+// GetBulkShardRouter returns the router of the cluster
+func GetBulkShardRouter(metadata *ElasticsearchMetadata) *BulkShardRouter {
+	v, ok := shardRouters.Load(metadata.Config.ID)
+	if ok && v.(*BulkShardRouter).metadata == metadata {
+		return v.(*BulkShardRouter)
+	}
+	//metadata was reloaded
+	router := NewBulkShardRouter(metadata)
+	shardRouters.Store(metadata.Config.ID, router)
+	return router
+}
+
+func NewBulkShardRouter(metadata *ElasticsearchMetadata) *BulkShardRouter {
+	return &BulkShardRouter{
+		metadata: metadata,
+		tables:   map[string]*indexShardRouting{},
+		ttl:      30 * time.Second,
+	}
+}
+
+// Split walks the bulk requests and groups them by the host of the target primary shard,
+// documents can't be routed, like the ones without id, are grouped under the empty host,
+// the message ids of the buffer are kept, a message split to several hosts is added to each of them
+func (router *BulkShardRouter) Split(buffer *BulkBuffer, pool *BulkBufferPool) map[string]*BulkBuffer {
+	buffers := map[string]*BulkBuffer{}
+	data := buffer.GetMessageBytes()
+	ids := buffer.MessageIDs
+
+	//messages were added with their data
+	if len(buffer.messageEnds) > 0 && len(buffer.messageEnds) == len(ids) {
+		start := 0
+		for i, end := range buffer.messageEnds {
+			router.splitMessage(ids[i], router.walkDocuments(data[start:end]), buffers, pool)
+			start = end
+		}
+		return buffers
+	}
+
+	//message ids were written for each document
+	docs := router.walkDocuments(data)
+	if len(docs) == len(ids) {
+		for i := range docs {
+			router.splitMessage(ids[i], docs[i:i+1], buffers, pool)
+		}
+		return buffers
+	}
+
+	//documents of each message are unknown, keep them together
+	current := pool.AcquireBulkBuffer()
+	current.WriteByteBuffer(data)
+	current.MessageIDs = append(current.MessageIDs, ids...)
+	buffers[""] = current
+	return buffers
+}
+
+type routedDocument struct {
+	host  string
+	lines [][]byte
+}
+
+func (router *BulkShardRouter) walkDocuments(data []byte) []routedDocument {
+	docs := []routedDocument{}
+	WalkBulkRequests("", data, nil, func(metaBytes []byte, actionStr, index, typeName, id, routing string, offset int) (err error) {
+		host, _ := router.GetPrimaryHost(index, id, routing)
+		docs = append(docs, routedDocument{host: host, lines: [][]byte{metaBytes}})
+		return nil
+	}, func(payloadBytes []byte, actionStr, index, typeName, id, routing string) {
+		docs[len(docs)-1].lines = append(docs[len(docs)-1].lines, payloadBytes)
+	}, nil)
+	return docs
+}
+
+// splitMessage write the documents of the message to the buffers of their hosts
+func (router *BulkShardRouter) splitMessage(id string, docs []routedDocument, buffers map[string]*BulkBuffer, pool *BulkBufferPool) {
+	hosts := map[string]bool{}
+	for _, doc := range docs {
+		current := buffers[doc.host]
+		if current == nil {
+			current = pool.AcquireBulkBuffer()
+			buffers[doc.host] = current
+		}
+		for _, line := range doc.lines {
+			current.WriteNewByteBufferLine("doc", line)
+		}
+		hosts[doc.host] = true
+	}
+	for host := range hosts {
+		buffers[host].endMessage(id)
+	}
+}
+
+// GetPrimaryHost returns the http host of the node holding the document's primary shard
+func (router *BulkShardRouter) GetPrimaryHost(index, id, routing string) (string, bool) {
+	key := routing
+	if key == "" {
+		key = id
+	}
+	if key == "" || index == "" {
+		return "", false
+	}
+
+	table := router.getIndexRouting(index)
+	if table == nil || table.numberOfShards <= 0 {
+		return "", false
+	}
+
+	//the shard of partitioned routing depends on the id too
+	if routing != "" && table.partitioned {
+		return "", false
+	}
+
+	shard := GetShardIDWithRoutingOffset(router.routingVersion(), []byte(key), table.numberOfShards, table.routingNumShards, 1)
+	host, ok := table.primaries[shard]
+	if !ok || !IsHostAvailable(host) {
+		return "", false
+	}
+	return host, true
+}
+
+func (router *BulkShardRouter) routingVersion() int {
+	//opensearch and easysearch route like elasticsearch 7
+	switch router.metadata.Config.Distribution {
+	case Opensearch, Easysearch:
+		return 7
+	}
+	return router.metadata.GetMajorVersion()
+}
+
+func (router *BulkShardRouter) getIndexRouting(index string) *indexShardRouting {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+
+	state := router.metadata.ClusterState
+	if state != nil && state.Version != router.stateVersion {
+		if global.Env().IsDebug {
+			log.Debugf("cluster state of [%v] changed from [%v] to [%v], reload routing tables", router.metadata.Config.ID, router.stateVersion, state.Version)
+		}
+		router.stateVersion = state.Version
+		router.tables = map[string]*indexShardRouting{}
+	}
+
+	table, ok := router.tables[index]
+	if ok && (state != nil || time.Since(table.loaded) < router.ttl) {
+		return table
+	}
+
+	table = router.loadIndexRouting(index)
+	router.tables[index] = table
+	return table
+}
+
+func (router *BulkShardRouter) loadIndexRouting(index string) *indexShardRouting {
+	table := &indexShardRouting{loaded: time.Now(), routingNumShards: -1, primaries: map[int]string{}}
+
+	shards, err := router.metadata.GetIndexRoutingTable(index)
+	if err != nil || len(shards) == 0 {
+		if global.Env().IsDebug {
+			log.Debugf("routing table of index [%v] not found, %v", index, err)
+		}
+		return table
+	}
+	table.numberOfShards = len(shards)
+
+	for _, routings := range shards {
+		for _, x := range routings {
+			if !x.Primary || x.Node == "" || (x.State != "STARTED" && x.State != "RELOCATING") {
+				continue
+			}
+			nodeInfo := router.metadata.GetNodeInfo(x.Node)
+			if nodeInfo == nil {
+				continue
+			}
+			table.primaries[x.Shard] = nodeInfo.GetHttpPublishHost()
+		}
+	}
+
+	_, settings, err := router.metadata.GetIndexSetting(index)
+	if err == nil && settings != nil {
+		table.routingNumShards, table.partitioned = getRoutingSettings(settings)
+	}
+	return table
+}
+
+// getRoutingSettings reads the routing settings from the index settings, both the plain settings
+// and the response of the settings api are accepted
+func getRoutingSettings(settings *util.MapStr) (routingNumShards int, partitioned bool) {
+	routingNumShards = -1
+	candidates := []util.MapStr{*settings}
+	for _, v := range *settings {
+		if m, ok := v.(map[string]interface{}); ok {
+			candidates = append(candidates, util.MapStr(m))
+		}
+	}
+
+	for _, m := range candidates {
+		for _, prefix := range []string{"", "settings.", "defaults."} {
+			if v, err := m.GetValue(prefix + "index.number_of_routing_shards"); err == nil && routingNumShards <= 0 {
+				if n, err := util.ToInt(util.ToString(v)); err == nil {
+					routingNumShards = n
+				}
+			}
+			if v, err := m.GetValue(prefix + "index.routing_partition_size"); err == nil {
+				if n, err := util.ToInt(util.ToString(v)); err == nil && n > 1 {
+					partitioned = true
+				}
+			}
+		}
+	}
+	return routingNumShards, partitioned
+}
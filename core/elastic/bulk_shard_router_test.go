@@ -0,0 +1,183 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/elastic/elastictest"
+	"infini.sh/framework/core/util"
+)
+
+func newShardRoutingState(version int64, index string, nodes ...string) *ClusterState {
+	shards := map[string][]IndexShardRouting{}
+	for i := 0; i < 4; i++ {
+		node := nodes[i%len(nodes)]
+		shards[util.ToString(i)] = []IndexShardRouting{
+			{State: "STARTED", Primary: true, Node: node, Shard: i, Index: index},
+			{State: "STARTED", Primary: false, Node: nodes[(i+1)%len(nodes)], Shard: i, Index: index},
+		}
+	}
+	state := &ClusterState{Version: version, RoutingTable: &ClusterRoutingTable{Indices: map[string]struct {
+		Shards map[string][]IndexShardRouting `json:"shards"`
+	}{}}}
+	state.RoutingTable.Indices[index] = struct {
+		Shards map[string][]IndexShardRouting `json:"shards"`
+	}{Shards: shards}
+	return state
+}
+
+func TestBulkShardRouter(t *testing.T) {
+	node1 := elastictest.NewServer("7.10.2")
+	defer node1.Close()
+	node2 := elastictest.NewServer("7.10.2")
+	defer node2.Close()
+
+	cfg := ElasticsearchConfig{Name: t.Name(), Enabled: true, Version: "7.10.2", Endpoint: node1.URL()}
+	cfg.ID = t.Name()
+	metadata := InitMetadata(&cfg, true)
+	nodes := map[string]NodesInfo{}
+	for id, server := range map[string]*elastictest.Server{"node1": node1, "node2": node2} {
+		info := NodesInfo{}
+		info.Http.PublishAddress = server.Host()
+		nodes[id] = info
+	}
+	metadata.Nodes = &nodes
+	metadata.IndexSettings = map[string]*util.MapStr{"test": {"index.number_of_shards": "4"}}
+	metadata.ClusterState = newShardRoutingState(1, "test", "node1", "node2")
+
+	hosts := map[int]string{0: node1.Host(), 1: node2.Host(), 2: node1.Host(), 3: node2.Host()}
+	router := NewBulkShardRouter(metadata)
+
+	doc := func(id string) string {
+		return "{\"index\":{\"_index\":\"test\",\"_id\":\"" + id + "\"}}\n{\"id\":\"" + id + "\"}\n"
+	}
+	ids := []string{"1", "2", "3", "4", "5", "6", "7", "8"}
+	pool := NewBulkBufferPool(t.Name(), 1024*1024, 100)
+	buffer := pool.AcquireBulkBuffer()
+	defer pool.ReturnBulkBuffer(buffer)
+	buffer.Add("m1", []byte(doc("1")+doc("2")+doc("3")+doc("4")))
+	buffer.Add("m2", []byte(doc("5")+doc("6")+doc("7")+doc("8")+"{\"delete\":{\"_index\":\"test\",\"_id\":\"1\"}}\n"))
+	buffer.Add("m3", []byte("{\"index\":{\"_index\":\"test\"}}\n{\"id\":\"auto\"}\n"))
+	buffers := router.Split(buffer, pool)
+
+	for _, id := range ids {
+		host := hosts[GetShardID(7, []byte(id), 4)]
+		assert.Contains(t, string(buffers[host].GetMessageBytes()), "{\"id\":\""+id+"\"}")
+	}
+	for host, buf := range buffers {
+		if host != "" {
+			for _, line := range strings.Split(strings.TrimSpace(string(buf.GetMessageBytes())), "\n") {
+				_, _, _, id, _, err := ParseActionMeta([]byte(line))
+				if err == nil && id != "" {
+					assert.Equal(t, host, hosts[GetShardID(7, []byte(id), 4)])
+				}
+			}
+		}
+	}
+	//the ids of the messages are kept, each part has the messages it got documents from
+	assert.Equal(t, []string{"m1", "m2"}, buffers[node1.Host()].MessageIDs)
+	assert.Equal(t, []string{"m1", "m2"}, buffers[node2.Host()].MessageIDs)
+	assert.Equal(t, []string{"m3"}, buffers[""].MessageIDs)
+	assert.Equal(t, "{\"index\":{\"_index\":\"test\"}}\n{\"id\":\"auto\"}", strings.TrimSpace(string(buffers[""].GetMessageBytes())))
+	assert.Contains(t, string(buffers[hosts[GetShardID(7, []byte("1"), 4)]].GetMessageBytes()), "{\"delete\":{\"_index\":\"test\",\"_id\":\"1\"}}")
+
+	//the parts can be merged back without losing the messages
+	merged := pool.AcquireBulkBuffer()
+	defer pool.ReturnBulkBuffer(merged)
+	merged.Append(buffers[node1.Host()])
+	merged.Append(buffers[""])
+	parts := router.Split(merged, pool)
+	assert.Equal(t, []string{"m1", "m2"}, parts[node1.Host()].MessageIDs)
+	assert.Equal(t, []string{"m3"}, parts[""].MessageIDs)
+	assert.Nil(t, parts[node2.Host()])
+	for _, buf := range parts {
+		pool.ReturnBulkBuffer(buf)
+	}
+	for _, buf := range buffers {
+		pool.ReturnBulkBuffer(buf)
+	}
+
+	//message ids written for each document
+	buffer.ResetData()
+	for _, id := range ids {
+		buffer.WriteByteBuffer([]byte(doc(id)))
+		buffer.WriteMessageID("doc" + id)
+	}
+	buffers = router.Split(buffer, pool)
+	count := 0
+	for host, buf := range buffers {
+		count += buf.GetMessageCount()
+		for _, id := range buf.MessageIDs {
+			assert.Equal(t, host, hosts[GetShardID(7, []byte(strings.TrimPrefix(id, "doc")), 4)])
+		}
+		pool.ReturnBulkBuffer(buf)
+	}
+	assert.Equal(t, 8, count)
+
+	//documents of the messages are unknown, they are kept together
+	buffer.ResetData()
+	buffer.WriteByteBuffer([]byte(doc("1") + doc("2")))
+	buffer.WriteMessageID("m1")
+	buffers = router.Split(buffer, pool)
+	assert.Equal(t, 1, len(buffers))
+	assert.Equal(t, []string{"m1"}, buffers[""].MessageIDs)
+	assert.Equal(t, doc("1")+doc("2"), string(buffers[""].GetMessageBytes()))
+	pool.ReturnBulkBuffer(buffers[""])
+
+	//custom routing takes precedence over the id
+	host, ok := router.GetPrimaryHost("test", "1", "user1")
+	assert.True(t, ok)
+	assert.Equal(t, hosts[GetShardID(7, []byte("user1"), 4)], host)
+
+	//reload after the allocation changed
+	metadata.ClusterState = newShardRoutingState(2, "test", "node2")
+	host, ok = router.GetPrimaryHost("test", "1", "")
+	assert.True(t, ok)
+	assert.Equal(t, node2.Host(), host)
+
+	_, ok = router.GetPrimaryHost("not_exists", "1", "")
+	assert.False(t, ok)
+}
+
+func TestGetRoutingSettings(t *testing.T) {
+	n, partitioned := getRoutingSettings(&util.MapStr{"index.number_of_routing_shards": "32"})
+	assert.Equal(t, 32, n)
+	assert.False(t, partitioned)
+
+	n, partitioned = getRoutingSettings(&util.MapStr{"test": map[string]interface{}{
+		"settings": map[string]interface{}{"index": map[string]interface{}{"number_of_routing_shards": "8", "routing_partition_size": "2"}},
+	}})
+	assert.Equal(t, 8, n)
+	assert.True(t, partitioned)
+
+	n, _ = getRoutingSettings(&util.MapStr{})
+	assert.Equal(t, -1, n)
+}
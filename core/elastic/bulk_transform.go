@@ -0,0 +1,371 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastic
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasttemplate"
+)
+
+// DocumentTransformConfig describes the changes applied to each document of a bulk request,
+// field paths are relative to the document source, templates and conditions see the whole event:
+// _action, _index, _type, _id, _routing and _source
+type DocumentTransformConfig struct {
+	Rename    []RenameFieldConfig    `config:"rename"`
+	Remove    []string               `config:"remove"`
+	Hash      []HashFieldConfig      `config:"hash"`
+	Timestamp []TimestampFieldConfig `config:"timestamp"`
+	Index     string                 `config:"index"`
+	DropWhen  *config.Config         `config:"drop_when"`
+}
+
+type RenameFieldConfig struct {
+	From string `config:"from"`
+	To   string `config:"to"`
+}
+
+type TimestampFieldConfig struct {
+	Field string `config:"field"`
+	//empty for RFC3339Nano, epoch_millis, or a go time layout
+	Format   string `config:"format"`
+	Override bool   `config:"override"`
+}
+
+type HashFieldConfig struct {
+	Fields []string `config:"fields"`
+	//empty to replace each field with its own hash, _id to use as the document id
+	Target string `config:"target"`
+	//md5, sha1 or sha256
+	Method string `config:"method"`
+}
+
+type DocumentCondition func(event util.MapStr) bool
+
+var documentConditionBuilder func(cfg *config.Config) (DocumentCondition, error)
+
+// RegisterDocumentConditionBuilder is used by the conditions package to plug in drop_when,
+// which can't be imported from here
+func RegisterDocumentConditionBuilder(builder func(cfg *config.Config) (DocumentCondition, error)) {
+	documentConditionBuilder = builder
+}
+
+type documentTransform struct {
+	config        DocumentTransformConfig
+	indexTemplate *fasttemplate.Template
+	dropWhen      DocumentCondition
+	hashers       []func() hash.Hash
+}
+
+type documentTransformer struct {
+	transforms []*documentTransform
+}
+
+func newDocumentTransformer(configs []DocumentTransformConfig) (*documentTransformer, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+
+	transformer := &documentTransformer{}
+	for i, cfg := range configs {
+		t := &documentTransform{config: cfg}
+
+		for _, v := range cfg.Rename {
+			if v.From == "" || v.To == "" {
+				return nil, errors.Errorf("transforms[%v]: rename requires both from and to", i)
+			}
+		}
+
+		for _, v := range cfg.Hash {
+			if len(v.Fields) == 0 {
+				return nil, errors.Errorf("transforms[%v]: hash requires fields", i)
+			}
+			switch v.Method {
+			case "md5":
+				t.hashers = append(t.hashers, md5.New)
+			case "sha1":
+				t.hashers = append(t.hashers, sha1.New)
+			case "sha256", "":
+				t.hashers = append(t.hashers, sha256.New)
+			default:
+				return nil, errors.Errorf("transforms[%v]: unsupported hash method [%v]", i, v.Method)
+			}
+		}
+
+		for _, v := range cfg.Timestamp {
+			if v.Field == "" {
+				return nil, errors.Errorf("transforms[%v]: timestamp requires field", i)
+			}
+		}
+
+		if cfg.Index != "" && util.ContainStr(cfg.Index, "$[[") {
+			var err error
+			t.indexTemplate, err = fasttemplate.NewTemplate(cfg.Index, "$[[", "]]")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if cfg.DropWhen != nil {
+			if documentConditionBuilder == nil {
+				return nil, errors.Errorf("transforms[%v]: drop_when is not available", i)
+			}
+			var err error
+			t.dropWhen, err = documentConditionBuilder(cfg.DropWhen)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		transformer.transforms = append(transformer.transforms, t)
+	}
+	return transformer, nil
+}
+
+// Transform applies the transforms to every index and create document of the bulk payload,
+// other actions are kept as they are, returns the new payload, the original payload of the documents
+// not dropped, in the same order, and the count of dropped documents.
+// the transforms are not idempotent, documents to be sent again should be transformed from the original payload
+func (this *documentTransformer) Transform(data []byte) ([]byte, []byte, int, error) {
+	now := time.Now()
+	output := bytes.Buffer{}
+	output.Grow(len(data))
+	original := bytes.Buffer{}
+	original.Grow(len(data))
+
+	dropped := 0
+	lines := bytes.Split(data, NEWLINEBYTES)
+	for i := 0; i < len(lines); i++ {
+		meta := lines[i]
+		if len(meta) == 0 {
+			continue
+		}
+
+		action, index, typeName, id, routing, err := ParseActionMeta(meta)
+		if err != nil {
+			return nil, nil, dropped, err
+		}
+
+		if action == ActionDelete {
+			output.Write(meta)
+			output.Write(NEWLINEBYTES)
+			original.Write(meta)
+			original.Write(NEWLINEBYTES)
+			continue
+		}
+
+		i++
+		if i >= len(lines) {
+			return nil, nil, dropped, errors.Errorf("document of [%v] action is missing", action)
+		}
+		payload := lines[i]
+		original.Write(meta)
+		original.Write(NEWLINEBYTES)
+		original.Write(payload)
+		original.Write(NEWLINEBYTES)
+
+		//update only carries a partial document
+		if action == ActionUpdate {
+			output.Write(meta)
+			output.Write(NEWLINEBYTES)
+			output.Write(payload)
+			output.Write(NEWLINEBYTES)
+			continue
+		}
+
+		doc := util.MapStr{}
+		err = util.FromJSONBytes(payload, &doc)
+		if err != nil {
+			if global.Env().IsDebug {
+				log.Debugf("skip transforming invalid document: %v, %v", err, string(payload))
+			}
+			output.Write(meta)
+			output.Write(NEWLINEBYTES)
+			output.Write(payload)
+			output.Write(NEWLINEBYTES)
+			continue
+		}
+
+		event := util.MapStr{
+			"_action":  action,
+			"_index":   index,
+			"_type":    typeName,
+			"_id":      id,
+			"_routing": routing,
+			"_source":  doc,
+		}
+
+		drop, docChanged, metaChanged := this.apply(event, doc, now)
+		if drop {
+			dropped++
+			original.Truncate(original.Len() - len(meta) - len(payload) - 2*len(NEWLINEBYTES))
+			continue
+		}
+
+		if metaChanged {
+			newIndex, _ := event.GetValue("_index")
+			newID, _ := event.GetValue("_id")
+			meta, err = UpdateBulkMetadata(action, meta, util.ToString(newIndex), "", util.ToString(newID))
+			if err != nil {
+				return nil, nil, dropped, err
+			}
+		}
+
+		if docChanged {
+			payload, err = util.ToJSONBytes(doc)
+			if err != nil {
+				return nil, nil, dropped, err
+			}
+		}
+
+		output.Write(meta)
+		output.Write(NEWLINEBYTES)
+		output.Write(payload)
+		output.Write(NEWLINEBYTES)
+	}
+
+	return output.Bytes(), original.Bytes(), dropped, nil
+}
+
+func (this *documentTransformer) apply(event, doc util.MapStr, now time.Time) (drop, docChanged, metaChanged bool) {
+	for _, t := range this.transforms {
+		if t.dropWhen != nil && t.dropWhen(event) {
+			return true, docChanged, metaChanged
+		}
+
+		for _, v := range t.config.Rename {
+			value, err := doc.GetValue(v.From)
+			if err != nil {
+				continue
+			}
+			doc.Delete(v.From)
+			doc.Put(v.To, value)
+			docChanged = true
+		}
+
+		for _, v := range t.config.Remove {
+			if doc.Delete(v) == nil {
+				docChanged = true
+			}
+		}
+
+		for j, v := range t.config.Hash {
+			if v.Target == "" {
+				for _, field := range v.Fields {
+					value, err := doc.GetValue(field)
+					if err != nil {
+						continue
+					}
+					doc.Put(field, hashValues(t.hashers[j](), value))
+					docChanged = true
+				}
+				continue
+			}
+
+			values := []interface{}{}
+			for _, field := range v.Fields {
+				value, err := doc.GetValue(field)
+				if err == nil {
+					values = append(values, value)
+				}
+			}
+			if len(values) == 0 {
+				continue
+			}
+			hashed := hashValues(t.hashers[j](), values...)
+			if v.Target == "_id" {
+				event["_id"] = hashed
+				metaChanged = true
+			} else {
+				doc.Put(v.Target, hashed)
+				docChanged = true
+			}
+		}
+
+		for _, v := range t.config.Timestamp {
+			if !v.Override {
+				if ok, _ := doc.HasKey(v.Field); ok {
+					continue
+				}
+			}
+			doc.Put(v.Field, formatTimestamp(now, v.Format))
+			docChanged = true
+		}
+
+		if t.config.Index != "" {
+			index := t.config.Index
+			if t.indexTemplate != nil {
+				index = t.indexTemplate.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
+					variable, err := event.GetValue(tag)
+					if err == nil {
+						return w.Write([]byte(util.ToString(variable)))
+					}
+					return 0, nil
+				})
+			}
+			if index != "" {
+				event["_index"] = index
+				metaChanged = true
+			}
+		}
+	}
+	return false, docChanged, metaChanged
+}
+
+func hashValues(h hash.Hash, values ...interface{}) string {
+	for i, v := range values {
+		if i > 0 {
+			h.Write([]byte{0})
+		}
+		h.Write([]byte(util.ToString(v)))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func formatTimestamp(t time.Time, format string) interface{} {
+	switch format {
+	case "":
+		return t.UTC().Format(time.RFC3339Nano)
+	case "epoch_millis":
+		return t.UnixNano() / int64(time.Millisecond)
+	default:
+		return t.Format(format)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastic
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/util"
+)
+
+func transformLines(t *testing.T, data []byte) []util.MapStr {
+	out := []util.MapStr{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		obj := util.MapStr{}
+		assert.NoError(t, util.FromJSONBytes([]byte(line), &obj))
+		out = append(out, obj)
+	}
+	return out
+}
+
+func TestDocumentTransform(t *testing.T) {
+	transformer, err := newDocumentTransformer([]DocumentTransformConfig{
+		{
+			Rename:    []RenameFieldConfig{{From: "user.name", To: "username"}},
+			Remove:    []string{"password", "not_exists"},
+			Timestamp: []TimestampFieldConfig{{Field: "@timestamp"}, {Field: "created", Format: "epoch_millis"}},
+		},
+		{
+			Hash:  []HashFieldConfig{{Fields: []string{"email"}, Method: "md5"}, {Fields: []string{"username", "tenant"}, Target: "_id"}},
+			Index: "logs-$[[_source.tenant]]",
+		},
+	})
+	assert.NoError(t, err)
+
+	data := []byte(`{"index":{"_index":"test","_id":"1"}}
+{"user":{"name":"medcl"},"password":"secret","email":"a@b.c","tenant":"t1","created":1}
+{"delete":{"_index":"test","_id":"2"}}
+{"update":{"_index":"test","_id":"3"}}
+{"doc":{"password":"secret"}}
+`)
+	output, original, dropped, err := transformer.Transform(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, dropped)
+	assert.Equal(t, string(data), string(original))
+
+	lines := transformLines(t, output)
+	assert.Equal(t, 5, len(lines))
+
+	index, _ := lines[0].GetValue("index._index")
+	assert.Equal(t, "logs-t1", index)
+	id, _ := lines[0].GetValue("index._id")
+	assert.Equal(t, hashValues(sha256.New(), "medcl", "t1"), id)
+
+	doc := lines[1]
+	assert.Equal(t, "medcl", doc["username"])
+	assert.Equal(t, map[string]interface{}{}, doc["user"])
+	assert.False(t, doc.SafetyHasKey("password"))
+	assert.Equal(t, util.MD5digest("a@b.c"), doc["email"])
+	assert.True(t, doc.SafetyHasKey("@timestamp"))
+	//existing fields are kept without override
+	assert.Equal(t, float64(1), doc["created"])
+
+	//delete and update are untouched
+	id, _ = lines[2].GetValue("delete._id")
+	assert.Equal(t, "2", id)
+	assert.True(t, lines[4].SafetyHasKey("doc.password"))
+}
+
+func TestDocumentTransformDrop(t *testing.T) {
+	builder := documentConditionBuilder
+	defer func() { documentConditionBuilder = builder }()
+	RegisterDocumentConditionBuilder(func(cfg *config.Config) (DocumentCondition, error) {
+		return func(event util.MapStr) bool {
+			v, _ := event.GetValue("_source.status")
+			return v == "deleted"
+		}, nil
+	})
+
+	dropWhen, err := config.NewConfigFrom(map[string]interface{}{"equals": map[string]interface{}{"_source.status": "deleted"}})
+	assert.NoError(t, err)
+
+	transformer, err := newDocumentTransformer([]DocumentTransformConfig{{DropWhen: dropWhen}})
+	assert.NoError(t, err)
+
+	data := []byte(`{"index":{"_index":"test","_id":"1"}}
+{"status":"deleted"}
+{"create":{"_index":"test","_id":"2"}}
+{"status":"active"}
+`)
+	output, original, dropped, err := transformer.Transform(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dropped)
+	assert.Equal(t, "{\"create\":{\"_index\":\"test\",\"_id\":\"2\"}}\n{\"status\":\"active\"}\n", string(output))
+	//the original of the dropped document is not kept
+	assert.Equal(t, string(output), string(original))
+
+	output, _, dropped, err = transformer.Transform(data[:len(`{"index":{"_index":"test","_id":"1"}}
+{"status":"deleted"}
+`)])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dropped)
+	assert.Equal(t, 0, len(output))
+}
+
+func TestDocumentTransformInvalidConfig(t *testing.T) {
+	_, err := newDocumentTransformer([]DocumentTransformConfig{{Hash: []HashFieldConfig{{Fields: []string{"a"}, Method: "crc"}}}})
+	assert.Error(t, err)
+	_, err = newDocumentTransformer([]DocumentTransformConfig{{Rename: []RenameFieldConfig{{From: "a"}}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,789 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastictest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/encoding/json"
+)
+
+func (s *Server) route(r *request) {
+	p := r.parts
+	switch {
+	case len(p) == 0:
+		s.handleInfo(r)
+	case p[0] == "_bulk":
+		s.handleBulk(r, "", "")
+	case p[0] == "_search" && len(p) >= 2 && p[1] == "scroll":
+		s.handleScroll(r)
+	case p[0] == "_search" && len(p) == 2 && p[1] == "point_in_time" && r.method == "DELETE":
+		s.handleClosePIT(r)
+	case p[0] == "_pit" && len(p) == 1 && r.method == "DELETE":
+		s.handleClosePIT(r)
+	case p[0] == "_search" || p[0] == "_count" || p[0] == "_refresh" || p[0] == "_mapping":
+		s.routeIndexAPI(r, "_all", p[0:])
+	case p[0] == "_cluster" && len(p) >= 2 && p[1] == "health":
+		s.handleClusterHealth(r)
+	case p[0] == "_cluster" && len(p) >= 2 && p[1] == "state":
+		s.handleClusterState(r)
+	case p[0] == "_nodes":
+		s.handleNodes(r)
+	case p[0] == "_cat" && len(p) >= 2 && p[1] == "shards":
+		s.handleCatShards(r)
+	case strings.HasPrefix(p[0], "_"):
+		writeError(r.ctx, 400, "illegal_argument_exception", fmt.Sprintf("unsupported api [%v %v] of the mock server", r.method, string(r.ctx.Path())))
+	case len(p) == 1:
+		s.handleIndex(r, p[0])
+	default:
+		s.routeIndexAPI(r, p[0], p[1:])
+	}
+}
+
+// routeIndexAPI routes apis under /{index}/
+func (s *Server) routeIndexAPI(r *request, indexName string, p []string) {
+	api := p[0]
+	switch api {
+	case "_search":
+		if len(p) == 2 && p[1] == "point_in_time" {
+			s.handleOpenPIT(r, indexName)
+		} else {
+			s.handleSearch(r, indexName)
+		}
+		return
+	case "_pit":
+		s.handleOpenPIT(r, indexName)
+		return
+	case "_count":
+		s.handleCount(r, indexName)
+		return
+	case "_refresh", "_flush":
+		s.handleRefresh(r, indexName)
+		return
+	case "_mapping":
+		docType := ""
+		if len(p) > 1 {
+			docType = p[1]
+		}
+		s.handleMapping(r, indexName, docType)
+		return
+	case "_settings":
+		s.handleSettings(r, indexName)
+		return
+	case "_bulk":
+		s.handleBulk(r, indexName, "")
+		return
+	case "_delete_by_query":
+		s.handleDeleteByQuery(r, indexName)
+		return
+	case "_update_by_query":
+		s.handleUpdateByQuery(r, indexName)
+		return
+	case "_doc", "_create":
+		if len(p) == 1 {
+			s.handleDoc(r, indexName, "_doc", "", api == "_create")
+		} else if len(p) == 2 {
+			s.handleDoc(r, indexName, "_doc", p[1], api == "_create")
+		} else if len(p) == 3 && p[2] == "_update" {
+			s.handleUpdate(r, indexName, "_doc", p[1])
+		} else {
+			break
+		}
+		return
+	case "_update":
+		if len(p) == 2 {
+			s.handleUpdate(r, indexName, "_doc", p[1])
+			return
+		}
+	}
+
+	if strings.HasPrefix(api, "_") {
+		writeError(r.ctx, 400, "illegal_argument_exception", fmt.Sprintf("unsupported api [%v %v] of the mock server", r.method, string(r.ctx.Path())))
+		return
+	}
+
+	// typed apis, /{index}/{type}/...
+	docType := api
+	switch {
+	case len(p) == 1:
+		s.handleDoc(r, indexName, docType, "", false)
+	case p[1] == "_bulk":
+		s.handleBulk(r, indexName, docType)
+	case p[1] == "_search":
+		s.handleSearch(r, indexName)
+	case p[1] == "_mapping":
+		s.handleMapping(r, indexName, docType)
+	case len(p) == 2:
+		s.handleDoc(r, indexName, docType, p[1], false)
+	case len(p) == 3 && p[2] == "_update":
+		s.handleUpdate(r, indexName, docType, p[1])
+	case len(p) == 3 && p[2] == "_create":
+		s.handleDoc(r, indexName, docType, p[1], true)
+	default:
+		writeError(r.ctx, 400, "illegal_argument_exception", fmt.Sprintf("unsupported api [%v %v] of the mock server", r.method, string(r.ctx.Path())))
+	}
+}
+
+func (s *Server) handleInfo(r *request) {
+	version := map[string]interface{}{
+		"number":         s.Version,
+		"build_flavor":   "default",
+		"build_type":     "tar",
+		"build_snapshot": false,
+		"lucene_version": "",
+	}
+	if s.Distribution != Elasticsearch {
+		version["distribution"] = s.Distribution
+	}
+	writeJSON(r.ctx, 200, map[string]interface{}{
+		"name":         s.NodeName,
+		"cluster_name": s.ClusterName,
+		"cluster_uuid": s.ClusterUUID,
+		"version":      version,
+		"tagline":      "You Know, for Search",
+	})
+}
+
+func (s *Server) indexNotFound(r *request, name string) {
+	body := errorBody(404, "index_not_found_exception", "no such index ["+name+"]")
+	body["error"].(map[string]interface{})["index"] = name
+	writeJSON(r.ctx, 404, body)
+}
+
+func (s *Server) handleIndex(r *request, name string) {
+	switch r.method {
+	case "PUT":
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if _, ok := s.indices[name]; ok {
+			errType := "resource_already_exists_exception"
+			if s.esMajor() < 6 {
+				errType = "index_already_exists_exception"
+			}
+			writeError(r.ctx, 400, errType, fmt.Sprintf("index [%v] already exists", name))
+			return
+		}
+		body, err := r.bodyMap()
+		if err != nil {
+			writeError(r.ctx, 400, "parse_exception", err.Error())
+			return
+		}
+		settings, _ := body["settings"].(map[string]interface{})
+		if v, ok := settings["index"].(map[string]interface{}); ok {
+			settings = v
+		}
+		shards := toInt(settings["number_of_shards"], s.defaultShards())
+		replicas := toInt(settings["number_of_replicas"], 1)
+		idx := s.newIndex(name, shards, replicas)
+		if mappings, ok := body["mappings"].(map[string]interface{}); ok {
+			s.putMapping(idx, "", mappings)
+		}
+		s.indices[name] = idx
+		s.stateVersion++
+		writeJSON(r.ctx, 200, map[string]interface{}{"acknowledged": true, "shards_acknowledged": true, "index": name})
+
+	case "DELETE":
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		names := s.resolveIndices(name)
+		if len(names) == 0 {
+			s.indexNotFound(r, name)
+			return
+		}
+		for _, v := range names {
+			delete(s.indices, v)
+		}
+		s.stateVersion++
+		writeJSON(r.ctx, 200, map[string]interface{}{"acknowledged": true})
+
+	case "GET", "HEAD":
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+		names, missing := s.resolveConcrete(name)
+		if missing != "" {
+			s.indexNotFound(r, missing)
+			return
+		}
+		out := map[string]interface{}{}
+		for _, v := range names {
+			idx := s.indices[v]
+			out[v] = map[string]interface{}{
+				"aliases":  map[string]interface{}{},
+				"mappings": s.mappingBody(idx),
+				"settings": idx.settings(),
+			}
+		}
+		writeJSON(r.ctx, 200, out)
+
+	default:
+		writeError(r.ctx, 405, "method_not_allowed", r.method+" is not allowed")
+	}
+}
+
+func (s *Server) handleSettings(r *request, name string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	names, missing := s.resolveConcrete(name)
+	if missing != "" {
+		s.indexNotFound(r, missing)
+		return
+	}
+	out := map[string]interface{}{}
+	for _, v := range names {
+		out[v] = map[string]interface{}{"settings": s.indices[v].settings()}
+	}
+	writeJSON(r.ctx, 200, out)
+}
+
+// resolveIndices expands comma separated names and wildcards to existing indices
+func (s *Server) resolveIndices(expr string) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, v := range strings.Split(expr, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if v == "_all" || v == "*" {
+			v = "*"
+		}
+		for name := range s.indices {
+			if !seen[name] && matchPattern(v, name) {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveConcrete is like resolveIndices but reports the first missing concrete index
+func (s *Server) resolveConcrete(expr string) ([]string, string) {
+	for _, v := range strings.Split(expr, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" || v == "_all" || strings.Contains(v, "*") {
+			continue
+		}
+		if _, ok := s.indices[v]; !ok {
+			return nil, v
+		}
+	}
+	return s.resolveIndices(expr), ""
+}
+
+func (s *Server) handleDoc(r *request, indexName, docType, id string, create bool) {
+	switch r.method {
+	case "PUT", "POST":
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		op := &writeOp{
+			action:  "index",
+			index:   indexName,
+			docType: docType,
+			id:      id,
+			routing: r.arg("routing"),
+			source:  r.body,
+		}
+		if create || r.arg("op_type") == "create" {
+			op.action = "create"
+		}
+		status, item := s.write(op)
+		writeJSON(r.ctx, status, s.writeResponse(status, item))
+
+	case "GET", "HEAD":
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+		idx, ok := s.indices[indexName]
+		if !ok {
+			s.indexNotFound(r, indexName)
+			return
+		}
+		doc, ok := idx.docs[id]
+		out := map[string]interface{}{"_index": indexName, "_id": id, "found": ok}
+		if s.includeType() {
+			out["_type"] = s.typeName(docType)
+			if ok {
+				out["_type"] = doc.docType
+			}
+		}
+		if !ok {
+			writeJSON(r.ctx, 404, out)
+			return
+		}
+		out["_version"] = doc.version
+		if s.withSeqNo() {
+			out["_seq_no"] = doc.seqNo
+			out["_primary_term"] = 1
+		}
+		if doc.routing != "" {
+			out["_routing"] = doc.routing
+		}
+		out["_source"] = json.RawMessage(doc.source)
+		writeJSON(r.ctx, 200, out)
+
+	case "DELETE":
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		status, item := s.write(&writeOp{action: "delete", index: indexName, docType: docType, id: id})
+		writeJSON(r.ctx, status, s.writeResponse(status, item))
+
+	default:
+		writeError(r.ctx, 405, "method_not_allowed", r.method+" is not allowed")
+	}
+}
+
+func (s *Server) handleUpdate(r *request, indexName, docType, id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	status, item := s.write(&writeOp{action: "update", index: indexName, docType: docType, id: id, routing: r.arg("routing"), source: r.body})
+	writeJSON(r.ctx, status, s.writeResponse(status, item))
+}
+
+// writeResponse turns a bulk item into the response of the single document api
+func (s *Server) writeResponse(status int, item map[string]interface{}) map[string]interface{} {
+	if e, ok := item["error"].(map[string]interface{}); ok {
+		body := errorBody(status, e["type"].(string), e["reason"].(string))
+		body["error"].(map[string]interface{})["index"] = item["_index"]
+		return body
+	}
+	return item
+}
+
+func (s *Server) handleBulk(r *request, urlIndex, urlType string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	items := []interface{}{}
+	hasErrors := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(r.body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(r.body)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		meta := map[string]map[string]interface{}{}
+		if err := json.Unmarshal(line, &meta); err != nil || len(meta) != 1 {
+			writeError(r.ctx, 400, "illegal_argument_exception", "Malformed action/metadata line: "+string(line))
+			return
+		}
+		for action, m := range meta {
+			op := &writeOp{
+				action:  action,
+				index:   urlIndex,
+				docType: urlType,
+				id:      toString(m["_id"]),
+				routing: toString(m["routing"]),
+			}
+			if v := toString(m["_index"]); v != "" {
+				op.index = v
+			}
+			if v := toString(m["_type"]); v != "" {
+				op.docType = v
+			}
+			if v := toString(m["_routing"]); v != "" {
+				op.routing = v
+			}
+			switch action {
+			case "index", "create", "update":
+				if !scanner.Scan() {
+					writeError(r.ctx, 400, "illegal_argument_exception", "The bulk request must be terminated by a newline [\\n]")
+					return
+				}
+				op.source = append([]byte(nil), bytes.TrimSpace(scanner.Bytes())...)
+			case "delete":
+			default:
+				writeError(r.ctx, 400, "illegal_argument_exception", "Malformed action/metadata line, expected one of [create, delete, index, update] but found ["+action+"]")
+				return
+			}
+			if op.index == "" {
+				writeError(r.ctx, 400, "action_request_validation_exception", "Validation Failed: 1: index is missing;")
+				return
+			}
+
+			status, item := s.write(op)
+			item["status"] = status
+			if _, ok := item["error"]; ok {
+				hasErrors = true
+			}
+			items = append(items, map[string]interface{}{action: item})
+		}
+	}
+
+	var out interface{} = map[string]interface{}{
+		"took":   1,
+		"errors": hasErrors,
+		"items":  items,
+	}
+	if filter := r.arg("filter_path"); filter != "" {
+		out = filterPaths(out, filter)
+	}
+	writeJSON(r.ctx, 200, out)
+}
+
+func (s *Server) handleRefresh(r *request, indexName string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	names, missing := s.resolveConcrete(indexName)
+	if missing != "" {
+		s.indexNotFound(r, missing)
+		return
+	}
+	writeJSON(r.ctx, 200, map[string]interface{}{"_shards": s.shardsHeader(names)})
+}
+
+func (s *Server) shardsHeader(names []string) map[string]interface{} {
+	total := 0
+	for _, v := range names {
+		total += s.indices[v].shards
+	}
+	return map[string]interface{}{"total": total, "successful": total, "skipped": 0, "failed": 0}
+}
+
+func (s *Server) handleMapping(r *request, indexName, docType string) {
+	if r.method == "PUT" || r.method == "POST" {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		body, err := r.bodyMap()
+		if err != nil {
+			writeError(r.ctx, 400, "mapper_parsing_exception", err.Error())
+			return
+		}
+		names, missing := s.resolveConcrete(indexName)
+		if missing != "" {
+			s.indexNotFound(r, missing)
+			return
+		}
+		for _, v := range names {
+			s.putMapping(s.indices[v], docType, body)
+		}
+		s.stateVersion++
+		writeJSON(r.ctx, 200, map[string]interface{}{"acknowledged": true})
+		return
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	names, missing := s.resolveConcrete(indexName)
+	if missing != "" {
+		s.indexNotFound(r, missing)
+		return
+	}
+	out := map[string]interface{}{}
+	for _, v := range names {
+		out[v] = map[string]interface{}{"mappings": s.mappingBody(s.indices[v])}
+	}
+	writeJSON(r.ctx, 200, out)
+}
+
+// putMapping merges properties, accepts both typed and typeless mappings
+func (s *Server) putMapping(idx *index, docType string, body map[string]interface{}) {
+	props, ok := body["properties"].(map[string]interface{})
+	if !ok {
+		for k, v := range body {
+			if m, ok := v.(map[string]interface{}); ok {
+				if p, ok := m["properties"].(map[string]interface{}); ok {
+					docType = k
+					props = p
+					break
+				}
+			}
+		}
+	}
+	if docType != "" && idx.mappingType == "" {
+		idx.mappingType = docType
+	}
+	if props != nil {
+		mergeMap(idx.properties, props)
+	}
+}
+
+// mappingBody nests properties under the mapping type before 7.0
+func (s *Server) mappingBody(idx *index) map[string]interface{} {
+	if len(idx.properties) == 0 {
+		return map[string]interface{}{}
+	}
+	body := map[string]interface{}{"properties": idx.properties}
+	if s.esMajor() < 7 {
+		docType := idx.mappingType
+		if docType == "" {
+			docType = s.defaultType()
+		}
+		return map[string]interface{}{docType: body}
+	}
+	return body
+}
+
+func (s *Server) handleClusterHealth(r *request) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	names := s.resolveIndices("*")
+	if len(r.parts) > 2 {
+		names = s.resolveIndices(r.parts[2])
+	}
+
+	primaries, unassigned := 0, 0
+	indices := map[string]interface{}{}
+	for _, v := range names {
+		idx := s.indices[v]
+		primaries += idx.shards
+		unassigned += idx.shards * idx.replicas
+		status := "green"
+		if idx.replicas > 0 {
+			status = "yellow"
+		}
+		indices[v] = map[string]interface{}{
+			"status":                status,
+			"number_of_shards":      idx.shards,
+			"number_of_replicas":    idx.replicas,
+			"active_primary_shards": idx.shards,
+			"active_shards":         idx.shards,
+			"relocating_shards":     0,
+			"initializing_shards":   0,
+			"unassigned_shards":     idx.shards * idx.replicas,
+		}
+	}
+
+	status := "green"
+	if unassigned > 0 {
+		status = "yellow"
+	}
+	percent := 100.0
+	if primaries+unassigned > 0 {
+		percent = float64(primaries) * 100 / float64(primaries+unassigned)
+	}
+	out := map[string]interface{}{
+		"cluster_name":                     s.ClusterName,
+		"status":                           status,
+		"timed_out":                        false,
+		"number_of_nodes":                  1,
+		"number_of_data_nodes":             1,
+		"active_primary_shards":            primaries,
+		"active_shards":                    primaries,
+		"relocating_shards":                0,
+		"initializing_shards":              0,
+		"unassigned_shards":                unassigned,
+		"delayed_unassigned_shards":        0,
+		"number_of_pending_tasks":          0,
+		"number_of_in_flight_fetch":        0,
+		"task_max_waiting_in_queue_millis": 0,
+		"active_shards_percent_as_number":  percent,
+	}
+	if r.arg("level") == "indices" || r.arg("level") == "shards" {
+		out["indices"] = indices
+	}
+	writeJSON(r.ctx, 200, out)
+}
+
+func (s *Server) handleClusterState(r *request) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	names := s.resolveIndices("*")
+	if len(r.parts) > 3 {
+		names = s.resolveIndices(r.parts[3])
+	}
+
+	routing := map[string]interface{}{}
+	metadata := map[string]interface{}{}
+	for _, v := range names {
+		idx := s.indices[v]
+		shards := map[string]interface{}{}
+		for i := 0; i < idx.shards; i++ {
+			copies := []interface{}{map[string]interface{}{
+				"state":           "STARTED",
+				"primary":         true,
+				"node":            s.NodeID,
+				"relocating_node": nil,
+				"shard":           i,
+				"index":           v,
+				"allocation_id":   map[string]interface{}{"id": fmt.Sprintf("%v-%v", idx.uuid, i)},
+			}}
+			for j := 0; j < idx.replicas; j++ {
+				copies = append(copies, map[string]interface{}{
+					"state":           "UNASSIGNED",
+					"primary":         false,
+					"node":            nil,
+					"relocating_node": nil,
+					"shard":           i,
+					"index":           v,
+					"unassigned_info": map[string]interface{}{"reason": "INDEX_CREATED"},
+				})
+			}
+			shards[strconv.Itoa(i)] = copies
+		}
+		routing[v] = map[string]interface{}{"shards": shards}
+		metadata[v] = map[string]interface{}{
+			"state":    "open",
+			"settings": idx.settings(),
+			"mappings": s.mappingBody(idx),
+			"aliases":  []interface{}{},
+		}
+	}
+
+	writeJSON(r.ctx, 200, map[string]interface{}{
+		"cluster_name":  s.ClusterName,
+		"cluster_uuid":  s.ClusterUUID,
+		"version":       s.stateVersion,
+		"state_uuid":    fmt.Sprintf("%v-%v", s.ClusterUUID, s.stateVersion),
+		"master_node":   s.NodeID,
+		"routing_table": map[string]interface{}{"indices": routing},
+		"metadata":      map[string]interface{}{"cluster_uuid": s.ClusterUUID, "indices": metadata},
+	})
+}
+
+func (s *Server) handleNodes(r *request) {
+	host := s.Host()
+	ip := strings.Split(host, ":")[0]
+	node := map[string]interface{}{
+		"name":              s.NodeName,
+		"transport_address": ip + ":9300",
+		"host":              ip,
+		"ip":                ip,
+		"version":           s.Version,
+		"build_flavor":      "default",
+		"build_type":        "tar",
+		"build_hash":        "",
+		"roles":             []string{"master", "data", "ingest"},
+		"attributes":        map[string]interface{}{},
+		"http": map[string]interface{}{
+			"bound_address":               []string{host},
+			"publish_address":             host,
+			"max_content_length_in_bytes": 104857600,
+		},
+		"settings": map[string]interface{}{
+			"cluster": map[string]interface{}{"name": s.ClusterName},
+			"node":    map[string]interface{}{"name": s.NodeName},
+		},
+	}
+
+	nodes := map[string]interface{}{s.NodeID: node}
+	if len(r.parts) > 1 {
+		switch r.parts[1] {
+		case s.NodeID, s.NodeName, "_all", "_local", "_master", "*":
+		default:
+			nodes = map[string]interface{}{}
+		}
+	}
+
+	writeJSON(r.ctx, 200, map[string]interface{}{
+		"_nodes":       map[string]interface{}{"total": len(nodes), "successful": len(nodes), "failed": 0},
+		"cluster_name": s.ClusterName,
+		"nodes":        nodes,
+	})
+}
+
+func (s *Server) handleCatShards(r *request) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	names := s.resolveIndices("*")
+	if len(r.parts) > 2 {
+		names = s.resolveIndices(r.parts[2])
+	}
+	rawBytes := r.arg("bytes") == "b"
+	ip := strings.Split(s.Host(), ":")[0]
+
+	rows := []map[string]interface{}{}
+	for _, v := range names {
+		idx := s.indices[v]
+		for i := 0; i < idx.shards; i++ {
+			store := strconv.Itoa(idx.storeSize(i))
+			if !rawBytes {
+				store += "b"
+			}
+			rows = append(rows, map[string]interface{}{
+				"index":  v,
+				"shard":  strconv.Itoa(i),
+				"prirep": "p",
+				"state":  "STARTED",
+				"docs":   strconv.Itoa(idx.docCount(i)),
+				"store":  store,
+				"id":     s.NodeID,
+				"node":   s.NodeName,
+				"ip":     ip,
+			})
+			for j := 0; j < idx.replicas; j++ {
+				rows = append(rows, map[string]interface{}{
+					"index":             v,
+					"shard":             strconv.Itoa(i),
+					"prirep":            "r",
+					"state":             "UNASSIGNED",
+					"unassigned.reason": "INDEX_CREATED",
+					"docs":              nil,
+					"store":             nil,
+					"id":                nil,
+					"node":              nil,
+					"ip":                nil,
+				})
+			}
+		}
+	}
+
+	columns := []string{"index", "shard", "prirep", "state", "docs", "store", "ip", "node"}
+	if h := r.arg("h"); h != "" {
+		columns = strings.Split(h, ",")
+		for i, row := range rows {
+			selected := map[string]interface{}{}
+			for _, c := range columns {
+				selected[c] = row[c]
+			}
+			rows[i] = selected
+		}
+	}
+
+	if r.arg("format") == "json" {
+		writeJSON(r.ctx, 200, rows)
+		return
+	}
+
+	buf := bytes.Buffer{}
+	for _, row := range rows {
+		values := []string{}
+		for _, c := range columns {
+			if v := row[c]; v != nil {
+				values = append(values, fmt.Sprint(v))
+			} else {
+				values = append(values, "")
+			}
+		}
+		buf.WriteString(strings.Join(values, " "))
+		buf.WriteString("\n")
+	}
+	r.ctx.SetStatusCode(200)
+	r.ctx.SetContentType("text/plain; charset=UTF-8")
+	r.ctx.SetBody(buf.Bytes())
+}
@@ -0,0 +1,219 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastictest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// pitContext keeps the documents visible at the time the point in time was opened
+type pitContext struct {
+	indices []string
+	hits    []hit
+}
+
+// handleOpenPIT serves POST /{index}/_pit of elasticsearch and
+// POST /{index}/_search/point_in_time of opensearch
+func (s *Server) handleOpenPIT(r *request, indexName string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.supportPIT() || r.method != "POST" {
+		writeError(r.ctx, 400, "illegal_argument_exception", fmt.Sprintf("unsupported api [%v %v] of the mock server", r.method, string(r.ctx.Path())))
+		return
+	}
+	if r.arg("keep_alive") == "" {
+		writeError(r.ctx, 400, "action_request_validation_exception", "Validation Failed: 1: [keep_alive] is not specified;")
+		return
+	}
+	names, missing := s.resolveConcrete(indexName)
+	if missing != "" {
+		s.indexNotFound(r, missing)
+		return
+	}
+
+	ctx := &pitContext{indices: names}
+	for _, name := range names {
+		for _, doc := range s.indices[name].docs {
+			ctx.hits = append(ctx.hits, hit{index: name, doc: doc})
+		}
+	}
+	sort.Slice(ctx.hits, func(i, j int) bool {
+		if ctx.hits[i].index != ctx.hits[j].index {
+			return ctx.hits[i].index < ctx.hits[j].index
+		}
+		return ctx.hits[i].doc.seqNo < ctx.hits[j].doc.seqNo
+	})
+	// _shard_doc is unique and stable within the point in time
+	for i := range ctx.hits {
+		ctx.hits[i].shardDoc = int64(i)
+	}
+
+	id := randomID()
+	s.pits[id] = ctx
+	if s.Distribution == Opensearch {
+		writeJSON(r.ctx, 200, map[string]interface{}{
+			"pit_id":        id,
+			"_shards":       s.shardsHeader(names),
+			"creation_time": time.Now().UnixNano() / int64(time.Millisecond),
+		})
+		return
+	}
+	writeJSON(r.ctx, 200, map[string]interface{}{"id": id, "_shards": s.shardsHeader(names)})
+}
+
+// handleClosePIT serves DELETE /_pit of elasticsearch and
+// DELETE /_search/point_in_time of opensearch
+func (s *Server) handleClosePIT(r *request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.supportPIT() {
+		writeError(r.ctx, 400, "illegal_argument_exception", fmt.Sprintf("unsupported api [%v %v] of the mock server", r.method, string(r.ctx.Path())))
+		return
+	}
+	body, err := r.bodyMap()
+	if err != nil {
+		writeError(r.ctx, 400, "parse_exception", err.Error())
+		return
+	}
+	key := "id"
+	if s.Distribution == Opensearch {
+		key = "pit_id"
+	}
+	ids := []string{}
+	switch v := body[key].(type) {
+	case string:
+		ids = append(ids, v)
+	case []interface{}:
+		for _, x := range v {
+			ids = append(ids, toString(x))
+		}
+	}
+	if len(ids) == 0 {
+		writeError(r.ctx, 400, "action_request_validation_exception", "Validation Failed: 1: [id] is not specified;")
+		return
+	}
+
+	if s.Distribution == Opensearch {
+		pits := []interface{}{}
+		for _, id := range ids {
+			_, ok := s.pits[id]
+			delete(s.pits, id)
+			pits = append(pits, map[string]interface{}{"successful": ok, "pit_id": id})
+		}
+		writeJSON(r.ctx, 200, map[string]interface{}{"pits": pits})
+		return
+	}
+
+	freed := 0
+	for _, id := range ids {
+		if _, ok := s.pits[id]; ok {
+			delete(s.pits, id)
+			freed++
+		}
+	}
+	status := 200
+	if freed == 0 {
+		status = 404
+	}
+	writeJSON(r.ctx, status, map[string]interface{}{"succeeded": true, "num_freed": freed})
+}
+
+// handlePITSearch searches the snapshot of a point in time, the lock is held by the caller
+func (s *Server) handlePITSearch(r *request, indexName string, req *searchRequest) {
+	if indexName != "_all" {
+		writeError(r.ctx, 400, "action_request_validation_exception", "Validation Failed: 1: [indices] cannot be used with point in time. Do not specify any index with point in time.;")
+		return
+	}
+	if r.arg("scroll") != "" {
+		writeError(r.ctx, 400, "action_request_validation_exception", "Validation Failed: 1: using [point in time] is not allowed in a scroll context;")
+		return
+	}
+	ctx, ok := s.pits[req.pit]
+	if !ok {
+		writeError(r.ctx, 404, "search_context_missing_exception", "No search context found for id ["+req.pit+"]")
+		return
+	}
+	req.indices = ctx.indices
+
+	// elasticsearch adds _shard_doc as the implicit tiebreaker since 7.12
+	if s.Distribution == Elasticsearch && s.atLeast(7, 12) && !hasSortField(req.sort, "_shard_doc") {
+		req.sort = append(req.sort, sortField{field: "_shard_doc"})
+	}
+
+	hits, err := matchHits(req, ctx.hits)
+	if err != nil {
+		writeError(r.ctx, 400, "parsing_exception", err.Error())
+		return
+	}
+	total := len(hits)
+	hits, err = searchAfter(req, hits)
+	if err != nil {
+		writeError(r.ctx, 400, "illegal_argument_exception", err.Error())
+		return
+	}
+	writeJSON(r.ctx, 200, s.searchResponse(req, total, page(hits, req.from, req.size), map[string]interface{}{"pit_id": req.pit}))
+}
+
+func hasSortField(fields []sortField, name string) bool {
+	for _, f := range fields {
+		if f.field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// searchAfter skips the sorted hits up to the search_after values
+func searchAfter(req *searchRequest, hits []hit) ([]hit, error) {
+	if len(req.searchAfter) == 0 {
+		return hits, nil
+	}
+	if len(req.searchAfter) != len(req.sort) {
+		return nil, fmt.Errorf("search_after has %v value(s) but sort has %v.", len(req.searchAfter), len(req.sort))
+	}
+	for i, h := range hits {
+		for j, f := range req.sort {
+			c := compareSortValues(sortValue(h, f.field), req.searchAfter[j], f.desc)
+			if c > 0 {
+				return hits[i:], nil
+			}
+			if c < 0 {
+				break
+			}
+		}
+	}
+	return []hit{}, nil
+}
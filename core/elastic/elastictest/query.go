@@ -0,0 +1,878 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastictest
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/segmentio/encoding/json"
+)
+
+type hit struct {
+	index    string
+	doc      *document
+	shardDoc int64
+}
+
+type sortField struct {
+	field string
+	desc  bool
+}
+
+type searchRequest struct {
+	query   map[string]interface{}
+	from    int
+	size    int
+	sort    []sortField
+	source  interface{}
+	slice   map[string]interface{}
+	filter  string
+	indices []string
+
+	pit         string
+	searchAfter []interface{}
+}
+
+type scrollContext struct {
+	search *searchRequest
+	hits   []hit
+	pos    int
+}
+
+func (s *Server) parseSearch(r *request, names []string) (*searchRequest, error) {
+	body, err := r.bodyMap()
+	if err != nil {
+		return nil, err
+	}
+	// url parameters take precedence over the body, as elasticsearch does
+	req := &searchRequest{
+		from:    r.intArg("from", toInt(body["from"], 0)),
+		size:    r.intArg("size", toInt(body["size"], 10)),
+		source:  body["_source"],
+		filter:  r.arg("filter_path"),
+		indices: names,
+	}
+	req.query, _ = body["query"].(map[string]interface{})
+	req.slice, _ = body["slice"].(map[string]interface{})
+	req.searchAfter, _ = body["search_after"].([]interface{})
+	if pit, ok := body["pit"].(map[string]interface{}); ok {
+		req.pit = toString(pit["id"])
+	}
+	if v, ok := body["sort"]; ok {
+		req.sort, err = parseSort(v)
+	} else if v := r.arg("sort"); v != "" {
+		req.sort, err = parseSort(strings.Split(v, ","))
+	}
+	return req, err
+}
+
+func (s *Server) handleSearch(r *request, indexName string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	names, missing := s.resolveConcrete(indexName)
+	if missing != "" {
+		s.indexNotFound(r, missing)
+		return
+	}
+	req, err := s.parseSearch(r, names)
+	if err != nil {
+		writeError(r.ctx, 400, "parsing_exception", err.Error())
+		return
+	}
+	if req.pit != "" {
+		s.handlePITSearch(r, indexName, req)
+		return
+	}
+	hits, err := s.search(req)
+	if err != nil {
+		writeError(r.ctx, 400, "parsing_exception", err.Error())
+		return
+	}
+	total := len(hits)
+	hits, err = searchAfter(req, hits)
+	if err != nil {
+		writeError(r.ctx, 400, "illegal_argument_exception", err.Error())
+		return
+	}
+
+	if r.arg("scroll") != "" {
+		id := randomID()
+		ctx := &scrollContext{search: req, hits: hits}
+		s.scrolls[id] = ctx
+		writeJSON(r.ctx, 200, s.nextScrollPage(id, ctx))
+		return
+	}
+
+	writeJSON(r.ctx, 200, s.searchResponse(req, total, page(hits, req.from, req.size), nil))
+}
+
+func (s *Server) nextScrollPage(id string, ctx *scrollContext) interface{} {
+	hits := page(ctx.hits, ctx.pos, ctx.search.size)
+	ctx.pos += len(hits)
+	return s.searchResponse(ctx.search, len(ctx.hits), hits, map[string]interface{}{"_scroll_id": id})
+}
+
+func (s *Server) handleScroll(r *request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ids := []string{}
+	if len(r.parts) > 2 {
+		ids = strings.Split(r.parts[2], ",")
+	} else if v := r.arg("scroll_id"); v != "" {
+		ids = []string{v}
+	} else if body, err := r.bodyMap(); err == nil {
+		switch v := body["scroll_id"].(type) {
+		case string:
+			ids = []string{v}
+		case []interface{}:
+			for _, x := range v {
+				ids = append(ids, toString(x))
+			}
+		}
+	} else if v := strings.TrimSpace(string(r.body)); v != "" {
+		ids = strings.Split(v, ",")
+	}
+
+	if r.method == "DELETE" {
+		freed := 0
+		for _, id := range ids {
+			if id == "_all" {
+				freed += len(s.scrolls)
+				s.scrolls = map[string]*scrollContext{}
+				continue
+			}
+			if _, ok := s.scrolls[id]; ok {
+				delete(s.scrolls, id)
+				freed++
+			}
+		}
+		status := 200
+		if freed == 0 {
+			status = 404
+		}
+		writeJSON(r.ctx, status, map[string]interface{}{"succeeded": true, "num_freed": freed})
+		return
+	}
+
+	if len(ids) == 0 {
+		writeError(r.ctx, 400, "action_request_validation_exception", "Validation Failed: 1: scrollId is missing;")
+		return
+	}
+	ctx, ok := s.scrolls[ids[0]]
+	if !ok {
+		writeError(r.ctx, 404, "search_context_missing_exception", "No search context found for id ["+ids[0]+"]")
+		return
+	}
+	writeJSON(r.ctx, 200, s.nextScrollPage(ids[0], ctx))
+}
+
+func (s *Server) handleCount(r *request, indexName string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	names, missing := s.resolveConcrete(indexName)
+	if missing != "" {
+		s.indexNotFound(r, missing)
+		return
+	}
+	req, err := s.parseSearch(r, names)
+	if err != nil {
+		writeError(r.ctx, 400, "parsing_exception", err.Error())
+		return
+	}
+	hits, err := s.search(req)
+	if err != nil {
+		writeError(r.ctx, 400, "parsing_exception", err.Error())
+		return
+	}
+	writeJSON(r.ctx, 200, map[string]interface{}{"count": len(hits), "_shards": s.shardsHeader(names)})
+}
+
+func (s *Server) handleDeleteByQuery(r *request, indexName string) {
+	s.handleByQuery(r, indexName, func(h hit) {
+		idx := s.indices[h.index]
+		delete(idx.docs, h.doc.id)
+		idx.seqNo++
+	}, "deleted")
+}
+
+// handleUpdateByQuery bumps the version of matched documents, scripts are not executed
+func (s *Server) handleUpdateByQuery(r *request, indexName string) {
+	s.handleByQuery(r, indexName, func(h hit) {
+		idx := s.indices[h.index]
+		idx.seqNo++
+		h.doc.version++
+		h.doc.seqNo = idx.seqNo
+	}, "updated")
+}
+
+func (s *Server) handleByQuery(r *request, indexName string, f func(h hit), key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	names, missing := s.resolveConcrete(indexName)
+	if missing != "" {
+		s.indexNotFound(r, missing)
+		return
+	}
+	req, err := s.parseSearch(r, names)
+	if err != nil {
+		writeError(r.ctx, 400, "parsing_exception", err.Error())
+		return
+	}
+	hits, err := s.search(req)
+	if err != nil {
+		writeError(r.ctx, 400, "parsing_exception", err.Error())
+		return
+	}
+	for _, h := range hits {
+		f(h)
+	}
+	writeJSON(r.ctx, 200, map[string]interface{}{
+		"took":              1,
+		"timed_out":         false,
+		"total":             len(hits),
+		key:                 len(hits),
+		"batches":           1,
+		"version_conflicts": 0,
+		"noops":             0,
+		"failures":          []interface{}{},
+	})
+}
+
+// searchResponse builds the search response, extra fields like _scroll_id are
+// added to the root
+func (s *Server) searchResponse(req *searchRequest, total int, hits []hit, extra map[string]interface{}) interface{} {
+	items := []interface{}{}
+	var maxScore interface{}
+	for _, h := range hits {
+		item := map[string]interface{}{
+			"_index": h.index,
+			"_id":    h.doc.id,
+		}
+		if s.includeType() {
+			item["_type"] = h.doc.docType
+		}
+		if h.doc.routing != "" {
+			item["_routing"] = h.doc.routing
+		}
+		if len(req.sort) > 0 {
+			item["_score"] = nil
+			values := []interface{}{}
+			for _, f := range req.sort {
+				values = append(values, sortValue(h, f.field))
+			}
+			item["sort"] = values
+		} else {
+			item["_score"] = 1.0
+			maxScore = 1.0
+		}
+		if source := filterSource(h.doc, req.source); source != nil {
+			item["_source"] = source
+		}
+		items = append(items, item)
+	}
+
+	var totalValue interface{} = total
+	if s.totalAsObject() {
+		totalValue = map[string]interface{}{"value": total, "relation": "eq"}
+	}
+
+	var out interface{}
+	resp := map[string]interface{}{
+		"took":      1,
+		"timed_out": false,
+		"_shards":   s.shardsHeader(req.indices),
+		"hits": map[string]interface{}{
+			"total":     totalValue,
+			"max_score": maxScore,
+			"hits":      items,
+		},
+	}
+	for k, v := range extra {
+		resp[k] = v
+	}
+	out = resp
+	if req.filter != "" {
+		out = filterPaths(resp, req.filter)
+	}
+	return out
+}
+
+func filterSource(doc *document, source interface{}) interface{} {
+	switch v := source.(type) {
+	case nil:
+		return json.RawMessage(doc.source)
+	case bool:
+		if v {
+			return json.RawMessage(doc.source)
+		}
+		return nil
+	case string:
+		return filterPaths(doc.fields, v)
+	case []interface{}:
+		return filterPaths(doc.fields, joinStrings(v))
+	case map[string]interface{}:
+		switch includes := v["includes"].(type) {
+		case []interface{}:
+			return filterPaths(doc.fields, joinStrings(includes))
+		case string:
+			return filterPaths(doc.fields, includes)
+		}
+	}
+	return json.RawMessage(doc.source)
+}
+
+func joinStrings(v []interface{}) string {
+	out := []string{}
+	for _, x := range v {
+		out = append(out, toString(x))
+	}
+	return strings.Join(out, ",")
+}
+
+func page(hits []hit, from, size int) []hit {
+	if from < 0 {
+		from = 0
+	}
+	if from >= len(hits) || size <= 0 {
+		return []hit{}
+	}
+	end := from + size
+	if end > len(hits) {
+		end = len(hits)
+	}
+	return hits[from:end]
+}
+
+// search returns all matched documents in sorted order
+func (s *Server) search(req *searchRequest) ([]hit, error) {
+	candidates := []hit{}
+	for _, name := range req.indices {
+		for _, doc := range s.indices[name].docs {
+			candidates = append(candidates, hit{index: name, doc: doc})
+		}
+	}
+	return matchHits(req, candidates)
+}
+
+// matchHits filters the candidates by slice and query, then sorts them
+func matchHits(req *searchRequest, candidates []hit) ([]hit, error) {
+	hits := []hit{}
+	for _, h := range candidates {
+		if req.slice != nil {
+			max := toInt(req.slice["max"], 1)
+			hash := fnv.New32a()
+			hash.Write([]byte(h.doc.id))
+			if max > 1 && int(hash.Sum32()%uint32(max)) != toInt(req.slice["id"], 0) {
+				continue
+			}
+		}
+		ok := true
+		if req.query != nil {
+			var err error
+			ok, err = matchQuery(h.doc, req.query)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if ok {
+			hits = append(hits, h)
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		for _, f := range req.sort {
+			c := compareSortValues(sortValue(hits[i], f.field), sortValue(hits[j], f.field), f.desc)
+			if c != 0 {
+				return c < 0
+			}
+		}
+		if hits[i].index != hits[j].index {
+			return hits[i].index < hits[j].index
+		}
+		return hits[i].doc.seqNo < hits[j].doc.seqNo
+	})
+	return hits, nil
+}
+
+func parseSort(v interface{}) ([]sortField, error) {
+	fields := []sortField{}
+	switch x := v.(type) {
+	case string:
+		f := sortField{field: x}
+		if i := strings.LastIndex(x, ":"); i > 0 {
+			f.field, f.desc = x[:i], x[i+1:] == "desc"
+		}
+		fields = append(fields, f)
+	case []string:
+		for _, item := range x {
+			f, err := parseSort(item)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f...)
+		}
+	case []interface{}:
+		for _, item := range x {
+			f, err := parseSort(item)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f...)
+		}
+	case map[string]interface{}:
+		for k, order := range x {
+			f := sortField{field: k}
+			switch o := order.(type) {
+			case string:
+				f.desc = o == "desc"
+			case map[string]interface{}:
+				f.desc = o["order"] == "desc"
+			}
+			fields = append(fields, f)
+		}
+	default:
+		return nil, fmt.Errorf("malformed sort [%v]", v)
+	}
+	return fields, nil
+}
+
+func sortValue(h hit, field string) interface{} {
+	switch field {
+	case "_id":
+		return h.doc.id
+	case "_doc":
+		return h.doc.seqNo
+	case "_shard_doc":
+		return h.shardDoc
+	case "_score":
+		return 1.0
+	}
+	values := fieldValues(h.doc, field)
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// compareSortValues puts missing values last for both orders
+func compareSortValues(a, b interface{}, desc bool) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return 1
+		default:
+			return -1
+		}
+	}
+	c := compareValues(a, b)
+	if desc {
+		return -c
+	}
+	return c
+}
+
+func matchQuery(doc *document, query map[string]interface{}) (bool, error) {
+	for name, v := range query {
+		body, _ := v.(map[string]interface{})
+		switch name {
+		case "match_all":
+			continue
+		case "match_none":
+			return false, nil
+		case "bool":
+			ok, err := matchBool(doc, body)
+			if err != nil || !ok {
+				return false, err
+			}
+		case "ids":
+			values, _ := body["values"].([]interface{})
+			if !containsValue(values, doc.id) {
+				return false, nil
+			}
+		case "exists":
+			if len(fieldValues(doc, toString(body["field"]))) == 0 {
+				return false, nil
+			}
+		case "term", "terms", "match", "match_phrase", "prefix", "wildcard", "range":
+			for field, cond := range body {
+				if field == "boost" {
+					continue
+				}
+				if !matchField(name, fieldValues(doc, field), cond) {
+					return false, nil
+				}
+			}
+		default:
+			return false, fmt.Errorf("unknown query [%v]", name)
+		}
+	}
+	return true, nil
+}
+
+func matchBool(doc *document, body map[string]interface{}) (bool, error) {
+	clauses := func(key string) []map[string]interface{} {
+		out := []map[string]interface{}{}
+		switch v := body[key].(type) {
+		case map[string]interface{}:
+			out = append(out, v)
+		case []interface{}:
+			for _, x := range v {
+				if m, ok := x.(map[string]interface{}); ok {
+					out = append(out, m)
+				}
+			}
+		}
+		return out
+	}
+
+	for _, key := range []string{"must", "filter"} {
+		for _, q := range clauses(key) {
+			ok, err := matchQuery(doc, q)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	for _, q := range clauses("must_not") {
+		ok, err := matchQuery(doc, q)
+		if err != nil || ok {
+			return false, err
+		}
+	}
+
+	should := clauses("should")
+	minimum := 0
+	if len(should) > 0 && len(clauses("must")) == 0 && len(clauses("filter")) == 0 {
+		minimum = 1
+	}
+	minimum = toInt(body["minimum_should_match"], minimum)
+	matched := 0
+	for _, q := range should {
+		ok, err := matchQuery(doc, q)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+	return matched >= minimum, nil
+}
+
+func matchField(queryType string, values []interface{}, cond interface{}) bool {
+	if m, ok := cond.(map[string]interface{}); ok && queryType != "range" {
+		for _, key := range []string{"value", "query"} {
+			if v, ok := m[key]; ok {
+				cond = v
+			}
+		}
+	}
+
+	for _, v := range values {
+		switch queryType {
+		case "term":
+			if equalValues(v, cond) {
+				return true
+			}
+		case "terms":
+			if arr, ok := cond.([]interface{}); ok && containsValue(arr, v) {
+				return true
+			}
+		case "match":
+			fieldTokens := tokenize(toString(v))
+			for t := range tokenize(toString(cond)) {
+				if fieldTokens[t] {
+					return true
+				}
+			}
+			if equalValues(v, cond) {
+				return true
+			}
+		case "match_phrase":
+			if strings.Contains(strings.ToLower(toString(v)), strings.ToLower(toString(cond))) {
+				return true
+			}
+		case "prefix":
+			if strings.HasPrefix(toString(v), toString(cond)) {
+				return true
+			}
+		case "wildcard":
+			if matchPattern(toString(cond), toString(v)) {
+				return true
+			}
+		case "range":
+			m, _ := cond.(map[string]interface{})
+			if matchRange(v, m) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchRange(v interface{}, cond map[string]interface{}) bool {
+	for op, bound := range cond {
+		c := compareValues(v, bound)
+		switch op {
+		case "gt":
+			if c <= 0 {
+				return false
+			}
+		case "gte", "from":
+			if c < 0 {
+				return false
+			}
+		case "lt":
+			if c >= 0 {
+				return false
+			}
+		case "lte", "to":
+			if c > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func tokenize(v string) map[string]bool {
+	tokens := map[string]bool{}
+	for _, t := range strings.FieldsFunc(strings.ToLower(v), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		tokens[t] = true
+	}
+	return tokens
+}
+
+// fieldValues returns the flattened values of a dotted field, .keyword
+// sub fields fallback to the field itself
+func fieldValues(doc *document, field string) []interface{} {
+	if field == "_id" {
+		return []interface{}{doc.id}
+	}
+	values := lookup(doc.fields, strings.Split(field, "."))
+	if len(values) == 0 && strings.HasSuffix(field, ".keyword") {
+		values = lookup(doc.fields, strings.Split(strings.TrimSuffix(field, ".keyword"), "."))
+	}
+	return values
+}
+
+func lookup(v interface{}, path []string) []interface{} {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		out := []interface{}{}
+		for _, item := range x {
+			out = append(out, lookup(item, path)...)
+		}
+		return out
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return []interface{}{x}
+		}
+		if len(path) > 1 {
+			if child, ok := x[strings.Join(path, ".")]; ok {
+				return lookup(child, nil)
+			}
+		}
+		child, ok := x[path[0]]
+		if !ok {
+			return nil
+		}
+		return lookup(child, path[1:])
+	}
+	if len(path) > 0 {
+		return nil
+	}
+	return []interface{}{v}
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, x := range values {
+		if equalValues(x, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalValues(a, b interface{}) bool {
+	return compareValues(a, b) == 0
+}
+
+// compareValues compares numerically if either side is a number, otherwise as strings
+func compareValues(a, b interface{}) int {
+	if isNumber(a) || isNumber(b) {
+		fa, ok1 := toFloat(a)
+		fb, ok2 := toFloat(b)
+		if ok1 && ok2 {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(toString(a), toString(b))
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case float64, int, int64:
+		return true
+	}
+	return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toInt(v interface{}, defaultValue int) int {
+	if f, ok := toFloat(v); ok {
+		return int(f)
+	}
+	return defaultValue
+}
+
+func toString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// matchPattern matches names with * wildcards
+func matchPattern(pattern, name string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == name
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, parts[len(parts)-1])
+}
+
+// filterPaths keeps the parts of the value matched by the comma separated
+// paths, like the filter_path parameter and source filtering
+func filterPaths(v interface{}, expr string) interface{} {
+	paths := [][]string{}
+	for _, p := range strings.Split(expr, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, strings.Split(p, "."))
+		}
+	}
+	out := filterValue(v, paths)
+	if out == nil {
+		return map[string]interface{}{}
+	}
+	return out
+}
+
+func filterValue(v interface{}, paths [][]string) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for k, child := range x {
+			whole := false
+			rest := [][]string{}
+			for _, p := range paths {
+				if matchPattern(p[0], k) {
+					if len(p) == 1 {
+						whole = true
+					} else {
+						rest = append(rest, p[1:])
+					}
+				}
+			}
+			if whole {
+				out[k] = child
+			} else if len(rest) > 0 {
+				if c := filterValue(child, rest); c != nil {
+					out[k] = c
+				}
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return out
+	case []interface{}:
+		out := []interface{}{}
+		for _, item := range x {
+			if c := filterValue(item, paths); c != nil {
+				out = append(out, c)
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+// Package elastictest provides an in-process elasticsearch mock server for
+// unit tests, it speaks the subset of the REST API used by the elastic.API
+// adapters, the bulk processor and the elastic orm, and answers with the
+// response shape of the configured distribution and version.
+package elastictest
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+const (
+	Elasticsearch = "elasticsearch"
+	Opensearch    = "opensearch"
+	Easysearch    = "easysearch"
+)
+
+// Interceptor is called before the request is routed, return true if the
+// request was fully handled, used to inject failures like 429 or 5xx
+type Interceptor func(ctx *fasthttp.RequestCtx) bool
+
+type Server struct {
+	Distribution string
+	Version      string
+	ClusterName  string
+	ClusterUUID  string
+	NodeID       string
+	NodeName     string
+
+	major        int
+	minor        int
+	listener     net.Listener
+	server       *fasthttp.Server
+	lock         sync.RWMutex
+	indices      map[string]*index
+	scrolls      map[string]*scrollContext
+	pits         map[string]*pitContext
+	interceptors []Interceptor
+	stateVersion int64
+}
+
+// NewServer starts an elasticsearch mock server of the given version
+func NewServer(version string) *Server {
+	return NewServerWithDistribution(Elasticsearch, version)
+}
+
+// NewServerWithDistribution starts a mock server listening on a random local port
+func NewServerWithDistribution(distribution, version string) *Server {
+	if distribution == "" {
+		distribution = Elasticsearch
+	}
+	parts := strings.Split(version, ".")
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		panic(fmt.Errorf("invalid version [%v]: %v", version, err))
+	}
+	minor := 0
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Errorf("failed to listen on a local port: %v", err))
+	}
+
+	s := &Server{
+		Distribution: distribution,
+		Version:      version,
+		ClusterName:  "elastictest",
+		ClusterUUID:  randomID(),
+		NodeID:       randomID(),
+		NodeName:     "elastictest-node-1",
+		major:        major,
+		minor:        minor,
+		listener:     ln,
+		indices:      map[string]*index{},
+		scrolls:      map[string]*scrollContext{},
+		pits:         map[string]*pitContext{},
+	}
+	s.server = &fasthttp.Server{Handler: s.handle, Name: "elastictest"}
+	go s.server.Serve(ln)
+	return s
+}
+
+// Host returns the address of the server, like 127.0.0.1:9200
+func (s *Server) Host() string {
+	return s.listener.Addr().String()
+}
+
+// URL returns the endpoint of the server, like http://127.0.0.1:9200
+func (s *Server) URL() string {
+	return "http://" + s.Host()
+}
+
+func (s *Server) Close() {
+	s.server.Shutdown()
+}
+
+// Intercept registers a hook which runs before the built-in handlers
+func (s *Server) Intercept(f Interceptor) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.interceptors = append(s.interceptors, f)
+}
+
+// Reset drops all indices and scroll contexts
+func (s *Server) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.indices = map[string]*index{}
+	s.scrolls = map[string]*scrollContext{}
+	s.pits = map[string]*pitContext{}
+	s.stateVersion++
+}
+
+// CreateIndex creates an empty index with the given number of primary shards
+// and replicas, shards less than 1 fallback to the version default
+func (s *Server) CreateIndex(name string, shards, replicas int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if shards < 1 {
+		shards = s.defaultShards()
+	}
+	s.indices[name] = s.newIndex(name, shards, replicas)
+	s.stateVersion++
+}
+
+// Count returns the number of documents in the index, -1 if it does not exist
+func (s *Server) Count(indexName string) int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	idx, ok := s.indices[indexName]
+	if !ok {
+		return -1
+	}
+	return len(idx.docs)
+}
+
+// Document returns the source of the document
+func (s *Server) Document(indexName, id string) (map[string]interface{}, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	idx, ok := s.indices[indexName]
+	if !ok {
+		return nil, false
+	}
+	doc, ok := idx.docs[id]
+	if !ok {
+		return nil, false
+	}
+	return doc.fields, true
+}
+
+// Shard returns the primary shard the document was routed to, -1 if not found
+func (s *Server) Shard(indexName, id string) int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	idx, ok := s.indices[indexName]
+	if !ok {
+		return -1
+	}
+	doc, ok := idx.docs[id]
+	if !ok {
+		return -1
+	}
+	return doc.shard
+}
+
+// compatible major version of elasticsearch, opensearch and easysearch answer like 7.x
+func (s *Server) esMajor() int {
+	if s.Distribution == Elasticsearch {
+		return s.major
+	}
+	return 7
+}
+
+// whether _type is still returned in responses
+func (s *Server) includeType() bool {
+	switch s.Distribution {
+	case Opensearch:
+		return s.major < 2
+	case Easysearch:
+		return false
+	}
+	return s.major < 8
+}
+
+func (s *Server) defaultType() string {
+	if s.esMajor() < 7 {
+		return "doc"
+	}
+	return "_doc"
+}
+
+func (s *Server) defaultShards() int {
+	if s.esMajor() < 7 {
+		return 5
+	}
+	return 1
+}
+
+func (s *Server) totalAsObject() bool {
+	return s.esMajor() >= 7
+}
+
+func (s *Server) withResult() bool {
+	return s.esMajor() >= 5
+}
+
+func (s *Server) withSeqNo() bool {
+	return s.esMajor() >= 6
+}
+
+func (s *Server) atLeast(major, minor int) bool {
+	return s.major > major || (s.major == major && s.minor >= minor)
+}
+
+// point in time is available since elasticsearch 7.10 and opensearch 2.4
+func (s *Server) supportPIT() bool {
+	switch s.Distribution {
+	case Elasticsearch:
+		return s.atLeast(7, 10)
+	case Opensearch:
+		return s.atLeast(2, 4)
+	}
+	return false
+}
+
+func (s *Server) handle(ctx *fasthttp.RequestCtx) {
+	defer func() {
+		if r := recover(); r != nil {
+			writeError(ctx, 500, "exception", fmt.Sprint(r))
+		}
+	}()
+
+	s.lock.RLock()
+	interceptors := s.interceptors
+	s.lock.RUnlock()
+	for _, f := range interceptors {
+		if f(ctx) {
+			return
+		}
+	}
+
+	method := string(ctx.Method())
+	parts := splitPath(string(ctx.Path()))
+	body, err := requestBody(ctx)
+	if err != nil {
+		writeError(ctx, 400, "parse_exception", err.Error())
+		return
+	}
+
+	req := &request{ctx: ctx, method: method, parts: parts, body: body}
+	s.route(req)
+}
+
+type request struct {
+	ctx    *fasthttp.RequestCtx
+	method string
+	parts  []string
+	body   []byte
+}
+
+func (r *request) arg(key string) string {
+	return string(r.ctx.QueryArgs().Peek(key))
+}
+
+func (r *request) hasArg(key string) bool {
+	return r.ctx.QueryArgs().Has(key)
+}
+
+func (r *request) intArg(key string, defaultValue int) int {
+	v := r.arg(key)
+	if v == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return defaultValue
+	}
+	return i
+}
+
+func (r *request) bodyMap() (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	if len(strings.TrimSpace(string(r.body))) == 0 {
+		return m, nil
+	}
+	err := json.Unmarshal(r.body, &m)
+	return m, err
+}
+
+func requestBody(ctx *fasthttp.RequestCtx) ([]byte, error) {
+	if strings.Contains(string(ctx.Request.Header.Peek(fasthttp.HeaderContentEncoding)), "gzip") {
+		return ctx.Request.BodyGunzip()
+	}
+	//fasthttp reuses the request buffer, stored documents need their own copy
+	return append([]byte(nil), ctx.PostBody()...), nil
+}
+
+func splitPath(path string) []string {
+	parts := []string{}
+	for _, v := range strings.Split(path, "/") {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return parts
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json; charset=UTF-8")
+	if !ctx.IsHead() {
+		ctx.SetBody(data)
+	}
+}
+
+func writeError(ctx *fasthttp.RequestCtx, status int, errType, reason string) {
+	writeJSON(ctx, status, errorBody(status, errType, reason))
+}
+
+func errorBody(status int, errType, reason string) map[string]interface{} {
+	cause := map[string]interface{}{"type": errType, "reason": reason}
+	return map[string]interface{}{
+		"error": map[string]interface{}{
+			"root_cause": []interface{}{cause},
+			"type":       errType,
+			"reason":     reason,
+		},
+		"status": status,
+	}
+}
+
+var idSeq int64
+
+func randomID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + strconv.FormatInt(atomic.AddInt64(&idSeq, 1), 36)
+}
@@ -0,0 +1,345 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastictest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func doRequest(t *testing.T, method, url, body string) (int, map[string]interface{}) {
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	assert.Nil(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	out := map[string]interface{}{}
+	if len(data) > 0 && data[0] == '{' {
+		assert.Nil(t, json.Unmarshal(data, &out))
+	}
+	return resp.StatusCode, out
+}
+
+func TestVersionInfo(t *testing.T) {
+	s := NewServer("7.10.2")
+	defer s.Close()
+	code, out := doRequest(t, "GET", s.URL(), "")
+	assert.Equal(t, 200, code)
+	version := out["version"].(map[string]interface{})
+	assert.Equal(t, "7.10.2", version["number"])
+	assert.Nil(t, version["distribution"])
+
+	o := NewServerWithDistribution(Opensearch, "2.11.0")
+	defer o.Close()
+	_, out = doRequest(t, "GET", o.URL(), "")
+	assert.Equal(t, "opensearch", out["version"].(map[string]interface{})["distribution"])
+}
+
+func TestVersionSpecificShapes(t *testing.T) {
+	v6 := NewServer("6.8.0")
+	defer v6.Close()
+	code, out := doRequest(t, "PUT", v6.URL()+"/test/doc/1", `{"name":"medcl"}`)
+	assert.Equal(t, 201, code)
+	assert.Equal(t, "doc", out["_type"])
+	assert.Equal(t, "created", out["result"])
+
+	_, out = doRequest(t, "POST", v6.URL()+"/test/_search", `{"query":{"term":{"name":"medcl"}}}`)
+	assert.Equal(t, float64(1), out["hits"].(map[string]interface{})["total"])
+
+	_, out = doRequest(t, "GET", v6.URL()+"/test/_mapping", "")
+	mappings := out["test"].(map[string]interface{})["mappings"].(map[string]interface{})
+	assert.NotNil(t, mappings["doc"])
+
+	v8 := NewServer("8.11.0")
+	defer v8.Close()
+	code, out = doRequest(t, "PUT", v8.URL()+"/test/_doc/1", `{"name":"medcl"}`)
+	assert.Equal(t, 201, code)
+	assert.Nil(t, out["_type"])
+
+	_, out = doRequest(t, "POST", v8.URL()+"/test/_search", `{"query":{"match":{"name":"Medcl"}}}`)
+	total := out["hits"].(map[string]interface{})["total"].(map[string]interface{})
+	assert.Equal(t, float64(1), total["value"])
+
+	_, out = doRequest(t, "GET", v8.URL()+"/test/_mapping", "")
+	mappings = out["test"].(map[string]interface{})["mappings"].(map[string]interface{})
+	assert.NotNil(t, mappings["properties"])
+
+	v2 := NewServer("2.4.6")
+	defer v2.Close()
+	_, out = doRequest(t, "PUT", v2.URL()+"/test/doc/1", `{"name":"medcl"}`)
+	assert.Equal(t, true, out["created"])
+	assert.Nil(t, out["result"])
+}
+
+func TestDocumentAPI(t *testing.T) {
+	s := NewServer("7.17.0")
+	defer s.Close()
+
+	code, out := doRequest(t, "GET", s.URL()+"/test/_doc/1", "")
+	assert.Equal(t, 404, code)
+	assert.Equal(t, "index_not_found_exception", out["error"].(map[string]interface{})["type"])
+
+	doRequest(t, "PUT", s.URL()+"/test/_doc/1", `{"name":"medcl","age":18}`)
+	code, out = doRequest(t, "POST", s.URL()+"/test/_update/1", `{"doc":{"age":19}}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, float64(2), out["_version"])
+
+	code, out = doRequest(t, "GET", s.URL()+"/test/_doc/1", "")
+	assert.Equal(t, 200, code)
+	source := out["_source"].(map[string]interface{})
+	assert.Equal(t, "medcl", source["name"])
+	assert.Equal(t, float64(19), source["age"])
+
+	code, _ = doRequest(t, "PUT", s.URL()+"/test/_create/1", `{"name":"medcl"}`)
+	assert.Equal(t, 409, code)
+
+	code, out = doRequest(t, "DELETE", s.URL()+"/test/_doc/1", "")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "deleted", out["result"])
+
+	code, out = doRequest(t, "DELETE", s.URL()+"/test/_doc/1", "")
+	assert.Equal(t, 404, code)
+	assert.Equal(t, "not_found", out["result"])
+	assert.Equal(t, 0, s.Count("test"))
+}
+
+func TestBulk(t *testing.T) {
+	s := NewServer("7.10.2")
+	defer s.Close()
+
+	body := `{"index":{"_index":"test","_id":"1"}}
+{"name":"a"}
+{"create":{"_index":"test","_id":"1"}}
+{"name":"b"}
+{"update":{"_index":"test","_id":"2"}}
+{"doc":{"name":"c"}}
+{"delete":{"_index":"test","_id":"1"}}
+`
+	code, out := doRequest(t, "POST", s.URL()+"/_bulk", body)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, true, out["errors"])
+	items := out["items"].([]interface{})
+	assert.Equal(t, 4, len(items))
+	status := []float64{}
+	for _, item := range items {
+		for _, v := range item.(map[string]interface{}) {
+			status = append(status, v.(map[string]interface{})["status"].(float64))
+		}
+	}
+	assert.Equal(t, []float64{201, 409, 404, 200}, status)
+
+	code, out = doRequest(t, "POST", s.URL()+"/test/_bulk?filter_path=items.*.error", "{\"index\":{\"_id\":\"3\"}}\n{\"name\":\"d\"}\n")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 0, len(out))
+	assert.Equal(t, 1, s.Count("test"))
+
+	code, out = doRequest(t, "POST", s.URL()+"/_bulk", "{\"index\":{\"_index\":\"test\"}}\n{\"name\":\n")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, true, out["errors"])
+}
+
+func TestSearchAndScroll(t *testing.T) {
+	s := NewServer("7.10.2")
+	defer s.Close()
+
+	body := bytes.Buffer{}
+	for i := 0; i < 25; i++ {
+		body.WriteString(`{"index":{"_index":"test"}}` + "\n")
+		doc, _ := json.Marshal(map[string]interface{}{"seq": i, "even": i%2 == 0, "tags": []string{"a", "b"}})
+		body.Write(doc)
+		body.WriteString("\n")
+	}
+	doRequest(t, "POST", s.URL()+"/_bulk", body.String())
+
+	_, out := doRequest(t, "POST", s.URL()+"/test/_search", `{"query":{"bool":{"must":[{"term":{"even":true}},{"range":{"seq":{"gte":10}}}],"must_not":{"term":{"seq":12}}}},"sort":[{"seq":{"order":"desc"}}],"size":2}`)
+	hits := out["hits"].(map[string]interface{})
+	assert.Equal(t, float64(7), hits["total"].(map[string]interface{})["value"])
+	docs := hits["hits"].([]interface{})
+	assert.Equal(t, 2, len(docs))
+	assert.Equal(t, float64(24), docs[0].(map[string]interface{})["_source"].(map[string]interface{})["seq"])
+
+	_, out = doRequest(t, "POST", s.URL()+"/test/_count", `{"query":{"terms":{"tags.keyword":["b"]}}}`)
+	assert.Equal(t, float64(25), out["count"])
+
+	_, out = doRequest(t, "POST", s.URL()+"/test/_search?scroll=1m&size=10", `{"sort":["seq"]}`)
+	seen := 0
+	for {
+		docs := out["hits"].(map[string]interface{})["hits"].([]interface{})
+		if len(docs) == 0 {
+			break
+		}
+		for _, doc := range docs {
+			assert.Equal(t, float64(seen), doc.(map[string]interface{})["_source"].(map[string]interface{})["seq"])
+			seen++
+		}
+		_, out = doRequest(t, "POST", s.URL()+"/_search/scroll", `{"scroll":"1m","scroll_id":"`+out["_scroll_id"].(string)+`"}`)
+	}
+	assert.Equal(t, 25, seen)
+
+	code, out := doRequest(t, "DELETE", s.URL()+"/_search/scroll", `{"scroll_id":"`+out["_scroll_id"].(string)+`"}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, float64(1), out["num_freed"])
+
+	code, out = doRequest(t, "POST", s.URL()+"/test/_delete_by_query", `{"query":{"term":{"even":false}}}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, float64(12), out["deleted"])
+	assert.Equal(t, 13, s.Count("test"))
+
+	code, _ = doRequest(t, "POST", s.URL()+"/test/_search", `{"query":{"geo_shape":{}}}`)
+	assert.Equal(t, 400, code)
+}
+
+func TestPointInTime(t *testing.T) {
+	for _, v := range []struct {
+		distribution string
+		version      string
+		open         string
+		idKey        string
+		close        string
+	}{
+		{Elasticsearch, "8.11.0", "_pit", "id", `{"id":"%v"}`},
+		{Opensearch, "2.11.0", "_search/point_in_time", "pit_id", `{"pit_id":["%v"]}`},
+	} {
+		s := NewServerWithDistribution(v.distribution, v.version)
+		body := bytes.Buffer{}
+		for i := 0; i < 25; i++ {
+			body.WriteString(`{"index":{"_index":"test"}}` + "\n")
+			body.WriteString(`{"seq":` + strconv.Itoa(i) + "}\n")
+		}
+		doRequest(t, "POST", s.URL()+"/_bulk", body.String())
+
+		code, out := doRequest(t, "POST", s.URL()+"/test/"+v.open+"?keep_alive=1m", "")
+		assert.Equal(t, 200, code)
+		pitID := out[v.idKey].(string)
+
+		// documents written after the point in time was opened are not visible
+		doRequest(t, "POST", s.URL()+"/test/_doc", `{"seq":100}`)
+		code, _ = doRequest(t, "POST", s.URL()+"/test/_search", `{"pit":{"id":"`+pitID+`"}}`)
+		assert.Equal(t, 400, code)
+
+		seen := 0
+		after := "null"
+		for {
+			query := `{"size":10,"sort":[{"seq":"asc"}],"pit":{"id":"` + pitID + `","keep_alive":"1m"}`
+			if after != "null" {
+				query += `,"search_after":` + after
+			}
+			code, out = doRequest(t, "POST", s.URL()+"/_search", query+"}")
+			assert.Equal(t, 200, code)
+			assert.Equal(t, pitID, out["pit_id"])
+			docs := out["hits"].(map[string]interface{})["hits"].([]interface{})
+			if len(docs) == 0 {
+				break
+			}
+			for _, doc := range docs {
+				assert.Equal(t, float64(seen), doc.(map[string]interface{})["_source"].(map[string]interface{})["seq"])
+				seen++
+			}
+			sort, _ := json.Marshal(docs[len(docs)-1].(map[string]interface{})["sort"])
+			after = string(sort)
+		}
+		assert.Equal(t, 25, seen)
+
+		code, _ = doRequest(t, "DELETE", s.URL()+"/"+v.open, fmt.Sprintf(v.close, pitID))
+		assert.Equal(t, 200, code)
+		code, _ = doRequest(t, "POST", s.URL()+"/_search", `{"pit":{"id":"`+pitID+`"}}`)
+		assert.Equal(t, 404, code)
+		s.Close()
+	}
+
+	s := NewServer("7.9.3")
+	defer s.Close()
+	s.CreateIndex("test", 1, 0)
+	code, _ := doRequest(t, "POST", s.URL()+"/test/_pit?keep_alive=1m", "")
+	assert.Equal(t, 400, code)
+}
+
+func TestClusterAPI(t *testing.T) {
+	s := NewServer("7.10.2")
+	defer s.Close()
+
+	s.CreateIndex("test", 3, 0)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6"} {
+		doRequest(t, "PUT", s.URL()+"/test/_doc/"+id, `{}`)
+	}
+
+	_, out := doRequest(t, "GET", s.URL()+"/_cluster/health", "")
+	assert.Equal(t, "green", out["status"])
+	assert.Equal(t, float64(3), out["active_primary_shards"])
+
+	_, out = doRequest(t, "GET", s.URL()+"/_nodes", "")
+	node := out["nodes"].(map[string]interface{})[s.NodeID].(map[string]interface{})
+	assert.Equal(t, s.Host(), node["http"].(map[string]interface{})["publish_address"])
+
+	req, _ := http.NewRequest("GET", s.URL()+"/_cat/shards?format=json&h=index,shard,prirep,docs", nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	rows := []map[string]interface{}{}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&rows))
+	assert.Equal(t, 3, len(rows))
+	docs := 0
+	for _, row := range rows {
+		n := toInt(row["docs"], 0)
+		shard := toInt(row["shard"], 0)
+		for _, id := range []string{"1", "2", "3", "4", "5", "6"} {
+			if s.Shard("test", id) == shard {
+				n--
+			}
+		}
+		assert.Equal(t, 0, n)
+		docs += toInt(row["docs"], 0)
+	}
+	assert.Equal(t, 6, docs)
+}
+
+func TestIntercept(t *testing.T) {
+	s := NewServer("7.10.2")
+	defer s.Close()
+	s.Intercept(func(ctx *fasthttp.RequestCtx) bool {
+		if string(ctx.Path()) == "/_bulk" {
+			writeError(ctx, 429, "es_rejected_execution_exception", "rejected execution")
+			return true
+		}
+		return false
+	})
+	code, out := doRequest(t, "POST", s.URL()+"/_bulk", "{\"index\":{\"_index\":\"test\"}}\n{}\n")
+	assert.Equal(t, 429, code)
+	assert.Equal(t, "es_rejected_execution_exception", out["error"].(map[string]interface{})["type"])
+	assert.Equal(t, -1, s.Count("test"))
+}
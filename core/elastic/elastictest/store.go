@@ -0,0 +1,394 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastictest
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"infini.sh/framework/lib/murmurhash3"
+)
+
+type index struct {
+	name        string
+	uuid        string
+	shards      int
+	replicas    int
+	created     int64
+	mappingType string
+	properties  map[string]interface{}
+	docs        map[string]*document
+	seqNo       int64
+}
+
+type document struct {
+	id      string
+	docType string
+	routing string
+	shard   int
+	version int64
+	seqNo   int64
+	source  []byte
+	fields  map[string]interface{}
+}
+
+func (s *Server) newIndex(name string, shards, replicas int) *index {
+	return &index{
+		name:       name,
+		uuid:       randomID(),
+		shards:     shards,
+		replicas:   replicas,
+		created:    time.Now().UnixNano() / int64(time.Millisecond),
+		properties: map[string]interface{}{},
+		docs:       map[string]*document{},
+	}
+}
+
+// getOrCreateIndex auto creates the index on write, like elasticsearch does by default
+func (s *Server) getOrCreateIndex(name string) *index {
+	idx, ok := s.indices[name]
+	if !ok {
+		idx = s.newIndex(name, s.defaultShards(), 1)
+		s.indices[name] = idx
+		s.stateVersion++
+	}
+	return idx
+}
+
+// shardID follows the routing of elasticsearch, murmur3 hash of the routing
+// key, partitioned by number_of_routing_shards since 7.0
+func (s *Server) shardID(idx *index, key string) int {
+	data := []byte(key)
+	utf16 := make([]byte, len(data)*2)
+	for i, v := range data {
+		utf16[i*2] = v
+	}
+	hash := int(murmurhash3.Murmur3A(utf16, 0))
+
+	routingShards := idx.shards
+	if s.esMajor() >= 7 {
+		log2NumShards := 32 - bits.LeadingZeros32(uint32(idx.shards-1))
+		numSplits := int(math.Max(1, float64(10-log2NumShards)))
+		routingShards = idx.shards * 1 << numSplits
+	}
+
+	mod := hash % routingShards
+	if mod < 0 {
+		mod += routingShards
+	}
+	return mod / (routingShards / idx.shards)
+}
+
+type writeOp struct {
+	action   string
+	index    string
+	docType  string
+	id       string
+	routing  string
+	source   []byte
+	opCreate bool
+	upsert   bool
+	refresh  string
+}
+
+// write applies an index, create, update or delete operation, returns the
+// status code and the item of the response
+func (s *Server) write(op *writeOp) (int, map[string]interface{}) {
+	var idx *index
+	if op.action == "delete" {
+		var ok bool
+		idx, ok = s.indices[op.index]
+		if !ok {
+			return 404, s.itemError(op, 404, "index_not_found_exception", fmt.Sprintf("no such index [%v]", op.index))
+		}
+	} else {
+		idx = s.getOrCreateIndex(op.index)
+	}
+
+	op.docType = s.typeName(op.docType)
+	if op.id == "" {
+		if op.action != "index" && op.action != "create" {
+			return 400, s.itemError(op, 400, "action_request_validation_exception", "Validation Failed: 1: id is missing;")
+		}
+		op.id = randomID()
+	}
+
+	existing := idx.docs[op.id]
+
+	switch op.action {
+	case "delete":
+		if existing == nil {
+			item := s.itemBase(idx, op, 1, idx.seqNo)
+			s.setResult(item, "not_found", false)
+			return 404, item
+		}
+		delete(idx.docs, op.id)
+		idx.seqNo++
+		item := s.itemBase(idx, op, existing.version+1, idx.seqNo)
+		s.setResult(item, "deleted", true)
+		return 200, item
+
+	case "update":
+		var body map[string]interface{}
+		if err := json.Unmarshal(op.source, &body); err != nil {
+			return 400, s.itemError(op, 400, "x_content_parse_exception", err.Error())
+		}
+		if _, ok := body["script"]; ok {
+			return 400, s.itemError(op, 400, "illegal_argument_exception", "scripted updates are not supported by the mock server")
+		}
+		fields := map[string]interface{}{}
+		if existing != nil {
+			fields = copyMap(existing.fields)
+			if doc, ok := body["doc"].(map[string]interface{}); ok {
+				mergeMap(fields, doc)
+			}
+		} else {
+			if v, ok := body["upsert"].(map[string]interface{}); ok {
+				fields = v
+			} else if doc, ok := body["doc"].(map[string]interface{}); ok && (op.upsert || body["doc_as_upsert"] == true) {
+				fields = doc
+			} else {
+				return 404, s.itemError(op, 404, "document_missing_exception", fmt.Sprintf("[%v][%v]: document missing", op.docType, op.id))
+			}
+		}
+		source, err := json.Marshal(fields)
+		if err != nil {
+			panic(err)
+		}
+		op.source = source
+
+	case "create":
+		if existing != nil {
+			return 409, s.itemError(op, 409, "version_conflict_engine_exception", fmt.Sprintf("[%v]: version conflict, document already exists (current version [%v])", op.id, existing.version))
+		}
+	}
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(op.source, &fields); err != nil {
+		return 400, s.itemError(op, 400, "mapper_parsing_exception", "failed to parse: "+err.Error())
+	}
+
+	routing := op.routing
+	if routing == "" {
+		routing = op.id
+	}
+	idx.seqNo++
+	doc := &document{
+		id:      op.id,
+		docType: op.docType,
+		routing: op.routing,
+		shard:   s.shardID(idx, routing),
+		version: 1,
+		seqNo:   idx.seqNo,
+		source:  op.source,
+		fields:  fields,
+	}
+	if existing != nil {
+		doc.version = existing.version + 1
+	}
+	idx.docs[op.id] = doc
+	if idx.mappingType == "" {
+		idx.mappingType = doc.docType
+	}
+	inferMapping(idx.properties, fields)
+
+	item := s.itemBase(idx, op, doc.version, doc.seqNo)
+	if existing == nil {
+		s.setResult(item, "created", true)
+		return 201, item
+	}
+	s.setResult(item, "updated", false)
+	return 200, item
+}
+
+// typeName returns the mapping type stored with the document, types are
+// replaced by _doc since 7.0
+func (s *Server) typeName(docType string) string {
+	if docType == "" || s.esMajor() >= 7 {
+		return s.defaultType()
+	}
+	return docType
+}
+
+func (s *Server) itemBase(idx *index, op *writeOp, version, seqNo int64) map[string]interface{} {
+	item := map[string]interface{}{
+		"_index":   idx.name,
+		"_id":      op.id,
+		"_version": version,
+		"_shards": map[string]interface{}{
+			"total":      idx.replicas + 1,
+			"successful": 1,
+			"failed":     0,
+		},
+	}
+	if s.includeType() {
+		item["_type"] = op.docType
+	}
+	if s.withSeqNo() {
+		item["_seq_no"] = seqNo
+		item["_primary_term"] = 1
+	}
+	return item
+}
+
+// setResult sets result since 5.0, or the legacy created and found flags
+func (s *Server) setResult(item map[string]interface{}, result string, flag bool) {
+	if s.withResult() {
+		item["result"] = result
+		return
+	}
+	switch result {
+	case "created", "updated":
+		item["created"] = flag
+	default:
+		item["found"] = flag
+	}
+}
+
+func (s *Server) itemError(op *writeOp, status int, errType, reason string) map[string]interface{} {
+	item := map[string]interface{}{
+		"_index": op.index,
+		"_id":    op.id,
+		"status": status,
+		"error": map[string]interface{}{
+			"type":   errType,
+			"reason": reason,
+			"index":  op.index,
+		},
+	}
+	if s.includeType() {
+		item["_type"] = op.docType
+	}
+	return item
+}
+
+// inferMapping adds dynamic mappings for new fields of the document
+func inferMapping(properties map[string]interface{}, fields map[string]interface{}) {
+	for k, v := range fields {
+		if arr, ok := v.([]interface{}); ok {
+			if len(arr) == 0 {
+				continue
+			}
+			v = arr[0]
+		}
+		if obj, ok := v.(map[string]interface{}); ok {
+			field, _ := properties[k].(map[string]interface{})
+			if field == nil {
+				field = map[string]interface{}{}
+				properties[k] = field
+			}
+			props, _ := field["properties"].(map[string]interface{})
+			if props == nil {
+				props = map[string]interface{}{}
+				field["properties"] = props
+			}
+			inferMapping(props, obj)
+			continue
+		}
+		if _, ok := properties[k]; ok || v == nil {
+			continue
+		}
+		switch x := v.(type) {
+		case bool:
+			properties[k] = map[string]interface{}{"type": "boolean"}
+		case float64:
+			if x == math.Trunc(x) {
+				properties[k] = map[string]interface{}{"type": "long"}
+			} else {
+				properties[k] = map[string]interface{}{"type": "float"}
+			}
+		case string:
+			properties[k] = map[string]interface{}{
+				"type": "text",
+				"fields": map[string]interface{}{
+					"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256},
+				},
+			}
+		}
+	}
+}
+
+func mergeMap(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sub, ok := v.(map[string]interface{}); ok {
+			if d, ok := dst[k].(map[string]interface{}); ok {
+				mergeMap(d, sub)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	data, err := json.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (idx *index) settings() map[string]interface{} {
+	return map[string]interface{}{
+		"index": map[string]interface{}{
+			"number_of_shards":   strconv.Itoa(idx.shards),
+			"number_of_replicas": strconv.Itoa(idx.replicas),
+			"uuid":               idx.uuid,
+			"provided_name":      idx.name,
+			"creation_date":      strconv.FormatInt(idx.created, 10),
+		},
+	}
+}
+
+func (idx *index) storeSize(shard int) int {
+	size := 0
+	for _, doc := range idx.docs {
+		if doc.shard == shard {
+			size += len(doc.source)
+		}
+	}
+	return size
+}
+
+func (idx *index) docCount(shard int) int {
+	count := 0
+	for _, doc := range idx.docs {
+		if doc.shard == shard {
+			count++
+		}
+	}
+	return count
+}
@@ -73,29 +73,7 @@ func GetPartitions(q *PartitionQuery, client API) ([]PartitionInfo, error) {
 	if q == nil {
 		return nil, fmt.Errorf("patition query can not be empty")
 	}
-	var (
-		vFilter interface{}
-		must    []interface{}
-	)
-	if q.Filter != nil {
-		must = append(must, q.Filter)
-	}
-	if docType := strings.TrimSpace(q.DocType); docType != "" {
-		must = append(must, util.MapStr{
-			"term": util.MapStr{
-				"_type": util.MapStr{
-					"value": docType,
-				},
-			},
-		})
-	}
-	if len(must) > 0 {
-		vFilter = util.MapStr{
-			"bool": util.MapStr{
-				"must": must,
-			},
-		}
-	}
+	vFilter := buildQueryFilter(q)
 
 	switch q.FieldType {
 	case PartitionByDate, PartitionByNumber:
@@ -154,6 +132,51 @@ func GetPartitions(q *PartitionQuery, client API) ([]PartitionInfo, error) {
 	}
 }
 
+// buildQueryFilter combines the filter and doc type of the partition query
+func buildQueryFilter(q *PartitionQuery) interface{} {
+	var must []interface{}
+	if q.Filter != nil {
+		must = append(must, q.Filter)
+	}
+	if docType := strings.TrimSpace(q.DocType); docType != "" {
+		must = append(must, util.MapStr{
+			"term": util.MapStr{
+				"_type": util.MapStr{
+					"value": docType,
+				},
+			},
+		})
+	}
+	if len(must) == 0 {
+		return nil
+	}
+	return util.MapStr{
+		"bool": util.MapStr{
+			"must": must,
+		},
+	}
+}
+
+// NewPartitionIterator iterates the documents of a partition returned by GetPartitions,
+// with point in time and search_after if the cluster supports it, otherwise scroll
+func NewPartitionIterator(client API, q *PartitionQuery, partition *PartitionInfo, size int, keepAlive string) *DocumentIterator {
+	var query interface{}
+	if partition.Filter != nil {
+		query = partition.Filter
+	}
+	// the filter of the other partition only checks the missing field
+	if partition.Other && query != nil {
+		if vFilter := buildQueryFilter(q); vFilter != nil {
+			query = util.MapStr{
+				"bool": util.MapStr{
+					"must": []interface{}{partition.Filter, vFilter},
+				},
+			}
+		}
+	}
+	return NewDocumentIterator(client, q.IndexName, query, size, keepAlive)
+}
+
 func getPartitionsByAgg(client API, indexName string, fieldName, fieldType string, step float64, filter interface{}) ([]PartitionInfo, error) {
 	queryDsl := util.MapStr{
 		"size": 0,
@@ -0,0 +1,259 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastic
+
+import (
+	"github.com/segmentio/encoding/json"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+var ErrPointInTimeNotSupported = errors.New("point in time is not supported")
+
+type SearchHit struct {
+	IndexDocument
+	// sort values are kept raw, long values like _shard_doc may overflow float64
+	Sort []json.RawMessage `json:"sort,omitempty"`
+}
+
+type SearchAfterResponse struct {
+	Took     int    `json:"took,omitempty"`
+	PitID    string `json:"pit_id,omitempty"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+	Hits     struct {
+		Total interface{} `json:"total,omitempty"`
+		Docs  []SearchHit `json:"hits,omitempty"`
+	} `json:"hits"`
+	Shards ShardResponse `json:"_shards,omitempty"`
+}
+
+func (response *SearchAfterResponse) GetHitsTotal() int64 {
+	if util.TypeIsMap(response.Hits.Total) {
+		v := response.Hits.Total.(map[string]interface{})
+		return util.GetInt64Value(v["value"])
+	}
+	return util.GetInt64Value(response.Hits.Total)
+}
+
+// GetSearchAfter returns the sort values of the last hit, used to fetch the next page
+func (response *SearchAfterResponse) GetSearchAfter() []interface{} {
+	if len(response.Hits.Docs) == 0 {
+		return nil
+	}
+	sort := response.Hits.Docs[len(response.Hits.Docs)-1].Sort
+	values := make([]interface{}, len(sort))
+	for i, v := range sort {
+		values[i] = v
+	}
+	return values
+}
+
+// DocumentIterator walks through all the documents matched by a query, with
+// point in time and search_after if the cluster supports it, otherwise it
+// falls back to scroll
+type DocumentIterator struct {
+	client     API
+	indexNames string
+	query      interface{}
+	size       int
+	keepAlive  string
+
+	started     bool
+	done        bool
+	total       int64
+	pitID       string
+	scrollID    string
+	searchAfter []interface{}
+}
+
+// NewDocumentIterator creates an iterator over the indices, query is the query
+// clause of the search body, nil to match all documents
+func NewDocumentIterator(client API, indexNames string, query interface{}, size int, keepAlive string) *DocumentIterator {
+	if size <= 0 {
+		size = 1000
+	}
+	if keepAlive == "" {
+		keepAlive = "5m"
+	}
+	return &DocumentIterator{
+		client:     client,
+		indexNames: indexNames,
+		query:      query,
+		size:       size,
+		keepAlive:  keepAlive,
+	}
+}
+
+// UsePointInTime returns true if the iterator is backed by point in time
+func (it *DocumentIterator) UsePointInTime() bool {
+	return it.pitID != ""
+}
+
+// Total returns the total hits reported by the first page
+func (it *DocumentIterator) Total() int64 {
+	return it.total
+}
+
+// Next returns the next page of documents, an empty page means all documents
+// were consumed, the request and response of ctx are reset before each call
+func (it *DocumentIterator) Next(ctx *APIContext) ([]IndexDocument, error) {
+	if it.done {
+		return nil, nil
+	}
+	ctx.Request.Reset()
+	ctx.Response.Reset()
+
+	if !it.started {
+		it.started = true
+		if !it.client.SupportPointInTime() {
+			return it.newScroll(ctx)
+		}
+		pitID, err := it.client.OpenPointInTime(it.indexNames, it.keepAlive)
+		if err != nil {
+			return nil, err
+		}
+		it.pitID = pitID
+	}
+
+	if it.pitID != "" {
+		return it.nextSearchAfter(ctx)
+	}
+	return it.nextScroll(ctx)
+}
+
+// Close releases the point in time or scroll context held by the iterator
+func (it *DocumentIterator) Close() error {
+	it.done = true
+	if it.pitID != "" {
+		pitID := it.pitID
+		it.pitID = ""
+		return it.client.ClosePointInTime(pitID)
+	}
+	if it.scrollID != "" {
+		scrollID := it.scrollID
+		it.scrollID = ""
+		return it.client.ClearScroll(scrollID)
+	}
+	return nil
+}
+
+func (it *DocumentIterator) newRequest() *SearchRequest {
+	req := &SearchRequest{Size: it.size}
+	if it.query != nil {
+		req.Set("query", it.query)
+	}
+	return req
+}
+
+func (it *DocumentIterator) nextSearchAfter(ctx *APIContext) ([]IndexDocument, error) {
+	data, err := it.client.SearchAfter(ctx, it.pitID, it.keepAlive, it.newRequest(), it.searchAfter)
+	if err != nil {
+		return nil, err
+	}
+	resp := SearchAfterResponse{}
+	err = util.FromJSONBytes(data, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if it.searchAfter == nil {
+		it.total = resp.GetHitsTotal()
+	}
+	// the id of point in time may change between requests
+	if resp.PitID != "" {
+		it.pitID = resp.PitID
+	}
+	it.searchAfter = resp.GetSearchAfter()
+	if len(resp.Hits.Docs) < it.size {
+		it.done = true
+	}
+
+	docs := make([]IndexDocument, len(resp.Hits.Docs))
+	for i, hit := range resp.Hits.Docs {
+		docs[i] = hit.IndexDocument
+	}
+	return docs, nil
+}
+
+func (it *DocumentIterator) newScroll(ctx *APIContext) ([]IndexDocument, error) {
+	data, err := it.client.NewScroll(it.indexNames, it.keepAlive, it.size, it.newRequest(), 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := it.parseScroll(data)
+	if err != nil {
+		return nil, err
+	}
+	it.total = resp.GetHitsTotal()
+	it.scrollID = resp.GetScrollId()
+	docs := resp.GetDocs()
+	// the first page of a scan scroll is always empty
+	if len(docs) == 0 && it.total > 0 {
+		ctx.Request.Reset()
+		ctx.Response.Reset()
+		return it.nextScroll(ctx)
+	}
+	if len(docs) == 0 {
+		it.done = true
+	}
+	return docs, nil
+}
+
+func (it *DocumentIterator) nextScroll(ctx *APIContext) ([]IndexDocument, error) {
+	data, err := it.client.NextScroll(ctx, it.keepAlive, it.scrollID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := it.parseScroll(data)
+	if err != nil {
+		return nil, err
+	}
+	if id := resp.GetScrollId(); id != "" {
+		it.scrollID = id
+	}
+	docs := resp.GetDocs()
+	if len(docs) == 0 {
+		it.done = true
+	}
+	return docs, nil
+}
+
+func (it *DocumentIterator) parseScroll(data []byte) (ScrollResponseAPI, error) {
+	var resp ScrollResponseAPI
+	ver := it.client.GetVersion()
+	if ver.Distribution == Easysearch || ver.Distribution == Opensearch || ver.Major >= 7 {
+		resp = &ScrollResponseV7{}
+	} else {
+		resp = &ScrollResponse{}
+	}
+	err := util.FromJSONBytes(data, resp)
+	return resp, err
+}
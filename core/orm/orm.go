@@ -510,6 +510,11 @@ func InitSchema() error {
 
 var handler ORM
 
+// HasHandler return true if any ORM handler was registered
+func HasHandler() bool {
+	return handler != nil
+}
+
 func getHandler() ORM {
 	if handler == nil {
 		panic(errors.New("ORM handler is not registered"))
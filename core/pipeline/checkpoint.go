@@ -0,0 +1,176 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/util"
+)
+
+// CheckpointBucket holds the checkpoint state of pipeline tasks, keyed by the task name,
+// processors save their progress through the context, and the state is passed back when the task is created again
+const CheckpointBucket = "pipeline_checkpoint"
+
+type Checkpoint struct {
+	TaskID  string      `json:"task_id"`
+	Version int64       `json:"version"`
+	Updated time.Time   `json:"updated"`
+	State   util.MapStr `json:"state"`
+}
+
+func (ctx *Context) root() *Context {
+	c := ctx
+	for c.ParentContext != nil {
+		c = c.ParentContext
+	}
+	return c
+}
+
+// SaveCheckpoint save the state of the key and persist the whole checkpoint of the task
+func (ctx *Context) SaveCheckpoint(key string, value interface{}) error {
+	c := ctx.root()
+	c.checkpointLock.Lock()
+	defer c.checkpointLock.Unlock()
+	if c.checkpoint == nil {
+		c.checkpoint = &Checkpoint{TaskID: c.Config.Name, State: util.MapStr{}}
+	}
+	c.checkpoint.State[key] = value
+	return c.persistCheckpoint()
+}
+
+// ClearCheckpoint remove the state of the key, usually called after the processor finished its job
+func (ctx *Context) ClearCheckpoint(key string) error {
+	c := ctx.root()
+	c.checkpointLock.Lock()
+	defer c.checkpointLock.Unlock()
+	if c.checkpoint == nil {
+		return nil
+	}
+	if _, ok := c.checkpoint.State[key]; !ok {
+		return nil
+	}
+	delete(c.checkpoint.State, key)
+	return c.persistCheckpoint()
+}
+
+// GetCheckpoint return the state of the key, restored values are decoded from json
+func (ctx *Context) GetCheckpoint(key string) (interface{}, bool) {
+	c := ctx.root()
+	c.checkpointLock.RLock()
+	defer c.checkpointLock.RUnlock()
+	if c.checkpoint == nil {
+		return nil, false
+	}
+	v, ok := c.checkpoint.State[key]
+	return v, ok
+}
+
+// UnpackCheckpoint decode the state of the key into v
+func (ctx *Context) UnpackCheckpoint(key string, v interface{}) (bool, error) {
+	obj, ok := ctx.GetCheckpoint(key)
+	if !ok {
+		return false, nil
+	}
+	err := util.FromJSONBytes(util.MustToJSONBytes(obj), v)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (ctx *Context) GetCheckpointInfo() *Checkpoint {
+	c := ctx.root()
+	c.checkpointLock.RLock()
+	defer c.checkpointLock.RUnlock()
+	if c.checkpoint == nil {
+		return nil
+	}
+	cp := *c.checkpoint
+	cp.State = c.checkpoint.State.Clone()
+	return &cp
+}
+
+// RestoreCheckpoint pass the saved checkpoint back to the context, should be called before the pipeline started
+func (ctx *Context) RestoreCheckpoint(checkpoint *Checkpoint) {
+	if checkpoint == nil {
+		return
+	}
+	ctx.checkpointLock.Lock()
+	defer ctx.checkpointLock.Unlock()
+	if checkpoint.State == nil {
+		checkpoint.State = util.MapStr{}
+	}
+	ctx.checkpoint = checkpoint
+}
+
+// ResetCheckpoint drop the checkpoint of the task, the next run starts from scratch
+func (ctx *Context) ResetCheckpoint() error {
+	c := ctx.root()
+	c.checkpointLock.Lock()
+	defer c.checkpointLock.Unlock()
+	c.checkpoint = nil
+	return DeleteCheckpoint(c.Config.Name)
+}
+
+// persistCheckpoint must be called after holding checkpointLock
+func (ctx *Context) persistCheckpoint() error {
+	ctx.checkpoint.Version++
+	ctx.checkpoint.Updated = time.Now()
+	if ctx.Config.Name == "" || ctx.Config.Transient {
+		return nil
+	}
+	return kv.AddValue(CheckpointBucket, []byte(ctx.Config.Name), util.MustToJSONBytes(ctx.checkpoint))
+}
+
+func LoadCheckpoint(taskID string) (*Checkpoint, error) {
+	data, err := kv.GetValue(CheckpointBucket, []byte(taskID))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	checkpoint := &Checkpoint{}
+	err = util.FromJSONBytes(data, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+func DeleteCheckpoint(taskID string) error {
+	exists, err := kv.ExistsKey(CheckpointBucket, []byte(taskID))
+	if err != nil || !exists {
+		return err
+	}
+	log.Debugf("deleting checkpoint of pipeline [%v]", taskID)
+	return kv.DeleteKey(CheckpointBucket, []byte(taskID))
+}
@@ -0,0 +1,67 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextCheckpoint(t *testing.T) {
+	ctx := AcquireContext(PipelineConfigV2{})
+	child := AcquireContext(PipelineConfigV2{})
+	child.ParentContext = ctx
+
+	_, ok := ctx.GetCheckpoint("offset")
+	assert.Equal(t, false, ok)
+	assert.Nil(t, ctx.GetCheckpointInfo())
+
+	type state struct {
+		Offset int64 `json:"offset"`
+	}
+	assert.Nil(t, child.SaveCheckpoint("offset", state{Offset: 100}))
+
+	//child contexts share the checkpoint of the task
+	v := state{}
+	ok, err := ctx.UnpackCheckpoint("offset", &v)
+	assert.Nil(t, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(100), v.Offset)
+	assert.Equal(t, int64(1), ctx.GetCheckpointInfo().Version)
+
+	//checkpoint survives the reset between runs
+	ctx.ResetContext()
+	_, ok = ctx.GetCheckpoint("offset")
+	assert.Equal(t, true, ok)
+
+	assert.Nil(t, ctx.ClearCheckpoint("offset"))
+	_, ok = ctx.GetCheckpoint("offset")
+	assert.Equal(t, false, ok)
+	assert.Equal(t, int64(2), ctx.GetCheckpointInfo().Version)
+}
@@ -145,6 +145,10 @@ type IfThenElseProcessor struct {
 
 // NewIfElseThenProcessor construct a new IfThenElseProcessor.
 func NewIfElseThenProcessor(cfg *config.Config) (*IfThenElseProcessor, error) {
+	return newIfElseThenProcessor(cfg, false)
+}
+
+func newIfElseThenProcessor(cfg *config.Config, simulation bool) (*IfThenElseProcessor, error) {
 	var tempConfig ifThenElseConfig
 	if err := cfg.Unpack(&tempConfig); err != nil {
 		return nil, err
@@ -160,14 +164,14 @@ func NewIfElseThenProcessor(cfg *config.Config) (*IfThenElseProcessor, error) {
 			return nil, nil
 		}
 		if !c.IsArray() {
-			return NewPipeline([]*config.Config{c})
+			return newPipeline([]*config.Config{c}, simulation)
 		}
 
 		var pc []*config.Config
 		if err := c.Unpack(&pc); err != nil {
 			return nil, err
 		}
-		return NewPipeline(pc)
+		return newPipeline(pc, simulation)
 	}
 
 	var ifProcessors, elseProcessors *Processors
@@ -24,6 +24,8 @@
 package pipeline
 
 import (
+	"sort"
+
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/util"
@@ -40,6 +42,18 @@ type PipelineConfigV2 struct {
 	Logging        struct {
 		Enabled bool `config:"enabled" json:"enabled"`
 	} `config:"logging" json:"logging"`
+	//record the spans of each processor for every run
+	Tracing struct {
+		Enabled  bool `config:"enabled" json:"enabled"`
+		MaxSpans int  `config:"max_spans" json:"max_spans,omitempty"`
+	} `config:"tracing" json:"tracing"`
+	//start the pipeline on a crontab or interval
+	Schedule *ScheduleConfig `config:"schedule" json:"schedule,omitempty"`
+	//limit the concurrency and resources together with other pipelines of the same group
+	ResourceGroup string `config:"resource_group" json:"resource_group,omitempty"`
+	//wait for other pipelines to end before start, waiting_after of the queue processors only waits for queues to be drained
+	DependsOn *DependsOnConfig `config:"depends_on" json:"depends_on,omitempty"`
+
 	Processors []*config.Config       `config:"processor" json:"-"`
 	Labels     map[string]interface{} `config:"labels" json:"labels"`
 
@@ -57,6 +71,10 @@ func (this PipelineConfigV2) Equals(target PipelineConfigV2) bool {
 		this.KeepRunning != target.KeepRunning ||
 		this.RetryDelayInMs != target.RetryDelayInMs ||
 		this.Logging.Enabled != target.Logging.Enabled ||
+		this.Tracing != target.Tracing ||
+		this.ResourceGroup != target.ResourceGroup ||
+		util.MustToJSON(this.DependsOn) != util.MustToJSON(target.DependsOn) ||
+		util.MustToJSON(this.Schedule) != util.MustToJSON(target.Schedule) ||
 		!this.ProcessorsEquals(target) {
 		return false
 	}
@@ -94,3 +112,30 @@ func (this PipelineConfigV2) ProcessorsEquals(target PipelineConfigV2) bool {
 	}
 	return true
 }
+
+// DiffConfigs compare the running pipelines with the new configs, return the names of the pipelines to start,
+// stop and restart, transient pipelines are created by api and never touched
+func DiffConfigs(running map[string]PipelineConfigV2, configs []PipelineConfigV2) (added, removed, changed []string) {
+	newConfigs := map[string]PipelineConfigV2{}
+	for _, v := range configs {
+		newConfigs[v.Name] = v
+		old, ok := running[v.Name]
+		if !ok {
+			added = append(added, v.Name)
+		} else if !old.Transient && !v.Equals(old) {
+			changed = append(changed, v.Name)
+		}
+	}
+	for k, v := range running {
+		if v.Transient {
+			continue
+		}
+		if _, ok := newConfigs[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
@@ -0,0 +1,55 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	running := map[string]PipelineConfigV2{
+		"same":      {Name: "same", AutoStart: true},
+		"changed":   {Name: "changed", AutoStart: true},
+		"removed":   {Name: "removed"},
+		"transient": {Name: "transient", Transient: true},
+	}
+	added, removed, changed := DiffConfigs(running, []PipelineConfigV2{
+		{Name: "same", AutoStart: true},
+		{Name: "changed", AutoStart: false},
+		{Name: "new"},
+		{Name: "transient", KeepRunning: true},
+	})
+	assert.Equal(t, []string{"new"}, added)
+	assert.Equal(t, []string{"removed"}, removed)
+	assert.Equal(t, []string{"changed"}, changed)
+
+	added, removed, changed = DiffConfigs(nil, nil)
+	assert.Equal(t, 0, len(added)+len(removed)+len(changed))
+}
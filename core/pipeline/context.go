@@ -86,6 +86,7 @@ type Context struct {
 	startTime      *time.Time
 	endTime        *time.Time
 	runningState   RunningState
+	lastEndState   RunningState
 	exitErr        error
 	processErrs    []error
 	processHistory []string
@@ -100,6 +101,14 @@ type Context struct {
 	stateLock    sync.Mutex
 	released     bool
 	loopReleased bool
+
+	checkpoint     *Checkpoint
+	checkpointLock sync.RWMutex
+
+	spans []Span
+
+	simulation  bool
+	sideEffects []SideEffect
 }
 
 func AcquireContext(config PipelineConfigV2) *Context {
@@ -176,21 +185,29 @@ func (ctx *Context) ResetContext() {
 	ctx.Context, ctx.cancelFunc = context.WithCancel(context.Background())
 	ctx.exitErr = nil
 	ctx.processErrs = []error{}
+	ctx.stateLock.Lock()
 	ctx.processHistory = []string{}
+	ctx.spans = nil
+	ctx.stateLock.Unlock()
 	ctx.ResetParameters()
 }
 
+// GetFlowProcess return a copy of the processed history, the processors may still be running
 func (ctx *Context) GetFlowProcess() []string {
-	return ctx.processHistory
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	return append([]string{}, ctx.processHistory...)
 }
 
 func (ctx *Context) GetRequestProcess() []string {
-	return ctx.processHistory
+	return ctx.GetFlowProcess()
 }
 
 func (ctx *Context) AddFlowProcess(str string) {
 	if str != "" {
+		ctx.stateLock.Lock()
 		ctx.processHistory = append(ctx.processHistory, str)
+		ctx.stateLock.Unlock()
 	}
 }
 
@@ -223,6 +240,9 @@ func (ctx *Context) Finished() {
 
 	t := time.Now()
 	ctx.endTime = &t
+	if ctx.runningState == STARTED {
+		ctx.lastEndState = FINISHED
+	}
 	ctx.setRunningState(FINISHED)
 }
 
@@ -231,6 +251,9 @@ func (ctx *Context) Failed(err error) {
 	defer ctx.stateLock.Unlock()
 
 	ctx.exitErr = err
+	if ctx.runningState == STARTED || ctx.runningState == FINISHED {
+		ctx.lastEndState = FAILED
+	}
 	ctx.setRunningState(FAILED)
 	t := time.Now()
 	ctx.endTime = &t
@@ -25,14 +25,16 @@ package pipeline
 
 import (
 	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/global"
 	"runtime"
 )
 
 // Dag represents directed acyclic graph
 type Dag struct {
-	mode string
-	jobs []*Job
+	mode  string
+	jobs  []*Job
+	graph *graph
 }
 
 // NewPipeline creates new DAG
@@ -52,13 +54,32 @@ func (dag *Dag) lastJob() *Job {
 	return dag.jobs[jobsCount-1]
 }
 
+// Parse build the dag from the yaml graph definition, see GraphConfig
 func (dag *Dag) Parse(dsl string) *Dag {
+	cfg, err := config.NewConfigWithYAML([]byte(dsl), "dag")
+	if err != nil {
+		panic(err)
+	}
+	graphCfg := GraphConfig{}
+	err = cfg.Unpack(&graphCfg)
+	if err != nil {
+		panic(err)
+	}
+	parsed, err := NewGraphDAG(graphCfg)
+	if err != nil {
+		panic(err)
+	}
+	dag.jobs = append(dag.jobs, parsed.jobs...)
+	dag.graph = parsed.graph
 	return dag
 }
 
 // Run starts the tasks
 // It will block until all functions are done
 func (dag *Dag) Run(ctx *Context) {
+	if dag.graph != nil {
+		dag.graph.reset()
+	}
 
 	//fmt.Println("total jobs:",len(dag.jobs))
 	for _, job := range dag.jobs {
@@ -0,0 +1,218 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"infini.sh/framework/core/errors"
+)
+
+// the end state the dependencies must reach before the pipeline starts
+const DependencyFinished = "finished"
+const DependencyFailed = "failed"
+
+// DependsOnConfig holds the pipelines that must end before this pipeline starts, eg:
+//
+//	depends_on: [pipeline_a, pipeline_b]
+//
+// or with the required end state:
+//
+//	depends_on:
+//	  pipelines: [pipeline_a]
+//	  state: failed
+type DependsOnConfig struct {
+	Pipelines []string `config:"pipelines" json:"pipelines"`
+	State     string   `config:"state" json:"state,omitempty"`
+}
+
+// Unpack accept a plain list of pipeline names or an object with pipelines and state
+func (cfg *DependsOnConfig) Unpack(v interface{}) error {
+	switch t := v.(type) {
+	case string:
+		cfg.Pipelines = []string{t}
+	case []interface{}:
+		names, err := toPipelineNames(t)
+		if err != nil {
+			return err
+		}
+		cfg.Pipelines = names
+	case map[string]interface{}:
+		for k, x := range t {
+			switch k {
+			case "pipelines":
+				switch y := x.(type) {
+				case string:
+					cfg.Pipelines = []string{y}
+				case []interface{}:
+					names, err := toPipelineNames(y)
+					if err != nil {
+						return err
+					}
+					cfg.Pipelines = names
+				default:
+					return errors.Errorf("invalid pipelines of depends_on: %v", x)
+				}
+			case "state":
+				state, ok := x.(string)
+				if !ok {
+					return errors.Errorf("invalid state of depends_on: %v", x)
+				}
+				cfg.State = state
+			default:
+				return errors.Errorf("unknown setting of depends_on: %v", k)
+			}
+		}
+	default:
+		return errors.Errorf("invalid depends_on: %v", v)
+	}
+	return nil
+}
+
+func (cfg *DependsOnConfig) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+	return cfg.Unpack(v)
+}
+
+func toPipelineNames(v []interface{}) ([]string, error) {
+	names := []string{}
+	for _, x := range v {
+		name, ok := x.(string)
+		if !ok {
+			return nil, errors.Errorf("invalid pipeline name of depends_on: %v", x)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (cfg *DependsOnConfig) Validate() error {
+	switch cfg.State {
+	case "", DependencyFinished, DependencyFailed:
+	default:
+		return errors.Errorf("invalid state of depends_on: %v", cfg.State)
+	}
+	for _, v := range cfg.Pipelines {
+		if v == "" {
+			return errors.New("pipeline name of depends_on can't be empty")
+		}
+	}
+	return nil
+}
+
+// GetState return the running state the dependencies must end with
+func (cfg *DependsOnConfig) GetState() RunningState {
+	if cfg.State == DependencyFailed {
+		return FAILED
+	}
+	return FINISHED
+}
+
+func (this PipelineConfigV2) GetDependencies() []string {
+	if this.DependsOn == nil {
+		return nil
+	}
+	return this.DependsOn.Pipelines
+}
+
+// ValidateDependencies check the dependency graph of the pipelines, return error if there is a cycle,
+// dependencies not in the configs are ignored as they may be created later
+func ValidateDependencies(configs map[string]PipelineConfigV2) error {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := map[string]int{}
+	path := []string{}
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch marks[name] {
+		case visiting:
+			for i, v := range path {
+				if v == name {
+					return errors.Errorf("cycle found in depends_on: %v", strings.Join(append(path[i:], name), " -> "))
+				}
+			}
+		case visited:
+			return nil
+		}
+		cfg, ok := configs[name]
+		if !ok {
+			return nil
+		}
+		marks[name] = visiting
+		path = append(path, name)
+		for _, v := range cfg.GetDependencies() {
+			if err := visit(v); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		marks[name] = visited
+		return nil
+	}
+
+	names := []string{}
+	for k := range configs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, v := range names {
+		if err := visit(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetLastEndState return the state of the last run, FINISHED or FAILED, empty if never ran
+func (ctx *Context) GetLastEndState() RunningState {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	return ctx.lastEndState
+}
+
+// DependencySatisfied check if the last run of the pipeline ended with the state and it is not running again
+func (ctx *Context) DependencySatisfied(state RunningState) bool {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	switch ctx.runningState {
+	case STARTING, STARTED, STOPPING:
+		return false
+	}
+	return ctx.lastEndState == state
+}
@@ -0,0 +1,108 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependsOnConfig(t *testing.T) {
+	cfg := DependsOnConfig{}
+	assert.NoError(t, cfg.Unpack([]interface{}{"a", "b"}))
+	assert.Equal(t, []string{"a", "b"}, cfg.Pipelines)
+	assert.Equal(t, FINISHED, cfg.GetState())
+
+	cfg = DependsOnConfig{}
+	assert.NoError(t, cfg.Unpack(map[string]interface{}{"pipelines": []interface{}{"a"}, "state": "failed"}))
+	assert.Equal(t, []string{"a"}, cfg.Pipelines)
+	assert.Equal(t, FAILED, cfg.GetState())
+	assert.NoError(t, cfg.Validate())
+
+	cfg = DependsOnConfig{}
+	assert.Error(t, cfg.Unpack([]interface{}{1}))
+	assert.Error(t, cfg.Unpack(map[string]interface{}{"unknown": "a"}))
+
+	cfg = DependsOnConfig{Pipelines: []string{"a"}, State: "stopped"}
+	assert.Error(t, cfg.Validate())
+
+	v := PipelineConfigV2{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"name":"c","depends_on":["a","b"]}`), &v))
+	assert.Equal(t, []string{"a", "b"}, v.GetDependencies())
+	v = PipelineConfigV2{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"name":"c","depends_on":{"pipelines":["a"],"state":"failed"}}`), &v))
+	assert.Equal(t, []string{"a"}, v.GetDependencies())
+	assert.Equal(t, DependencyFailed, v.DependsOn.State)
+}
+
+func TestValidateDependencies(t *testing.T) {
+	configs := map[string]PipelineConfigV2{
+		"a": {Name: "a"},
+		"b": {Name: "b", DependsOn: &DependsOnConfig{Pipelines: []string{"a", "x"}}},
+		"c": {Name: "c", DependsOn: &DependsOnConfig{Pipelines: []string{"a", "b"}}},
+	}
+	assert.NoError(t, ValidateDependencies(configs))
+
+	configs["a"] = PipelineConfigV2{Name: "a", DependsOn: &DependsOnConfig{Pipelines: []string{"c"}}}
+	err := ValidateDependencies(configs)
+	assert.Error(t, err)
+	assert.Equal(t, "cycle found in depends_on: a -> c -> a", err.Error())
+
+	configs = map[string]PipelineConfigV2{
+		"a": {Name: "a", DependsOn: &DependsOnConfig{Pipelines: []string{"a"}}},
+	}
+	assert.Error(t, ValidateDependencies(configs))
+}
+
+func TestDependencySatisfied(t *testing.T) {
+	ctx := AcquireContext(PipelineConfigV2{Name: "a"})
+	assert.False(t, ctx.DependencySatisfied(FINISHED))
+
+	//skipped runs are not counted
+	ctx.Starting()
+	ctx.Finished()
+	assert.False(t, ctx.DependencySatisfied(FINISHED))
+
+	ctx.Starting()
+	ctx.Started()
+	assert.False(t, ctx.DependencySatisfied(FINISHED))
+	ctx.Finished()
+	ctx.Stopped()
+	assert.True(t, ctx.DependencySatisfied(FINISHED))
+	assert.False(t, ctx.DependencySatisfied(FAILED))
+
+	//running again
+	ctx.Starting()
+	assert.False(t, ctx.DependencySatisfied(FINISHED))
+	ctx.Started()
+	ctx.Failed(nil)
+	assert.Equal(t, FAILED, ctx.GetLastEndState())
+	assert.True(t, ctx.DependencySatisfied(FAILED))
+}
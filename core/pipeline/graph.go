@@ -0,0 +1,432 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	config2 "infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+// GraphConfig defines a dag with named nodes, eg:
+//
+//	nodes:
+//	  - name: fetch
+//	    timeout: 10m
+//	    processor:
+//	      - echo: {message: "fetch"}
+//	  - name: index
+//	    depends_on: [ fetch ]
+//	    on_failure: [ notify ]
+//	    processor: ...
+//	  - name: notify
+//	    processor: ...
+//
+// nodes listed in on_failure only run when the referencing node failed
+type GraphConfig struct {
+	Nodes []GraphNodeConfig `config:"nodes"`
+}
+
+type GraphNodeConfig struct {
+	Name       string            `config:"name"`
+	DependsOn  []string          `config:"depends_on"`
+	OnFailure  []string          `config:"on_failure"`
+	Timeout    string            `config:"timeout"`
+	Processors []*config2.Config `config:"processor"`
+}
+
+type NodeStatus string
+
+const NodeSuccess NodeStatus = "success"
+const NodeFailed NodeStatus = "failed"
+const NodeSkipped NodeStatus = "skipped"
+
+// graph holds the nodes and the status of the current run
+type graph struct {
+	nodes []*graphNode
+	lock  sync.Mutex
+	state map[string]NodeStatus
+	errs  map[string]error
+}
+
+type graphNode struct {
+	graph      *graph
+	name       string
+	dependsOn  []string
+	failureOf  []string
+	handlers   []string
+	timeout    time.Duration
+	processors *Processors
+	//the processors of a timed out node may still be running, wait for them before the next run
+	running sync.WaitGroup
+}
+
+// validateGraph check the references and cycles of the nodes, return the nodes grouped by layers,
+// nodes in the same layer don't depend on each other and run in parallel
+func validateGraph(nodes []GraphNodeConfig) ([][]string, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("graph has no nodes")
+	}
+
+	order := map[string]int{}
+	for i, v := range nodes {
+		if v.Name == "" {
+			return nil, errors.Errorf("name of node [%v] is not set", i)
+		}
+		if _, ok := order[v.Name]; ok {
+			return nil, errors.Errorf("duplicated node [%v]", v.Name)
+		}
+		order[v.Name] = i
+	}
+
+	//edges from the upstream to the downstream
+	downstream := map[string][]string{}
+	inDegree := map[string]int{}
+	addEdge := func(from, to, field string) error {
+		if _, ok := order[from]; !ok {
+			return errors.Errorf("node [%v] in %v of [%v] was not found", from, field, to)
+		}
+		if from == to {
+			return errors.Errorf("node [%v] can't reference itself", to)
+		}
+		downstream[from] = append(downstream[from], to)
+		inDegree[to]++
+		return nil
+	}
+	for _, v := range nodes {
+		for _, dep := range v.DependsOn {
+			if err := addEdge(dep, v.Name, "depends_on"); err != nil {
+				return nil, err
+			}
+		}
+		for _, handler := range v.OnFailure {
+			if _, ok := order[handler]; !ok {
+				return nil, errors.Errorf("node [%v] in on_failure of [%v] was not found", handler, v.Name)
+			}
+			if err := addEdge(v.Name, handler, "on_failure"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	layers := [][]string{}
+	current := []string{}
+	for _, v := range nodes {
+		if inDegree[v.Name] == 0 {
+			current = append(current, v.Name)
+		}
+	}
+	visited := 0
+	for len(current) > 0 {
+		layers = append(layers, current)
+		visited += len(current)
+		next := []string{}
+		for _, v := range current {
+			for _, d := range downstream[v] {
+				inDegree[d]--
+				if inDegree[d] == 0 {
+					next = append(next, d)
+				}
+			}
+		}
+		sort.Slice(next, func(i, j int) bool {
+			return order[next[i]] < order[next[j]]
+		})
+		current = next
+	}
+
+	if visited != len(nodes) {
+		cycle := []string{}
+		for _, v := range nodes {
+			if inDegree[v.Name] > 0 {
+				cycle = append(cycle, v.Name)
+			}
+		}
+		return nil, errors.Errorf("graph has cycles between nodes: %v", strings.Join(cycle, ", "))
+	}
+	return layers, nil
+}
+
+// NewGraphDAG build a dag from the graph config, each layer of the graph runs as a job,
+// a single node runs with the sync runner, and multiple nodes fan out with the async runner
+func NewGraphDAG(cfg GraphConfig) (*Dag, error) {
+	layers, err := validateGraph(cfg.Nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &graph{}
+	nodes := map[string]*graphNode{}
+	for _, v := range cfg.Nodes {
+		node := &graphNode{
+			graph:     g,
+			name:      v.Name,
+			dependsOn: v.DependsOn,
+			handlers:  v.OnFailure,
+		}
+		if v.Timeout != "" {
+			node.timeout, err = util.ParseDuration(v.Timeout)
+			if err != nil {
+				return nil, errors.Errorf("invalid timeout of node [%v], %v", v.Name, err)
+			}
+		}
+		node.processors, err = NewPipeline(v.Processors)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to init processors of node [%v]", v.Name)
+		}
+		node.processors.SkipCatchError = true
+		nodes[v.Name] = node
+		g.nodes = append(g.nodes, node)
+	}
+	for _, v := range cfg.Nodes {
+		for _, handler := range v.OnFailure {
+			nodes[handler].failureOf = append(nodes[handler].failureOf, v.Name)
+		}
+	}
+
+	dag := NewDAG("")
+	dag.graph = g
+	for _, layer := range layers {
+		tasks := make([]Processor, 0, len(layer))
+		for _, name := range layer {
+			tasks = append(tasks, nodes[name])
+		}
+		if len(tasks) == 1 {
+			dag.Pipeline(tasks...)
+		} else {
+			dag.Spawns(tasks...)
+		}
+	}
+	return dag, nil
+}
+
+func (g *graph) reset() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.state = map[string]NodeStatus{}
+	g.errs = map[string]error{}
+}
+
+func (g *graph) setStatus(name string, status NodeStatus, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.state[name] = status
+	if err != nil {
+		g.errs[name] = err
+	}
+}
+
+func (g *graph) getStatus(name string) NodeStatus {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.state[name]
+}
+
+// result return the status of each node, and an error if any failure was not handled
+func (g *graph) result() (map[string]NodeStatus, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	status := make(map[string]NodeStatus, len(g.state))
+	msgs := []string{}
+	for _, node := range g.nodes {
+		status[node.name] = g.state[node.name]
+		if g.state[node.name] != NodeFailed {
+			continue
+		}
+		handled := false
+		for _, h := range node.handlers {
+			if g.state[h] == NodeSuccess {
+				handled = true
+				break
+			}
+		}
+		if !handled {
+			msgs = append(msgs, fmt.Sprintf("[%v]: %v", node.name, g.errs[node.name]))
+		}
+	}
+	if len(msgs) > 0 {
+		return status, errors.Errorf("graph nodes failed, %v", strings.Join(msgs, "; "))
+	}
+	return status, nil
+}
+
+func (g *graph) release() {
+	for _, node := range g.nodes {
+		node.running.Wait()
+		node.processors.Release()
+	}
+}
+
+func (node *graphNode) Name() string {
+	return node.name
+}
+
+func (node *graphNode) shouldRun(ctx *Context) bool {
+	if ctx.IsCanceled() {
+		return false
+	}
+	for _, v := range node.dependsOn {
+		if node.graph.getStatus(v) != NodeSuccess {
+			return false
+		}
+	}
+	if len(node.failureOf) == 0 {
+		return true
+	}
+	for _, v := range node.failureOf {
+		if node.graph.getStatus(v) == NodeFailed {
+			return true
+		}
+	}
+	return false
+}
+
+func (node *graphNode) Process(ctx *Context) error {
+	if !node.shouldRun(ctx) {
+		node.graph.setStatus(node.name, NodeSkipped, nil)
+		return nil
+	}
+
+	err := node.execute(ctx)
+	if err != nil {
+		log.Errorf("node [%v] of pipeline [%v] failed, %v", node.name, ctx.Config.Name, err)
+		node.graph.setStatus(node.name, NodeFailed, err)
+		return nil
+	}
+	node.graph.setStatus(node.name, NodeSuccess, nil)
+	return nil
+}
+
+// newContext return the context of the node, it is canceled with the parent or on timeout,
+// the node works on a copy of the context data, which is merged back once the node succeeded
+func (node *graphNode) newContext(ctx *Context) (*Context, context.CancelFunc) {
+	nodeCtx := &Context{ParentContext: ctx, Config: ctx.Config}
+	nodeCtx.Context, nodeCtx.cancelFunc = context.WithCancel(ctx.Context)
+	nodeCtx.ResetParameters()
+	nodeCtx.Data = ctx.CloneData()
+	return nodeCtx, nodeCtx.cancelFunc
+}
+
+// execute run the processors of the node, they are canceled on timeout, and the downstream nodes
+// will treat the node as failed, the next run of the node waits for them to exit
+func (node *graphNode) execute(ctx *Context) error {
+	node.running.Wait()
+
+	nodeCtx, cancel := node.newContext(ctx)
+	ch := make(chan error, 1)
+	node.running.Add(1)
+	go func() {
+		defer node.running.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				var v string
+				switch r.(type) {
+				case error:
+					v = r.(error).Error()
+				case runtime.Error:
+					v = r.(runtime.Error).Error()
+				case string:
+					v = r.(string)
+				}
+				log.Errorf("error on node [%v], %v", node.name, v)
+				ch <- errors.Errorf("panic: %v", v)
+			}
+		}()
+		ch <- node.processors.Process(nodeCtx)
+	}()
+
+	var timeout <-chan time.Time
+	if node.timeout > 0 {
+		timer := time.NewTimer(node.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case err := <-ch:
+		cancel()
+		if err != nil {
+			return err
+		}
+		for k, v := range nodeCtx.Data {
+			if _, err := ctx.PutValue(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case <-timeout:
+		cancel()
+		return errors.Errorf("timeout after %v", node.timeout)
+	}
+}
+
+type GraphProcessor struct {
+	dag  *Dag
+	lock sync.Mutex
+}
+
+func NewGraphProcessor(c *config2.Config) (Processor, error) {
+	cfg := GraphConfig{}
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unpack the configuration of graph processor: %s", err)
+	}
+
+	dag, err := NewGraphDAG(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &GraphProcessor{dag: dag}, nil
+}
+
+func (processor *GraphProcessor) Name() string {
+	return "graph"
+}
+
+func (processor *GraphProcessor) Process(ctx *Context) error {
+	processor.lock.Lock()
+	defer processor.lock.Unlock()
+
+	processor.dag.Run(ctx)
+	status, err := processor.dag.graph.result()
+	log.Debugf("graph finished, %v", status)
+	return err
+}
+
+func (processor *GraphProcessor) Release() error {
+	processor.dag.graph.release()
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+)
+
+type graphTestStep struct {
+	name  string
+	fail  bool
+	lock  *sync.Mutex
+	steps *[]string
+}
+
+func (step *graphTestStep) Name() string {
+	return "graph_test_step"
+}
+
+func (step *graphTestStep) Process(ctx *Context) error {
+	step.lock.Lock()
+	*step.steps = append(*step.steps, step.name)
+	step.lock.Unlock()
+	if step.fail {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func TestValidateGraph(t *testing.T) {
+	layers, err := validateGraph([]GraphNodeConfig{
+		{Name: "join", DependsOn: []string{"a", "b"}},
+		{Name: "a", DependsOn: []string{"start"}},
+		{Name: "b", DependsOn: []string{"start"}, OnFailure: []string{"notify"}},
+		{Name: "start"},
+		{Name: "notify"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"start"}, {"a", "b"}, {"join", "notify"}}, layers)
+
+	_, err = validateGraph([]GraphNodeConfig{
+		{Name: "a", DependsOn: []string{"c"}},
+		{Name: "b", DependsOn: []string{"a"}},
+		{Name: "c", DependsOn: []string{"b"}},
+		{Name: "d"},
+	})
+	assert.Equal(t, "graph has cycles between nodes: a, b, c", err.Error())
+
+	_, err = validateGraph([]GraphNodeConfig{{Name: "a", DependsOn: []string{"x"}}})
+	assert.NotNil(t, err)
+
+	_, err = validateGraph([]GraphNodeConfig{{Name: "a"}, {Name: "a"}})
+	assert.NotNil(t, err)
+}
+
+func TestGraphRun(t *testing.T) {
+	lock := sync.Mutex{}
+	steps := []string{}
+	RegisterProcessorPlugin("graph_test_step", func(c *config.Config) (Processor, error) {
+		name, _ := c.String("name", -1)
+		fail, _ := c.Bool("fail", -1)
+		return &graphTestStep{name: name, fail: fail, lock: &lock, steps: &steps}, nil
+	})
+
+	dag := NewDAG("").Parse(`
+nodes:
+  - name: start
+    processor:
+      - graph_test_step: {name: start}
+  - name: a
+    depends_on: [start]
+    processor:
+      - graph_test_step: {name: a}
+  - name: b
+    depends_on: [start]
+    on_failure: [recover]
+    processor:
+      - graph_test_step: {name: b, fail: true}
+  - name: join
+    depends_on: [a, b]
+    processor:
+      - graph_test_step: {name: join}
+  - name: recover
+    processor:
+      - graph_test_step: {name: recover}
+`)
+	ctx := AcquireContext(PipelineConfigV2{})
+	dag.Run(ctx)
+
+	status, err := dag.graph.result()
+	assert.Nil(t, err)
+	assert.Equal(t, NodeSuccess, status["a"])
+	assert.Equal(t, NodeFailed, status["b"])
+	assert.Equal(t, NodeSkipped, status["join"])
+	assert.Equal(t, NodeSuccess, status["recover"])
+	assert.Equal(t, 4, len(steps))
+	assert.Equal(t, "start", steps[0])
+	assert.Equal(t, "recover", steps[3])
+}
+
+// graphSlowStep ignores the cancellation for a while, then waits for it
+type graphSlowStep struct {
+	running    *int32
+	maxRunning *int32
+	canceled   *int32
+}
+
+func (step *graphSlowStep) Name() string {
+	return "graph_slow_step"
+}
+
+func (step *graphSlowStep) Process(ctx *Context) error {
+	n := atomic.AddInt32(step.running, 1)
+	defer atomic.AddInt32(step.running, -1)
+	if n > atomic.LoadInt32(step.maxRunning) {
+		atomic.StoreInt32(step.maxRunning, n)
+	}
+	time.Sleep(200 * time.Millisecond)
+	<-ctx.Done()
+	atomic.AddInt32(step.canceled, 1)
+	return nil
+}
+
+func TestGraphNodeTimeout(t *testing.T) {
+	var running, maxRunning, canceled int32
+	RegisterProcessorPlugin("graph_slow_step", func(c *config.Config) (Processor, error) {
+		return &graphSlowStep{running: &running, maxRunning: &maxRunning, canceled: &canceled}, nil
+	})
+
+	dag := NewDAG("").Parse(`
+nodes:
+  - name: slow
+    timeout: 50ms
+    processor:
+      - graph_slow_step: {}
+`)
+	ctx := AcquireContext(PipelineConfigV2{})
+	for i := 0; i < 2; i++ {
+		dag.Run(ctx)
+		status, err := dag.graph.result()
+		assert.NotNil(t, err)
+		assert.Equal(t, NodeFailed, status["slow"])
+	}
+	dag.graph.release()
+
+	//the processors were canceled, and never ran twice at the same time
+	assert.False(t, ctx.IsCanceled())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&canceled))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&maxRunning))
+}
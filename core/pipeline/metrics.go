@@ -0,0 +1,284 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/event"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// upper bounds of the duration histogram buckets
+var durationBucketsInMs = []int64{1, 5, 10, 50, 100, 500, 1000, 5000, 10000, 60000}
+
+type ProcessorMetrics struct {
+	Processor string
+	total     int64
+	success   int64
+	failure   int64
+	sumInMs   int64
+	maxInMs   int64
+	buckets   []int64
+}
+
+type Span struct {
+	Processor string    `json:"processor"`
+	Start     time.Time `json:"start"`
+	Duration  int64     `json:"duration_in_us"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// pipeline name -> processor name -> metrics
+var pipelineMetrics = sync.Map{}
+
+func getProcessorMetrics(pipelineName, processorName string) *ProcessorMetrics {
+	v, ok := pipelineMetrics.Load(pipelineName)
+	if !ok {
+		v, _ = pipelineMetrics.LoadOrStore(pipelineName, &sync.Map{})
+	}
+	processors := v.(*sync.Map)
+	m, ok := processors.Load(processorName)
+	if !ok {
+		m, _ = processors.LoadOrStore(processorName, &ProcessorMetrics{
+			Processor: processorName,
+			buckets:   make([]int64, len(durationBucketsInMs)+1),
+		})
+	}
+	return m.(*ProcessorMetrics)
+}
+
+func (m *ProcessorMetrics) record(pipelineName string, duration time.Duration, err error) {
+	ms := duration.Milliseconds()
+	atomic.AddInt64(&m.total, 1)
+	atomic.AddInt64(&m.sumInMs, ms)
+	for {
+		max := atomic.LoadInt64(&m.maxInMs)
+		if ms <= max || atomic.CompareAndSwapInt64(&m.maxInMs, max, ms) {
+			break
+		}
+	}
+	i := sort.Search(len(durationBucketsInMs), func(i int) bool {
+		return durationBucketsInMs[i] >= ms
+	})
+	atomic.AddInt64(&m.buckets[i], 1)
+
+	key := pipelineName + "." + m.Processor
+	stats.Increment("pipeline", key, "total")
+	if err != nil {
+		atomic.AddInt64(&m.failure, 1)
+		stats.Increment("pipeline", key, "failure")
+	} else {
+		atomic.AddInt64(&m.success, 1)
+		stats.Increment("pipeline", key, "success")
+	}
+	stats.IncrementBy("pipeline", key+".duration_in_ms_sum", ms)
+	stats.Timing("pipeline", key+".duration", ms)
+	//cumulative buckets, same as prometheus histograms
+	for j := i; j < len(durationBucketsInMs); j++ {
+		stats.Increment("pipeline", key, "duration_bucket", fmt.Sprintf("le_%vms", durationBucketsInMs[j]))
+	}
+	stats.Increment("pipeline", key, "duration_bucket", "le_inf")
+}
+
+func (m *ProcessorMetrics) ToMap() util.MapStr {
+	total := atomic.LoadInt64(&m.total)
+	sum := atomic.LoadInt64(&m.sumInMs)
+	buckets := util.MapStr{}
+	var cumulative int64
+	for i, v := range durationBucketsInMs {
+		cumulative += atomic.LoadInt64(&m.buckets[i])
+		buckets[fmt.Sprintf("le_%vms", v)] = cumulative
+	}
+	buckets["le_inf"] = total
+	var avg float64
+	if total > 0 {
+		avg = float64(sum) / float64(total)
+	}
+	return util.MapStr{
+		"total":   total,
+		"success": atomic.LoadInt64(&m.success),
+		"failure": atomic.LoadInt64(&m.failure),
+		"duration_in_ms": util.MapStr{
+			"sum":     sum,
+			"avg":     avg,
+			"max":     atomic.LoadInt64(&m.maxInMs),
+			"buckets": buckets,
+		},
+	}
+}
+
+// GetPipelineMetrics return the metrics of each processor in the pipeline
+func GetPipelineMetrics(pipelineName string) util.MapStr {
+	v, ok := pipelineMetrics.Load(pipelineName)
+	if !ok {
+		return nil
+	}
+	result := util.MapStr{}
+	v.(*sync.Map).Range(func(key, value interface{}) bool {
+		result[key.(string)] = value.(*ProcessorMetrics).ToMap()
+		return true
+	})
+	return result
+}
+
+func ResetPipelineMetrics(pipelineName string) {
+	pipelineMetrics.Delete(pipelineName)
+}
+
+// instrumentedProcessor records the metrics and spans of the wrapped processor
+type instrumentedProcessor struct {
+	Processor
+	action      string
+	config      *config.Config
+	sideEffects bool
+}
+
+func (p *instrumentedProcessor) Process(ctx *Context) error {
+	root := ctx.root()
+	if root.simulation && p.sideEffects {
+		return p.stubProcess(ctx)
+	}
+	pipelineName := root.Config.Name
+	if pipelineName == "" {
+		pipelineName = "_"
+	}
+	start := time.Now()
+	var err error
+	defer func() {
+		duration := time.Since(start)
+		e := err
+		r := recover()
+		if r != nil {
+			e = fmt.Errorf("panic: %v", r)
+		}
+		if !root.simulation {
+			getProcessorMetrics(pipelineName, p.Name()).record(pipelineName, duration, e)
+		}
+		if root.Config.Tracing.Enabled {
+			span := Span{Processor: p.Name(), Start: start, Duration: duration.Microseconds()}
+			if e != nil {
+				span.Error = e.Error()
+			}
+			root.addSpan(span)
+		}
+		if r != nil {
+			panic(r)
+		}
+	}()
+	err = p.Processor.Process(ctx)
+	return err
+}
+
+func (p *instrumentedProcessor) Release() error {
+	if releaser, ok := p.Processor.(Releaser); ok {
+		return releaser.Release()
+	}
+	return nil
+}
+
+func (p *instrumentedProcessor) Close() error {
+	return Close(p.Processor)
+}
+
+// Unwrap return the original processor
+func (p *instrumentedProcessor) Unwrap() Processor {
+	return p.Processor
+}
+
+func (ctx *Context) addSpan(span Span) {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	if ctx.Config.Tracing.MaxSpans > 0 && len(ctx.spans) >= ctx.Config.Tracing.MaxSpans {
+		return
+	}
+	ctx.spans = append(ctx.spans, span)
+}
+
+// GetSpans return the spans of the current run
+func (ctx *Context) GetSpans() []Span {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+	spans := make([]Span, len(ctx.spans))
+	copy(spans, ctx.spans)
+	return spans
+}
+
+// ExportSpans save the spans of the current run as pipeline logs
+func (ctx *Context) ExportSpans() {
+	defer func() {
+		if r := recover(); r != nil {
+			var v string
+			switch r.(type) {
+			case error:
+				v = r.(error).Error()
+			case runtime.Error:
+				v = r.(runtime.Error).Error()
+			case string:
+				v = r.(string)
+			}
+			log.Errorf("failed to export spans of pipeline [%v], %v", ctx.Config.Name, v)
+		}
+	}()
+
+	spans := ctx.GetSpans()
+	if len(spans) == 0 {
+		return
+	}
+	labels := util.MapStr{
+		"task_id":    ctx.Config.Name,
+		"context_id": ctx.id,
+	}
+	for k, v := range ctx.Config.Labels {
+		labels[k] = v
+	}
+	eventData := event.Event{
+		Metadata: event.EventMetadata{
+			Category: "pipeline",
+			Name:     "tracing",
+			Datatype: "event",
+			Labels:   labels,
+		},
+		Fields: util.MapStr{
+			"pipeline": util.MapStr{
+				"tracing": util.MapStr{
+					"spans": spans,
+				},
+			},
+		},
+	}
+	event.SaveLog(&eventData)
+}
@@ -0,0 +1,64 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+func TestProcessorMetrics(t *testing.T) {
+	m := getProcessorMetrics("test_metrics", "echo")
+	m.record("test_metrics", 3*time.Millisecond, nil)
+	m.record("test_metrics", 200*time.Millisecond, nil)
+	m.record("test_metrics", 2*time.Minute, errors.New("failed"))
+
+	assert.Equal(t, m, getProcessorMetrics("test_metrics", "echo"))
+
+	obj := GetPipelineMetrics("test_metrics")["echo"].(util.MapStr)
+	assert.Equal(t, int64(3), obj["total"])
+	assert.Equal(t, int64(2), obj["success"])
+	assert.Equal(t, int64(1), obj["failure"])
+
+	duration := obj["duration_in_ms"].(util.MapStr)
+	assert.Equal(t, int64(120203), duration["sum"])
+	assert.Equal(t, int64(120000), duration["max"])
+	buckets := duration["buckets"].(util.MapStr)
+	assert.Equal(t, int64(0), buckets["le_1ms"])
+	assert.Equal(t, int64(1), buckets["le_5ms"])
+	assert.Equal(t, int64(2), buckets["le_500ms"])
+	assert.Equal(t, int64(2), buckets["le_60000ms"])
+	assert.Equal(t, int64(3), buckets["le_inf"])
+
+	ResetPipelineMetrics("test_metrics")
+	assert.Nil(t, GetPipelineMetrics("test_metrics"))
+}
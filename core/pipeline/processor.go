@@ -83,12 +83,17 @@ func NewPipelineList() *Processors {
 }
 
 func NewPipeline(cfg []*config.Config) (*Processors, error) {
+	return newPipeline(cfg, false)
+}
+
+// newPipeline build the processors, the processors with side effects are not constructed in simulation
+func newPipeline(cfg []*config.Config, simulation bool) (*Processors, error) {
 	procs := NewPipelineList()
 
 	for _, procConfig := range cfg {
 		// Handle if/then/else processor which has multiple top-level keys.
 		if procConfig.HasField("if") {
-			p, err := NewIfElseThenProcessor(procConfig)
+			p, err := newIfElseThenProcessor(procConfig, simulation)
 			if err != nil {
 				return nil, errors.Wrap(err, "failed to make if/then/else processor")
 			}
@@ -121,6 +126,16 @@ func NewPipeline(cfg []*config.Config) (*Processors, error) {
 			return nil, errors.Errorf("the processor %s does not exist. valid processors: %v", actionName, strings.Join(validActions, ", "))
 		}
 
+		if simulation && hasSideEffects(actionName) {
+			procs.AddProcessor(&instrumentedProcessor{
+				Processor:   &simulatedProcessor{name: actionName},
+				action:      actionName,
+				config:      actionCfg,
+				sideEffects: true,
+			})
+			continue
+		}
+
 		constructor := gen.ProcessorPlugin()
 		plugin, err := constructor(actionCfg)
 		if err != nil {
@@ -129,7 +144,12 @@ func NewPipeline(cfg []*config.Config) (*Processors, error) {
 
 		p, ok := plugin.(Processor)
 		if ok {
-			procs.AddProcessor(p)
+			procs.AddProcessor(&instrumentedProcessor{
+				Processor:   p,
+				action:      actionName,
+				config:      actionCfg,
+				sideEffects: hasSideEffects(actionName),
+			})
 		} else {
 			return nil, errors.Errorf("invalid processor: [%v]", plugin.Name())
 		}
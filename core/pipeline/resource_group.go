@@ -0,0 +1,314 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// what to do if the resource group is full when a pipeline is about to run
+const OnLimitQueue = "queue"
+const OnLimitThrottle = "throttle"
+
+// ResourceGroupConfig limits the pipelines assigned to the same group, eg:
+//
+//	resource_group:
+//	  - name: ingest
+//	    max_concurrent_pipelines: 2
+//	    max_workers: 20
+//	    max_memory: 2gb
+//	    on_limit: queue
+type ResourceGroupConfig struct {
+	Name string `config:"name" json:"name"`
+	//max num of pipelines running at the same time
+	MaxConcurrentPipelines int `config:"max_concurrent_pipelines" json:"max_concurrent_pipelines,omitempty"`
+	//max num of workers shared by the pipelines, acquired by processors via ctx.AcquireWorker
+	MaxWorkers int `config:"max_workers" json:"max_workers,omitempty"`
+	//no new runs or workers will be started while the heap in use of the process exceeds this size
+	MaxMemory string `config:"max_memory" json:"max_memory,omitempty"`
+	//queue: wait in line for a free slot, throttle: skip this run, retry on the next round
+	OnLimit string `config:"on_limit" json:"on_limit,omitempty"`
+}
+
+func (cfg *ResourceGroupConfig) Validate() error {
+	if cfg.Name == "" {
+		return errors.New("name of resource group can't be empty")
+	}
+	switch cfg.OnLimit {
+	case "", OnLimitQueue, OnLimitThrottle:
+	default:
+		return errors.Errorf("invalid on_limit policy of resource group [%v]: %v", cfg.Name, cfg.OnLimit)
+	}
+	_, err := cfg.GetMaxMemoryInBytes()
+	return err
+}
+
+func (cfg *ResourceGroupConfig) GetOnLimit() string {
+	if cfg.OnLimit == "" {
+		return OnLimitQueue
+	}
+	return cfg.OnLimit
+}
+
+func (cfg *ResourceGroupConfig) GetMaxMemoryInBytes() (uint64, error) {
+	if cfg.MaxMemory == "" {
+		return 0, nil
+	}
+	v, err := util.ToBytes(cfg.MaxMemory)
+	if err != nil {
+		return 0, errors.Errorf("invalid max_memory of resource group [%v]: %v", cfg.Name, cfg.MaxMemory)
+	}
+	return v, nil
+}
+
+type ResourceGroupUsage struct {
+	Config           ResourceGroupConfig `json:"config"`
+	Running          []string            `json:"running"`
+	Queued           []string            `json:"queued"`
+	Workers          int                 `json:"workers"`
+	MemoryInBytes    uint64              `json:"memory_in_bytes"`
+	MemoryExceeded   bool                `json:"memory_exceeded"`
+	ThrottledRuns    int64               `json:"throttled_runs"`
+	ThrottledWorkers int64               `json:"throttled_workers"`
+}
+
+type ResourceGroup struct {
+	lock             sync.Mutex
+	config           ResourceGroupConfig
+	maxMemoryInBytes uint64
+
+	running          map[string]time.Time
+	queued           []string
+	workers          int
+	throttledRuns    int64
+	throttledWorkers int64
+}
+
+var resourceGroups = sync.Map{}
+
+// RegisterResourceGroup add a new resource group or update the limits of an existing one,
+// pipelines already running in the group are kept
+func RegisterResourceGroup(cfg ResourceGroupConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	maxMemory, _ := cfg.GetMaxMemoryInBytes()
+	v, loaded := resourceGroups.LoadOrStore(cfg.Name, &ResourceGroup{
+		config:           cfg,
+		maxMemoryInBytes: maxMemory,
+		running:          map[string]time.Time{},
+	})
+	if loaded {
+		group := v.(*ResourceGroup)
+		group.lock.Lock()
+		group.config = cfg
+		group.maxMemoryInBytes = maxMemory
+		group.lock.Unlock()
+	}
+	log.Debugf("resource group [%v] registered, %v", cfg.Name, util.MustToJSON(cfg))
+	return nil
+}
+
+func GetResourceGroup(name string) *ResourceGroup {
+	if name == "" {
+		return nil
+	}
+	v, ok := resourceGroups.Load(name)
+	if !ok {
+		return nil
+	}
+	return v.(*ResourceGroup)
+}
+
+func GetResourceGroupUsages() map[string]ResourceGroupUsage {
+	usages := map[string]ResourceGroupUsage{}
+	resourceGroups.Range(func(key, value interface{}) bool {
+		group := value.(*ResourceGroup)
+		usages[key.(string)] = group.Usage()
+		return true
+	})
+	return usages
+}
+
+// Acquire hold a slot of the group for the pipeline, with the queue policy it blocks until a slot is available
+// or the cancel func returns true, with the throttle policy it returns false immediately if the group is full
+func (group *ResourceGroup) Acquire(pipeline string, cancel func() bool) bool {
+	group.lock.Lock()
+	if _, ok := group.running[pipeline]; ok {
+		group.lock.Unlock()
+		return true
+	}
+	if group.fits() {
+		group.running[pipeline] = time.Now()
+		group.lock.Unlock()
+		return true
+	}
+	if group.config.GetOnLimit() == OnLimitThrottle {
+		group.throttledRuns++
+		group.lock.Unlock()
+		stats.Increment("pipeline_resource_group", group.config.Name, "throttled_runs")
+		return false
+	}
+	group.queued = append(group.queued, pipeline)
+	group.lock.Unlock()
+
+	log.Debugf("pipeline [%v] queued in resource group [%v]", pipeline, group.config.Name)
+	stats.Increment("pipeline_resource_group", group.config.Name, "queued_runs")
+
+	for {
+		group.lock.Lock()
+		if len(group.queued) > 0 && group.queued[0] == pipeline && group.fits() {
+			group.queued = group.queued[1:]
+			group.running[pipeline] = time.Now()
+			group.lock.Unlock()
+			return true
+		}
+		group.lock.Unlock()
+
+		if cancel != nil && cancel() {
+			group.lock.Lock()
+			group.dequeue(pipeline)
+			group.lock.Unlock()
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Release free the slot held by the pipeline, safe to call if no slot was held
+func (group *ResourceGroup) Release(pipeline string) {
+	group.lock.Lock()
+	delete(group.running, pipeline)
+	group.lock.Unlock()
+}
+
+// TryAcquireWorker hold a worker of the group, return false if no more workers are allowed
+func (group *ResourceGroup) TryAcquireWorker() bool {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+	if (group.config.MaxWorkers > 0 && group.workers >= group.config.MaxWorkers) || group.memoryExceeded() {
+		group.throttledWorkers++
+		stats.Increment("pipeline_resource_group", group.config.Name, "throttled_workers")
+		return false
+	}
+	group.workers++
+	return true
+}
+
+func (group *ResourceGroup) ReleaseWorker() {
+	group.lock.Lock()
+	if group.workers > 0 {
+		group.workers--
+	}
+	group.lock.Unlock()
+}
+
+func (group *ResourceGroup) Usage() ResourceGroupUsage {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+	usage := ResourceGroupUsage{
+		Config:           group.config,
+		Running:          []string{},
+		Queued:           append([]string{}, group.queued...),
+		Workers:          group.workers,
+		MemoryInBytes:    memoryUsage(),
+		MemoryExceeded:   group.memoryExceeded(),
+		ThrottledRuns:    group.throttledRuns,
+		ThrottledWorkers: group.throttledWorkers,
+	}
+	for k := range group.running {
+		usage.Running = append(usage.Running, k)
+	}
+	sort.Strings(usage.Running)
+	return usage
+}
+
+// should be called with the lock held
+func (group *ResourceGroup) fits() bool {
+	if group.config.MaxConcurrentPipelines > 0 && len(group.running) >= group.config.MaxConcurrentPipelines {
+		return false
+	}
+	return !group.memoryExceeded()
+}
+
+func (group *ResourceGroup) memoryExceeded() bool {
+	return group.maxMemoryInBytes > 0 && memoryUsage() > group.maxMemoryInBytes
+}
+
+func (group *ResourceGroup) dequeue(pipeline string) {
+	for i, v := range group.queued {
+		if v == pipeline {
+			group.queued = append(group.queued[:i], group.queued[i+1:]...)
+			return
+		}
+	}
+}
+
+var memStatsLock sync.Mutex
+var memStats runtime.MemStats
+var memStatsTime time.Time
+
+// heap in use of the process, refreshed at most once per second
+var memoryUsage = func() uint64 {
+	memStatsLock.Lock()
+	defer memStatsLock.Unlock()
+	if time.Since(memStatsTime) > time.Second {
+		runtime.ReadMemStats(&memStats)
+		memStatsTime = time.Now()
+	}
+	return memStats.HeapInuse
+}
+
+func (ctx *Context) resourceGroup() *ResourceGroup {
+	return GetResourceGroup(ctx.root().Config.ResourceGroup)
+}
+
+// AcquireWorker hold a worker from the resource group of the pipeline,
+// always true if the pipeline is not assigned to any group
+func (ctx *Context) AcquireWorker() bool {
+	group := ctx.resourceGroup()
+	if group == nil {
+		return true
+	}
+	return group.TryAcquireWorker()
+}
+
+func (ctx *Context) ReleaseWorker() {
+	group := ctx.resourceGroup()
+	if group != nil {
+		group.ReleaseWorker()
+	}
+}
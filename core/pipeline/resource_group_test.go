@@ -0,0 +1,140 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceGroupConfig(t *testing.T) {
+	cfg := ResourceGroupConfig{}
+	assert.Error(t, cfg.Validate())
+
+	cfg = ResourceGroupConfig{Name: "test", OnLimit: "drop"}
+	assert.Error(t, cfg.Validate())
+
+	cfg = ResourceGroupConfig{Name: "test", MaxMemory: "abc"}
+	assert.Error(t, cfg.Validate())
+
+	cfg = ResourceGroupConfig{Name: "test", MaxMemory: "2gb"}
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, OnLimitQueue, cfg.GetOnLimit())
+	v, _ := cfg.GetMaxMemoryInBytes()
+	assert.Equal(t, uint64(2*1024*1024*1024), v)
+}
+
+func TestResourceGroupThrottle(t *testing.T) {
+	assert.NoError(t, RegisterResourceGroup(ResourceGroupConfig{Name: "test_throttle", MaxConcurrentPipelines: 1, OnLimit: OnLimitThrottle}))
+	group := GetResourceGroup("test_throttle")
+
+	assert.True(t, group.Acquire("a", nil))
+	//re-entrant for the same pipeline
+	assert.True(t, group.Acquire("a", nil))
+	assert.False(t, group.Acquire("b", nil))
+
+	group.Release("a")
+	assert.True(t, group.Acquire("b", nil))
+
+	usage := group.Usage()
+	assert.Equal(t, []string{"b"}, usage.Running)
+	assert.Equal(t, int64(1), usage.ThrottledRuns)
+	group.Release("b")
+}
+
+func TestResourceGroupQueue(t *testing.T) {
+	assert.NoError(t, RegisterResourceGroup(ResourceGroupConfig{Name: "test_queue", MaxConcurrentPipelines: 1}))
+	group := GetResourceGroup("test_queue")
+	assert.True(t, group.Acquire("a", nil))
+
+	acquired := make(chan string, 2)
+	go func() {
+		if group.Acquire("b", nil) {
+			acquired <- "b"
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancelled := atomic.Bool{}
+	go func() {
+		if group.Acquire("c", func() bool { return cancelled.Load() }) {
+			acquired <- "c"
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"b", "c"}, group.Usage().Queued)
+
+	//first in, first out
+	group.Release("a")
+	assert.Equal(t, "b", <-acquired)
+
+	//cancelled while waiting
+	cancelled.Store(true)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 0, len(group.Usage().Queued))
+	assert.Equal(t, 0, len(acquired))
+	group.Release("b")
+}
+
+func TestResourceGroupLimits(t *testing.T) {
+	assert.NoError(t, RegisterResourceGroup(ResourceGroupConfig{Name: "test_limits", MaxWorkers: 2, MaxMemory: "1mb", OnLimit: OnLimitThrottle}))
+	group := GetResourceGroup("test_limits")
+
+	usage := memoryUsage
+	defer func() {
+		memoryUsage = usage
+	}()
+	memoryUsage = func() uint64 {
+		return 1024
+	}
+
+	ctx := AcquireContext(PipelineConfigV2{Name: "test", ResourceGroup: "test_limits"})
+	child := &Context{ParentContext: ctx}
+	assert.True(t, child.AcquireWorker())
+	assert.True(t, ctx.AcquireWorker())
+	assert.False(t, ctx.AcquireWorker())
+	child.ReleaseWorker()
+	assert.True(t, ctx.AcquireWorker())
+	assert.Equal(t, 2, group.Usage().Workers)
+	ctx.ReleaseWorker()
+	ctx.ReleaseWorker()
+
+	//over the memory limit
+	memoryUsage = func() uint64 {
+		return 2 * 1024 * 1024
+	}
+	assert.False(t, ctx.AcquireWorker())
+	assert.False(t, group.Acquire("test", nil))
+
+	//not in any group
+	ctx = AcquireContext(PipelineConfigV2{Name: "test"})
+	assert.True(t, ctx.AcquireWorker())
+}
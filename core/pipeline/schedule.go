@@ -0,0 +1,109 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"time"
+
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/task/chrono"
+	"infini.sh/framework/core/util"
+)
+
+// what to do if the pipeline is still running when the next run is due
+const OverlapSkip = "skip"
+const OverlapQueue = "queue"
+const OverlapCancel = "cancel"
+
+// ScheduleConfig starts the pipeline on a crontab or a fixed interval, eg:
+//
+//	schedule:
+//	  crontab: "0 0 1 * * *" #every day at 01:00, with seconds
+//	  overlap: skip
+type ScheduleConfig struct {
+	Crontab  string `config:"crontab" json:"crontab,omitempty"`
+	Interval string `config:"interval" json:"interval,omitempty"`
+	Overlap  string `config:"overlap" json:"overlap,omitempty"`
+	//max num of runs to keep in the history
+	MaxHistory int `config:"max_history" json:"max_history,omitempty"`
+}
+
+func (cfg *ScheduleConfig) Validate() error {
+	if cfg.Crontab == "" && cfg.Interval == "" {
+		return errors.New("crontab or interval must be set for schedule")
+	}
+	if cfg.Crontab != "" && cfg.Interval != "" {
+		return errors.New("crontab and interval can't be set at the same time")
+	}
+	switch cfg.Overlap {
+	case "", OverlapSkip, OverlapQueue, OverlapCancel:
+	default:
+		return errors.Errorf("invalid overlap policy: %v", cfg.Overlap)
+	}
+	_, err := cfg.NextTime(time.Now())
+	return err
+}
+
+func (cfg *ScheduleConfig) GetOverlap() string {
+	if cfg.Overlap == "" {
+		return OverlapSkip
+	}
+	return cfg.Overlap
+}
+
+// NextTime return the next run time after the given time
+func (cfg *ScheduleConfig) NextTime(after time.Time) (time.Time, error) {
+	if cfg.Crontab != "" {
+		exp, err := chrono.ParseCronExpression(cfg.Crontab)
+		if err != nil {
+			return time.Time{}, err
+		}
+		next := exp.NextTime(after)
+		if next.IsZero() {
+			return next, errors.Errorf("no next run time for crontab: %v", cfg.Crontab)
+		}
+		return next, nil
+	}
+	interval, err := util.ParseDuration(cfg.Interval)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if interval <= 0 {
+		return time.Time{}, errors.Errorf("invalid interval: %v", cfg.Interval)
+	}
+	return after.Add(interval), nil
+}
+
+type ScheduledRun struct {
+	TriggerTime time.Time    `json:"trigger_time"`
+	Action      string       `json:"action"`
+	StartTime   *time.Time   `json:"start_time,omitempty"`
+	EndTime     *time.Time   `json:"end_time,omitempty"`
+	State       RunningState `json:"state,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
@@ -0,0 +1,57 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleConfig(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 30, 0, 0, time.Local)
+
+	cfg := ScheduleConfig{Crontab: "0 0 1 * * *"}
+	assert.Nil(t, cfg.Validate())
+	assert.Equal(t, OverlapSkip, cfg.GetOverlap())
+	next, err := cfg.NextTime(now)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2024, 1, 2, 1, 0, 0, 0, time.Local), next)
+
+	cfg = ScheduleConfig{Interval: "30m", Overlap: OverlapQueue}
+	assert.Nil(t, cfg.Validate())
+	next, err = cfg.NextTime(now)
+	assert.Nil(t, err)
+	assert.Equal(t, now.Add(30*time.Minute), next)
+
+	assert.NotNil(t, (&ScheduleConfig{}).Validate())
+	assert.NotNil(t, (&ScheduleConfig{Crontab: "0 0 1 * * *", Interval: "1h"}).Validate())
+	assert.NotNil(t, (&ScheduleConfig{Interval: "1h", Overlap: "wait"}).Validate())
+	assert.NotNil(t, (&ScheduleConfig{Crontab: "0 1 * * *"}).Validate())
+}
@@ -0,0 +1,218 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/param"
+	"infini.sh/framework/core/util"
+)
+
+// processors writing to queues, elasticsearch or other external systems, they are stubbed out in simulation
+var sideEffectProcessors = map[string]bool{}
+var sideEffectLock = sync.RWMutex{}
+
+func RegisterSideEffectProcessor(name string) {
+	sideEffectLock.Lock()
+	defer sideEffectLock.Unlock()
+	sideEffectProcessors[name] = true
+}
+
+func hasSideEffects(name string) bool {
+	sideEffectLock.RLock()
+	defer sideEffectLock.RUnlock()
+	return sideEffectProcessors[name]
+}
+
+type SideEffect struct {
+	Processor string                 `json:"processor"`
+	Config    map[string]interface{} `json:"config,omitempty"`
+}
+
+type SimulateResult struct {
+	Success     bool         `json:"success"`
+	State       RunningState `json:"state"`
+	Context     util.MapStr  `json:"context"`
+	FlowProcess []string     `json:"flow_process"`
+	Processors  []Span       `json:"processors"`
+	SideEffects []SideEffect `json:"side_effects,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+func (ctx *Context) IsSimulation() bool {
+	return ctx.root().simulation
+}
+
+// AddSideEffect record the side effect skipped in simulation, for processors only partially stubbed out
+func (ctx *Context) AddSideEffect(v SideEffect) {
+	c := ctx.root()
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	c.sideEffects = append(c.sideEffects, v)
+}
+
+// simulatedProcessor takes the place of the processors with side effects, they are not even constructed in simulation
+type simulatedProcessor struct {
+	name string
+}
+
+func (p *simulatedProcessor) Name() string {
+	return p.name
+}
+
+func (p *simulatedProcessor) Process(ctx *Context) error {
+	return nil
+}
+
+// Simulate run the processors against the sample parameters in an isolated context,
+// processors with side effects are skipped and reported in the result
+func Simulate(processors []*config.Config, params util.MapStr, timeout time.Duration) (*SimulateResult, error) {
+	procs, err := newPipeline(processors, true)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := PipelineConfigV2{Name: "_simulate", Transient: true}
+	cfg.Tracing.Enabled = true
+	ctx := AcquireContext(cfg)
+	ctx.simulation = true
+	ctx.Started()
+	ctx.ResetContext()
+	for k, v := range params {
+		ctx.Set(param.ParaKey(k), v)
+	}
+
+	//the processors may still be running after timeout, they are released after they exit and the result was collected
+	collected := make(chan struct{})
+	defer close(collected)
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			<-collected
+			procs.Release()
+			ReleaseContext(ctx)
+		}()
+		done <- procs.Process(ctx)
+	}()
+
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		ctx.CancelTask()
+		err = errors.Errorf("simulation timeout after %v", timeout)
+	}
+
+	if err != nil {
+		ctx.Failed(err)
+	} else if !ctx.IsFailed() {
+		ctx.Finished()
+	}
+
+	result := &SimulateResult{
+		State:       ctx.GetRunningState(),
+		Context:     ctx.CloneData(),
+		FlowProcess: ctx.GetFlowProcess(),
+		Processors:  ctx.GetSpans(),
+	}
+	ctx.stateLock.Lock()
+	result.SideEffects = append([]SideEffect{}, ctx.sideEffects...)
+	if ctx.exitErr != nil {
+		result.Error = ctx.exitErr.Error()
+	}
+	ctx.stateLock.Unlock()
+	result.Success = result.State == FINISHED && result.Error == ""
+	if errs := ctx.Errors(); len(errs) > 0 && result.Error == "" {
+		result.Error = strings.Join(errorsToStrings(errs), "; ")
+		result.Success = false
+	}
+	return result, nil
+}
+
+// SimulateYAML run the processors defined in yaml, plugin authors can test their processors with it, eg:
+//
+//	result, err := pipeline.SimulateYAML(`
+//	- echo:
+//	    message: hello
+//	`, util.MapStr{"key": "value"})
+func SimulateYAML(yml string, params util.MapStr) (*SimulateResult, error) {
+	cfg, err := config.NewConfigWithYAML([]byte("processor:\n"+indent(yml)), "simulate")
+	if err != nil {
+		return nil, err
+	}
+	obj := struct {
+		Processors []*config.Config `config:"processor"`
+	}{}
+	err = cfg.Unpack(&obj)
+	if err != nil {
+		return nil, err
+	}
+	return Simulate(obj.Processors, params, 0)
+}
+
+func indent(yml string) string {
+	lines := strings.Split(yml, "\n")
+	for i, v := range lines {
+		lines[i] = "  " + v
+	}
+	return strings.Join(lines, "\n")
+}
+
+func errorsToStrings(errs []error) []string {
+	result := make([]string, 0, len(errs))
+	for _, v := range errs {
+		if v != nil {
+			result = append(result, v.Error())
+		}
+	}
+	return result
+}
+
+// stubProcess record the processor instead of running it
+func (p *instrumentedProcessor) stubProcess(ctx *Context) error {
+	effect := SideEffect{Processor: p.action}
+	if p.config != nil {
+		effect.Config = map[string]interface{}{}
+		if err := p.config.Unpack(effect.Config); err != nil {
+			log.Warn(err)
+		}
+	}
+	ctx.AddSideEffect(effect)
+	ctx.AddFlowProcess("simulated")
+	return nil
+}
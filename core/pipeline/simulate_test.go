@@ -0,0 +1,170 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/param"
+	"infini.sh/framework/core/util"
+)
+
+type simulateTestProcessor struct {
+	name string
+	fail bool
+}
+
+func (p *simulateTestProcessor) Name() string {
+	return p.name
+}
+
+func (p *simulateTestProcessor) Process(ctx *Context) error {
+	if p.fail {
+		return errors.New("failed on purpose")
+	}
+	name, _ := ctx.GetString("name")
+	ctx.Set(param.ParaKey("greeting"), "hello "+name)
+	return nil
+}
+
+func TestSimulate(t *testing.T) {
+	RegisterProcessorPlugin("simulate_test_set", func(c *config.Config) (Processor, error) {
+		fail, _ := c.Bool("fail", -1)
+		return &simulateTestProcessor{name: "simulate_test_set", fail: fail}, nil
+	})
+	RegisterProcessorPlugin("simulate_test_index", func(c *config.Config) (Processor, error) {
+		return &simulateTestProcessor{name: "simulate_test_index", fail: true}, nil
+	})
+	RegisterSideEffectProcessor("simulate_test_index")
+
+	result, err := SimulateYAML(`
+- simulate_test_set: {}
+- simulate_test_index:
+    index: test
+`, util.MapStr{"name": "world"})
+	assert.Nil(t, err)
+	assert.Equal(t, true, result.Success)
+	assert.Equal(t, FINISHED, result.State)
+	assert.Equal(t, "hello world", result.Context["greeting"])
+	assert.Equal(t, []string{"simulate_test_set", "simulate_test_index", "simulated"}, result.FlowProcess)
+	assert.Equal(t, 1, len(result.Processors))
+	assert.Equal(t, 1, len(result.SideEffects))
+	assert.Equal(t, "test", result.SideEffects[0].Config["index"])
+
+	result, err = SimulateYAML(`
+- simulate_test_set:
+    fail: true
+`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, false, result.Success)
+	assert.Equal(t, FAILED, result.State)
+	assert.Equal(t, "failed on purpose", result.Processors[0].Error)
+
+	_, err = SimulateYAML(`
+- simulate_test_not_exists: {}
+`, nil)
+	assert.NotNil(t, err)
+}
+
+// simulateSlowProcessor keeps running for a while after the simulation was canceled
+type simulateSlowProcessor struct {
+	exited   *int32
+	released *int32
+}
+
+func (p *simulateSlowProcessor) Name() string {
+	return "simulate_test_slow"
+}
+
+func (p *simulateSlowProcessor) Process(ctx *Context) error {
+	<-ctx.Done()
+	time.Sleep(100 * time.Millisecond)
+	atomic.StoreInt32(p.exited, 1)
+	return nil
+}
+
+func (p *simulateSlowProcessor) Release() error {
+	if atomic.LoadInt32(p.exited) == 0 {
+		return errors.New("released while running")
+	}
+	atomic.StoreInt32(p.released, 1)
+	return nil
+}
+
+func TestSimulateTimeout(t *testing.T) {
+	var exited, released int32
+	RegisterProcessorPlugin("simulate_test_slow", func(c *config.Config) (Processor, error) {
+		return &simulateSlowProcessor{exited: &exited, released: &released}, nil
+	})
+
+	cfg, err := config.NewConfigWithYAML([]byte("processor:\n  - simulate_test_slow: {}"), "simulate")
+	assert.Nil(t, err)
+	obj := struct {
+		Processors []*config.Config `config:"processor"`
+	}{}
+	assert.Nil(t, cfg.Unpack(&obj))
+
+	result, err := Simulate(obj.Processors, nil, 50*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, false, result.Success)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&exited))
+
+	//released only after the processor exited
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&released) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSimulateSkipConstructors(t *testing.T) {
+	var constructed int32
+	RegisterProcessorPlugin("simulate_test_produce", func(c *config.Config) (Processor, error) {
+		atomic.AddInt32(&constructed, 1)
+		return &simulateTestProcessor{name: "simulate_test_produce"}, nil
+	})
+	RegisterSideEffectProcessor("simulate_test_produce")
+
+	result, err := SimulateYAML(`
+- simulate_test_produce:
+    queue: test
+- if:
+    equals:
+      name: world
+  then:
+    - simulate_test_produce:
+        queue: test
+`, util.MapStr{"name": "world"})
+	assert.Nil(t, err)
+	assert.Equal(t, true, result.Success)
+	assert.Equal(t, 2, len(result.SideEffects))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&constructed))
+}
@@ -62,6 +62,8 @@ type AdvancedQueueAPI interface {
 	GetOffset(k *QueueConfig, consumer *ConsumerConfig) (Offset, error)
 	DeleteOffset(k *QueueConfig, consumer *ConsumerConfig) error
 	CommitOffset(k *QueueConfig, consumer *ConsumerConfig, offset Offset) (bool, error)
+	//resolve the offset of the first message produced at or after the time
+	GetOffsetByTime(k *QueueConfig, t time.Time) (Offset, error)
 
 	AcquireConsumer(k *QueueConfig, consumer *ConsumerConfig) (ConsumerAPI, error)
 	ReleaseConsumer(k *QueueConfig, c *ConsumerConfig, consumer ConsumerAPI) error
@@ -355,6 +357,18 @@ func CommitOffset(k *QueueConfig, consumer *ConsumerConfig, offset Offset) (bool
 	panic(errors.New("handler is not registered"))
 }
 
+func GetOffsetByTime(k *QueueConfig, t time.Time) (Offset, error) {
+	if k == nil || k.ID == "" {
+		panic(errors.New("queue name can't be nil"))
+	}
+
+	handler := getAdvancedHandler(k)
+	if handler != nil {
+		return handler.GetOffsetByTime(k, t)
+	}
+	panic(errors.New("handler is not registered"))
+}
+
 func GetStorageSize(k string) uint64 {
 	if k == "" {
 		panic(errors.New("queue name can't be nil"))
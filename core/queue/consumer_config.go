@@ -64,6 +64,10 @@ type ConsumerConfig struct {
 	ClientExpiredInSeconds int64 `config:"client_expired_in_seconds" json:"client_expired_in_seconds,omitempty"` //client acquires lock for this long
 	fetchMaxWaitMs         time.Duration
 
+	//move message to dead letter queue after this many failed deliveries, 0 means never
+	MaxDeliveryCount int    `config:"max_delivery_count" json:"max_delivery_count,omitempty"`
+	DeadLetterQueue  string `config:"dead_letter_queue" json:"dead_letter_queue,omitempty"`
+
 	CommitLocker sync.Mutex
 }
 
@@ -88,6 +92,10 @@ func (cfg *ConsumerConfig) GetFetchMaxWaitMs() time.Duration {
 	return cfg.fetchMaxWaitMs
 }
 
+func (cfg *ConsumerConfig) DeadLetterEnabled() bool {
+	return cfg.MaxDeliveryCount > 0 && cfg.DeadLetterQueue != ""
+}
+
 func (cfg *ConsumerConfig) String() string {
 	return fmt.Sprintf("group:%v,name:%v,id:%v,source:%v, simple:%v", cfg.Group, cfg.Name, cfg.ID, cfg.Source, cfg.SimpleSlicedGroup)
 }
@@ -0,0 +1,621 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/locker"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// members of a consumer group share a set of resources (slices or queues) through a coordinator,
+// each member holds a lease in the locker, the member holding the leader lease assigns the resources
+// to the live members and saves the assignment to the kv store, members pick up their resources
+// on each heartbeat, a resource is only owned after its previous owner released it or its lease expired,
+// workers enter a resource by AcquireResource, and the lease of a revoked resource is kept until its workers exit
+const GroupMembersBucket = "queue_group_members"
+const GroupAssignmentBucket = "queue_group_assignment"
+
+const groupMemberLeaseBucket = "queue_group_member"
+const groupLeaderLeaseBucket = "queue_group_leader"
+const groupResourceLeaseBucket = "queue_group_resource"
+
+type CoordinatorConfig struct {
+	Enabled bool `config:"enabled" json:"enabled"`
+	//default to the node id
+	MemberID                   string `config:"member_id" json:"member_id,omitempty"`
+	SessionTimeoutInSeconds    int    `config:"session_timeout_in_seconds" json:"session_timeout_in_seconds,omitempty"`
+	HeartbeatIntervalInSeconds int    `config:"heartbeat_interval_in_seconds" json:"heartbeat_interval_in_seconds,omitempty"`
+}
+
+type GroupMember struct {
+	ID        string    `json:"id"`
+	Resources []string  `json:"resources,omitempty"`
+	Joined    time.Time `json:"joined"`
+}
+
+type GroupAssignment struct {
+	Group      string              `json:"group"`
+	Generation int64               `json:"generation"`
+	Leader     string              `json:"leader"`
+	Members    map[string][]string `json:"members"`
+	Updated    time.Time           `json:"updated"`
+}
+
+type GroupCoordinator struct {
+	group    string
+	memberID string
+	timeout  time.Duration
+	interval time.Duration
+
+	lock       sync.RWMutex
+	resources  map[string]struct{}
+	joined     bool
+	owned      map[string]struct{}
+	releasing  map[string]struct{}
+	running    map[string]int //resource=num of workers
+	assignment *GroupAssignment
+
+	quit     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+var coordinators = sync.Map{}
+
+func NewGroupCoordinator(group string, cfg CoordinatorConfig) *GroupCoordinator {
+	if group == "" {
+		panic(errors.New("group can't be empty"))
+	}
+
+	c := &GroupCoordinator{
+		group:     group,
+		memberID:  cfg.MemberID,
+		timeout:   time.Duration(cfg.SessionTimeoutInSeconds) * time.Second,
+		interval:  time.Duration(cfg.HeartbeatIntervalInSeconds) * time.Second,
+		resources: map[string]struct{}{},
+		owned:     map[string]struct{}{},
+		releasing: map[string]struct{}{},
+		running:   map[string]int{},
+		quit:      make(chan struct{}),
+	}
+	if c.memberID == "" {
+		c.memberID = global.Env().SystemConfig.NodeConfig.ID
+	}
+	if c.timeout <= 0 {
+		c.timeout = 30 * time.Second
+	}
+	if c.interval <= 0 || c.interval >= c.timeout {
+		c.interval = c.timeout / 3
+	}
+	return c
+}
+
+func (c *GroupCoordinator) Group() string {
+	return c.group
+}
+
+func (c *GroupCoordinator) MemberID() string {
+	return c.memberID
+}
+
+func (c *GroupCoordinator) Start() {
+	coordinators.Store(c.group+"/"+c.memberID, c)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			c.heartbeat()
+			select {
+			case <-c.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Debugf("coordinator of group [%v] started, member: %v", c.group, c.memberID)
+}
+
+// Stop leave the group and release all the resources
+func (c *GroupCoordinator) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.quit)
+		c.wg.Wait()
+		coordinators.Delete(c.group + "/" + c.memberID)
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		for k := range c.owned {
+			locker.Release(groupResourceLeaseBucket, c.group+"/"+k, c.memberID)
+		}
+		for k := range c.releasing {
+			locker.Release(groupResourceLeaseBucket, c.group+"/"+k, c.memberID)
+		}
+		c.owned = map[string]struct{}{}
+		c.releasing = map[string]struct{}{}
+
+		members := loadGroupMembers(c.group)
+		if _, ok := members[c.memberID]; ok {
+			delete(members, c.memberID)
+			saveGroupMembers(c.group, members)
+		}
+		locker.Release(groupMemberLeaseBucket, c.group+"/"+c.memberID, c.memberID)
+		locker.Release(groupLeaderLeaseBucket, c.group, c.memberID)
+		log.Debugf("coordinator of group [%v] stopped, member: %v", c.group, c.memberID)
+	})
+}
+
+// AddResources declare the resources this member is able to handle, they are assigned on the next heartbeats
+func (c *GroupCoordinator) AddResources(resources ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, v := range resources {
+		if _, ok := c.resources[v]; !ok {
+			c.resources[v] = struct{}{}
+			c.joined = false
+		}
+	}
+}
+
+// IsAssigned return true if the resource is assigned to this member and its lease is held
+func (c *GroupCoordinator) IsAssigned(resource string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	_, ok := c.owned[resource]
+	return ok
+}
+
+// AcquireResource should be called by the worker before it starts working on the resource,
+// it returns false if the resource is not assigned to this member, otherwise ReleaseResource must be called on exit
+func (c *GroupCoordinator) AcquireResource(resource string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.owned[resource]; !ok {
+		return false
+	}
+	c.running[resource]++
+	return true
+}
+
+// ReleaseResource is called by the worker on exit, the lease of a revoked resource is released with its last worker
+func (c *GroupCoordinator) ReleaseResource(resource string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.running[resource] > 1 {
+		c.running[resource]--
+		return
+	}
+	delete(c.running, resource)
+	if _, ok := c.releasing[resource]; ok {
+		c.releaseLease(resource)
+	}
+}
+
+func (c *GroupCoordinator) releaseLease(resource string) {
+	delete(c.releasing, resource)
+	err := locker.Release(groupResourceLeaseBucket, c.group+"/"+resource, c.memberID)
+	if err != nil {
+		log.Debug(err)
+	}
+}
+
+func (c *GroupCoordinator) Assigned() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	result := make([]string, 0, len(c.owned))
+	for k := range c.owned {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (c *GroupCoordinator) Status() util.MapStr {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	owned := make([]string, 0, len(c.owned))
+	for k := range c.owned {
+		owned = append(owned, k)
+	}
+	sort.Strings(owned)
+	m := util.MapStr{
+		"group":     c.group,
+		"member_id": c.memberID,
+		"owned":     owned,
+	}
+	if c.assignment != nil {
+		m["generation"] = c.assignment.Generation
+		m["leader"] = c.assignment.Leader
+	}
+	return m
+}
+
+func (c *GroupCoordinator) heartbeat() {
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				var v string
+				switch r.(type) {
+				case error:
+					v = r.(error).Error()
+				case runtime.Error:
+					v = r.(runtime.Error).Error()
+				case string:
+					v = r.(string)
+				}
+				log.Errorf("error on heartbeat of group [%v], member [%v], %v", c.group, c.memberID, v)
+			}
+		}
+	}()
+
+	ok, err := locker.Hold(groupMemberLeaseBucket, c.group+"/"+c.memberID, c.memberID, c.timeout, true)
+	if !ok || err != nil {
+		log.Warnf("failed to renew the lease of member [%v] in group [%v], %v", c.memberID, c.group, err)
+		return
+	}
+
+	c.join()
+
+	ok, _ = locker.Hold(groupLeaderLeaseBucket, c.group, c.memberID, c.timeout, true)
+	if ok {
+		c.rebalance()
+	}
+
+	assignment, err := GetGroupAssignment(c.group)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	c.apply(assignment)
+}
+
+// join add this member and its resources to the group, members may be dropped by concurrent
+// updates of the record, so it is checked on every heartbeat
+func (c *GroupCoordinator) join() {
+	c.lock.RLock()
+	resources := make([]string, 0, len(c.resources))
+	for k := range c.resources {
+		resources = append(resources, k)
+	}
+	joined := c.joined
+	c.lock.RUnlock()
+	sort.Strings(resources)
+
+	members := loadGroupMembers(c.group)
+	if v, ok := members[c.memberID]; ok && joined && reflect.DeepEqual(v.Resources, resources) {
+		return
+	}
+
+	member := &GroupMember{ID: c.memberID, Resources: resources, Joined: time.Now()}
+	if v, ok := members[c.memberID]; ok {
+		member.Joined = v.Joined
+	}
+	members[c.memberID] = member
+	saveGroupMembers(c.group, members)
+
+	c.lock.Lock()
+	c.joined = true
+	c.lock.Unlock()
+}
+
+func (c *GroupCoordinator) isMemberAlive(memberID string) bool {
+	ok, info, err := locker.GetAllocateInfo(groupMemberLeaseBucket, c.group+"/"+memberID)
+	if !ok || err != nil || info == nil {
+		return false
+	}
+	return info.ClientID == memberID && time.Since(info.Timestamp) <= c.timeout
+}
+
+// rebalance is only called by the leader
+func (c *GroupCoordinator) rebalance() {
+	members := loadGroupMembers(c.group)
+	declared := map[string][]string{}
+	resources := map[string]struct{}{}
+	var changed bool
+	for id, m := range members {
+		if !c.isMemberAlive(id) {
+			log.Infof("member [%v] of group [%v] expired, leaving the group", id, c.group)
+			stats.Increment("consumer_group", c.group, "member_expired")
+			delete(members, id)
+			changed = true
+			continue
+		}
+		declared[id] = m.Resources
+		for _, r := range m.Resources {
+			resources[r] = struct{}{}
+		}
+	}
+	if changed {
+		saveGroupMembers(c.group, members)
+	}
+
+	previous, err := GetGroupAssignment(c.group)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	assigned := assignResources(declared, previous.Members)
+	if reflect.DeepEqual(assigned, previous.Members) && previous.Leader == c.memberID {
+		return
+	}
+
+	assignment := GroupAssignment{
+		Group:      c.group,
+		Generation: previous.Generation + 1,
+		Leader:     c.memberID,
+		Members:    assigned,
+		Updated:    time.Now(),
+	}
+	err = kv.AddValue(GroupAssignmentBucket, []byte(c.group), util.MustToJSONBytes(assignment))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	stats.Increment("consumer_group", c.group, "rebalance")
+	log.Debugf("group [%v] rebalanced, generation: %v, members: %v, resources: %v", c.group, assignment.Generation, len(declared), len(resources))
+}
+
+// apply take the leases of the assigned resources, the revoked ones are released once their workers exit,
+// their leases are renewed till then, so that the new owner won't start before them
+func (c *GroupCoordinator) apply(assignment *GroupAssignment) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	owned := map[string]struct{}{}
+	for _, v := range assignment.Members[c.memberID] {
+		ok, _ := locker.Hold(groupResourceLeaseBucket, c.group+"/"+v, c.memberID, c.timeout, true)
+		if ok {
+			owned[v] = struct{}{}
+			delete(c.releasing, v)
+		} else if global.Env().IsDebug {
+			log.Debugf("resource [%v] of group [%v] is still held by the previous owner", v, c.group)
+		}
+	}
+
+	for k := range c.owned {
+		if _, ok := owned[k]; !ok {
+			c.releasing[k] = struct{}{}
+		}
+	}
+	c.owned = owned
+	c.assignment = assignment
+
+	for k := range c.releasing {
+		if c.running[k] == 0 {
+			c.releaseLease(k)
+			continue
+		}
+		ok, _ := locker.Hold(groupResourceLeaseBucket, c.group+"/"+k, c.memberID, c.timeout, true)
+		if !ok {
+			log.Warnf("failed to renew the lease of revoked resource [%v] in group [%v], its worker is still running", k, c.group)
+		}
+	}
+}
+
+// assignResources assign each resource to one of the members declared it, the resources declared by
+// the same members are spread evenly across them, members may not be able to handle the others
+func assignResources(declared map[string][]string, previous map[string][]string) map[string][]string {
+	byResource := map[string][]string{}
+	for m, resources := range declared {
+		for _, r := range resources {
+			byResource[r] = append(byResource[r], m)
+		}
+	}
+
+	//resources grouped by the members declared them
+	members := map[string][]string{}
+	resources := map[string][]string{}
+	for r, v := range byResource {
+		sort.Strings(v)
+		key := strings.Join(v, ",")
+		members[key] = v
+		resources[key] = append(resources[key], r)
+	}
+
+	result := map[string][]string{}
+	for m := range declared {
+		result[m] = []string{}
+	}
+	for key, v := range members {
+		for m, assigned := range spreadResources(v, resources[key], previous) {
+			result[m] = append(result[m], assigned...)
+		}
+	}
+	for m := range result {
+		sort.Strings(result[m])
+	}
+	return result
+}
+
+// spreadResources spread the resources evenly across the members, and keep the previous assignment where possible
+func spreadResources(members []string, resources []string, previous map[string][]string) map[string][]string {
+	result := map[string][]string{}
+	if len(members) == 0 {
+		return result
+	}
+
+	members = append([]string{}, members...)
+	sort.Strings(members)
+	resources = append([]string{}, resources...)
+	sort.Strings(resources)
+
+	valid := map[string]bool{}
+	for _, v := range resources {
+		valid[v] = true
+	}
+
+	//resources each member already have
+	kept := map[string][]string{}
+	taken := map[string]bool{}
+	for _, m := range members {
+		prev := append([]string{}, previous[m]...)
+		sort.Strings(prev)
+		for _, r := range prev {
+			if valid[r] && !taken[r] {
+				kept[m] = append(kept[m], r)
+				taken[r] = true
+			}
+		}
+	}
+
+	//members with most resources get the extra ones
+	base := len(resources) / len(members)
+	extra := len(resources) % len(members)
+	order := append([]string{}, members...)
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(kept[order[i]]) > len(kept[order[j]])
+	})
+	target := map[string]int{}
+	for i, m := range order {
+		target[m] = base
+		if i < extra {
+			target[m]++
+		}
+	}
+
+	unassigned := []string{}
+	for _, m := range members {
+		v := kept[m]
+		if len(v) > target[m] {
+			unassigned = append(unassigned, v[target[m]:]...)
+			v = v[:target[m]]
+		}
+		result[m] = v
+	}
+	for _, r := range resources {
+		if !taken[r] {
+			unassigned = append(unassigned, r)
+		}
+	}
+	sort.Strings(unassigned)
+
+	for _, m := range members {
+		for len(result[m]) < target[m] && len(unassigned) > 0 {
+			result[m] = append(result[m], unassigned[0])
+			unassigned = unassigned[1:]
+		}
+		if result[m] == nil {
+			result[m] = []string{}
+		}
+		sort.Strings(result[m])
+	}
+	return result
+}
+
+func loadGroupMembers(group string) map[string]*GroupMember {
+	members := map[string]*GroupMember{}
+	data, err := kv.GetValue(GroupMembersBucket, []byte(group))
+	if err != nil {
+		panic(err)
+	}
+	if len(data) > 0 {
+		err = util.FromJSONBytes(data, &members)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return members
+}
+
+func saveGroupMembers(group string, members map[string]*GroupMember) {
+	err := kv.AddValue(GroupMembersBucket, []byte(group), util.MustToJSONBytes(members))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func GetGroupAssignment(group string) (*GroupAssignment, error) {
+	assignment := &GroupAssignment{Group: group, Members: map[string][]string{}}
+	data, err := kv.GetValue(GroupAssignmentBucket, []byte(group))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		err = util.FromJSONBytes(data, assignment)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return assignment, nil
+}
+
+func GetGroupMembers(group string) map[string]*GroupMember {
+	return loadGroupMembers(group)
+}
+
+// GetLocalGroupCoordinators return the status of the coordinators running in this instance
+func GetLocalGroupCoordinators(group string) []util.MapStr {
+	result := []util.MapStr{}
+	coordinators.Range(func(key, value interface{}) bool {
+		c := value.(*GroupCoordinator)
+		if group == "" || c.group == group {
+			result = append(result, c.Status())
+		}
+		return true
+	})
+	return result
+}
+
+// GetGroupStatus return the assignment of the group, with the last heartbeat of each member
+func GetGroupStatus(group string) (util.MapStr, error) {
+	assignment, err := GetGroupAssignment(group)
+	if err != nil {
+		return nil, err
+	}
+	members := util.MapStr{}
+	for id, m := range loadGroupMembers(group) {
+		member := util.MapStr{
+			"resources": m.Resources,
+			"joined":    m.Joined,
+		}
+		ok, info, err := locker.GetAllocateInfo(groupMemberLeaseBucket, group+"/"+id)
+		if ok && err == nil && info != nil {
+			member["last_heartbeat"] = info.Timestamp
+		}
+		members[id] = member
+	}
+	return util.MapStr{
+		"assignment": assignment,
+		"members":    members,
+		"local":      GetLocalGroupCoordinators(group),
+	}, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/queue/queuetest"
+)
+
+func waitFor(t *testing.T, timeout time.Duration, f func() bool) {
+	start := time.Now()
+	for !f() {
+		if time.Since(start) > timeout {
+			t.Fatal("timeout")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestRevokedResourceIsHeldByWorker(t *testing.T) {
+	queuetest.Setup()
+	cfg := queue.CoordinatorConfig{Enabled: true, SessionTimeoutInSeconds: 3, HeartbeatIntervalInSeconds: 1}
+
+	cfg.MemberID = "a"
+	a := queue.NewGroupCoordinator(t.Name(), cfg)
+	a.AddResources("r-0", "r-1")
+	a.Start()
+	defer a.Stop()
+	waitFor(t, 5*time.Second, func() bool { return len(a.Assigned()) == 2 })
+
+	//a worker is running on r-1
+	assert.Equal(t, a.AcquireResource("r-1"), true)
+
+	cfg.MemberID = "b"
+	b := queue.NewGroupCoordinator(t.Name(), cfg)
+	b.AddResources("r-0", "r-1")
+	b.Start()
+	defer b.Stop()
+
+	//r-1 is revoked from a, but b can't take it while the worker is running
+	waitFor(t, 5*time.Second, func() bool { return !a.IsAssigned("r-1") })
+	assert.Equal(t, a.AcquireResource("r-1"), false)
+	time.Sleep(2500 * time.Millisecond)
+	assert.Equal(t, b.IsAssigned("r-1"), false)
+
+	a.ReleaseResource("r-1")
+	waitFor(t, 5*time.Second, func() bool { return b.IsAssigned("r-1") })
+	assert.Equal(t, a.Assigned(), []string{"r-0"})
+}
@@ -0,0 +1,77 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"github.com/magiconair/properties/assert"
+	"testing"
+)
+
+func TestSpreadResources(t *testing.T) {
+	resources := []string{"q-0", "q-1", "q-2", "q-3", "q-4"}
+
+	assigned := spreadResources([]string{"b", "a"}, resources, nil)
+	assert.Equal(t, assigned["a"], []string{"q-0", "q-1", "q-2"})
+	assert.Equal(t, assigned["b"], []string{"q-3", "q-4"})
+
+	//a new member only takes over the extra resources
+	assigned = spreadResources([]string{"a", "b", "c"}, resources, assigned)
+	assert.Equal(t, assigned["a"], []string{"q-0", "q-1"})
+	assert.Equal(t, assigned["b"], []string{"q-3", "q-4"})
+	assert.Equal(t, assigned["c"], []string{"q-2"})
+
+	//resources of the expired member are moved to the others
+	assigned = spreadResources([]string{"a", "c"}, resources, assigned)
+	assert.Equal(t, assigned["a"], []string{"q-0", "q-1", "q-3"})
+	assert.Equal(t, assigned["c"], []string{"q-2", "q-4"})
+
+	assigned = spreadResources([]string{"a", "b", "c", "d", "e", "f"}, resources, assigned)
+	assert.Equal(t, len(assigned["f"]), 0)
+
+	assert.Equal(t, len(spreadResources(nil, resources, nil)), 0)
+}
+
+func TestAssignResources(t *testing.T) {
+	declared := map[string][]string{
+		"a": {"q1-0", "q1-1", "q2-0"},
+		"b": {"q1-0", "q1-1"},
+		"c": {},
+	}
+	assigned := assignResources(declared, nil)
+	assert.Equal(t, assigned["a"], []string{"q1-0", "q2-0"})
+	assert.Equal(t, assigned["b"], []string{"q1-1"})
+	//members never get the resources they didn't declare
+	assert.Equal(t, assigned["c"], []string{})
+
+	//the previous assignment is kept for the same members
+	declared["c"] = []string{"q1-0", "q1-1", "q2-0"}
+	assigned = assignResources(declared, assigned)
+	assert.Equal(t, assigned["a"], []string{"q1-0", "q2-0"})
+	assert.Equal(t, assigned["b"], []string{"q1-1"})
+	assert.Equal(t, assigned["c"], []string{})
+}
@@ -0,0 +1,322 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+const DeliveryStateBucket = "queue_consumer_delivery_state"
+
+// DeadLetterLabel marks the queue as a dead letter queue, only those queues can be replayed
+const DeadLetterLabel = "dead_letter"
+
+const (
+	DeadLetterHeaderReason        = "dlq_reason"
+	DeadLetterHeaderSourceQueue   = "dlq_source_queue"
+	DeadLetterHeaderSourceQueueID = "dlq_source_queue_id"
+	DeadLetterHeaderSourceOffset  = "dlq_source_offset"
+	DeadLetterHeaderConsumer      = "dlq_consumer"
+	DeadLetterHeaderDeliveryCount = "dlq_delivery_count"
+	DeadLetterHeaderTimestamp     = "dlq_timestamp"
+)
+
+// DeadLetterMessage is the record written to the dead letter queue, the original payload is kept untouched
+type DeadLetterMessage struct {
+	Headers map[string]string `json:"headers"`
+	Data    []byte            `json:"data"`
+
+	//key and headers of the original message, restored on replay
+	Key             []byte            `json:"key,omitempty"`
+	OriginalHeaders map[string]string `json:"original_headers,omitempty"`
+}
+
+// DeliveryState tracks the failed deliveries of the message which is blocking the consumer
+type DeliveryState struct {
+	Offset  Offset `json:"offset"`
+	Count   int    `json:"count"`
+	Reason  string `json:"reason,omitempty"`
+	Updated int64  `json:"updated"`
+}
+
+func getDeliveryStateKey(k *QueueConfig, consumer *ConsumerConfig) []byte {
+	return []byte(fmt.Sprintf("%v-%v", k.ID, consumer.Key()))
+}
+
+func GetDeliveryState(k *QueueConfig, consumer *ConsumerConfig) (*DeliveryState, error) {
+	data, err := kv.GetValue(DeliveryStateBucket, getDeliveryStateKey(k, consumer))
+	if err != nil {
+		return nil, err
+	}
+	state := &DeliveryState{}
+	if len(data) > 0 {
+		err = util.FromJSONBytes(data, state)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// GetDeliveryCount returns the failed deliveries of the message at the offset, 0 if it never failed
+func GetDeliveryCount(k *QueueConfig, consumer *ConsumerConfig, offset Offset) (int, error) {
+	state, err := GetDeliveryState(k, consumer)
+	if err != nil {
+		return 0, err
+	}
+	if state.Offset != offset {
+		return 0, nil
+	}
+	return state.Count, nil
+}
+
+func ResetDeliveryState(k *QueueConfig, consumer *ConsumerConfig) error {
+	return kv.DeleteKey(DeliveryStateBucket, getDeliveryStateKey(k, consumer))
+}
+
+// IncreaseDeliveryCount records one more failed delivery for the message at the offset,
+// only the message which is blocking the consumer is tracked, the counter restarts when the offset moves
+func IncreaseDeliveryCount(k *QueueConfig, consumer *ConsumerConfig, offset Offset, reason error) (int, error) {
+	state, err := GetDeliveryState(k, consumer)
+	if err != nil {
+		return 0, err
+	}
+
+	if state.Offset != offset {
+		state.Offset = offset
+		state.Count = 0
+	}
+	state.Count++
+	state.Updated = time.Now().Unix()
+	if reason != nil {
+		state.Reason = reason.Error()
+	}
+
+	err = kv.AddValue(DeliveryStateBucket, getDeliveryStateKey(k, consumer), util.MustToJSONBytes(state))
+	if err != nil {
+		return 0, err
+	}
+	stats.Increment("consumer", k.ID, consumer.GetID(), "delivery_failure")
+	return state.Count, nil
+}
+
+// OnDeliveryFailure should be called when the messages failed to be handled,
+// messages are moved to the dead letter queue once the max delivery count was reached,
+// return true if they were moved, so that the consumer can safely commit the offset after them
+func OnDeliveryFailure(k *QueueConfig, consumer *ConsumerConfig, messages []Message, reason error) (bool, error) {
+	if !consumer.DeadLetterEnabled() || len(messages) == 0 {
+		return false, nil
+	}
+
+	count, err := IncreaseDeliveryCount(k, consumer, messages[0].Offset, reason)
+	if err != nil {
+		return false, err
+	}
+
+	if count < consumer.MaxDeliveryCount {
+		log.Debugf("queue [%v], consumer [%v], offset [%v] failed %v/%v times", k.Name, consumer.Key(), messages[0].Offset.EncodeToString(), count, consumer.MaxDeliveryCount)
+		return false, nil
+	}
+
+	err = MoveToDeadLetterQueue(k, consumer, messages, reason, count)
+	if err != nil {
+		return false, err
+	}
+
+	err = ResetDeliveryState(k, consumer)
+	if err != nil {
+		log.Error(err)
+	}
+	return true, nil
+}
+
+func GetDeadLetterQueueConfig(consumer *ConsumerConfig) *QueueConfig {
+	if consumer.DeadLetterQueue == "" {
+		panic(errors.Errorf("dead letter queue for consumer [%v] was not configured", consumer.Key()))
+	}
+	return AdvancedGetOrInitConfig("", consumer.DeadLetterQueue, util.MapStr{DeadLetterLabel: true})
+}
+
+func IsDeadLetterQueue(k *QueueConfig) bool {
+	if k == nil || k.Labels == nil {
+		return false
+	}
+	switch v := k.Labels[DeadLetterLabel].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func MoveToDeadLetterQueue(k *QueueConfig, consumer *ConsumerConfig, messages []Message, reason error, deliveryCount int) error {
+	dlq := GetDeadLetterQueueConfig(consumer)
+	if dlq.ID == k.ID {
+		return errors.Errorf("queue [%v] can't be the dead letter queue of itself", k.Name)
+	}
+
+	producer, err := AcquireProducer(dlq)
+	if err != nil {
+		return err
+	}
+	defer producer.Close()
+
+	var reasonStr string
+	if reason != nil {
+		reasonStr = reason.Error()
+	}
+
+	reqs := []ProduceRequest{}
+	for _, msg := range messages {
+		dlm := DeadLetterMessage{
+			Headers: map[string]string{
+				DeadLetterHeaderReason:        reasonStr,
+				DeadLetterHeaderSourceQueue:   k.Name,
+				DeadLetterHeaderSourceQueueID: k.ID,
+				DeadLetterHeaderSourceOffset:  msg.Offset.EncodeToString(),
+				DeadLetterHeaderConsumer:      consumer.Key(),
+				DeadLetterHeaderDeliveryCount: util.IntToString(deliveryCount),
+				DeadLetterHeaderTimestamp:     time.Now().Format(time.RFC3339),
+			},
+			Data:            msg.Data,
+			Key:             msg.Key,
+			OriginalHeaders: msg.Headers,
+		}
+		reqs = append(reqs, ProduceRequest{Topic: dlq.ID, Key: msg.Key, Headers: dlm.Headers, Data: util.MustToJSONBytes(dlm)})
+	}
+
+	_, err = producer.Produce(&reqs)
+	if err != nil {
+		stats.Increment("consumer", k.ID, consumer.GetID(), "dead_letter_error")
+		return err
+	}
+
+	stats.IncrementBy("consumer", k.ID+"."+consumer.GetID()+".dead_letter", int64(len(messages)))
+	log.Warnf("queue [%v], consumer [%v], %v messages from offset [%v] moved to dead letter queue [%v] after %v failed deliveries, reason: %v",
+		k.Name, consumer.Key(), len(messages), messages[0].Offset.EncodeToString(), dlq.Name, deliveryCount, reasonStr)
+	return nil
+}
+
+const deadLetterReplayGroup = "dead_letter"
+const deadLetterReplayName = "replay"
+
+// ReplayDeadLetterMessages moves up to size messages from the dead letter queue back to their source queue,
+// or to the target queue if specified, return the number of messages replayed
+func ReplayDeadLetterMessages(dlq *QueueConfig, target string, size int) (int, error) {
+	if dlq == nil || dlq.ID == "" {
+		panic(errors.New("queue name can't be nil"))
+	}
+
+	if !IsDeadLetterQueue(dlq) {
+		return 0, errors.Errorf("queue [%v] is not a dead letter queue", dlq.Name)
+	}
+
+	//the registered config keeps the offset, fetch settings only apply to this replay
+	GetOrInitConsumerConfig(dlq.ID, deadLetterReplayGroup, deadLetterReplayName)
+	consumerConfig := NewConsumerConfig(dlq.ID, deadLetterReplayGroup, deadLetterReplayName)
+	if size > 0 {
+		consumerConfig.FetchMaxMessages = size
+	}
+	consumerConfig.EOFMaxRetryTimes = 1
+
+	consumer, err := AcquireConsumer(dlq, consumerConfig, util.GetUUID())
+	if err != nil {
+		return 0, err
+	}
+	defer ReleaseConsumer(dlq, consumerConfig, consumer)
+
+	ctx := &Context{}
+	messages, _, err := consumer.FetchMessages(ctx, size)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	reqs := map[string][]ProduceRequest{}
+	for _, msg := range messages {
+		dlm := DeadLetterMessage{}
+		err = util.FromJSONBytes(msg.Data, &dlm)
+		if err != nil {
+			return 0, errors.Errorf("invalid dead letter message at offset [%v], %v", msg.Offset.EncodeToString(), err)
+		}
+
+		queueName := target
+		if queueName == "" {
+			queueName = dlm.Headers[DeadLetterHeaderSourceQueueID]
+			if queueName == "" {
+				queueName = dlm.Headers[DeadLetterHeaderSourceQueue]
+			}
+		}
+		if queueName == "" {
+			return 0, errors.Errorf("no target queue for dead letter message at offset [%v]", msg.Offset.EncodeToString())
+		}
+		reqs[queueName] = append(reqs[queueName], ProduceRequest{Key: dlm.Key, Headers: dlm.OriginalHeaders, Data: dlm.Data})
+	}
+
+	for queueName, v := range reqs {
+		qConfig, ok := SmartGetConfig(queueName)
+		if !ok {
+			qConfig = GetOrInitConfig(queueName)
+		}
+		for i := range v {
+			v[i].Topic = qConfig.ID
+		}
+		producer, err := AcquireProducer(qConfig)
+		if err != nil {
+			return 0, err
+		}
+		_, err = producer.Produce(&v)
+		producer.Close()
+		if err != nil {
+			return 0, err
+		}
+		if global.Env().IsDebug {
+			log.Debugf("replayed %v messages from dead letter queue [%v] to [%v]", len(v), dlq.Name, qConfig.Name)
+		}
+	}
+
+	err = consumer.CommitOffset(ctx.NextOffset)
+	if err != nil {
+		return 0, err
+	}
+
+	stats.IncrementBy("queue", dlq.ID+".dead_letter_replayed", int64(len(messages)))
+	return len(messages), nil
+}
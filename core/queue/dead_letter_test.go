@@ -0,0 +1,157 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/queue/queuetest"
+	"infini.sh/framework/core/util"
+)
+
+func newDeadLetterConsumer(t *testing.T) (*queue.QueueConfig, *queue.ConsumerConfig) {
+	queuetest.Setup()
+	qCfg := queue.GetOrInitConfig(t.Name())
+	cCfg := queue.GetOrInitConsumerConfig(qCfg.ID, "group-001", "consumer-001")
+	cCfg.MaxDeliveryCount = 3
+	cCfg.DeadLetterQueue = t.Name() + "-dlq"
+	return qCfg, cCfg
+}
+
+func TestMaxDeliveryCount(t *testing.T) {
+	qCfg, cCfg := newDeadLetterConsumer(t)
+	messages := []queue.Message{{Offset: queue.NewOffset(0, 10), Data: []byte("a")}}
+	reason := errors.New("mapper_parsing_exception")
+
+	for i := 1; i < cCfg.MaxDeliveryCount; i++ {
+		moved, err := queue.OnDeliveryFailure(qCfg, cCfg, messages, reason)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, moved, false)
+		count, _ := queue.GetDeliveryCount(qCfg, cCfg, messages[0].Offset)
+		assert.Equal(t, count, i)
+	}
+
+	//other offsets never failed
+	count, _ := queue.GetDeliveryCount(qCfg, cCfg, queue.NewOffset(0, 20))
+	assert.Equal(t, count, 0)
+
+	moved, err := queue.OnDeliveryFailure(qCfg, cCfg, messages, reason)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, moved, true)
+
+	//the counter restarts after the message was moved
+	count, _ = queue.GetDeliveryCount(qCfg, cCfg, messages[0].Offset)
+	assert.Equal(t, count, 0)
+
+	//the counter restarts when another message is blocking
+	queue.IncreaseDeliveryCount(qCfg, cCfg, queue.NewOffset(0, 30), reason)
+	count, _ = queue.IncreaseDeliveryCount(qCfg, cCfg, queue.NewOffset(0, 40), reason)
+	assert.Equal(t, count, 1)
+}
+
+func TestDeadLetterHeaders(t *testing.T) {
+	mq := queuetest.Setup()
+	qCfg, cCfg := newDeadLetterConsumer(t)
+	messages := []queue.Message{
+		{Offset: queue.NewOffset(1, 10), Data: []byte("a"), Key: []byte("k1")},
+		{Offset: queue.NewOffset(1, 20), Data: []byte("b")},
+	}
+
+	err := queue.MoveToDeadLetterQueue(qCfg, cCfg, messages, errors.New("invalid document"), 3)
+	assert.Equal(t, err, nil)
+
+	dlq := queue.GetDeadLetterQueueConfig(cCfg)
+	assert.Equal(t, queue.IsDeadLetterQueue(dlq), true)
+	assert.Equal(t, queue.IsDeadLetterQueue(qCfg), false)
+
+	records := mq.Messages(dlq.ID)
+	assert.Equal(t, len(records), 2)
+	assert.Equal(t, string(records[0].Key), "k1")
+
+	dlm := queue.DeadLetterMessage{}
+	assert.Equal(t, util.FromJSONBytes(records[1].Data, &dlm), nil)
+	assert.Equal(t, string(dlm.Data), "b")
+	assert.Equal(t, dlm.Headers[queue.DeadLetterHeaderReason], "invalid document")
+	assert.Equal(t, dlm.Headers[queue.DeadLetterHeaderSourceQueue], qCfg.Name)
+	assert.Equal(t, dlm.Headers[queue.DeadLetterHeaderSourceQueueID], qCfg.ID)
+	assert.Equal(t, dlm.Headers[queue.DeadLetterHeaderSourceOffset], "1,20,0")
+	assert.Equal(t, dlm.Headers[queue.DeadLetterHeaderConsumer], cCfg.Key())
+	assert.Equal(t, dlm.Headers[queue.DeadLetterHeaderDeliveryCount], "3")
+	assert.Equal(t, records[1].Headers[queue.DeadLetterHeaderSourceQueueID], qCfg.ID)
+}
+
+func TestReplayDeadLetterMessages(t *testing.T) {
+	mq := queuetest.Setup()
+	qCfg, cCfg := newDeadLetterConsumer(t)
+	messages := []queue.Message{
+		{Offset: queue.NewOffset(0, 1), Key: []byte("key-a"), Headers: map[string]string{"source": "test"}, Data: []byte("a")},
+		{Offset: queue.NewOffset(0, 2), Data: []byte("b")},
+		{Offset: queue.NewOffset(0, 3), Data: []byte("c")},
+	}
+	assert.Equal(t, queue.MoveToDeadLetterQueue(qCfg, cCfg, messages, errors.New("failed"), 3), nil)
+	dlq := queue.GetDeadLetterQueueConfig(cCfg)
+
+	//back to the source queue
+	count, err := queue.ReplayDeadLetterMessages(dlq, "", 2)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, 2)
+	replayed := mq.Messages(qCfg.ID)
+	assert.Equal(t, len(replayed), 2)
+	assert.Equal(t, string(replayed[0].Data), "a")
+	assert.Equal(t, string(replayed[1].Data), "b")
+	//key and headers of the original message are restored
+	assert.Equal(t, string(replayed[0].Key), "key-a")
+	assert.Equal(t, replayed[0].Headers, map[string]string{"source": "test"})
+
+	//the rest goes to the target queue
+	target := queue.GetOrInitConfig(t.Name() + "-target")
+	count, err = queue.ReplayDeadLetterMessages(dlq, target.Name, 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, 1)
+	replayed = mq.Messages(target.ID)
+	assert.Equal(t, len(replayed), 1)
+	assert.Equal(t, string(replayed[0].Data), "c")
+
+	//nothing left
+	count, err = queue.ReplayDeadLetterMessages(dlq, "", 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, count, 0)
+
+	//only dead letter queues can be replayed
+	_, err = queue.ReplayDeadLetterMessages(qCfg, "", 10)
+	assert.Equal(t, err != nil, true)
+	assert.Equal(t, len(mq.Messages(qCfg.ID)), 2)
+
+	//the shared consumer config is not changed by the replay
+	cfg := queue.GetOrInitConsumerConfig(dlq.ID, "dead_letter", "replay")
+	assert.Equal(t, cfg.FetchMaxMessages, 500)
+	assert.Equal(t, cfg.EOFMaxRetryTimes, 10)
+}
@@ -165,6 +165,9 @@ type Message struct {
 	NextOffset Offset `config:"next_offset" json:"next_offset"  parquet:"next_offset"` //offset for next message
 	Size       int    `config:"size" json:"size"  parquet:"size"`
 	Data       []byte `config:"data" json:"data"  parquet:"data,zstd"`
+
+	Key     []byte            `config:"key" json:"key,omitempty" parquet:"key,optional"`
+	Headers map[string]string `config:"headers" json:"headers,omitempty" parquet:"headers,optional"`
 }
 
 func (m *Message) String() string {
@@ -172,14 +175,20 @@ func (m *Message) String() string {
 }
 
 type ProduceRequest struct {
-	Topic string `config:"topic" json:"topic"` //queue_id
-	Key   []byte `config:"key" json:"key"`
-	Data  []byte `config:"data" json:"data"`
+	Topic     string            `config:"topic" json:"topic"` //queue_id
+	Key       []byte            `config:"key" json:"key"`
+	Data      []byte            `config:"data" json:"data"`
+	Timestamp int64             `config:"timestamp" json:"timestamp,omitempty"` //unix nano, default to now
+	Headers   map[string]string `config:"headers" json:"headers,omitempty"`
+	NotBefore int64             `config:"not_before" json:"not_before,omitempty"` //unix nano, the message is invisible to consumers until then
 }
 
 type ProduceResponse struct {
 	Topic     string `config:"topic" json:"topic"`
 	Partition int64  `config:"partition" json:"partition"`
-	Offset    Offset `config:"offset" json:"offset"`
-	Timestamp int64  `config:"timestamp" json:"timestamp"`
+	Offset    Offset `config:"offset" json:"offset"`       //empty for scheduled messages, they get their offset on delivery
+	Timestamp int64  `config:"timestamp" json:"timestamp"` //unix nano
+	Scheduled bool   `config:"scheduled" json:"scheduled,omitempty"`
 }
+
+var ErrDelayedDeliveryNotSupported = errors.New("delayed delivery is not supported")
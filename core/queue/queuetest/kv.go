@@ -0,0 +1,113 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+// Package queuetest provides in-memory kv store and queue implementations
+// for unit tests of queue consumers, they are registered as the default
+// handlers by Setup and keep everything in process memory.
+package queuetest
+
+import (
+	"sync"
+
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/queue"
+)
+
+type MemoryKVStore struct {
+	lock    sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+func NewMemoryKVStore() *MemoryKVStore {
+	return &MemoryKVStore{buckets: map[string]map[string][]byte{}}
+}
+
+func (s *MemoryKVStore) Open() error {
+	return nil
+}
+
+func (s *MemoryKVStore) Close() error {
+	return nil
+}
+
+func (s *MemoryKVStore) GetValue(bucket string, key []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	v, ok := s.buckets[bucket][string(key)]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, v...), nil
+}
+
+func (s *MemoryKVStore) GetCompressedValue(bucket string, key []byte) ([]byte, error) {
+	return s.GetValue(bucket, key)
+}
+
+func (s *MemoryKVStore) AddValueCompress(bucket string, key []byte, value []byte) error {
+	return s.AddValue(bucket, key, value)
+}
+
+func (s *MemoryKVStore) AddValue(bucket string, key []byte, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		b = map[string][]byte{}
+		s.buckets[bucket] = b
+	}
+	b[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (s *MemoryKVStore) ExistsKey(bucket string, key []byte) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	_, ok := s.buckets[bucket][string(key)]
+	return ok, nil
+}
+
+func (s *MemoryKVStore) DeleteKey(bucket string, key []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.buckets[bucket], string(key))
+	return nil
+}
+
+var setupOnce sync.Once
+var defaultQueue *MemoryQueue
+
+// Setup registers the in-memory kv store and queue as the default handlers, it is safe to be called by every test,
+// the returned queue is shared by all the tests in the same package
+func Setup() *MemoryQueue {
+	setupOnce.Do(func() {
+		kv.Register("memory", NewMemoryKVStore())
+		defaultQueue = NewMemoryQueue()
+		queue.RegisterDefaultHandler(defaultQueue)
+	})
+	return defaultQueue
+}
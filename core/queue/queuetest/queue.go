@@ -0,0 +1,249 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queuetest
+
+import (
+	"sync"
+	"time"
+
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/queue"
+)
+
+// MemoryQueue implements queue.AdvancedQueueAPI, messages of each queue are kept in a slice,
+// the position of the offset is the index of the message in the slice
+type MemoryQueue struct {
+	lock     sync.RWMutex
+	messages map[string][]queue.Message
+	offsets  map[string]queue.Offset
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		messages: map[string][]queue.Message{},
+		offsets:  map[string]queue.Offset{},
+	}
+}
+
+func (q *MemoryQueue) Name() string {
+	return "memory"
+}
+
+func (q *MemoryQueue) Init(string) error {
+	return nil
+}
+
+func (q *MemoryQueue) Close(string) error {
+	return nil
+}
+
+func (q *MemoryQueue) GetStorageSize(k string) uint64 {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	var size uint64
+	for _, m := range q.messages[k] {
+		size += uint64(m.Size)
+	}
+	return size
+}
+
+func (q *MemoryQueue) Destroy(k string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.messages, k)
+	return nil
+}
+
+func (q *MemoryQueue) GetQueues() []string {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	queues := []string{}
+	for k := range q.messages {
+		queues = append(queues, k)
+	}
+	return queues
+}
+
+func (q *MemoryQueue) Push(k string, data []byte) error {
+	q.append(k, queue.ProduceRequest{Data: data})
+	return nil
+}
+
+func (q *MemoryQueue) append(k string, req queue.ProduceRequest) queue.Offset {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	pos := int64(len(q.messages[k]))
+	timestamp := req.Timestamp
+	if timestamp <= 0 {
+		timestamp = time.Now().UnixNano()
+	}
+	msg := queue.Message{
+		Timestamp:  timestamp,
+		Offset:     queue.NewOffset(0, pos),
+		NextOffset: queue.NewOffset(0, pos+1),
+		Size:       len(req.Data),
+		Data:       append([]byte{}, req.Data...),
+		Key:        req.Key,
+		Headers:    req.Headers,
+	}
+	q.messages[k] = append(q.messages[k], msg)
+	return msg.Offset
+}
+
+// Messages returns all the messages ever produced to the queue
+func (q *MemoryQueue) Messages(k string) []queue.Message {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	return append([]queue.Message{}, q.messages[k]...)
+}
+
+func (q *MemoryQueue) LatestOffset(k *queue.QueueConfig) queue.Offset {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	return queue.NewOffset(0, int64(len(q.messages[k.ID])))
+}
+
+func (q *MemoryQueue) GetOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.Offset, error) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	return q.offsets[k.ID+consumer.Key()], nil
+}
+
+func (q *MemoryQueue) DeleteOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.offsets, k.ID+consumer.Key())
+	return nil
+}
+
+func (q *MemoryQueue) CommitOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig, offset queue.Offset) (bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.offsets[k.ID+consumer.Key()] = offset
+	return true, nil
+}
+
+func (q *MemoryQueue) GetOffsetByTime(k *queue.QueueConfig, t time.Time) (queue.Offset, error) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	for _, m := range q.messages[k.ID] {
+		if m.Timestamp >= t.UnixNano() {
+			return m.Offset, nil
+		}
+	}
+	return queue.NewOffset(0, int64(len(q.messages[k.ID]))), nil
+}
+
+func (q *MemoryQueue) AcquireConsumer(k *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.ConsumerAPI, error) {
+	offset, err := q.GetOffset(k, consumer)
+	if err != nil {
+		return nil, err
+	}
+	return &Consumer{q: q, qCfg: k, cCfg: consumer, position: offset.Position}, nil
+}
+
+func (q *MemoryQueue) ReleaseConsumer(k *queue.QueueConfig, c *queue.ConsumerConfig, consumer queue.ConsumerAPI) error {
+	return consumer.Close()
+}
+
+func (q *MemoryQueue) AcquireProducer(cfg *queue.QueueConfig) (queue.ProducerAPI, error) {
+	return &Producer{q: q, qCfg: cfg}, nil
+}
+
+func (q *MemoryQueue) ReleaseProducer(k *queue.QueueConfig, producer queue.ProducerAPI) error {
+	return producer.Close()
+}
+
+type Producer struct {
+	q    *MemoryQueue
+	qCfg *queue.QueueConfig
+}
+
+func (p *Producer) Produce(reqs *[]queue.ProduceRequest) (*[]queue.ProduceResponse, error) {
+	resps := []queue.ProduceResponse{}
+	for _, req := range *reqs {
+		topic := req.Topic
+		if topic == "" {
+			topic = p.qCfg.ID
+		}
+		offset := p.q.append(topic, req)
+		resps = append(resps, queue.ProduceResponse{Topic: topic, Offset: offset, Timestamp: time.Now().UnixNano()})
+	}
+	return &resps, nil
+}
+
+func (p *Producer) Close() error {
+	return nil
+}
+
+type Consumer struct {
+	q        *MemoryQueue
+	qCfg     *queue.QueueConfig
+	cCfg     *queue.ConsumerConfig
+	position int64
+}
+
+func (c *Consumer) Close() error {
+	return nil
+}
+
+func (c *Consumer) ResetOffset(segment, readPos int64) error {
+	if segment != 0 {
+		return errors.Errorf("invalid segment: %v", segment)
+	}
+	c.position = readPos
+	return nil
+}
+
+func (c *Consumer) FetchMessages(ctx *queue.Context, numOfMessages int) ([]queue.Message, bool, error) {
+	c.q.lock.RLock()
+	defer c.q.lock.RUnlock()
+
+	all := c.q.messages[c.qCfg.ID]
+	ctx.UpdateInitOffset(0, c.position, 0)
+	if c.position >= int64(len(all)) {
+		ctx.UpdateNextOffset(0, c.position)
+		ctx.MessageCount = 0
+		return nil, false, nil
+	}
+
+	end := c.position + int64(numOfMessages)
+	if numOfMessages <= 0 || end > int64(len(all)) {
+		end = int64(len(all))
+	}
+	messages := append([]queue.Message{}, all[c.position:end]...)
+	c.position = end
+	ctx.UpdateNextOffset(0, end)
+	ctx.MessageCount = len(messages)
+	return messages, false, nil
+}
+
+func (c *Consumer) CommitOffset(offset queue.Offset) error {
+	_, err := c.q.CommitOffset(c.qCfg, c.cCfg, offset)
+	return err
+}
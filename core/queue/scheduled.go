@@ -0,0 +1,78 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"time"
+
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+const RetryCountHeader = "retry_count"
+
+// RetryLater produce the messages back to the queue, they will be delivered to the consumers again after the delay
+func RetryLater(k *QueueConfig, messages []Message, delay time.Duration) error {
+	if k == nil || k.ID == "" {
+		panic(errors.New("queue name can't be nil"))
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	producer, err := AcquireProducer(k)
+	if err != nil {
+		return err
+	}
+	defer producer.Close()
+
+	notBefore := time.Now().Add(delay).UnixNano()
+	reqs := make([]ProduceRequest, 0, len(messages))
+	for _, msg := range messages {
+		headers := make(map[string]string, len(msg.Headers)+1)
+		for hk, hv := range msg.Headers {
+			headers[hk] = hv
+		}
+		var retries int
+		if v, ok := headers[RetryCountHeader]; ok {
+			retries, _ = util.ToInt(v)
+		}
+		headers[RetryCountHeader] = util.IntToString(retries + 1)
+		reqs = append(reqs, ProduceRequest{Topic: k.ID, Key: msg.Key, Headers: headers, Data: msg.Data, NotBefore: notBefore})
+	}
+
+	_, err = producer.Produce(&reqs)
+	if err != nil {
+		return err
+	}
+
+	stats.IncrementBy("queue", k.ID+".retry_later", int64(len(messages)))
+	return nil
+}
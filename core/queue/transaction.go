@@ -0,0 +1,230 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"sync"
+
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// TransactionKey is the key of the transaction in the pipeline context,
+// processors should produce through the transaction if it exists
+const TransactionKey = "QUEUE_TRANSACTION"
+
+// TransactionIDHeader is added to messages produced in a transaction
+const TransactionIDHeader = "txn_id"
+
+// TransactionalQueueAPI is implemented by queue handlers which can apply
+// produce requests and consumer offsets atomically
+type TransactionalQueueAPI interface {
+	CommitTransaction(txn *Transaction) error
+}
+
+type TransactionProduce struct {
+	Queue    string           `json:"queue"`
+	Requests []ProduceRequest `json:"requests"`
+}
+
+type TransactionCommit struct {
+	Queue    string `json:"queue"`
+	Group    string `json:"group"`
+	Consumer string `json:"consumer"`
+	Offset   Offset `json:"offset"`
+}
+
+type TransactionState int
+
+const (
+	TransactionOpen TransactionState = iota
+	TransactionCommitted
+	TransactionAborted
+)
+
+// Transaction collect messages to produce to N queues and consumer offsets to commit,
+// nothing is applied until End, and either all or none of them become visible
+type Transaction struct {
+	ID       string                `json:"id"`
+	Produces []*TransactionProduce `json:"produces,omitempty"`
+	Commits  []*TransactionCommit  `json:"commits,omitempty"`
+
+	state TransactionState
+	lock  sync.Mutex
+}
+
+func BeginTransaction() *Transaction {
+	return &Transaction{ID: util.GetUUID()}
+}
+
+func (txn *Transaction) State() TransactionState {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+	return txn.state
+}
+
+func (txn *Transaction) Produce(k *QueueConfig, reqs ...ProduceRequest) error {
+	if k == nil || k.ID == "" {
+		panic(errors.New("queue name can't be nil"))
+	}
+
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+
+	if txn.state != TransactionOpen {
+		return errors.Errorf("transaction [%v] is already closed", txn.ID)
+	}
+
+	for i := range reqs {
+		if reqs[i].NotBefore > 0 {
+			return ErrDelayedDeliveryNotSupported
+		}
+		if reqs[i].Topic == "" {
+			reqs[i].Topic = k.ID
+		}
+	}
+
+	for _, v := range txn.Produces {
+		if v.Queue == k.ID {
+			v.Requests = append(v.Requests, reqs...)
+			return nil
+		}
+	}
+	txn.Produces = append(txn.Produces, &TransactionProduce{Queue: k.ID, Requests: reqs})
+	return nil
+}
+
+// CommitOffset commit the consumer offset together with the produced messages, the last one wins
+func (txn *Transaction) CommitOffset(k *QueueConfig, consumer *ConsumerConfig, offset Offset) error {
+	if k == nil || k.ID == "" {
+		panic(errors.New("queue name can't be nil"))
+	}
+	if consumer == nil {
+		panic(errors.New("consumer can't be nil"))
+	}
+
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+
+	if txn.state != TransactionOpen {
+		return errors.Errorf("transaction [%v] is already closed", txn.ID)
+	}
+
+	for _, v := range txn.Commits {
+		if v.Queue == k.ID && v.Group == consumer.Group && v.Consumer == consumer.Name {
+			v.Offset = offset
+			return nil
+		}
+	}
+	txn.Commits = append(txn.Commits, &TransactionCommit{Queue: k.ID, Group: consumer.Group, Consumer: consumer.Name, Offset: offset})
+	return nil
+}
+
+func (txn *Transaction) Abort() {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+	if txn.state == TransactionOpen {
+		txn.state = TransactionAborted
+		stats.Increment("queue", "transaction", "aborted")
+	}
+}
+
+// End apply the transaction, all produce requests must go to queues of the same handler
+func (txn *Transaction) End() error {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+
+	if txn.state != TransactionOpen {
+		return errors.Errorf("transaction [%v] is already closed", txn.ID)
+	}
+
+	var err error
+	if len(txn.Produces) == 0 {
+		//nothing to produce, offsets are enough
+		err = ApplyTransactionCommits(txn)
+	} else {
+		var handler QueueAPI
+		for _, v := range txn.Produces {
+			cfg, ok := GetConfigByUUID(v.Queue)
+			if !ok {
+				err = errors.Errorf("queue [%v] was not found", v.Queue)
+				break
+			}
+			h := getHandler(cfg)
+			if handler != nil && handler != h {
+				err = errors.Errorf("queues of different types can't be produced in one transaction")
+				break
+			}
+			handler = h
+		}
+
+		if err == nil {
+			h, ok := handler.(TransactionalQueueAPI)
+			if ok {
+				err = h.CommitTransaction(txn)
+			} else {
+				err = errors.Errorf("queue handler [%v] does not support transaction", handler.Name())
+			}
+		}
+	}
+
+	if err != nil {
+		txn.state = TransactionAborted
+		stats.Increment("queue", "transaction", "failed")
+		return err
+	}
+
+	txn.state = TransactionCommitted
+	stats.Increment("queue", "transaction", "committed")
+	return nil
+}
+
+// ApplyTransactionCommits commit all consumer offsets of the transaction, it is idempotent
+func ApplyTransactionCommits(txn *Transaction) error {
+	for _, v := range txn.Commits {
+		cfg, ok := GetConfigByUUID(v.Queue)
+		if !ok {
+			return errors.Errorf("queue [%v] was not found", v.Queue)
+		}
+		consumer, ok := GetConsumerConfig(v.Queue, v.Group, v.Consumer)
+		if !ok {
+			return errors.Errorf("consumer [%v][%v] of queue [%v] was not found", v.Group, v.Consumer, v.Queue)
+		}
+		current, err := GetOffset(cfg, consumer)
+		if err == nil && current.LatestThan(v.Offset) {
+			//already committed
+			continue
+		}
+		ok, err = CommitOffset(cfg, consumer, v.Offset)
+		if !ok || err != nil {
+			return errors.Errorf("failed to commit offset %v for consumer [%v][%v] of queue [%v], %v", v.Offset, v.Group, v.Consumer, v.Queue, err)
+		}
+	}
+	return nil
+}
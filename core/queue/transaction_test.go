@@ -0,0 +1,60 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestTransactionCollect(t *testing.T) {
+	txn := BeginTransaction()
+	q1 := &QueueConfig{ID: "q1"}
+	q2 := &QueueConfig{ID: "q2"}
+	consumer := &ConsumerConfig{Group: "group-001", Name: "consumer-001"}
+
+	assert.Equal(t, txn.Produce(q1, ProduceRequest{Data: []byte("a")}), nil)
+	assert.Equal(t, txn.Produce(q2, ProduceRequest{Data: []byte("b")}), nil)
+	assert.Equal(t, txn.Produce(q1, ProduceRequest{Data: []byte("c")}), nil)
+
+	assert.Equal(t, len(txn.Produces), 2)
+	assert.Equal(t, len(txn.Produces[0].Requests), 2)
+	assert.Equal(t, txn.Produces[0].Requests[1].Topic, "q1")
+
+	//last offset wins
+	assert.Equal(t, txn.CommitOffset(q1, consumer, NewOffset(0, 10)), nil)
+	assert.Equal(t, txn.CommitOffset(q1, consumer, NewOffset(0, 20)), nil)
+	assert.Equal(t, len(txn.Commits), 1)
+	assert.Equal(t, txn.Commits[0].Offset, NewOffset(0, 20))
+
+	txn.Abort()
+	assert.Equal(t, txn.State(), TransactionAborted)
+	assert.Equal(t, txn.Produce(q1, ProduceRequest{Data: []byte("d")}) != nil, true)
+	assert.Equal(t, txn.End() != nil, true)
+}
@@ -0,0 +1,69 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package rpc
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"infini.sh/framework/core/util"
+)
+
+// JSONCodecName is the content-subtype of the json codec, services described by hand
+// (without generated protobuf code) can exchange plain go structs with it
+const JSONCodecName = "json"
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return util.ToJSONBytes(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return util.FromJSONBytes(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return JSONCodecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// JSONCallOption let the client call use the json codec
+func JSONCallOption() grpc.CallOption {
+	return grpc.CallContentSubtype(JSONCodecName)
+}
+
+// RegisterService register a service to the rpc server, should be called before StartRPCServer
+func RegisterService(desc *grpc.ServiceDesc, impl interface{}) {
+	if s == nil {
+		panic("rpc server was not setup")
+	}
+	s.RegisterService(desc, impl)
+}
@@ -734,7 +734,7 @@ func CompareStringAndBytes(b []byte, s string) bool {
 }
 
 func LimitedBytesSearch(data []byte, term []byte, limit int) bool {
-	buffer := make([]byte, len(term))
+	buffer := make([]byte, 0, len(term))
 	start := false
 	bufferOffset := 0
 	for i, v := range data {
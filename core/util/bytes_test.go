@@ -742,6 +742,12 @@ func TestLimitedBytesSearch(t *testing.T) {
 	term = []byte("\"errors\":false")
 	ok = LimitedBytesSearch(data, term, limit)
 	assert.Equal(t, false, ok)
+
+	//term at the very beginning of the response
+	data = []byte("{\"errors\":true,\"took\":2,\"items\":[]}")
+	term = []byte("\"errors\":true")
+	ok = LimitedBytesSearch(data, term, limit)
+	assert.Equal(t, true, ok)
 }
 
 func TestBytesSearchValue(t *testing.T) {
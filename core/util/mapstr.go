@@ -246,6 +246,9 @@ func toMapStr(v interface{}) (MapStr, error) {
 		m := v.(map[string]interface{})
 		return MapStr(m), nil
 	default:
+		if v == nil {
+			return nil, errors.Errorf("expected map but value is nil")
+		}
 		// Convert slices to maps for array indices support.
 		if kind := reflect.TypeOf(v).Kind(); kind == reflect.Slice || kind == reflect.Array {
 			m := map[string]interface{}{}
@@ -1929,3 +1929,20 @@ func (c *ESAPIV0) ClusterAllocationExplain(ctx context.Context, body []byte, par
 	}
 	return resp.Body, nil
 }
+
+// SupportPointInTime returns false, callers should fallback to scroll
+func (c *ESAPIV0) SupportPointInTime() bool {
+	return false
+}
+
+func (c *ESAPIV0) OpenPointInTime(indexNames string, keepAlive string) (string, error) {
+	return "", elastic.ErrPointInTimeNotSupported
+}
+
+func (c *ESAPIV0) ClosePointInTime(pitID string) error {
+	return elastic.ErrPointInTimeNotSupported
+}
+
+func (c *ESAPIV0) SearchAfter(ctx *elastic.APIContext, pitID string, keepAlive string, query *elastic.SearchRequest, searchAfter []interface{}) ([]byte, error) {
+	return nil, elastic.ErrPointInTimeNotSupported
+}
@@ -0,0 +1,124 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/modules/elastic/adapter"
+)
+
+type ESAPIV7_10 struct {
+	ESAPIV7_7
+}
+
+// SupportPointInTime returns true, point in time was introduced in elasticsearch 7.10
+func (c *ESAPIV7_10) SupportPointInTime() bool {
+	return true
+}
+
+func (c *ESAPIV7_10) OpenPointInTime(indexNames string, keepAlive string) (string, error) {
+	indexNames = util.UrlEncode(indexNames)
+
+	url := fmt.Sprintf("%s/%s/_pit?keep_alive=%s", c.GetEndpoint(), indexNames, keepAlive)
+	resp, err := c.Request(nil, util.Verb_POST, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != 200 {
+		return "", errors.New(string(resp.Body))
+	}
+	return jsonparser.GetString(resp.Body, "id")
+}
+
+func (c *ESAPIV7_10) ClosePointInTime(pitID string) error {
+	url := fmt.Sprintf("%s/_pit", c.GetEndpoint())
+	body := util.MustToJSONBytes(util.MapStr{"id": pitID})
+
+	resp, err := c.Request(context.Background(), util.Verb_DELETE, url, body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 {
+		return errors.New(string(resp.Body))
+	}
+	return nil
+}
+
+func (c *ESAPIV7_10) SearchAfter(ctx *elastic.APIContext, pitID string, keepAlive string, query *elastic.SearchRequest, searchAfter []interface{}) ([]byte, error) {
+	if query == nil {
+		query = &elastic.SearchRequest{}
+	}
+	if query.Sort == nil {
+		query.Sort = &[]interface{}{util.MapStr{c.pointInTimeTiebreaker(): "asc"}}
+	}
+	err := query.Set("pit", util.MapStr{
+		"id":         pitID,
+		"keep_alive": keepAlive,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(searchAfter) > 0 {
+		err = query.Set("search_after", searchAfter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the index is bound to the point in time, it must not be set in the url
+	url := fmt.Sprintf("%s/_search", c.GetEndpoint())
+	body := util.UnsafeStringToBytes(query.ToJSONString())
+
+	resp, err := adapter.RequestTimeout(ctx, util.Verb_POST, url, body, c.metadata, time.Duration(c.metadata.Config.RequestTimeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(string(resp.Body))
+	}
+	return resp.Body, nil
+}
+
+// _shard_doc is available since 7.12, sort by _id on 7.10 and 7.11
+func (c *ESAPIV7_10) pointInTimeTiebreaker() string {
+	cr, err := util.VersionCompare(c.GetVersion().Number, "7.12")
+	if err == nil && cr < 0 {
+		return "_id"
+	}
+	return "_shard_doc"
+}
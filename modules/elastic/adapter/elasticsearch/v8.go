@@ -40,7 +40,7 @@ import (
 )
 
 type ESAPIV8 struct {
-	ESAPIV7_7
+	ESAPIV7_10
 }
 
 func (c *ESAPIV8) InitDefaultTemplate(templateName, indexPrefix string) {
@@ -28,8 +28,12 @@
 package opensearch
 
 import (
+	"context"
 	"fmt"
+	"github.com/buger/jsonparser"
 	"github.com/segmentio/encoding/json"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/modules/elastic/adapter/elasticsearch"
 	"strings"
@@ -94,3 +98,59 @@ func (s *APIV1) DeleteILMPolicy(target string) error {
 
 	return nil
 }
+
+// SupportPointInTime returns true since opensearch 2.4
+func (s *APIV1) SupportPointInTime() bool {
+	cr, err := util.VersionCompare(s.GetVersion().Number, "2.4")
+	return err == nil && cr > -1
+}
+
+func (s *APIV1) OpenPointInTime(indexNames string, keepAlive string) (string, error) {
+	if !s.SupportPointInTime() {
+		return "", elastic.ErrPointInTimeNotSupported
+	}
+	indexNames = util.UrlEncode(indexNames)
+
+	url := fmt.Sprintf("%s/%s/_search/point_in_time?keep_alive=%s", s.GetEndpoint(), indexNames, keepAlive)
+	resp, err := s.Request(nil, util.Verb_POST, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != 200 {
+		return "", errors.New(string(resp.Body))
+	}
+	return jsonparser.GetString(resp.Body, "pit_id")
+}
+
+func (s *APIV1) ClosePointInTime(pitID string) error {
+	if !s.SupportPointInTime() {
+		return elastic.ErrPointInTimeNotSupported
+	}
+	url := fmt.Sprintf("%s/_search/point_in_time", s.GetEndpoint())
+	body := util.MustToJSONBytes(util.MapStr{"pit_id": []string{pitID}})
+
+	resp, err := s.Request(context.Background(), util.Verb_DELETE, url, body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 {
+		return errors.New(string(resp.Body))
+	}
+	return nil
+}
+
+func (s *APIV1) SearchAfter(ctx *elastic.APIContext, pitID string, keepAlive string, query *elastic.SearchRequest, searchAfter []interface{}) ([]byte, error) {
+	if !s.SupportPointInTime() {
+		return nil, elastic.ErrPointInTimeNotSupported
+	}
+	if query == nil {
+		query = &elastic.SearchRequest{}
+	}
+	// opensearch has no _shard_doc, sort by _id as the tiebreaker
+	if query.Sort == nil {
+		query.Sort = &[]interface{}{util.MapStr{"_id": "asc"}}
+	}
+	return s.ESAPIV8.SearchAfter(ctx, pitID, keepAlive, query, searchAfter)
+}
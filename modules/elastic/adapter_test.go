@@ -0,0 +1,279 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/elastic/elastictest"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/framework/modules/elastic/common"
+)
+
+var mockVersions = []struct {
+	distribution string
+	version      string
+	major        int
+	pit          bool
+}{
+	{elastictest.Elasticsearch, "2.4.6", 2, false},
+	{elastictest.Elasticsearch, "5.6.16", 5, false},
+	{elastictest.Elasticsearch, "6.8.0", 6, false},
+	{elastictest.Elasticsearch, "7.10.2", 7, true},
+	{elastictest.Elasticsearch, "8.11.0", 8, true},
+	{elastictest.Opensearch, "2.11.0", 7, true},
+	{elastictest.Easysearch, "1.9.0", 7, false},
+}
+
+func newMockClient(t *testing.T, server *elastictest.Server) elastic.API {
+	cfg := elastic.ElasticsearchConfig{
+		Name:           "mock",
+		Enabled:        true,
+		Endpoint:       server.URL(),
+		RequestTimeout: 10,
+	}
+	//the client is cached by id, keep it unique per test
+	cfg.ID = t.Name()
+	client, err := common.InitElasticInstance(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestAdapterWithMockServer(t *testing.T) {
+	for _, v := range mockVersions {
+		t.Run(v.distribution+"-"+v.version, func(t *testing.T) {
+			server := elastictest.NewServerWithDistribution(v.distribution, v.version)
+			defer server.Close()
+			client := newMockClient(t, server)
+
+			ver := client.GetVersion()
+			assert.Equal(t, v.version, ver.Number)
+
+			_, err := client.Index("test", "", "1", util.MapStr{"name": "first", "age": 10}, "true")
+			assert.Nil(t, err)
+
+			doc, err := client.Get("test", "", "1")
+			assert.Nil(t, err)
+			assert.True(t, doc.Found)
+			assert.Equal(t, "first", doc.Source["name"])
+
+			doc, err = client.Get("test", "", "2")
+			assert.Nil(t, err)
+			assert.Equal(t, 404, doc.StatusCode)
+
+			docType := ""
+			if v.major < 7 {
+				docType = `,"_type":"doc"`
+			}
+			bulk := fmt.Sprintf("{\"index\":{\"_index\":\"test\"%[1]v,\"_id\":\"2\"}}\n{\"name\":\"second\",\"age\":20}\n"+
+				"{\"index\":{\"_index\":\"test\"%[1]v,\"_id\":\"3\"}}\n{\"name\":\"third\",\"age\":30}\n", docType)
+			_, err = client.Bulk([]byte(bulk))
+			assert.Nil(t, err)
+			assert.Equal(t, 3, server.Count("test"))
+
+			count, err := client.Count(context.Background(), "test", nil)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(3), count.Count)
+
+			res, err := client.SearchWithRawQueryDSL("test", []byte(`{"query":{"range":{"age":{"gte":20}}}}`))
+			assert.Nil(t, err)
+			assert.Equal(t, int64(2), res.GetTotal())
+
+			health, err := client.ClusterHealth(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, server.ClusterName, health.Name)
+
+			nodes, err := client.GetNodes()
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(*nodes))
+
+			shards, err := client.CatShards()
+			assert.Nil(t, err)
+			assert.NotEmpty(t, shards)
+			assert.Equal(t, "test", shards[0].Index)
+
+			if v.major >= 5 {
+				_, err = client.Delete("test", "", "3", "true")
+				assert.Nil(t, err)
+				assert.Equal(t, 2, server.Count("test"))
+			}
+		})
+	}
+}
+
+func TestScrollWithMockServer(t *testing.T) {
+	for _, v := range mockVersions {
+		t.Run(v.distribution+"-"+v.version, func(t *testing.T) {
+			server := elastictest.NewServerWithDistribution(v.distribution, v.version)
+			defer server.Close()
+			client := newMockClient(t, server)
+
+			docType := ""
+			if v.major < 7 {
+				docType = `,"_type":"doc"`
+			}
+			bulk := []byte{}
+			for i := 0; i < 25; i++ {
+				bulk = append(bulk, fmt.Sprintf("{\"index\":{\"_index\":\"scroll\"%v,\"_id\":\"%v\"}}\n{\"seq\":%v}\n", docType, i, i)...)
+			}
+			_, err := client.Bulk(bulk)
+			assert.Nil(t, err)
+
+			data, err := client.NewScroll("scroll", "1m", 10, &elastic.SearchRequest{}, 0, 0)
+			assert.Nil(t, err)
+
+			ctx := &elastic.APIContext{
+				Context:  context.Background(),
+				Client:   &fasthttp.Client{},
+				Request:  &fasthttp.Request{},
+				Response: &fasthttp.Response{},
+			}
+
+			total := 0
+			for i := 0; i < 10; i++ {
+				var (
+					scrollID string
+					docs     []elastic.IndexDocument
+				)
+				if v.major >= 7 {
+					resp := elastic.ScrollResponseV7{}
+					assert.Nil(t, util.FromJSONBytes(data, &resp))
+					assert.Equal(t, int64(25), resp.Hits.Total.Value)
+					scrollID, docs = resp.ScrollId, resp.Hits.Docs
+				} else {
+					resp := elastic.ScrollResponse{}
+					assert.Nil(t, util.FromJSONBytes(data, &resp))
+					assert.Equal(t, int64(25), resp.Hits.Total)
+					scrollID, docs = resp.ScrollId, resp.Hits.Docs
+				}
+				assert.NotEmpty(t, scrollID)
+				total += len(docs)
+				if total >= 25 {
+					assert.Nil(t, client.ClearScroll(scrollID))
+					break
+				}
+				ctx.Request.Reset()
+				ctx.Response.Reset()
+				data, err = client.NextScroll(ctx, "1m", scrollID)
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, 25, total)
+		})
+	}
+}
+
+func TestDocumentIteratorWithMockServer(t *testing.T) {
+	for _, v := range mockVersions {
+		t.Run(v.distribution+"-"+v.version, func(t *testing.T) {
+			server := elastictest.NewServerWithDistribution(v.distribution, v.version)
+			defer server.Close()
+			client := newMockClient(t, server)
+			assert.Equal(t, v.pit, client.SupportPointInTime())
+
+			docType := ""
+			if v.major < 7 {
+				docType = `,"_type":"doc"`
+			}
+			bulk := []byte{}
+			for i := 0; i < 25; i++ {
+				bulk = append(bulk, fmt.Sprintf("{\"index\":{\"_index\":\"iterate\"%v,\"_id\":\"%v\"}}\n{\"seq\":%v}\n", docType, i, i)...)
+			}
+			_, err := client.Bulk(bulk)
+			assert.Nil(t, err)
+
+			ctx := &elastic.APIContext{
+				Context:  context.Background(),
+				Client:   &fasthttp.Client{},
+				Request:  &fasthttp.Request{},
+				Response: &fasthttp.Response{},
+			}
+
+			q := &elastic.PartitionQuery{IndexName: "iterate", FieldType: elastic.PartitionByNumber, FieldName: "seq"}
+			partition := &elastic.PartitionInfo{
+				Filter: util.MapStr{"range": util.MapStr{"seq": util.MapStr{"gte": 5, "lte": 16}}},
+			}
+			for _, iterator := range []*elastic.DocumentIterator{
+				elastic.NewDocumentIterator(client, "iterate", nil, 10, "1m"),
+				elastic.NewPartitionIterator(client, q, partition, 5, "1m"),
+			} {
+				seen := map[string]bool{}
+				for i := 0; i < 10; i++ {
+					docs, err := iterator.Next(ctx)
+					assert.Nil(t, err)
+					if len(docs) == 0 {
+						break
+					}
+					for _, doc := range docs {
+						assert.False(t, seen[doc.ID])
+						seen[doc.ID] = true
+					}
+				}
+				assert.Equal(t, v.pit, iterator.UsePointInTime())
+				assert.Equal(t, int64(len(seen)), iterator.Total())
+				assert.Nil(t, iterator.Close())
+			}
+		})
+	}
+}
+
+type mockHost struct {
+	ID   string `json:"id,omitempty" elastic_meta:"_id"`
+	Name string `json:"name,omitempty"`
+}
+
+func TestORMWithMockServer(t *testing.T) {
+	server := elastictest.NewServer("7.10.2")
+	defer server.Close()
+
+	handler := ElasticORM{Client: newMockClient(t, server), Config: common.ORMConfig{Enabled: true}}
+
+	host := mockHost{ID: "h1", Name: "localhost"}
+	assert.Nil(t, handler.Save(nil, &host))
+
+	got := mockHost{ID: "h1"}
+	exists, err := handler.Get(&got)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "localhost", got.Name)
+
+	count, err := handler.Count(&host, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	assert.Nil(t, handler.Delete(nil, &host))
+	exists, err = handler.Get(&got)
+	assert.Equal(t, ErrNotFound, err)
+	assert.False(t, exists)
+}
@@ -128,7 +128,12 @@ func InitClientWithConfig(esConfig elastic.ElasticsearchConfig) (client elastic.
 		api.Version = apiVer
 		client = api
 	} else if major == 7 {
-		if minor >= 7 {
+		if minor >= 10 {
+			api := new(elasticsearch.ESAPIV7_10)
+			api.Elasticsearch = esConfig.ID
+			api.Version = apiVer
+			client = api
+		} else if minor >= 7 {
 			api := new(elasticsearch.ESAPIV7_7)
 			api.Elasticsearch = esConfig.ID
 			api.Version = apiVer
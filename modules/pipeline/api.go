@@ -25,6 +25,7 @@ package pipeline
 
 import (
 	"net/http"
+	"time"
 
 	log "github.com/cihub/seelog"
 	httprouter "infini.sh/framework/core/api/router"
@@ -87,7 +88,15 @@ func (module *PipeModule) getPipelineStatus(id string, config string, processor
 		StartTime:  c1.GetStartTime(),
 		EndTime:    c1.GetEndTime(),
 		Context:    c1.CloneData(),
+		Checkpoint: c1.GetCheckpointInfo(),
+		Metrics:    pipeline.GetPipelineMetrics(id),
+		Spans:      c1.GetSpans(),
 	}
+	if schedule := module.getSchedule(id); schedule != nil {
+		ret.Schedule = schedule.status(10)
+	}
+	ret.ResourceGroup = c1.Config.ResourceGroup
+	ret.Dependencies = module.getDependencyStatus(c1.Config)
 	if config != "false" {
 		v1, ok := module.configs.Load(id)
 		if !ok {
@@ -150,11 +159,40 @@ func (module *PipeModule) createPipelineHandler(w http.ResponseWriter, req *http
 	module.WriteAckOKJSON(w)
 }
 
+func (module *PipeModule) simulatePipelineHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var obj = SimulatePipelineRequest{}
+	err := module.DecodeJSON(req, &obj)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var processors []*config.Config
+	for _, processorDict := range obj.Processors {
+		processor, err := ucfg.NewFrom(processorDict)
+		if err != nil {
+			module.WriteError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		processors = append(processors, config.FromConfig(processor))
+	}
+	result, err := pipeline.Simulate(processors, obj.Context, time.Duration(obj.TimeoutInMs)*time.Millisecond)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	module.WriteJSON(w, result, 200)
+}
+
 func (module *PipeModule) deletePipelineHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	id := ps.ByName("id")
 	_, exists := module.contexts.Load(id)
 	if exists {
 		module.deleteTask(id)
+		err := pipeline.DeleteCheckpoint(id)
+		if err != nil {
+			log.Error("failed to delete checkpoint: ", err)
+		}
+		pipeline.ResetPipelineMetrics(id)
 		module.WriteAckOKJSON(w)
 	} else {
 		module.WriteAckJSON(w, false, 404, util.MapStr{
@@ -186,3 +224,29 @@ func (module *PipeModule) stopTaskHandler(w http.ResponseWriter, req *http.Reque
 		})
 	}
 }
+
+func (module *PipeModule) resetCheckpointHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	c, ok := module.contexts.Load(id)
+	if !ok {
+		module.WriteAckJSON(w, false, 404, util.MapStr{
+			"error": "task not found",
+		})
+		return
+	}
+	ctx, ok := c.(*pipeline.Context)
+	if !ok {
+		module.WriteError(w, "invalid pipeline context", http.StatusInternalServerError)
+		return
+	}
+	err := ctx.ResetCheckpoint()
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	module.WriteAckOKJSON(w)
+}
+
+func (module *PipeModule) getResourceGroupsHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	module.WriteJSON(w, pipeline.GetResourceGroupUsages(), 200)
+}
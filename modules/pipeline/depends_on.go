@@ -0,0 +1,138 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"sort"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+)
+
+type DependencyStatus struct {
+	DependsOn  []string `json:"depends_on,omitempty"`
+	State      string   `json:"state,omitempty"`
+	Pending    []string `json:"pending,omitempty"`
+	Dependents []string `json:"dependents,omitempty"`
+}
+
+// validateDependencies check the depends_on of the new pipeline together with the created ones
+func (module *PipeModule) validateDependencies(v pipeline.PipelineConfigV2) error {
+	if v.DependsOn == nil {
+		return nil
+	}
+	if err := v.DependsOn.Validate(); err != nil {
+		return errors.Errorf("invalid depends_on of pipeline [%v], %v", v.Name, err)
+	}
+	configs := map[string]pipeline.PipelineConfigV2{}
+	module.configs.Range(func(key, value any) bool {
+		cfg, ok := value.(pipeline.PipelineConfigV2)
+		if ok {
+			configs[cfg.Name] = cfg
+		}
+		return true
+	})
+	configs[v.Name] = v
+	for _, dep := range v.GetDependencies() {
+		if _, ok := configs[dep]; !ok {
+			log.Warnf("dependency [%v] of pipeline [%v] not found, will wait for it to be created", dep, v.Name)
+		}
+	}
+	return pipeline.ValidateDependencies(configs)
+}
+
+// pendingDependencies return the dependencies which have not ended with the required state yet
+func (module *PipeModule) pendingDependencies(cfg pipeline.PipelineConfigV2) []string {
+	if cfg.DependsOn == nil {
+		return nil
+	}
+	state := cfg.DependsOn.GetState()
+	pending := []string{}
+	for _, dep := range cfg.DependsOn.Pipelines {
+		v, ok := module.contexts.Load(dep)
+		if !ok {
+			pending = append(pending, dep)
+			continue
+		}
+		ctx, ok := v.(*pipeline.Context)
+		if !ok || !ctx.DependencySatisfied(state) {
+			pending = append(pending, dep)
+		}
+	}
+	return pending
+}
+
+// waitForDependencies block until all the dependencies ended with the required state,
+// return false if the pipeline was stopped while waiting
+func (module *PipeModule) waitForDependencies(ctx *pipeline.Context, cfg pipeline.PipelineConfigV2) bool {
+	logged := false
+	for {
+		pending := module.pendingDependencies(cfg)
+		if len(pending) == 0 {
+			return true
+		}
+		if !logged {
+			log.Infof("pipeline [%v] is waiting for dependencies %v to be %v", cfg.Name, pending, cfg.DependsOn.GetState())
+			logged = true
+		}
+		if ctx.IsExit() || ctx.IsReleased() || module.closed.Load() || global.ShuttingDown() {
+			return false
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (module *PipeModule) getDependencyStatus(cfg pipeline.PipelineConfigV2) *DependencyStatus {
+	status := DependencyStatus{}
+	if cfg.DependsOn != nil && len(cfg.DependsOn.Pipelines) > 0 {
+		status.DependsOn = cfg.DependsOn.Pipelines
+		status.State = string(cfg.DependsOn.GetState())
+		status.Pending = module.pendingDependencies(cfg)
+	}
+	module.configs.Range(func(key, value any) bool {
+		v, ok := value.(pipeline.PipelineConfigV2)
+		if !ok {
+			return true
+		}
+		for _, dep := range v.GetDependencies() {
+			if dep == cfg.Name {
+				status.Dependents = append(status.Dependents, v.Name)
+				break
+			}
+		}
+		return true
+	})
+	if len(status.DependsOn) == 0 && len(status.Dependents) == 0 {
+		return nil
+	}
+	sort.Strings(status.Dependents)
+	return &status
+}
@@ -0,0 +1,286 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/task"
+	"infini.sh/framework/core/util"
+)
+
+// history of the pipeline runs, saved through orm in background, eg:
+//
+//	pipeline_history:
+//	  enabled: true
+//	  max_runs_per_pipeline: 1000
+//	  retention: 7d
+var historyCfg = struct {
+	Enabled bool `config:"enabled"`
+	//max num of runs to keep for each pipeline, 0 means no limit
+	MaxRunsPerPipeline int `config:"max_runs_per_pipeline"`
+	//runs ended before this period are deleted, empty means no limit
+	Retention string `config:"retention"`
+	//how often to apply the retention limits
+	CleanupInterval string `config:"cleanup_interval"`
+	//max num of runs waiting to be saved, runs are dropped when it is full
+	QueueSize int `config:"queue_size"`
+	//save the context data of each run, it may be large
+	IncludeContext bool `config:"include_context"`
+}{Enabled: false, MaxRunsPerPipeline: 1000, Retention: "7d", CleanupInterval: "1h", QueueSize: 1000}
+
+const pipelineRunIndexName = "pipeline_run"
+
+func (module *PipeModule) registerHistoryCleanup() {
+	if !historyCfg.Enabled || (historyCfg.MaxRunsPerPipeline <= 0 && historyCfg.Retention == "") {
+		return
+	}
+	task.RegisterScheduleTask(task.ScheduleTask{
+		Description: "cleanup the history of pipeline runs",
+		Type:        "interval",
+		Interval:    historyCfg.CleanupInterval,
+		Singleton:   true,
+		Task: func(ctx context.Context) {
+			module.cleanupHistory()
+		},
+	})
+}
+
+// startHistoryWriter save the runs in background, so that the pipelines are not blocked by orm
+func (module *PipeModule) startHistoryWriter() {
+	if !historyCfg.Enabled {
+		return
+	}
+	size := historyCfg.QueueSize
+	if size <= 0 {
+		size = 1000
+	}
+	module.historyRuns = make(chan *PipelineRun, size)
+	module.historyQuit = make(chan struct{})
+	module.historyWG.Add(1)
+	go func() {
+		defer module.historyWG.Done()
+		for {
+			select {
+			case run := <-module.historyRuns:
+				module.writeRun(run)
+			case <-module.historyQuit:
+				//flush the pending runs
+				for {
+					select {
+					case run := <-module.historyRuns:
+						module.writeRun(run)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (module *PipeModule) stopHistoryWriter() {
+	if module.historyQuit == nil {
+		return
+	}
+	close(module.historyQuit)
+	module.historyWG.Wait()
+}
+
+func (module *PipeModule) writeRun(run *PipelineRun) {
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				var v string
+				switch r.(type) {
+				case error:
+					v = r.(error).Error()
+				case runtime.Error:
+					v = r.(runtime.Error).Error()
+				case string:
+					v = r.(string)
+				}
+				log.Errorf("error on saving run of pipeline [%v], %v", run.PipelineID, v)
+			}
+		}
+	}()
+
+	if !orm.HasHandler() {
+		return
+	}
+	if err := orm.Create(nil, run); err != nil {
+		log.Errorf("failed to save run of pipeline [%v], %v", run.PipelineID, err)
+	}
+}
+
+// saveRun record the run which just ended, it is saved in background, and dropped if too many runs are pending
+func (module *PipeModule) saveRun(ctx *pipeline.Context, cfg pipeline.PipelineConfigV2, start time.Time, err error) {
+	if module.historyRuns == nil {
+		return
+	}
+
+	end := time.Now()
+	run := &PipelineRun{
+		PipelineID: cfg.Name,
+		ContextID:  ctx.ID(),
+		NodeID:     global.Env().SystemConfig.NodeConfig.ID,
+		StartTime:  &start,
+		EndTime:    &end,
+		Duration:   end.Sub(start).Milliseconds(),
+		State:      ctx.GetRunningState(),
+	}
+	if historyCfg.IncludeContext {
+		run.Context = ctx.CloneData()
+	}
+	errs := []string{}
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, v := range ctx.Errors() {
+		errs = append(errs, v.Error())
+	}
+	run.Error = strings.Join(errs, "; ")
+
+	select {
+	case module.historyRuns <- run:
+	default:
+		log.Warnf("too many runs waiting to be saved, dropping the run of pipeline [%v]", cfg.Name)
+	}
+}
+
+// cleanupHistory delete the runs out of the retention limits
+func (module *PipeModule) cleanupHistory() {
+	if !orm.HasHandler() {
+		return
+	}
+
+	if historyCfg.Retention != "" {
+		retention, err := util.ParseDuration(historyCfg.Retention)
+		if err != nil {
+			log.Errorf("invalid retention of pipeline history: %v, %v", historyCfg.Retention, err)
+		} else {
+			query := util.MapStr{
+				"query": util.MapStr{
+					"range": util.MapStr{
+						"end_time": util.MapStr{
+							"lt": time.Now().Add(-retention),
+						},
+					},
+				},
+			}
+			if err := orm.DeleteBy(&PipelineRun{}, util.MustToJSONBytes(query)); err != nil {
+				log.Errorf("failed to delete expired pipeline runs, %v", err)
+			}
+		}
+	}
+
+	if historyCfg.MaxRunsPerPipeline <= 0 {
+		return
+	}
+	module.configs.Range(func(key, value any) bool {
+		if global.ShuttingDown() {
+			return false
+		}
+		id, ok := key.(string)
+		if !ok {
+			return true
+		}
+		//find the oldest run to keep
+		runs := []PipelineRun{}
+		q := &orm.Query{From: historyCfg.MaxRunsPerPipeline - 1, Size: 1, Conds: orm.And(orm.Eq("pipeline_id", id))}
+		q.AddSort("start_time", orm.DESC)
+		err, _ := orm.SearchWithJSONMapper(&runs, q)
+		if err != nil {
+			log.Errorf("failed to search runs of pipeline [%v], %v", id, err)
+			return true
+		}
+		if len(runs) == 0 || runs[0].StartTime == nil {
+			return true
+		}
+		query := util.MapStr{
+			"query": util.MapStr{
+				"bool": util.MapStr{
+					"must": []util.MapStr{
+						{"term": util.MapStr{"pipeline_id": id}},
+						{"range": util.MapStr{"start_time": util.MapStr{"lt": runs[0].StartTime}}},
+					},
+				},
+			},
+		}
+		if err := orm.DeleteBy(&PipelineRun{}, util.MustToJSONBytes(query)); err != nil {
+			log.Errorf("failed to delete old runs of pipeline [%v], %v", id, err)
+		}
+		return true
+	})
+}
+
+func (module *PipeModule) getPipelineHistoryHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	if !orm.HasHandler() {
+		module.WriteError(w, "orm is not enabled, pipeline history is not available", http.StatusNotFound)
+		return
+	}
+
+	from := module.GetIntOrDefault(req, "from", 0)
+	size := module.GetIntOrDefault(req, "size", 20)
+	if from < 0 {
+		from = 0
+	}
+	if size <= 0 || size > 1000 {
+		size = 20
+	}
+
+	q := &orm.Query{From: from, Size: size, Conds: orm.And(orm.Eq("pipeline_id", id))}
+	if state := module.GetParameterOrDefault(req, "state", ""); state != "" {
+		q.Conds = append(q.Conds, orm.Eq("state", strings.ToUpper(state)))
+	}
+	q.AddSort("start_time", orm.DESC)
+
+	runs := []PipelineRun{}
+	err, result := orm.SearchWithJSONMapper(&runs, q)
+	if err != nil {
+		log.Errorf("failed to search runs of pipeline [%v], %v", id, err)
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	module.WriteJSON(w, GetPipelineHistoryResponse{
+		Total: result.Total,
+		From:  from,
+		Size:  size,
+		Runs:  runs,
+	}, 200)
+}
@@ -0,0 +1,115 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/elastic/elastictest"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/lib/fasthttp"
+	elastic1 "infini.sh/framework/modules/elastic"
+	"infini.sh/framework/modules/elastic/common"
+)
+
+func TestHistoryDisabledByDefault(t *testing.T) {
+	assert.False(t, historyCfg.Enabled)
+	assert.False(t, historyCfg.IncludeContext)
+
+	module := &PipeModule{}
+	module.startHistoryWriter()
+	defer module.stopHistoryWriter()
+	assert.Nil(t, module.historyRuns)
+
+	//nothing to save to
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "test"})
+	module.saveRun(ctx, ctx.Config, time.Now(), nil)
+}
+
+func TestSaveRunInBackground(t *testing.T) {
+	server := elastictest.NewServer("7.10.2")
+	defer server.Close()
+
+	cfg := elastic.ElasticsearchConfig{
+		Name:           "mock",
+		Enabled:        true,
+		Endpoint:       server.URL(),
+		RequestTimeout: 10,
+	}
+	cfg.ID = t.Name()
+	client, err := common.InitElasticInstance(cfg)
+	assert.NoError(t, err)
+	handler := &elastic1.ElasticORM{Client: client, Config: common.ORMConfig{Enabled: true}}
+	orm.Register(t.Name(), handler)
+
+	//hold the writes until the run was saved
+	blocked := make(chan struct{})
+	server.Intercept(func(ctx *fasthttp.RequestCtx) bool {
+		<-blocked
+		return false
+	})
+
+	historyCfg.Enabled = true
+	defer func() {
+		historyCfg.Enabled = false
+	}()
+	module := &PipeModule{}
+	module.startHistoryWriter()
+
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "test"})
+	ctx.Set("key", "value")
+	ctx.Failed(errors.New("failed"))
+
+	start := time.Now()
+	module.saveRun(ctx, ctx.Config, start, errors.New("failed"))
+	assert.Less(t, time.Since(start), time.Second)
+
+	close(blocked)
+	module.stopHistoryWriter()
+
+	index := handler.GetIndexName(&PipelineRun{})
+	assert.Equal(t, 1, server.Count(index))
+	runs := []PipelineRun{}
+	err, _ = orm.SearchWithJSONMapper(&runs, &orm.Query{Size: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(runs))
+	assert.Equal(t, "test", runs[0].PipelineID)
+	assert.Equal(t, pipeline.FAILED, runs[0].State)
+	assert.Equal(t, "failed", runs[0].Error)
+	//the context is not saved by default
+	assert.Nil(t, runs[0].Context)
+}
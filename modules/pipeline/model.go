@@ -26,6 +26,7 @@ package pipeline
 import (
 	"time"
 
+	"infini.sh/framework/core/orm"
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/core/util"
 )
@@ -38,4 +39,27 @@ type PipelineStatus struct {
 	Context    util.MapStr                `json:"context"`
 	Config     *pipeline.PipelineConfigV2 `json:"config"`
 	Processors []map[string]interface{}   `json:"processor"`
+	Checkpoint *pipeline.Checkpoint       `json:"checkpoint,omitempty"`
+	Metrics    util.MapStr                `json:"metrics,omitempty"`
+	Spans      []pipeline.Span            `json:"spans,omitempty"`
+	Schedule   *ScheduleStatus            `json:"schedule,omitempty"`
+	//name of the resource group the pipeline belongs to
+	ResourceGroup string `json:"resource_group,omitempty"`
+	//pipelines this pipeline depends on and the ones depend on it
+	Dependencies *DependencyStatus `json:"dependencies,omitempty"`
+}
+
+// PipelineRun is the record of a single run of the pipeline
+type PipelineRun struct {
+	orm.ORMObjectBase
+
+	PipelineID string                `json:"pipeline_id" elastic_mapping:"pipeline_id: { type: keyword }"`
+	ContextID  string                `json:"context_id" elastic_mapping:"context_id: { type: keyword }"`
+	NodeID     string                `json:"node_id,omitempty" elastic_mapping:"node_id: { type: keyword }"`
+	StartTime  *time.Time            `json:"start_time" elastic_mapping:"start_time: { type: date }"`
+	EndTime    *time.Time            `json:"end_time" elastic_mapping:"end_time: { type: date }"`
+	Duration   int64                 `json:"duration_in_ms" elastic_mapping:"duration_in_ms: { type: long }"`
+	State      pipeline.RunningState `json:"state" elastic_mapping:"state: { type: keyword }"`
+	Error      string                `json:"error,omitempty" elastic_mapping:"error: { type: text }"`
+	Context    util.MapStr           `json:"context,omitempty" elastic_mapping:"context: { type: object, enabled: false }"`
 }
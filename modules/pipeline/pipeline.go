@@ -40,6 +40,7 @@ import (
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/orm"
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/core/rate"
 	"infini.sh/framework/core/util"
@@ -52,6 +53,11 @@ type PipeModule struct {
 	pipelines sync.Map
 	configs   sync.Map
 	contexts  sync.Map
+	schedules sync.Map
+
+	historyRuns chan *PipelineRun
+	historyQuit chan struct{}
+	historyWG   sync.WaitGroup
 }
 
 func (module *PipeModule) Name() string {
@@ -72,20 +78,48 @@ func (module *PipeModule) Setup() {
 		panic(err)
 	}
 
+	ok, err = env.ParseConfig("pipeline_history", &historyCfg)
+	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+		panic(err)
+	}
+	if historyCfg.Enabled {
+		orm.MustRegisterSchemaWithIndexName(PipelineRun{}, pipelineRunIndexName)
+	}
+
+	resourceGroups := []pipeline.ResourceGroupConfig{}
+	ok, err = env.ParseConfig("resource_group", &resourceGroups)
+	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+		panic(err)
+	}
+	for _, v := range resourceGroups {
+		if err := pipeline.RegisterResourceGroup(v); err != nil {
+			log.Errorf("error on register resource group: %v", err)
+			if global.Env().SystemConfig.Configs.PanicOnConfigError {
+				panic(err)
+			}
+		}
+	}
+
 	module.pipelines = sync.Map{}
 	module.contexts = sync.Map{}
 	module.configs = sync.Map{}
+	module.schedules = sync.Map{}
 
 	pipeline.RegisterProcessorPlugin("dag", pipeline.NewDAGProcessor)
+	pipeline.RegisterProcessorPlugin("graph", pipeline.NewGraphProcessor)
 	pipeline.RegisterProcessorPlugin("echo", NewEchoProcessor)
 
 	api.HandleAPIMethod(api.GET, "/pipeline/tasks/", module.getPipelinesHandler)
 	api.HandleAPIMethod(api.POST, "/pipeline/tasks/_search", module.searchPipelinesHandler)
 	api.HandleAPIMethod(api.POST, "/pipeline/tasks/", module.createPipelineHandler)
+	api.HandleAPIMethod(api.POST, "/pipeline/_simulate", module.simulatePipelineHandler)
 	api.HandleAPIMethod(api.GET, "/pipeline/task/:id", module.getPipelineHandler)
 	api.HandleAPIMethod(api.DELETE, "/pipeline/task/:id", module.deletePipelineHandler)
 	api.HandleAPIMethod(api.POST, "/pipeline/task/:id/_start", module.startTaskHandler)
 	api.HandleAPIMethod(api.POST, "/pipeline/task/:id/_stop", module.stopTaskHandler)
+	api.HandleAPIMethod(api.DELETE, "/pipeline/task/:id/_checkpoint", module.resetCheckpointHandler)
+	api.HandleAPIMethod(api.GET, "/pipeline/task/:id/_history", module.getPipelineHistoryHandler)
+	api.HandleAPIMethod(api.GET, "/pipeline/resource_groups", module.getResourceGroupsHandler)
 
 }
 
@@ -150,6 +184,7 @@ func (module *PipeModule) stopTask(taskID string) (exists bool) {
 
 // deleteTask will clean all in-memory states and release the pipeline context
 func (module *PipeModule) deleteTask(taskID string) {
+	module.stopSchedule(taskID)
 	module.pipelines.Delete(taskID)
 	module.configs.Delete(taskID)
 	module.releaseContext(taskID)
@@ -205,6 +240,8 @@ func (module *PipeModule) Start() error {
 		pipelines []pipeline.PipelineConfigV2
 		err       error
 	)
+	module.startHistoryWriter()
+
 	pipelines, err = getPipelineConfig()
 	if err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
 		panic(err)
@@ -217,96 +254,14 @@ func (module *PipeModule) Start() error {
 		}
 	}
 
-	//listen on changes
-	config.NotifyOnConfigChange(func(ev fsnotify.Event) {
-		if module.closed.Load() || global.ShuttingDown() {
-			log.Warn("module closed, skip reloading pipelines")
-			return
-		}
-
-		log.Infof("config changed, checking for new pipeline configs, %v, %v", ev.Op, ev.Name)
-
-		newConfig := []pipeline.PipelineConfigV2{}
-		newConfig, err = getPipelineConfig()
-
-		if err != nil {
-			log.Error(err)
-			return
-		}
-
-		defer func() {
-			if !global.Env().IsDebug {
-				if r := recover(); r != nil {
-					var v string
-					switch r.(type) {
-					case error:
-						v = r.(error).Error()
-					case runtime.Error:
-						v = r.(runtime.Error).Error()
-					case string:
-						v = r.(string)
-					}
-					log.Error("error on apply pipeline change,", v)
-				}
-			}
-		}()
-
-		needStopAndClean := []string{}
-		newPipelines := map[string]pipeline.PipelineConfigV2{}
-
-		newPipelineNames := []string{}
-		for _, v := range newConfig {
-			newPipelines[v.Name] = v
-			newPipelineNames = append(newPipelineNames, v.Name)
-		}
-
-		log.Debugf("we now have %v new pipelines: %v", len(newPipelineNames), newPipelineNames)
-
-		module.configs.Range(func(k, v any) bool {
-			oldC, ok := v.(pipeline.PipelineConfigV2)
-			if !ok {
-				log.Warnf("impossible value from configs: %v", v)
-				return true
-			}
-
-			// Don't stop transient pipelines
-			if oldC.Transient {
-				log.Debugf("transient pipeline %v should not be reloaded", oldC.Name)
-				return true
-			}
-			newC, ok := newPipelines[oldC.Name]
-			// Skip condition: (old pipeline is present in the new pipeline configs, config is the same, new config is also enabled)
-			if ok && newC.Equals(oldC) && isPipelineEnabled(newC.Enabled) {
-				log.Debugf("pipeline %v config not changed, skip reloading", oldC.Name)
-				return true
-			}
-
-			log.Debug("pipeline config changed, stop and clean:", oldC.Name, ",", oldC, ",", ok, ",", newC.Equals(oldC), ",", isPipelineEnabled(newC.Enabled))
+	module.registerHistoryCleanup()
 
-			needStopAndClean = append(needStopAndClean, oldC.Name)
-			return true
-		})
-
-		log.Debugf("we now have %v old pipelines need to clean: %v", len(needStopAndClean), needStopAndClean)
-
-		if len(needStopAndClean) > 0 {
-			log.Trace("stop and wait for pipelines to release: ", needStopAndClean)
-			module.stopAndWaitForRelease(needStopAndClean, time.Minute)
-			log.Debug("old pipelines released")
-
-			for _, taskID := range needStopAndClean {
-				log.Infof("removing pipeline [%s]", taskID)
-				module.deleteTask(taskID)
-			}
-		}
-
-		log.Debug("starting new pipeline")
-		for _, v := range newPipelines {
-			err := module.createPipeline(v, false)
-			if err != nil {
-				log.Error("failed to create pipeline: ", err)
-			}
-		}
+	//listen on changes, pipelines may come from any file, so the whole set is loaded and compared
+	config.NotifyOnConfigSectionChange("pipeline", func(pCfg, cCfg *config.Config) {
+		module.reloadPipelines("pipeline section changed")
+	})
+	config.NotifyOnConfigChange(func(ev fsnotify.Event) {
+		module.reloadPipelines(fmt.Sprintf("%v %v", ev.Op, ev.Name))
 	})
 
 	return nil
@@ -317,6 +272,7 @@ func (module *PipeModule) Stop() error {
 		return nil
 	}
 	module.closed.Store(true)
+	defer module.stopHistoryWriter()
 
 	total := util.GetSyncMapSize(&module.contexts)
 	if total <= 0 {
@@ -325,6 +281,11 @@ func (module *PipeModule) Stop() error {
 
 	log.Info("shutting down pipelines")
 
+	module.schedules.Range(func(key, value any) bool {
+		value.(*pipelineSchedule).stop()
+		return true
+	})
+
 	var taskIDs []string
 	module.contexts.Range(func(key, value any) bool {
 		taskID, ok := key.(string)
@@ -341,12 +302,18 @@ func (module *PipeModule) Stop() error {
 	return nil
 }
 
-func (module *PipeModule) stopAndWaitForRelease(taskIDs []string, timeout time.Duration) {
+// stopAndWaitForRelease return the tasks still running after timeout
+func (module *PipeModule) stopAndWaitForRelease(taskIDs []string, timeout time.Duration) (running []string) {
 	start := time.Now()
 
 	for {
 		if time.Now().Sub(start) > timeout {
 			log.Error("waitForStop timed out")
+			for _, taskID := range taskIDs {
+				if v, ok := module.contexts.Load(taskID); ok && !v.(*pipeline.Context).IsLoopReleased() {
+					running = append(running, taskID)
+				}
+			}
 			break
 		}
 
@@ -385,6 +352,7 @@ func (module *PipeModule) stopAndWaitForRelease(taskIDs []string, timeout time.D
 			break
 		}
 	}
+	return running
 }
 
 const pipelineSingleton = "pipeline_singleton"
@@ -401,9 +369,23 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 		return nil
 	}
 
+	if v.Schedule != nil {
+		if err := v.Schedule.Validate(); err != nil {
+			return errors.Errorf("invalid schedule of pipeline [%v], %v", v.Name, err)
+		}
+	}
+
+	if v.ResourceGroup != "" && pipeline.GetResourceGroup(v.ResourceGroup) == nil {
+		return errors.Errorf("resource group [%v] of pipeline [%v] not found", v.ResourceGroup, v.Name)
+	}
+
 	creatingLocker.Lock()
 	defer creatingLocker.Unlock()
 
+	if err := module.validateDependencies(v); err != nil {
+		return err
+	}
+
 	v.Transient = transient
 
 	// NOTE: hold the slot before creating pipeline loops
@@ -432,9 +414,24 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 		}
 
 		ctx := pipeline.AcquireContext(v)
+		if !v.Transient {
+			//resume from the last checkpoint
+			checkpoint, err := pipeline.LoadCheckpoint(v.Name)
+			if err != nil {
+				log.Errorf("failed to load checkpoint of pipeline [%v], %v", v.Name, err)
+			} else if checkpoint != nil {
+				log.Infof("pipeline [%v] resuming from checkpoint, version: %v, updated: %v", v.Name, checkpoint.Version, checkpoint.Updated)
+				ctx.RestoreCheckpoint(checkpoint)
+			}
+		}
 		module.pipelines.Store(v.Name, processor)
 		module.contexts.Store(v.Name, ctx)
 
+		var schedule *pipelineSchedule
+		if cfg.Schedule != nil {
+			schedule = module.startSchedule(cfg.Name, *cfg.Schedule)
+		}
+
 		defer func() {
 			if !global.Env().IsDebug {
 				if r := recover(); r != nil {
@@ -451,11 +448,14 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 				}
 			}
 
+			if group := pipeline.GetResourceGroup(cfg.ResourceGroup); group != nil {
+				group.Release(cfg.Name)
+			}
 			ctx.SetLoopReleased()
 			processor.Release()
 		}()
 
-		if !cfg.AutoStart {
+		if !cfg.AutoStart || schedule != nil {
 			// Mark pipeline as exited, don't run automatically
 			ctx.Exit()
 		} else {
@@ -509,6 +509,26 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 					}
 				}
 
+				//wait for the dependencies to end
+				if cfg.DependsOn != nil && !module.waitForDependencies(ctx, cfg) {
+					log.Debugf("pipeline [%v] stopped while waiting for dependencies", cfg.Name)
+					ctx.Finished()
+					continue
+				}
+
+				//wait or throttle if the resource group is full
+				group := pipeline.GetResourceGroup(cfg.ResourceGroup)
+				if group != nil {
+					ok := group.Acquire(cfg.Name, func() bool {
+						return ctx.IsExit() || ctx.IsReleased() || module.closed.Load() || global.ShuttingDown()
+					})
+					if !ok {
+						log.Debugf("pipeline [%v] is throttled by resource group [%v]", cfg.Name, cfg.ResourceGroup)
+						ctx.Finished()
+						continue
+					}
+				}
+
 				// Pipeline needs to run
 				if started {
 					log.Errorf("pipeline [%v] started twice, should not happen", cfg.Name)
@@ -516,9 +536,14 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 				started = true
 				ctx.Started()
 				ctx.ResetContext()
+				runStart := time.Now()
 
 				err = processor.Process(ctx)
 
+				if cfg.Tracing.Enabled {
+					ctx.ExportSpans()
+				}
+
 				if err != nil {
 					log.Errorf("error on pipeline:%v, %v", cfg.Name, err)
 					ctx.Failed(err)
@@ -528,6 +553,13 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 					}
 					ctx.Finished()
 				}
+				module.saveRun(ctx, cfg, runStart, err)
+				if group != nil {
+					group.Release(cfg.Name)
+				}
+				if schedule != nil {
+					schedule.onRunEnd(ctx, runStart, err)
+				}
 				started = false
 			case pipeline.STARTED, pipeline.STOPPING:
 				log.Errorf("pipeline [%v] loop should not detect %s", cfg.Name, state)
@@ -535,7 +567,7 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 				// Pipeline ended, pause or start next round
 				// keep_running: true & not stopped manually by Exit()
 				// For IsExit, don't pause here, wait for STOPPED state, or we could Pause twice for STOPPED & IsExit.
-				if cfg.KeepRunning {
+				if cfg.KeepRunning && schedule == nil {
 					if global.Env().IsDebug {
 						log.Tracef("pipeline [%v] end running, restart again, retry in [%v]ms", cfg.Name, retryDelayInMs)
 					}
@@ -548,6 +580,9 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 
 					// restart after delay.
 					ctx.Starting()
+				} else if schedule != nil && schedule.popPending() {
+					// scheduled run queued during the last run
+					ctx.Starting()
 				} else {
 					ctx.Stopped()
 					ctx.Pause()
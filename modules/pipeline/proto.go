@@ -35,3 +35,16 @@ type CreatePipelineRequest struct {
 type SearchPipelinesRequest struct {
 	Ids []string `json:"ids"`
 }
+
+type SimulatePipelineRequest struct {
+	Processors  []map[string]interface{} `json:"processor"`
+	Context     map[string]interface{}   `json:"context"`
+	TimeoutInMs int                      `json:"timeout_in_ms"`
+}
+
+type GetPipelineHistoryResponse struct {
+	Total int64         `json:"total"`
+	From  int           `json:"from"`
+	Size  int           `json:"size"`
+	Runs  []PipelineRun `json:"runs"`
+}
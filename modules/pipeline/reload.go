@@ -0,0 +1,168 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/event"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/util"
+)
+
+type ReloadReport struct {
+	Source     string            `json:"source"`
+	StartTime  time.Time         `json:"start_time"`
+	Duration   int64             `json:"duration_in_ms"`
+	Added      []string          `json:"added,omitempty"`
+	Removed    []string          `json:"removed,omitempty"`
+	Restarted  []string          `json:"restarted,omitempty"`
+	NotDrained []string          `json:"not_drained,omitempty"`
+	Failed     map[string]string `json:"failed,omitempty"`
+}
+
+var reloadLocker = sync.Mutex{}
+
+// reloadPipelines only touch the pipelines added, removed or changed, siblings keep running
+func (module *PipeModule) reloadPipelines(source string) {
+	if module.closed.Load() || global.ShuttingDown() {
+		log.Warn("module closed, skip reloading pipelines")
+		return
+	}
+
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				var v string
+				switch r.(type) {
+				case error:
+					v = r.(error).Error()
+				case runtime.Error:
+					v = r.(runtime.Error).Error()
+				case string:
+					v = r.(string)
+				}
+				log.Error("error on apply pipeline change,", v)
+			}
+		}
+	}()
+
+	reloadLocker.Lock()
+	defer reloadLocker.Unlock()
+
+	newConfig, err := getPipelineConfig()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	enabled := []pipeline.PipelineConfigV2{}
+	newPipelines := map[string]pipeline.PipelineConfigV2{}
+	for _, v := range newConfig {
+		if isPipelineEnabled(v.Enabled) {
+			enabled = append(enabled, v)
+			newPipelines[v.Name] = v
+		}
+	}
+
+	running := map[string]pipeline.PipelineConfigV2{}
+	module.configs.Range(func(k, v any) bool {
+		c, ok := v.(pipeline.PipelineConfigV2)
+		if !ok {
+			log.Warnf("impossible value from configs: %v", v)
+			return true
+		}
+		running[c.Name] = c
+		return true
+	})
+
+	added, removed, changed := pipeline.DiffConfigs(running, enabled)
+	if len(added) == 0 && len(removed) == 0 && len(changed) == 0 {
+		log.Debugf("pipeline configs not changed, %v", source)
+		return
+	}
+
+	log.Infof("reloading pipelines, added: %v, removed: %v, changed: %v, %v", added, removed, changed, source)
+
+	report := ReloadReport{
+		Source:    source,
+		StartTime: time.Now(),
+		Added:     added,
+		Removed:   removed,
+		Failed:    map[string]string{},
+	}
+
+	needStopAndClean := append(append([]string{}, removed...), changed...)
+	if len(needStopAndClean) > 0 {
+		log.Trace("stop and wait for pipelines to release: ", needStopAndClean)
+		report.NotDrained = module.stopAndWaitForRelease(needStopAndClean, time.Minute)
+		for _, taskID := range needStopAndClean {
+			log.Infof("removing pipeline [%s]", taskID)
+			module.deleteTask(taskID)
+		}
+	}
+
+	for _, name := range append(changed, added...) {
+		err := module.createPipeline(newPipelines[name], false)
+		if err != nil {
+			log.Errorf("failed to create pipeline [%v], %v", name, err)
+			report.Failed[name] = err.Error()
+		}
+	}
+	report.Restarted = changed
+	report.Duration = time.Since(report.StartTime).Milliseconds()
+
+	log.Infof("pipelines reloaded in %vms, failed: %v, not drained: %v", report.Duration, len(report.Failed), report.NotDrained)
+	pushReloadReport(report)
+}
+
+func pushReloadReport(report ReloadReport) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warnf("failed to save pipeline reload report, %v", r)
+		}
+	}()
+
+	eventData := event.Event{
+		Metadata: event.EventMetadata{
+			Category: "pipeline",
+			Name:     "reload",
+			Datatype: "event",
+		},
+		Fields: util.MapStr{
+			"pipeline": util.MapStr{
+				"reload": report,
+			},
+		},
+	}
+	event.SaveLog(&eventData)
+}
@@ -0,0 +1,275 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/util"
+)
+
+const scheduleHistoryBucket = "pipeline_schedule_history"
+
+type ScheduleStatus struct {
+	pipeline.ScheduleConfig
+	PrevRunTime *time.Time              `json:"prev_run_time,omitempty"`
+	NextRunTime *time.Time              `json:"next_run_time,omitempty"`
+	Pending     bool                    `json:"pending"`
+	History     []pipeline.ScheduledRun `json:"history,omitempty"`
+}
+
+// pipelineSchedule triggers the runs of a scheduled pipeline
+type pipelineSchedule struct {
+	module *PipeModule
+	name   string
+	cfg    pipeline.ScheduleConfig
+
+	lock           sync.Mutex
+	pending        bool
+	pendingTrigger time.Time
+	triggered      bool
+	lastTrigger    time.Time
+	prevRunTime    *time.Time
+	nextRunTime    *time.Time
+
+	quit     chan struct{}
+	stopOnce sync.Once
+}
+
+func (module *PipeModule) startSchedule(name string, cfg pipeline.ScheduleConfig) *pipelineSchedule {
+	s := &pipelineSchedule{
+		module: module,
+		name:   name,
+		cfg:    cfg,
+		quit:   make(chan struct{}),
+	}
+	if s.cfg.MaxHistory <= 0 {
+		s.cfg.MaxHistory = 100
+	}
+	history := s.loadHistory()
+	if len(history) > 0 {
+		t := history[len(history)-1].TriggerTime
+		s.prevRunTime = &t
+	}
+	if old, ok := module.schedules.Load(name); ok {
+		old.(*pipelineSchedule).stop()
+	}
+	module.schedules.Store(name, s)
+	go s.run()
+	return s
+}
+
+func (module *PipeModule) stopSchedule(name string) {
+	if v, ok := module.schedules.LoadAndDelete(name); ok {
+		v.(*pipelineSchedule).stop()
+	}
+}
+
+func (module *PipeModule) getSchedule(name string) *pipelineSchedule {
+	if v, ok := module.schedules.Load(name); ok {
+		return v.(*pipelineSchedule)
+	}
+	return nil
+}
+
+func (s *pipelineSchedule) stop() {
+	s.stopOnce.Do(func() {
+		close(s.quit)
+	})
+}
+
+func (s *pipelineSchedule) run() {
+	for {
+		now := time.Now()
+		next, err := s.cfg.NextTime(now)
+		if err != nil {
+			log.Errorf("failed to schedule pipeline [%v], %v", s.name, err)
+			return
+		}
+		s.lock.Lock()
+		s.nextRunTime = &next
+		s.lock.Unlock()
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-s.quit:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.trigger(next)
+	}
+}
+
+func (s *pipelineSchedule) trigger(t time.Time) {
+	v, ok := s.module.contexts.Load(s.name)
+	if !ok {
+		return
+	}
+	ctx := v.(*pipeline.Context)
+
+	s.lock.Lock()
+	s.prevRunTime = &t
+	state := ctx.GetRunningState()
+	if state == pipeline.STARTING || state == pipeline.STARTED || state == pipeline.STOPPING {
+		switch s.cfg.GetOverlap() {
+		case pipeline.OverlapSkip:
+			s.lock.Unlock()
+			log.Debugf("pipeline [%v] is still running, skip the scheduled run", s.name)
+			s.appendHistory(pipeline.ScheduledRun{TriggerTime: t, Action: "skipped"})
+			return
+		case pipeline.OverlapQueue:
+			s.pending = true
+			s.pendingTrigger = t
+			s.lock.Unlock()
+			log.Debugf("pipeline [%v] is still running, queue the scheduled run", s.name)
+			return
+		case pipeline.OverlapCancel:
+			s.pending = true
+			s.pendingTrigger = t
+			s.lock.Unlock()
+			log.Infof("pipeline [%v] is still running, cancel the previous run", s.name)
+			ctx.CancelTask()
+			return
+		}
+	}
+	s.pending = true
+	s.pendingTrigger = t
+	s.lock.Unlock()
+
+	//the loop picks up the pending run when it just finished, otherwise wake it up
+	for i := 0; i < 50; i++ {
+		if ctx.IsPause() {
+			if s.popPending() {
+				s.module.startTask(s.name)
+			}
+			return
+		}
+		if !s.hasPending() {
+			return
+		}
+		select {
+		case <-s.quit:
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	log.Warnf("pipeline [%v] is not ready for the scheduled run, state: %v", s.name, ctx.GetRunningState())
+}
+
+func (s *pipelineSchedule) hasPending() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pending
+}
+
+// popPending take the pending run, the next run of the pipeline is marked as scheduled
+func (s *pipelineSchedule) popPending() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.pending {
+		return false
+	}
+	s.pending = false
+	s.triggered = true
+	s.lastTrigger = s.pendingTrigger
+	return true
+}
+
+func (s *pipelineSchedule) onRunEnd(ctx *pipeline.Context, start time.Time, err error) {
+	s.lock.Lock()
+	run := pipeline.ScheduledRun{
+		TriggerTime: s.lastTrigger,
+		Action:      "scheduled",
+		State:       ctx.GetRunningState(),
+	}
+	if !s.triggered {
+		run.TriggerTime = start
+		run.Action = "manual"
+	}
+	s.triggered = false
+	s.lock.Unlock()
+
+	end := time.Now()
+	run.StartTime = &start
+	run.EndTime = &end
+	if err != nil {
+		run.Error = err.Error()
+	}
+	s.appendHistory(run)
+}
+
+func (s *pipelineSchedule) loadHistory() []pipeline.ScheduledRun {
+	history := []pipeline.ScheduledRun{}
+	data, err := kv.GetValue(scheduleHistoryBucket, []byte(s.name))
+	if err != nil {
+		log.Error(err)
+		return history
+	}
+	if len(data) > 0 {
+		err = util.FromJSONBytes(data, &history)
+		if err != nil {
+			log.Errorf("invalid schedule history of pipeline [%v], %v", s.name, err)
+		}
+	}
+	return history
+}
+
+func (s *pipelineSchedule) appendHistory(run pipeline.ScheduledRun) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	history := append(s.loadHistory(), run)
+	if len(history) > s.cfg.MaxHistory {
+		history = history[len(history)-s.cfg.MaxHistory:]
+	}
+	err := kv.AddValue(scheduleHistoryBucket, []byte(s.name), util.MustToJSONBytes(history))
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (s *pipelineSchedule) status(historySize int) *ScheduleStatus {
+	s.lock.Lock()
+	status := &ScheduleStatus{
+		ScheduleConfig: s.cfg,
+		PrevRunTime:    s.prevRunTime,
+		NextRunTime:    s.nextRunTime,
+		Pending:        s.pending,
+	}
+	history := s.loadHistory()
+	s.lock.Unlock()
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+	status.History = history
+	return status
+}
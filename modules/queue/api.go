@@ -51,6 +51,10 @@ func init() {
 	api.HandleAPIMethod(api.GET, "/queue/stats", module.QueueStatsAction)
 	api.HandleAPIMethod(api.GET, "/queue/:id/stats", module.SingleQueueStatsAction)
 	api.HandleAPIMethod(api.GET, "/queue/:id/_scroll", module.QueueExplore)
+	//move messages in dead letter queue back to their source queue
+	api.HandleAPIMethod(api.POST, "/queue/:id/_replay", module.ReplayDeadLetterQueue)
+	//promote a replica queue to leader
+	api.HandleAPIMethod(api.POST, "/queue/:id/_promote", module.PromoteReplicaQueue)
 
 	api.HandleAPIMethod(api.DELETE, "/queue/:id", module.DeleteQueue)
 	api.HandleAPIMethod(api.DELETE, "/queue/_search", module.DeleteQueuesByQuery)
@@ -62,11 +66,16 @@ func init() {
 	api.HandleAPIMethod(api.PUT, "/queue/:id/consumer/:consumer_id/offset", module.QueueResetConsumerOffset)
 	//get consumer offset
 	api.HandleAPIMethod(api.GET, "/queue/:id/consumer/:consumer_id/offset", module.QueueGetConsumerOffset)
+	//resolve consumer offset by time
+	api.HandleAPIMethod(api.GET, "/queue/:id/consumer/:consumer_id/offset/_seek", module.QueueSeekConsumerOffset)
 
 	// delete consumer and it's offset
 	api.HandleAPIMethod(api.DELETE, "/queue/:id/consumer/:consumer_id", module.QueueDeleteConsumerByID)
 	// delete all consumers of queues specified by query
 	api.HandleAPIMethod(api.DELETE, "/queue/consumer/_search", module.DeleteConsumersByQuery)
+
+	//get the slice assignment of consumer group
+	api.HandleAPIMethod(api.GET, "/queue/consumer_group/:group/_assignment", module.GetConsumerGroupAssignment)
 }
 
 func (module *API) SingleQueueStatsAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
@@ -186,6 +195,12 @@ func (module *API) getQueueStats(t, q string, metadata string, consumer string,
 			"local_usage":          util.ByteSize(storeSize),
 			"local_usage_in_bytes": storeSize,
 		}
+		if replication := queue.GetReplicationStats(cfg.ID); replication != nil {
+			qd["replication"] = replication
+		}
+		if scheduled := queue.GetScheduledStats(cfg.ID); scheduled != nil {
+			qd["scheduled"] = scheduled
+		}
 	}
 
 	if metadata != "false" {
@@ -213,6 +228,15 @@ func (module *API) getQueueStats(t, q string, metadata string, consumer string,
 				if err == nil {
 					m["offset"] = offset.EncodeToString()
 				}
+
+				if v.DeadLetterEnabled() {
+					m["dead_letter_queue"] = v.DeadLetterQueue
+					m["max_delivery_count"] = v.MaxDeliveryCount
+					state, err := queue1.GetDeliveryState(cfg, v)
+					if err == nil && state.Count > 0 {
+						m["delivery"] = state
+					}
+				}
 				maps = append(maps, m)
 			}
 			if len(maps) > 0 {
@@ -278,6 +302,15 @@ func (module *API) QueueExplore(w http.ResponseWriter, req *http.Request, ps htt
 					msg["message"] = string(v.Data)
 					msg["offset"] = v.Offset.String()
 					msg["size"] = v.Size
+					if v.Timestamp > 0 {
+						msg["timestamp"] = time.Unix(0, v.Timestamp).Format(time.RFC3339Nano)
+					}
+					if len(v.Key) > 0 {
+						msg["key"] = string(v.Key)
+					}
+					if len(v.Headers) > 0 {
+						msg["headers"] = v.Headers
+					}
 					msgs = append(msgs, msg)
 				}
 				result["messages"] = msgs
@@ -333,6 +366,64 @@ func (module *API) QueueExplore(w http.ResponseWriter, req *http.Request, ps htt
 
 }
 
+func (module *API) ReplayDeadLetterQueue(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	queueID := ps.MustGetParameter("id")
+	target := module.GetParameterOrDefault(req, "target", "")
+	size := module.GetIntOrDefault(req, "size", 100)
+
+	qConfig, ok := queue1.SmartGetConfig(queueID)
+	if !ok {
+		module.WriteError(w, fmt.Sprintf("queue [%v] not exists", queueID), http.StatusNotFound)
+		return
+	}
+
+	if !queue1.IsDeadLetterQueue(qConfig) {
+		module.WriteError(w, fmt.Sprintf("queue [%v] is not a dead letter queue", queueID), http.StatusBadRequest)
+		return
+	}
+
+	count, err := queue1.ReplayDeadLetterMessages(qConfig, target, size)
+	if err != nil {
+		module.WriteJSON(w, util.MapStr{
+			"acknowledged": false,
+			"replayed":     count,
+			"error":        err.Error(),
+		}, 500)
+		return
+	}
+
+	module.WriteJSON(w, util.MapStr{
+		"acknowledged": true,
+		"replayed":     count,
+	}, 200)
+}
+
+func (module *API) PromoteReplicaQueue(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	queueID := ps.MustGetParameter("id")
+	cfg, ok := queue1.SmartGetConfig(queueID)
+	if !ok {
+		module.WriteError(w, fmt.Sprintf("queue [%v] was not found", queueID), http.StatusNotFound)
+		return
+	}
+
+	err := queue.PromoteReplica(cfg.ID)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	module.WriteAckOKJSON(w)
+}
+
+func (module *API) GetConsumerGroupAssignment(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	group := ps.MustGetParameter("group")
+	obj, err := queue1.GetGroupStatus(group)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	module.WriteJSON(w, obj, 200)
+}
+
 func (module *API) QueueGetConsumerOffset(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	queueID := ps.MustGetParameter("id")
 	consumerID := ps.MustGetParameter("consumer_id")
@@ -356,6 +447,51 @@ func (module *API) QueueGetConsumerOffset(w http.ResponseWriter, req *http.Reque
 	module.WriteJSON(w, obj, status)
 }
 
+func (module *API) QueueSeekConsumerOffset(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	queueID := ps.MustGetParameter("id")
+	consumerID := ps.MustGetParameter("consumer_id")
+
+	timeStr := module.GetParameter(req, "time")
+	if timeStr == "" {
+		module.WriteError(w, "parameter time is required", http.StatusBadRequest)
+		return
+	}
+
+	cfg, ok := queue1.SmartGetConfig(queueID)
+	_, ok1 := queue1.GetConsumerConfigID(queueID, consumerID)
+	obj := util.MapStr{}
+	var status = 404
+	if ok && ok1 {
+		offset, err := getOffsetByTime(cfg, timeStr)
+		if err != nil {
+			obj["error"] = err.Error()
+			status = 400
+		} else {
+			obj["found"] = true
+			obj["time"] = timeStr
+			obj["result"] = offset
+			status = 200
+		}
+	} else {
+		obj["found"] = false
+	}
+	module.WriteJSON(w, obj, status)
+}
+
+// getOffsetByTime accept time in RFC3339 format or unix timestamp in milliseconds
+func getOffsetByTime(cfg *queue1.QueueConfig, str string) (queue1.Offset, error) {
+	var t time.Time
+	if millis, err := util.ToInt64(str); err == nil {
+		t = util.FromUnixTimestampInMilli(millis)
+	} else {
+		t, err = time.Parse(time.RFC3339Nano, str)
+		if err != nil {
+			return queue1.Offset{}, errors.Errorf("invalid time: %v", str)
+		}
+	}
+	return queue1.GetOffsetByTime(cfg, t)
+}
+
 func (module *API) QueueDeleteConsumerByID(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	queueID := ps.MustGetParameter("id")
 	consumerID := ps.MustGetParameter("consumer_id")
@@ -435,6 +571,7 @@ func (module *API) QueueResetConsumerOffset(w http.ResponseWriter, req *http.Req
 	consumerID := ps.MustGetParameter("consumer_id")
 
 	offsetStr := module.GetParameterOrDefault(req, "offset", "0,0")
+	timeStr := module.GetParameter(req, "time")
 	cfg, ok := queue1.SmartGetConfig(queueID)
 	cfg1, ok1 := queue1.GetConsumerConfigID(queueID, consumerID)
 	var ack = false
@@ -445,7 +582,16 @@ func (module *API) QueueResetConsumerOffset(w http.ResponseWriter, req *http.Req
 			panic(err)
 		}
 
-		newOffset := queue1.DecodeFromString(offsetStr)
+		var newOffset queue1.Offset
+		if timeStr != "" {
+			newOffset, err = getOffsetByTime(cfg, timeStr)
+			if err != nil {
+				module.WriteError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			newOffset = queue1.DecodeFromString(offsetStr)
+		}
 		newOffset.Version = oldOffset.Version + 1
 		ok, err := queue1.CommitOffset(cfg, cfg1, newOffset)
 		ack = ok
@@ -29,10 +29,15 @@ package queue
 
 import (
 	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/stats"
 	"infini.sh/framework/core/util"
 )
 
@@ -89,6 +94,9 @@ func (module *DiskQueue) deleteUnusedFiles(queueID string, fileNum int64) {
 
 	//check consumers offset
 	consumers, eSegmentNum := module.GetEarlierOffsetByQueueID(queueID)
+	if segment, ok := module.followerSegment(queueID); ok && segment < eSegmentNum {
+		eSegmentNum = segment
+	}
 	fileStartToDelete := fileNum - module.cfg.Retention.MaxNumOfLocalFiles
 
 	if fileStartToDelete <= 0 || consumers <= 0 || eSegmentNum < 0 {
@@ -137,32 +145,15 @@ func (module *DiskQueue) deleteUnusedFiles(queueID string, fileNum int64) {
 				continue
 			}
 
-			file := GetFileName(queueID, x)
-			log.Trace(queueID, " start to delete:", file)
-			var exists = false
-			if util.FileExists(file) {
-				exists = true
-				log.Trace("delete queue file:", file)
-				err := os.Remove(file)
-				if err != nil {
-					log.Error(err)
-					break
-				}
-			}
-			compressedFile := file + compressFileSuffix
-			if util.FileExists(compressedFile) {
-				exists = true
-				log.Trace("delete compressed queue file:", compressedFile)
-				err := os.Remove(compressedFile)
-				if err != nil {
-					log.Error(err)
-					break
-				}
+			deleted, err := module.deleteSegment(queueID, x, "max_num_of_local_files")
+			if err != nil {
+				log.Error(err)
+				break
 			}
 
 			//no compress or flat file exists
-			if !exists {
-				log.Tracef("continue further delete, missing queue file:", file)
+			if !deleted {
+				log.Tracef("continue further delete, missing queue file: %v", GetFileName(queueID, x))
 				continue
 			}
 		}
@@ -173,3 +164,279 @@ func (module *DiskQueue) deleteUnusedFiles(queueID string, fileNum int64) {
 	}
 
 }
+
+// deleteSegment remove the segment and its compressed and index files,
+// return false if nothing was there to delete
+func (module *DiskQueue) deleteSegment(queueID string, segmentID int64, reason string) (bool, error) {
+	file := GetFileName(queueID, segmentID)
+	var size int64
+	var exists bool
+	for _, v := range []string{file, file + compressFileSuffix} {
+		info, err := os.Stat(v)
+		if err != nil {
+			continue
+		}
+		exists = true
+		log.Trace("delete queue file:", v)
+		err = os.Remove(v)
+		if err != nil {
+			return false, err
+		}
+		size += info.Size()
+	}
+
+	indexFile := GetTimeIndexFileName(queueID, segmentID)
+	if util.FileExists(indexFile) {
+		log.Trace("delete time index file:", indexFile)
+		err := os.Remove(indexFile)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+
+	if !exists {
+		return false, nil
+	}
+
+	log.Debugf("queue [%v], segment [%v] deleted, size: %v, reason: %v", queueID, segmentID, util.ByteSize(uint64(size)), reason)
+	stats.Increment("disk_queue", queueID, "deleted_segments")
+	stats.IncrementBy("disk_queue", queueID+".deleted_bytes", size)
+	notifyEvent(Event{Queue: queueID, Type: SegmentDeleted, FileNum: segmentID, Size: size, Reason: reason})
+	return true, nil
+}
+
+type segmentInfo struct {
+	Queue   string
+	ID      int64
+	Size    int64
+	ModTime time.Time
+	Reason  string
+}
+
+var segmentFilePattern = regexp.MustCompile(`^(\d+)\.dat(\.zstd)?$`)
+
+// listSegments return the local segments of the queue, ordered by segment id
+func listSegments(queueID string) ([]segmentInfo, error) {
+	entries, err := os.ReadDir(GetDataPath(queueID))
+	if err != nil {
+		return nil, err
+	}
+
+	segments := map[int64]*segmentInfo{}
+	for _, entry := range entries {
+		matches := segmentFilePattern.FindStringSubmatch(entry.Name())
+		if len(matches) == 0 {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		id, err := util.ToInt64(matches[1])
+		if err != nil {
+			continue
+		}
+		seg, ok := segments[id]
+		if !ok {
+			seg = &segmentInfo{Queue: queueID, ID: id}
+			segments[id] = seg
+		}
+		seg.Size += info.Size()
+		//the compressed file is created later, prefer the time of the flat file
+		if seg.ModTime.IsZero() || matches[2] == "" {
+			seg.ModTime = info.ModTime()
+		}
+	}
+
+	result := make([]segmentInfo, 0, len(segments))
+	for _, v := range segments {
+		result = append(result, *v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// selectExpiredSegments return the oldest segments exceed the max age or the max bytes,
+// segments from protectFrom onwards are always kept
+func selectExpiredSegments(segments []segmentInfo, protectFrom int64, maxBytes int64, cutoff time.Time) []segmentInfo {
+	var total int64
+	for _, v := range segments {
+		total += v.Size
+	}
+
+	expired := []segmentInfo{}
+	for _, v := range segments {
+		if v.ID >= protectFrom {
+			break
+		}
+		if !cutoff.IsZero() && v.ModTime.Before(cutoff) {
+			v.Reason = "max_age"
+		} else if maxBytes > 0 && total > maxBytes {
+			v.Reason = "max_bytes_per_queue"
+		} else {
+			break
+		}
+		expired = append(expired, v)
+		total -= v.Size
+	}
+	return expired
+}
+
+// selectOverTotalSegments return the oldest segments across queues until the total size fits in maxBytes,
+// each queue can only be trimmed from its head
+func selectOverTotalSegments(queues map[string][]segmentInfo, protectFrom map[string]int64, maxBytes int64) []segmentInfo {
+	var total int64
+	keys := make([]string, 0, len(queues))
+	for k, segments := range queues {
+		keys = append(keys, k)
+		for _, v := range segments {
+			total += v.Size
+		}
+	}
+	sort.Strings(keys)
+
+	heads := map[string]int{}
+	expired := []segmentInfo{}
+	for total > maxBytes {
+		var oldest *segmentInfo
+		var oldestQueue string
+		for _, k := range keys {
+			i := heads[k]
+			if i >= len(queues[k]) || queues[k][i].ID >= protectFrom[k] {
+				continue
+			}
+			if oldest == nil || queues[k][i].ModTime.Before(oldest.ModTime) {
+				oldest = &queues[k][i]
+				oldestQueue = k
+			}
+		}
+		if oldest == nil {
+			break
+		}
+		v := *oldest
+		v.Reason = "max_total_bytes"
+		expired = append(expired, v)
+		total -= v.Size
+		heads[oldestQueue]++
+	}
+	return expired
+}
+
+// getRetentionCandidates return the local segments of the queue, and the first segment must be kept
+func (module *DiskQueue) getRetentionCandidates(queueID string) ([]segmentInfo, int64, bool) {
+	v, ok := module.queues.Load(queueID)
+	if !ok || module.IsReplica(queueID) {
+		return nil, 0, false
+	}
+	q := v.(*DiskBasedQueue)
+
+	//never touch the segment in writing
+	protectFrom := q.ReadContext().WriteFileNum
+
+	if module.cfg.Retention.ProtectUnconsumed {
+		if _, ok := queue.GetConfigByUUID(queueID); ok {
+			consumers, eSegmentNum := module.GetEarlierOffsetByQueueID(queueID)
+			if consumers > 0 && eSegmentNum < protectFrom {
+				protectFrom = eSegmentNum
+			}
+		}
+		if !q.consumerMode && q.readSegmentFileNum < protectFrom {
+			protectFrom = q.readSegmentFileNum
+		}
+	}
+
+	//segments not pulled by the followers yet
+	if segment, ok := module.followerSegment(queueID); ok && segment < protectFrom {
+		protectFrom = segment
+	}
+
+	segments, err := listSegments(queueID)
+	if err != nil {
+		log.Errorf("queue [%v], failed to list segments, %v", queueID, err)
+		return nil, 0, false
+	}
+	return segments, protectFrom, true
+}
+
+func (module *DiskQueue) applyRetention(queueID string) {
+	cfg := module.cfg.Retention
+	if cfg.MaxAge == "" && cfg.MaxBytesPerQueue == 0 {
+		return
+	}
+
+	var cutoff time.Time
+	if cfg.MaxAge != "" {
+		maxAge, err := util.ParseDuration(cfg.MaxAge)
+		if err != nil {
+			log.Errorf("invalid retention max_age [%v], %v", cfg.MaxAge, err)
+			return
+		}
+		cutoff = time.Now().Add(-maxAge)
+	}
+
+	segments, protectFrom, ok := module.getRetentionCandidates(queueID)
+	if !ok {
+		return
+	}
+
+	for _, v := range selectExpiredSegments(segments, protectFrom, int64(cfg.MaxBytesPerQueue), cutoff) {
+		_, err := module.deleteSegment(v.Queue, v.ID, v.Reason)
+		if err != nil {
+			log.Errorf("queue [%v], failed to delete segment [%v], %v", v.Queue, v.ID, err)
+			return
+		}
+	}
+}
+
+func (module *DiskQueue) onRetentionCheck() {
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				var v string
+				switch r.(type) {
+				case error:
+					v = r.(error).Error()
+				case runtime.Error:
+					v = r.(runtime.Error).Error()
+				case string:
+					v = r.(string)
+				}
+				log.Errorf("error on checking retention [%v]", v)
+			}
+		}
+	}()
+
+	queueIDs := []string{}
+	module.queues.Range(func(key, value any) bool {
+		queueIDs = append(queueIDs, key.(string))
+		return true
+	})
+
+	for _, v := range queueIDs {
+		module.applyRetention(v)
+	}
+
+	if module.cfg.Retention.MaxTotalBytes == 0 {
+		return
+	}
+
+	queues := map[string][]segmentInfo{}
+	protectFrom := map[string]int64{}
+	for _, v := range queueIDs {
+		segments, protect, ok := module.getRetentionCandidates(v)
+		if !ok {
+			continue
+		}
+		queues[v] = segments
+		protectFrom[v] = protect
+	}
+
+	for _, v := range selectOverTotalSegments(queues, protectFrom, int64(module.cfg.Retention.MaxTotalBytes)) {
+		_, err := module.deleteSegment(v.Queue, v.ID, v.Reason)
+		if err != nil {
+			log.Errorf("queue [%v], failed to delete segment [%v], %v", v.Queue, v.ID, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectExpiredSegments(t *testing.T) {
+	now := time.Now()
+	segments := []segmentInfo{
+		{Queue: "q", ID: 1, Size: 100, ModTime: now.Add(-3 * time.Hour)},
+		{Queue: "q", ID: 2, Size: 100, ModTime: now.Add(-2 * time.Hour)},
+		{Queue: "q", ID: 3, Size: 100, ModTime: now.Add(-1 * time.Hour)},
+		{Queue: "q", ID: 4, Size: 100, ModTime: now},
+	}
+
+	//by age
+	expired := selectExpiredSegments(segments, 4, 0, now.Add(-90*time.Minute))
+	assert.Equal(t, 2, len(expired))
+	assert.Equal(t, int64(2), expired[1].ID)
+	assert.Equal(t, "max_age", expired[1].Reason)
+
+	//by size
+	expired = selectExpiredSegments(segments, 4, 150, time.Time{})
+	assert.Equal(t, 3, len(expired))
+	assert.Equal(t, "max_bytes_per_queue", expired[0].Reason)
+
+	//protected by consumers
+	expired = selectExpiredSegments(segments, 2, 150, time.Time{})
+	assert.Equal(t, 1, len(expired))
+	assert.Equal(t, int64(1), expired[0].ID)
+
+	expired = selectExpiredSegments(segments, 4, 1000, now.Add(-5*time.Hour))
+	assert.Equal(t, 0, len(expired))
+}
+
+func TestSelectOverTotalSegments(t *testing.T) {
+	now := time.Now()
+	queues := map[string][]segmentInfo{
+		"a": {
+			{Queue: "a", ID: 0, Size: 100, ModTime: now.Add(-4 * time.Hour)},
+			{Queue: "a", ID: 1, Size: 100, ModTime: now.Add(-1 * time.Hour)},
+			{Queue: "a", ID: 2, Size: 100, ModTime: now},
+		},
+		"b": {
+			{Queue: "b", ID: 5, Size: 100, ModTime: now.Add(-3 * time.Hour)},
+			{Queue: "b", ID: 6, Size: 100, ModTime: now.Add(-2 * time.Hour)},
+			{Queue: "b", ID: 7, Size: 100, ModTime: now},
+		},
+	}
+	protectFrom := map[string]int64{"a": 2, "b": 6}
+
+	expired := selectOverTotalSegments(queues, protectFrom, 300)
+	assert.Equal(t, 3, len(expired))
+	assert.Equal(t, "a", expired[0].Queue)
+	assert.Equal(t, "b", expired[1].Queue)
+	assert.Equal(t, int64(1), expired[2].ID)
+	assert.Equal(t, "max_total_bytes", expired[2].Reason)
+
+	//everything left is protected
+	expired = selectOverTotalSegments(queues, protectFrom, 0)
+	assert.Equal(t, 3, len(expired))
+}
+
+func TestStopWithRetentionCheck(t *testing.T) {
+	module := newTestDiskQueueModule(t)
+	module.cfg.Retention = RetentionConfig{MaxAge: "1h", CheckIntervalInSeconds: 1}
+	module.messages = make(chan Event)
+	module.quit = make(chan struct{})
+	assert.Nil(t, module.Start())
+
+	//the retention check must not be sent after the channel is closed
+	assert.Nil(t, module.Stop())
+	time.Sleep(1500 * time.Millisecond)
+	_, ok := <-module.messages
+	assert.False(t, ok)
+}
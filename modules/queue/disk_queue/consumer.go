@@ -111,6 +111,8 @@ func (this *Consumer) CommitOffset(offset queue.Offset) error {
 func (d *Consumer) FetchMessages(ctx *queue.Context, numOfMessages int) (messages []queue.Message, isTimeout bool, err error) {
 
 	var msgSize int32
+	var sizeFlag uint32
+	var versioned bool
 	var totalMessageSize int = 0
 	ctx.MessageCount = 0
 
@@ -147,7 +149,8 @@ READ_MSG:
 		return messages, false, errors.New("reader is nil")
 	}
 	//read message size
-	err = binary.Read(d.reader, binary.BigEndian, &msgSize)
+	err = binary.Read(d.reader, binary.BigEndian, &sizeFlag)
+	msgSize, versioned = parseRecordSize(sizeFlag)
 	if err != nil {
 		if global.Env().IsDebug {
 			log.Trace(err)
@@ -342,6 +345,17 @@ READ_MSG:
 			goto RELOAD_FILE
 		}
 
+		var record *Record
+		if versioned {
+			record, err = decodeRecord(readBuf)
+			if err != nil {
+				log.Errorf("decode record error: %v %v,%v %v", d.fileName, d.segment, previousPos, err)
+				ctx.UpdateNextOffset(d.segment, nextReadPos)
+				return messages, false, err
+			}
+			readBuf = record.Data
+		}
+
 		if d.mCfg.Compress.Message.Enabled {
 			if global.Env().IsDebug {
 				log.Tracef("decompress message: %v %v", d.fileName, d.segment)
@@ -362,6 +376,12 @@ READ_MSG:
 			NextOffset: queue.NewOffsetWithVersion(d.segment, nextReadPos, d.version),
 		}
 
+		if record != nil {
+			message.Timestamp = record.Timestamp
+			message.Key = record.Key
+			message.Headers = record.Headers
+		}
+
 		ctx.UpdateNextOffset(d.segment, nextReadPos)
 
 		messages = append(messages, message)
@@ -79,6 +79,11 @@ type DiskBasedQueue struct {
 	writeSegmentNum int64
 	writeFile       *os.File
 	writeBuf        bytes.Buffer
+	recordBuf       bytes.Buffer
+
+	timeIndexFile         *os.File
+	lastTimeIndexPos      int64
+	maxTimeIndexTimestamp int64
 
 	// instantiation time metadata
 	name     string
@@ -103,7 +108,7 @@ type DiskBasedQueue struct {
 
 	// internal channels
 	depthChan         chan int64
-	writeChan         chan []byte
+	writeChan         chan *Record
 	writeResponseChan chan WriteResponse
 	emptyChan         chan int
 	emptyResponseChan chan error
@@ -112,6 +117,8 @@ type DiskBasedQueue struct {
 
 	consumersInReading sync.Map
 
+	scheduler *scheduler
+
 	cfg *DiskQueueConfig
 }
 
@@ -124,7 +131,7 @@ func NewDiskQueueByConfig(name, dataPath string, cfg *DiskQueueConfig) *DiskBase
 		cfg:                cfg,
 		readChan:           make(chan []byte, cfg.ReadChanBuffer),
 		depthChan:          make(chan int64),
-		writeChan:          make(chan []byte, cfg.WriteChanBuffer),
+		writeChan:          make(chan *Record, cfg.WriteChanBuffer),
 		writeResponseChan:  make(chan WriteResponse),
 		emptyChan:          make(chan int),
 		emptyResponseChan:  make(chan error),
@@ -146,6 +153,8 @@ func NewDiskQueueByConfig(name, dataPath string, cfg *DiskQueueConfig) *DiskBase
 	}
 
 	go d.ioLoop()
+
+	d.scheduler = newScheduler(&d)
 	return &d
 }
 
@@ -177,10 +186,23 @@ func (d *DiskBasedQueue) ReadChan() <-chan []byte {
 
 // Put writes a []byte to the queue
 func (d *DiskBasedQueue) Put(data []byte) WriteResponse {
+	return d.PutRecord(&Record{Timestamp: time.Now().UnixNano(), Data: data})
+}
+
+// Schedule persists a record which will be written to the queue after notBefore (unix nano)
+func (d *DiskBasedQueue) Schedule(notBefore int64, record *Record) error {
+	if d.exitFlag == 1 {
+		return errors.New("exiting")
+	}
+	return d.scheduler.schedule(notBefore, record)
+}
+
+// PutRecord writes a record with key, timestamp and headers to the queue
+func (d *DiskBasedQueue) PutRecord(record *Record) WriteResponse {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(d.cfg.WriteTimeoutInMS)*time.Millisecond)
 	defer cancel()
 
-	size := int64(len(data))
+	size := int64(len(record.Data))
 	stats.IncrementBy("disk_queue", "inflight_data_size", size)
 
 	d.RLock()
@@ -222,7 +244,7 @@ func (d *DiskBasedQueue) Put(data []byte) WriteResponse {
 	}
 
 	select {
-	case d.writeChan <- data:
+	case d.writeChan <- record:
 		return <-d.writeResponseChan
 	case <-ctx.Done():
 		// Handle timeout
@@ -265,6 +287,11 @@ func (d *DiskBasedQueue) Delete() error {
 }
 
 func (d *DiskBasedQueue) exit(deleted bool) error {
+	//stop the scheduler first, as it may be writing to the queue
+	if d.scheduler != nil {
+		d.scheduler.stop()
+	}
+
 	d.Lock()
 
 	defer func() {
@@ -309,6 +336,8 @@ func (d *DiskBasedQueue) exit(deleted bool) error {
 		d.writeFile = nil
 	}
 
+	d.closeTimeIndex()
+
 	return nil
 }
 
@@ -380,6 +409,8 @@ func (d *DiskBasedQueue) skipToNextRWFile(delete bool) error {
 		d.writeFile = nil
 	}
 
+	d.closeTimeIndex()
+
 	if delete {
 		for i := d.readSegmentFileNum; i <= d.writeSegmentNum; i++ {
 
@@ -392,6 +423,7 @@ func (d *DiskBasedQueue) skipToNextRWFile(delete bool) error {
 				log.Errorf("diskqueue(%s) failed to remove data file - %s", d.name, innerErr)
 				err = innerErr
 			}
+			os.Remove(GetTimeIndexFileName(d.name, i))
 		}
 	}
 
@@ -411,6 +443,8 @@ func (d *DiskBasedQueue) skipToNextRWFile(delete bool) error {
 func (d *DiskBasedQueue) readOne() ([]byte, error) {
 	var err error
 	var msgSize int32
+	var sizeFlag uint32
+	var versioned bool
 
 	if d.readFile == nil {
 		curFileName := d.GetFileName(d.readSegmentFileNum)
@@ -453,12 +487,13 @@ func (d *DiskBasedQueue) readOne() ([]byte, error) {
 		d.reader = bufio.NewReader(d.readFile)
 	}
 
-	err = binary.Read(d.reader, binary.BigEndian, &msgSize)
+	err = binary.Read(d.reader, binary.BigEndian, &sizeFlag)
 	if err != nil {
 		d.readFile.Close()
 		d.readFile = nil
 		return nil, err
 	}
+	msgSize, versioned = parseRecordSize(sizeFlag)
 
 	if msgSize < d.cfg.MinMsgSize || msgSize > d.cfg.MaxMsgSize {
 		// this file is corrupt and we have no reasonable guarantee on
@@ -498,6 +533,15 @@ func (d *DiskBasedQueue) readOne() ([]byte, error) {
 		d.nextReadPos = 0
 	}
 
+	if versioned {
+		record, err := decodeRecord(readBuf)
+		if err != nil {
+			log.Errorf("diskqueue(%s) failed to decode record %v,%v - %s", d.name, d.readSegmentFileNum, d.readPos, err)
+			return nil, err
+		}
+		readBuf = record.Data
+	}
+
 	if d.cfg.Compress.Message.Enabled {
 		if global.Env().IsDebug {
 			log.Tracef("decompress message: %v %v", d.readSegmentFileNum, d.readPos)
@@ -519,9 +563,9 @@ type WriteResponse struct {
 	Error    error
 }
 
-// writeOne performs a low level filesystem write for a single []byte
+// writeOne performs a low level filesystem write for a single record
 // while advancing write positions and rolling files, if necessary
-func (d *DiskBasedQueue) writeOne(data []byte) WriteResponse {
+func (d *DiskBasedQueue) writeOne(record *Record) WriteResponse {
 	var err error
 	var res WriteResponse
 
@@ -546,7 +590,9 @@ func (d *DiskBasedQueue) writeOne(data []byte) WriteResponse {
 		}
 	}
 
-	//compress data
+	data := record.Data
+
+	//compress data, only the payload of the record get compressed
 	if d.cfg.Compress.Message.Enabled {
 		if global.Env().IsDebug {
 			log.Tracef("compress message: %v %v", d.readSegmentFileNum, d.readPos)
@@ -560,7 +606,10 @@ func (d *DiskBasedQueue) writeOne(data []byte) WriteResponse {
 		data = newData
 	}
 
-	dataLen := int32(len(data))
+	d.recordBuf.Reset()
+	encodeRecord(&d.recordBuf, &Record{Timestamp: record.Timestamp, Key: record.Key, Headers: record.Headers, Data: data})
+
+	dataLen := int32(d.recordBuf.Len())
 
 	if dataLen < d.cfg.MinMsgSize || dataLen > d.cfg.MaxMsgSize {
 		res.Error = fmt.Errorf("invalid message write size (%d) minMsgSize=%d maxMsgSize=%d", dataLen, d.cfg.MinMsgSize, d.cfg.MaxMsgSize)
@@ -568,13 +617,13 @@ func (d *DiskBasedQueue) writeOne(data []byte) WriteResponse {
 	}
 
 	d.writeBuf.Reset()
-	err = binary.Write(&d.writeBuf, binary.BigEndian, dataLen)
+	err = binary.Write(&d.writeBuf, binary.BigEndian, uint32(dataLen)|recordVersionFlag)
 	if err != nil {
 		res.Error = err
 		return res
 	}
 
-	_, err = d.writeBuf.Write(data)
+	_, err = d.writeBuf.Write(d.recordBuf.Bytes())
 	if err != nil {
 		res.Error = err
 		return res
@@ -589,6 +638,8 @@ func (d *DiskBasedQueue) writeOne(data []byte) WriteResponse {
 		return res
 	}
 
+	d.writeTimeIndex(record.Timestamp, d.writePos)
+
 	totalBytes := int64(4 + dataLen)
 	d.writePos += totalBytes
 	d.depth += 1
@@ -614,6 +665,7 @@ func (d *DiskBasedQueue) writeOne(data []byte) WriteResponse {
 			d.writeFile.Close()
 			d.writeFile = nil
 		}
+		d.closeTimeIndex()
 	}
 
 	res.Error = err
@@ -814,6 +866,7 @@ func (d *DiskBasedQueue) handleReadError() {
 			d.writeFile.Close()
 			d.writeFile = nil
 		}
+		d.closeTimeIndex()
 		d.writeSegmentNum++
 		d.writePos = 0
 	}
@@ -47,11 +47,15 @@ type EventType string
 
 const WriteComplete = EventType("WriteComplete")
 const ReadComplete = EventType("ReadComplete")
+const SegmentDeleted = EventType("SegmentDeleted")
+const RetentionCheck = EventType("RetentionCheck")
 
 type Event struct {
 	Queue   string
 	Type    EventType
 	FileNum int64
+	Size    int64  //bytes deleted, only for SegmentDeleted
+	Reason  string //why the segment was deleted, only for SegmentDeleted
 }
 
 type EventHandler func(event Event) error
@@ -71,12 +75,14 @@ func Notify(queue string, eventType EventType, fileNum int64) {
 		log.Tracef("notify on queue: %v, type: %v, segment: %v", queue, eventType, fileNum)
 	}
 
-	event := Event{
+	notifyEvent(Event{
 		Queue:   queue,
 		Type:    eventType,
 		FileNum: fileNum,
-	}
+	})
+}
 
+func notifyEvent(event Event) {
 	for _, v := range handlers {
 		v(event)
 	}
@@ -55,6 +55,10 @@ type DiskQueue struct {
 	queues     sync.Map
 	messages   chan Event
 	cfgs       map[string]*queue.QueueConfig
+
+	//stop background workers which send to messages before it is closed
+	quit      chan struct{}
+	workersWG sync.WaitGroup
 }
 
 func (module *DiskQueue) Name() string {
@@ -64,7 +68,20 @@ func (module *DiskQueue) Name() string {
 type RetentionConfig struct {
 	MaxNumOfLocalFiles int64 `config:"max_num_of_local_files"`
 	//DeleteAfterSaveToS3 bool `config:"delete_after_save_to_s3"`
-	//MaxAge int  	   `config:"max_age"`
+
+	//delete the segments older than this, eg: 72h, 7d
+	MaxAge string `config:"max_age"`
+	//delete the oldest segments once the queue exceed this size
+	MaxBytesPerQueue uint64 `config:"max_bytes_per_queue"`
+	//delete the oldest segments across all queues once they exceed this size
+	MaxTotalBytes uint64 `config:"max_total_bytes"`
+	//never delete the segments which are not yet consumed by any registered consumer
+	ProtectUnconsumed      bool `config:"protect_unconsumed_segments"`
+	CheckIntervalInSeconds int  `config:"check_interval_in_seconds"`
+}
+
+func (cfg *RetentionConfig) Enabled() bool {
+	return cfg.MaxAge != "" || cfg.MaxBytesPerQueue > 0 || cfg.MaxTotalBytes > 0
 }
 
 //#  disk.max_used_bytes:  100GB #trigger warning message
@@ -97,6 +114,9 @@ type DiskQueueConfig struct {
 
 	CompressAndCleanupDuringInit bool `config:"cleanup_files_on_init"`
 
+	//add an entry to the segment's time index every this many bytes, 0 means every message
+	TimeIndexIntervalBytes int64 `config:"time_index_interval_bytes"`
+
 	//default queue adaptor
 	Default bool `config:"default"`
 	Enabled bool `config:"enabled"`
@@ -107,6 +127,8 @@ type DiskQueueConfig struct {
 
 	Retention RetentionConfig `config:"retention"`
 
+	Replication ReplicationConfig `config:"replication"`
+
 	S3 config.S3BucketConfig `config:"s3"`
 }
 
@@ -184,6 +206,11 @@ func (module *DiskQueue) Init(name string) error {
 		return nil
 	}
 
+	if module.IsReplica(name) {
+		log.Tracef("queue [%s] is a replica, skip init", name)
+		return nil
+	}
+
 	log.Tracef("init queue: %s", name)
 
 	dataPath := GetDataPath(name)
@@ -214,24 +241,25 @@ func GetFileName(queueID string, segmentID int64) string {
 
 func (module *DiskQueue) Setup() {
 	module.cfg = &DiskQueueConfig{
-		Enabled:             true,
-		Default:             true,
-		AutoSkipCorruptFile: true,
-		UploadToS3:          false,
-		Retention:           RetentionConfig{MaxNumOfLocalFiles: 5},
-		MinMsgSize:          1,
-		MaxMsgSize:          104857600,         //100MB
-		MaxBytesPerFile:     100 * 1024 * 1024, //100MB
-		WriteTimeoutInMS:    1000,              //1s
-		EOFRetryDelayInMs:   500,
-		SyncEveryRecords:    1000,
-		SyncTimeoutInMS:     1000,
-		NotifyChanBuffer:    100,
-		ReadChanBuffer:      0,
-		WriteChanBuffer:     0,
-		WarningFreeBytes:    10 * 1024 * 1024 * 1024,
-		ReservedFreeBytes:   5 * 1024 * 1024 * 1024,
-		PrepareFilesToRead:  true,
+		Enabled:                true,
+		Default:                true,
+		AutoSkipCorruptFile:    true,
+		UploadToS3:             false,
+		Retention:              RetentionConfig{MaxNumOfLocalFiles: 5, ProtectUnconsumed: true, CheckIntervalInSeconds: 60},
+		MinMsgSize:             1,
+		MaxMsgSize:             104857600,         //100MB
+		MaxBytesPerFile:        100 * 1024 * 1024, //100MB
+		WriteTimeoutInMS:       1000,              //1s
+		EOFRetryDelayInMs:      500,
+		SyncEveryRecords:       1000,
+		SyncTimeoutInMS:        1000,
+		NotifyChanBuffer:       100,
+		ReadChanBuffer:         0,
+		WriteChanBuffer:        0,
+		WarningFreeBytes:       10 * 1024 * 1024 * 1024,
+		ReservedFreeBytes:      5 * 1024 * 1024 * 1024,
+		PrepareFilesToRead:     true,
+		TimeIndexIntervalBytes: 1024 * 1024, //1MB
 		Compress: DiskCompress{
 			IdleThreshold:             3,
 			DeleteAfterCompress:       false,
@@ -272,8 +300,13 @@ func (module *DiskQueue) Setup() {
 	module.queues = sync.Map{}
 
 	module.messages = make(chan Event, module.cfg.NotifyChanBuffer)
+	module.quit = make(chan struct{})
 
 	RegisterEventListener(func(event Event) error {
+		//segment deletion is emitted by the cleanup inside the event loop, don't feed it back
+		if event.Type == SegmentDeleted {
+			return nil
+		}
 
 		module.messages <- event
 
@@ -306,6 +339,10 @@ func (module *DiskQueue) Destroy(k string) error {
 }
 
 func (module *DiskQueue) Push(k string, v []byte) error {
+	if module.IsReplica(k) {
+		return errors.Errorf("queue [%v] is a replica, writes are not allowed", k)
+	}
+
 	q, ok := module.queues.Load(k)
 	if !ok {
 		//try init
@@ -365,6 +402,10 @@ func (this *DiskQueue) ReleaseConsumer(qconfig *queue.QueueConfig, consumer *que
 }
 
 func (module *DiskQueue) AcquireConsumer(qconfig *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.ConsumerAPI, error) {
+	if module.IsReplica(qconfig.ID) {
+		return nil, errors.Errorf("queue [%v] is a replica, promote it before consuming", qconfig.Name)
+	}
+
 	offset, _ := queue.GetOffset(qconfig, consumer)
 	q, ok := module.queues.Load(qconfig.ID)
 	if !ok {
@@ -392,6 +433,11 @@ func (module *DiskQueue) Close(k string) error {
 }
 
 func (module *DiskQueue) GetStorageSize(k string) uint64 {
+	if module.IsReplica(k) {
+		size, _ := status.DirSize(GetDataPath(k))
+		return size
+	}
+
 	q, ok := module.queues.Load(k)
 	if !ok {
 		//try init
@@ -408,6 +454,10 @@ func (module *DiskQueue) GetStorageSize(k string) uint64 {
 }
 
 func (module *DiskQueue) LatestOffset(k *queue.QueueConfig) queue.Offset {
+	if module.IsReplica(k.ID) {
+		return loadReplicaOffset(k.ID)
+	}
+
 	q, ok := module.queues.Load(k.ID)
 	if !ok {
 		//try init
@@ -422,6 +472,10 @@ func (module *DiskQueue) LatestOffset(k *queue.QueueConfig) queue.Offset {
 }
 
 func (module *DiskQueue) Depth(k string) int64 {
+	if module.IsReplica(k) {
+		return 0
+	}
+
 	q, ok := module.queues.Load(k)
 	if !ok {
 		//try init
@@ -449,6 +503,10 @@ func (module *DiskQueue) Start() error {
 		return nil
 	}
 
+	if module.cfg.Replication.Enabled {
+		module.setupReplication()
+	}
+
 	//load configs from local file
 	if module.cfgs != nil {
 		for _, v := range module.cfgs {
@@ -467,6 +525,9 @@ func (module *DiskQueue) Start() error {
 		}
 	}
 
+	//apply transactions interrupted by last crash
+	module.recoverTransactions()
+
 	//trigger s3 uploading
 	//from lastUpload to current WrtieFile
 	if module.cfg.UploadToS3 {
@@ -508,6 +569,28 @@ func (module *DiskQueue) Start() error {
 		}()
 	}
 
+	//retention runs inside the event loop, so it never races with compress and cleanup
+	if module.cfg.Retention.Enabled() && module.cfg.Retention.CheckIntervalInSeconds > 0 {
+		module.workersWG.Add(1)
+		go func() {
+			defer module.workersWG.Done()
+			ticker := time.NewTicker(time.Duration(module.cfg.Retention.CheckIntervalInSeconds) * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-module.quit:
+					return
+				case <-ticker.C:
+				}
+				select {
+				case <-module.quit:
+					return
+				case module.messages <- Event{Type: RetentionCheck}:
+				}
+			}
+		}()
+	}
+
 	go func() {
 		defer func() {
 			if !global.Env().IsDebug {
@@ -540,6 +623,9 @@ func (module *DiskQueue) Start() error {
 					lastFilePrepared = v
 				}
 				break
+			case RetentionCheck:
+				module.onRetentionCheck()
+				break
 			}
 		}
 
@@ -578,6 +664,8 @@ func (module *DiskQueue) onWriteComplete(evt Event) {
 	//delete old unused files
 	module.deleteUnusedFiles(evt.Queue, evt.FileNum)
 
+	//apply retention policies of this queue
+	module.applyRetention(evt.Queue)
 }
 
 func (module *DiskQueue) onReadComplete(evt Event, lastFilePrepared int64) int64 {
@@ -621,6 +709,13 @@ func (module *DiskQueue) Stop() error {
 		return nil
 	}
 
+	if replication != nil {
+		replication.stop()
+	}
+
+	//make sure nothing sends to messages after it is closed
+	close(module.quit)
+	module.workersWG.Wait()
 	close(module.messages)
 	module.queues.Range(func(key, value interface{}) bool {
 		q, ok := module.queues.Load(key)
@@ -734,6 +829,10 @@ func (module *DiskQueue) AcquireProducer(cfg *queue.QueueConfig) (queue.Producer
 		panic("queue config is nil")
 	}
 
+	if module.IsReplica(cfg.ID) {
+		return nil, errors.Errorf("queue [%v] is a replica, writes are not allowed", cfg.Name)
+	}
+
 	q, ok := module.queues.Load(cfg.ID)
 	if !ok {
 		//try init
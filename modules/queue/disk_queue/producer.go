@@ -55,13 +55,30 @@ func (p *Producer) Produce(reqs *[]queue.ProduceRequest) (*[]queue.ProduceRespon
 			panic(errors.Errorf("invalid topic: %v vs %v", req.Topic, p.cfg.ID))
 		}
 
-		res := p.q.Put(req.Data)
+		timestamp := req.Timestamp
+		if req.NotBefore > time.Now().UnixNano() {
+			if timestamp <= 0 {
+				timestamp = req.NotBefore
+			}
+			err := p.q.Schedule(req.NotBefore, &Record{Timestamp: timestamp, Key: req.Key, Headers: req.Headers, Data: req.Data})
+			if err != nil {
+				return &results, err
+			}
+			results = append(results, queue.ProduceResponse{Topic: p.cfg.ID, Timestamp: timestamp, Scheduled: true})
+			continue
+		}
+
+		if timestamp <= 0 {
+			timestamp = time.Now().UnixNano()
+		}
+
+		res := p.q.PutRecord(&Record{Timestamp: timestamp, Key: req.Key, Headers: req.Headers, Data: req.Data})
 		if res.Error != nil {
 			return &results, res.Error
 		}
 
 		result := queue.ProduceResponse{}
-		result.Timestamp = time.Now().Unix()
+		result.Timestamp = timestamp
 		result.Topic = p.cfg.ID
 		result.Partition = 0
 		result.Offset = queue.Offset{Segment: int64(res.Segment), Position: res.Position}
@@ -0,0 +1,163 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"infini.sh/framework/core/errors"
+)
+
+// on-disk layout of each record in the segment file
+//
+// legacy:  [int32 size][data]
+// v1:      [uint32 size|recordVersionFlag][version:1][timestamp:8][uvarint key_len][key]
+//
+//	[uvarint num_of_headers]{[uvarint k_len][k][uvarint v_len][v]}...[data]
+//
+// the highest bit of the size is never set for legacy records, as max_msg_size is an int32,
+// so old segments can still be read after upgrade
+const recordVersionFlag uint32 = 1 << 31
+
+const recordVersion1 byte = 1
+
+type Record struct {
+	Timestamp int64 //unix nano
+	Key       []byte
+	Headers   map[string]string
+	Data      []byte
+}
+
+// parseRecordSize return the size of the record body and whether the record is versioned
+func parseRecordSize(v uint32) (int32, bool) {
+	if v&recordVersionFlag != 0 {
+		return int32(v &^ recordVersionFlag), true
+	}
+	return int32(v), false
+}
+
+func encodeRecord(buf *bytes.Buffer, r *Record) {
+	var tmp [binary.MaxVarintLen64]byte
+
+	buf.WriteByte(recordVersion1)
+
+	binary.BigEndian.PutUint64(tmp[:8], uint64(r.Timestamp))
+	buf.Write(tmp[:8])
+
+	n := binary.PutUvarint(tmp[:], uint64(len(r.Key)))
+	buf.Write(tmp[:n])
+	buf.Write(r.Key)
+
+	n = binary.PutUvarint(tmp[:], uint64(len(r.Headers)))
+	buf.Write(tmp[:n])
+	if len(r.Headers) > 0 {
+		//keep the output stable
+		keys := make([]string, 0, len(r.Headers))
+		for k := range r.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := r.Headers[k]
+			n = binary.PutUvarint(tmp[:], uint64(len(k)))
+			buf.Write(tmp[:n])
+			buf.WriteString(k)
+			n = binary.PutUvarint(tmp[:], uint64(len(v)))
+			buf.Write(tmp[:n])
+			buf.WriteString(v)
+		}
+	}
+
+	buf.Write(r.Data)
+}
+
+var errInvalidRecord = errors.New("invalid record")
+
+func readBytes(body []byte, pos int) ([]byte, int, error) {
+	l, n := binary.Uvarint(body[pos:])
+	if n <= 0 {
+		return nil, pos, errInvalidRecord
+	}
+	pos += n
+	if uint64(len(body)-pos) < l {
+		return nil, pos, errInvalidRecord
+	}
+	end := pos + int(l)
+	return body[pos:end], end, nil
+}
+
+func decodeRecord(body []byte) (*Record, error) {
+	if len(body) < 9 {
+		return nil, errInvalidRecord
+	}
+
+	if body[0] != recordVersion1 {
+		return nil, errors.Errorf("unknown record version: %v", body[0])
+	}
+
+	r := &Record{}
+	r.Timestamp = int64(binary.BigEndian.Uint64(body[1:9]))
+	pos := 9
+
+	var err error
+	var key []byte
+	key, pos, err = readBytes(body, pos)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) > 0 {
+		r.Key = key
+	}
+
+	count, n := binary.Uvarint(body[pos:])
+	if n <= 0 {
+		return nil, errInvalidRecord
+	}
+	pos += n
+
+	if count > 0 {
+		r.Headers = make(map[string]string, count)
+		for i := uint64(0); i < count; i++ {
+			var k, v []byte
+			k, pos, err = readBytes(body, pos)
+			if err != nil {
+				return nil, err
+			}
+			v, pos, err = readBytes(body, pos)
+			if err != nil {
+				return nil, err
+			}
+			r.Headers[string(k)] = string(v)
+		}
+	}
+
+	r.Data = body[pos:]
+	return r, nil
+}
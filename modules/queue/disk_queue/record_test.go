@@ -0,0 +1,85 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodeRecord(t *testing.T) {
+	r := &Record{
+		Timestamp: 1700000000123456789,
+		Key:       []byte("doc-1"),
+		Headers:   map[string]string{"source": "gateway", "retry": "1", "empty": ""},
+		Data:      []byte(`{"index":{"_index":"test"}}`),
+	}
+
+	buf := bytes.Buffer{}
+	encodeRecord(&buf, r)
+
+	r1, err := decodeRecord(buf.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, r.Timestamp, r1.Timestamp)
+	assert.Equal(t, r.Key, r1.Key)
+	assert.Equal(t, r.Headers, r1.Headers)
+	assert.Equal(t, r.Data, r1.Data)
+
+	//no key and headers
+	buf.Reset()
+	encodeRecord(&buf, &Record{Timestamp: 1, Data: []byte("hello")})
+	r1, err = decodeRecord(buf.Bytes())
+	assert.Nil(t, err)
+	assert.Nil(t, r1.Key)
+	assert.Nil(t, r1.Headers)
+	assert.Equal(t, []byte("hello"), r1.Data)
+
+	//truncated record
+	_, err = decodeRecord(buf.Bytes()[:5])
+	assert.NotNil(t, err)
+}
+
+func TestParseRecordSize(t *testing.T) {
+	size, versioned := parseRecordSize(uint32(1024))
+	assert.Equal(t, int32(1024), size)
+	assert.False(t, versioned)
+
+	size, versioned = parseRecordSize(uint32(1024) | recordVersionFlag)
+	assert.Equal(t, int32(1024), size)
+	assert.True(t, versioned)
+
+	//legacy record written as int32 should be read back as is
+	buf := bytes.Buffer{}
+	binary.Write(&buf, binary.BigEndian, int32(2048))
+	size, versioned = parseRecordSize(binary.BigEndian.Uint32(buf.Bytes()))
+	assert.Equal(t, int32(2048), size)
+	assert.False(t, versioned)
+}
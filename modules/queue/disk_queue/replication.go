@@ -0,0 +1,920 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/rpc"
+	"infini.sh/framework/core/util"
+)
+
+// queue replication, the follower pulls raw segments from the leader over rpc and writes them
+// to the same files locally, so offsets are identical on both sides, consumer configs and
+// committed offsets are mirrored too, a replica is read only until it gets promoted
+type ReplicationConfig struct {
+	Enabled bool `config:"enabled"`
+	//rpc address of the leader, mirror queues from the leader if set
+	Leader string `config:"leader"`
+	//queues to mirror, empty means all queues of the leader
+	Queues            []string `config:"queues"`
+	ChunkSize         int      `config:"chunk_size"`
+	IdleIntervalInMs  int      `config:"idle_interval_in_ms"`
+	RetryDelayInMs    int      `config:"retry_delay_in_ms"`
+	SyncIntervalInSec int      `config:"sync_interval_in_seconds"`
+}
+
+const replicaBucket = "queue_replicas"
+const replicaOffsetBucket = "queue_replica_offset"
+const replicaFollowerBucket = "queue_replica_followers"
+const replicaPromoted = "promoted"
+
+const replicationServiceName = "disk_queue.Replication"
+
+type ListQueuesRequest struct {
+	Queues []string `json:"queues,omitempty"`
+}
+
+type ListQueuesResponse struct {
+	Queues []*queue.QueueConfig `json:"queues"`
+}
+
+// ReplicaRequest is sent by the follower to start replication, then as acknowledgement
+type ReplicaRequest struct {
+	Queue    string       `json:"queue"`
+	Follower string       `json:"follower,omitempty"`
+	Offset   queue.Offset `json:"offset"`
+}
+
+// ReplicaChunk is sent by the leader, data always contains whole records
+type ReplicaChunk struct {
+	Queue  *queue.QueueConfig `json:"queue,omitempty"`
+	Offset queue.Offset       `json:"offset"`
+	Data   []byte             `json:"data,omitempty"`
+	Latest queue.Offset       `json:"latest"`
+	//first local segment of the leader, older segments are removed on the follower too
+	First int64 `json:"first,omitempty"`
+
+	Meta            *replicaMeta      `json:"meta,omitempty"`
+	Consumers       []byte            `json:"consumers,omitempty"`
+	ConsumerOffsets map[string]string `json:"consumer_offsets,omitempty"`
+}
+
+type replicaMeta struct {
+	Depth int64        `json:"depth"`
+	Read  queue.Offset `json:"read"`
+}
+
+type replicationServer interface {
+	ListQueues(ctx context.Context, req *ListQueuesRequest) (*ListQueuesResponse, error)
+	Replicate(stream grpc.ServerStream) error
+}
+
+var replicationServiceDesc = grpc.ServiceDesc{
+	ServiceName: replicationServiceName,
+	HandlerType: (*replicationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListQueues",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &ListQueuesRequest{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				return srv.(replicationServer).ListQueues(ctx, req)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Replicate",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(replicationServer).Replicate(stream)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+type FollowerState struct {
+	ID       string       `json:"id"`
+	Address  string       `json:"address,omitempty"`
+	Offset   queue.Offset `json:"offset"`
+	LastSeen time.Time    `json:"last_seen"`
+	lock     sync.RWMutex
+}
+
+type ReplicaState struct {
+	Queue        string       `json:"queue"`
+	Leader       string       `json:"leader"`
+	Offset       queue.Offset `json:"offset"`
+	LeaderOffset queue.Offset `json:"leader_offset"`
+	Connected    bool         `json:"connected"`
+	LastSeen     *time.Time   `json:"last_seen,omitempty"`
+	Error        string       `json:"error,omitempty"`
+
+	meta   *replicaMeta
+	lock   sync.RWMutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type replicationManager struct {
+	module    *DiskQueue
+	cfg       *ReplicationConfig
+	followers sync.Map //queue_id/follower_id=*FollowerState
+	replicas  sync.Map //queue_id=*ReplicaState
+	isReplica sync.Map //queue_id=bool
+
+	followerLock sync.Mutex
+}
+
+var replication *replicationManager
+
+func (module *DiskQueue) setupReplication() {
+	cfg := &module.cfg.Replication
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = 1024 * 1024
+	}
+	if cfg.IdleIntervalInMs <= 0 {
+		cfg.IdleIntervalInMs = 500
+	}
+	if cfg.RetryDelayInMs <= 0 {
+		cfg.RetryDelayInMs = 5000
+	}
+	if cfg.SyncIntervalInSec <= 0 {
+		cfg.SyncIntervalInSec = 1
+	}
+
+	replication = &replicationManager{module: module, cfg: cfg}
+
+	if rpc.GetRPCServer() == nil {
+		rpc.Setup(&global.Env().SystemConfig.ClusterConfig.RPCConfig)
+	}
+	rpc.RegisterService(&replicationServiceDesc, replication)
+	rpc.StartRPCServer()
+
+	if cfg.Leader != "" {
+		go replication.follow()
+	}
+}
+
+func (r *replicationManager) stop() {
+	r.replicas.Range(func(key, value any) bool {
+		state := value.(*ReplicaState)
+		state.cancel()
+		<-state.done
+		return true
+	})
+}
+
+// IsReplica check if the queue is mirrored from the leader and not promoted yet
+func (module *DiskQueue) IsReplica(queueID string) bool {
+	if replication == nil || replication.cfg.Leader == "" {
+		return false
+	}
+	return replication.checkReplica(queueID)
+}
+
+func (r *replicationManager) checkReplica(queueID string) bool {
+	if v, ok := r.isReplica.Load(queueID); ok {
+		return v.(bool)
+	}
+	b, err := kv.GetValue(replicaBucket, util.UnsafeStringToBytes(queueID))
+	if err != nil {
+		panic(err)
+	}
+	ok := len(b) > 0 && string(b) != replicaPromoted
+	r.isReplica.Store(queueID, ok)
+	return ok
+}
+
+func (r *replicationManager) ListQueues(ctx context.Context, req *ListQueuesRequest) (*ListQueuesResponse, error) {
+	res := &ListQueuesResponse{}
+	if len(req.Queues) > 0 {
+		for _, v := range req.Queues {
+			cfg, ok := queue.SmartGetConfig(v)
+			if ok && (cfg.Type == "" || cfg.Type == "disk") {
+				res.Queues = append(res.Queues, cfg)
+			}
+		}
+		return res, nil
+	}
+
+	for _, cfg := range queue.GetAllConfigs() {
+		if cfg.Type != "" && cfg.Type != "disk" {
+			continue
+		}
+		if r.module.IsReplica(cfg.ID) {
+			continue
+		}
+		res.Queues = append(res.Queues, cfg)
+	}
+	return res, nil
+}
+
+// Replicate stream records of the queue to the follower, starting from the offset it already has
+func (r *replicationManager) Replicate(stream grpc.ServerStream) error {
+	req := &ReplicaRequest{}
+	err := stream.RecvMsg(req)
+	if err != nil {
+		return err
+	}
+
+	cfg, ok := queue.SmartGetConfig(req.Queue)
+	if !ok {
+		return errors.Errorf("queue [%v] was not found", req.Queue)
+	}
+	if r.module.IsReplica(cfg.ID) {
+		return errors.Errorf("queue [%v] is a replica", cfg.ID)
+	}
+
+	follower := &FollowerState{ID: req.Follower, Offset: req.Offset, LastSeen: time.Now()}
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		follower.Address = p.Addr.String()
+	}
+	key := cfg.ID + "/" + req.Follower
+	r.followers.Store(key, follower)
+	defer r.followers.Delete(key)
+	r.saveFollowerOffset(cfg.ID, req.Follower, req.Offset)
+
+	log.Infof("follower [%v] start to replicate queue [%v] from offset: %v", follower.ID, cfg.Name, req.Offset)
+
+	//acknowledgements from the follower
+	go func() {
+		for {
+			ack := &ReplicaRequest{}
+			err := stream.RecvMsg(ack)
+			if err != nil {
+				return
+			}
+			follower.lock.Lock()
+			follower.Offset = ack.Offset
+			follower.LastSeen = time.Now()
+			follower.lock.Unlock()
+			r.saveFollowerOffset(cfg.ID, req.Follower, ack.Offset)
+		}
+	}()
+
+	offset := req.Offset
+	first := true
+	var lastSync time.Time
+	syncInterval := time.Duration(r.cfg.SyncIntervalInSec) * time.Second
+	idleInterval := time.Duration(r.cfg.IdleIntervalInMs) * time.Millisecond
+	for !global.ShuttingDown() {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		default:
+		}
+
+		latest := r.module.LatestOffset(cfg)
+		chunk := &ReplicaChunk{Offset: offset, Latest: latest}
+		if first {
+			chunk.Queue = cfg
+			first = false
+		}
+
+		if offset.Segment < latest.Segment || (offset.Segment == latest.Segment && offset.Position < latest.Position) {
+			var limit int64 = -1
+			if offset.Segment == latest.Segment {
+				limit = latest.Position
+			}
+			fileName, _, _ := SmartGetFileName(r.module.cfg, cfg.ID, offset.Segment)
+			var data []byte
+			if util.FileExists(fileName) {
+				data, err = readRecords(r.module.cfg, fileName, offset.Position, limit, r.cfg.ChunkSize)
+				if err != nil {
+					return err
+				}
+			}
+			if len(data) == 0 && offset.Segment < latest.Segment {
+				if !util.FileExists(fileName) {
+					log.Warnf("segment [%v] of queue [%v] was removed before follower [%v] pulled it", offset.Segment, cfg.Name, follower.ID)
+				}
+				//segment is done or already removed, move to next one
+				offset = queue.NewOffset(offset.Segment+1, 0)
+				continue
+			}
+			chunk.Data = data
+			offset.Position += int64(len(data))
+		}
+
+		if len(chunk.Data) == 0 || time.Since(lastSync) > syncInterval {
+			r.attachMetadata(cfg, chunk)
+			lastSync = time.Now()
+		}
+
+		err = stream.SendMsg(chunk)
+		if err != nil {
+			return err
+		}
+
+		if len(chunk.Data) == 0 {
+			time.Sleep(idleInterval)
+		}
+	}
+	return nil
+}
+
+func (r *replicationManager) attachMetadata(cfg *queue.QueueConfig, chunk *ReplicaChunk) {
+	b, err := kv.GetValue(queue.ConsumerBucket, util.UnsafeStringToBytes(cfg.ID))
+	if err == nil && len(b) > 0 {
+		chunk.Consumers = b
+	}
+
+	consumers, ok := queue.GetConsumerConfigsByQueueID(cfg.ID)
+	if ok {
+		chunk.ConsumerOffsets = map[string]string{}
+		for _, v := range consumers {
+			offset, err := loadOffset(cfg, v)
+			if err == nil {
+				chunk.ConsumerOffsets[getCommitKey(cfg, v)] = offset.EncodeToString()
+			}
+		}
+	}
+
+	segments, err := listSegments(cfg.ID)
+	if err == nil && len(segments) > 0 {
+		chunk.First = segments[0].ID
+	}
+
+	depth, read, _, err := readMetaData(path.Join(GetDataPath(cfg.ID), "meta.dat"))
+	if err == nil {
+		chunk.Meta = &replicaMeta{Depth: depth, Read: read}
+	}
+}
+
+// saveFollowerOffset persist the offset acknowledged by the follower, segments from there are kept
+// by the retention of the leader, even while the follower is disconnected
+func (r *replicationManager) saveFollowerOffset(queueID, followerID string, offset queue.Offset) {
+	r.followerLock.Lock()
+	defer r.followerLock.Unlock()
+
+	offsets := loadFollowerOffsets(queueID)
+	offsets[followerID] = offset.EncodeToString()
+	err := kv.AddValue(replicaFollowerBucket, util.UnsafeStringToBytes(queueID), util.MustToJSONBytes(offsets))
+	if err != nil {
+		log.Errorf("queue [%v], failed to save offset of follower [%v], %v", queueID, followerID, err)
+	}
+}
+
+func loadFollowerOffsets(queueID string) map[string]string {
+	offsets := map[string]string{}
+	b, err := kv.GetValue(replicaFollowerBucket, util.UnsafeStringToBytes(queueID))
+	if err != nil || len(b) == 0 {
+		return offsets
+	}
+	err = util.FromJSONBytes(b, &offsets)
+	if err != nil {
+		log.Errorf("queue [%v], invalid follower offsets, %v", queueID, err)
+	}
+	return offsets
+}
+
+// followerSegment return the segment of the slowest follower of the queue, false if it has no followers
+func (module *DiskQueue) followerSegment(queueID string) (int64, bool) {
+	if replication == nil {
+		return 0, false
+	}
+	var segment int64 = -1
+	for _, v := range loadFollowerOffsets(queueID) {
+		offset := queue.DecodeFromString(v)
+		if segment < 0 || offset.Segment < segment {
+			segment = offset.Segment
+		}
+	}
+	return segment, segment >= 0
+}
+
+// trimReplica remove the local segments already removed on the leader, segments not fully replicated are kept,
+// return the segment trimmed to
+func (r *replicationManager) trimReplica(queueID string, first int64, offset queue.Offset) int64 {
+	if offset.Segment < first {
+		first = offset.Segment
+	}
+	segments, err := listSegments(queueID)
+	if err != nil {
+		log.Errorf("queue [%v], failed to list segments, %v", queueID, err)
+		return 0
+	}
+	for _, v := range segments {
+		if v.ID >= first {
+			break
+		}
+		_, err = r.module.deleteSegment(queueID, v.ID, "leader_retention")
+		if err != nil {
+			log.Errorf("queue [%v], failed to delete segment [%v], %v", queueID, v.ID, err)
+			return 0
+		}
+	}
+	return first
+}
+
+// readRecords read whole records from the position, until the size reach chunk size or the limit
+func readRecords(cfg *DiskQueueConfig, fileName string, pos, limit int64, chunkSize int) ([]byte, error) {
+	f, err := os.OpenFile(fileName, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if pos > 0 {
+		_, err = f.Seek(pos, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reader := bufio.NewReader(f)
+	buf := []byte{}
+	var header [4]byte
+	for len(buf) < chunkSize {
+		if limit >= 0 && pos+int64(len(buf)) >= limit {
+			break
+		}
+
+		_, err = io.ReadFull(reader, header[:])
+		if err != nil {
+			break
+		}
+
+		size, _ := parseRecordSize(binary.BigEndian.Uint32(header[:]))
+		if size < cfg.MinMsgSize || size > cfg.MaxMsgSize {
+			return nil, errors.Errorf("invalid message read size (%d), file: %v, pos: %v", size, fileName, pos+int64(len(buf)))
+		}
+
+		body := make([]byte, size)
+		_, err = io.ReadFull(reader, body)
+		if err != nil {
+			//partial record, wait for next round
+			break
+		}
+		buf = append(buf, header[:]...)
+		buf = append(buf, body...)
+	}
+	return buf, nil
+}
+
+func readMetaData(fileName string) (depth int64, read, write queue.Offset, err error) {
+	f, err := os.OpenFile(fileName, os.O_RDONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	_, err = fmt.Fscanf(f, "%d\n%d,%d\n%d,%d\n",
+		&depth,
+		&read.Segment, &read.Position,
+		&write.Segment, &write.Position)
+	return
+}
+
+func writeMetaData(fileName string, depth int64, read, write queue.Offset) error {
+	tmpFileName := fileName + ".tmp"
+	f, err := os.OpenFile(tmpFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d\n%d,%d\n%d,%d\n",
+		depth,
+		read.Segment, read.Position,
+		write.Segment, write.Position)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	f.Sync()
+	f.Close()
+	return util.AtomicFileRename(tmpFileName, fileName)
+}
+
+// follow discover queues from the leader and keep them mirrored
+func (r *replicationManager) follow() {
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				var v string
+				switch r.(type) {
+				case error:
+					v = r.(error).Error()
+				case runtime.Error:
+					v = r.(runtime.Error).Error()
+				case string:
+					v = r.(string)
+				}
+				log.Error("error in queue replication,", v)
+			}
+		}
+	}()
+
+	for !global.ShuttingDown() {
+		cfgs, err := r.listLeaderQueues()
+		if err != nil {
+			log.Errorf("failed to list queues from leader [%v], %v", r.cfg.Leader, err)
+		}
+		for _, cfg := range cfgs {
+			r.startReplica(cfg)
+		}
+		time.Sleep(time.Duration(r.cfg.RetryDelayInMs) * time.Millisecond)
+	}
+}
+
+func (r *replicationManager) listLeaderQueues() ([]*queue.QueueConfig, error) {
+	conn, err := rpc.ObtainConnection(r.cfg.Leader)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res := &ListQueuesResponse{}
+	err = conn.Invoke(ctx, "/"+replicationServiceName+"/ListQueues", &ListQueuesRequest{Queues: r.cfg.Queues}, res, rpc.JSONCallOption())
+	if err != nil {
+		return nil, err
+	}
+	return res.Queues, nil
+}
+
+func (r *replicationManager) startReplica(cfg *queue.QueueConfig) {
+	if _, ok := r.replicas.Load(cfg.ID); ok {
+		return
+	}
+
+	b, err := kv.GetValue(replicaBucket, util.UnsafeStringToBytes(cfg.ID))
+	if err != nil {
+		panic(err)
+	}
+	if string(b) == replicaPromoted {
+		return
+	}
+
+	//local writes to a mirrored queue are not allowed
+	if len(b) == 0 {
+		if _, ok := r.module.queues.Load(cfg.ID); ok {
+			log.Warnf("queue [%v] already exists locally, skip replication", cfg.Name)
+			return
+		}
+		err = kv.AddValue(replicaBucket, util.UnsafeStringToBytes(cfg.ID), []byte(r.cfg.Leader))
+		if err != nil {
+			panic(err)
+		}
+	}
+	r.isReplica.Store(cfg.ID, true)
+
+	if _, ok := queue.GetConfigByUUID(cfg.ID); !ok {
+		queue.RegisterConfig(cfg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	state := &ReplicaState{Queue: cfg.ID, Leader: r.cfg.Leader, cancel: cancel, done: make(chan struct{})}
+	state.Offset = loadReplicaOffset(cfg.ID)
+	r.replicas.Store(cfg.ID, state)
+
+	go func() {
+		defer close(state.done)
+		for {
+			err := r.replicate(ctx, state)
+			state.lock.Lock()
+			state.Connected = false
+			if err != nil {
+				state.Error = err.Error()
+			}
+			state.lock.Unlock()
+
+			if err != nil && ctx.Err() == nil {
+				log.Errorf("replication of queue [%v] from [%v] failed, %v", cfg.Name, r.cfg.Leader, err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(r.cfg.RetryDelayInMs) * time.Millisecond):
+			}
+			if global.ShuttingDown() {
+				return
+			}
+		}
+	}()
+}
+
+func loadReplicaOffset(queueID string) queue.Offset {
+	b, err := kv.GetValue(replicaOffsetBucket, util.UnsafeStringToBytes(queueID))
+	if err != nil {
+		panic(err)
+	}
+	if len(b) == 0 {
+		return queue.NewOffset(0, 0)
+	}
+	return queue.DecodeFromString(string(b))
+}
+
+func (r *replicationManager) replicate(ctx context.Context, state *ReplicaState) (err error) {
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				err = errors.Errorf("%v", r)
+			}
+		}
+	}()
+
+	conn, err := rpc.ObtainConnection(r.cfg.Leader)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stream, err := conn.NewStream(ctx, &replicationServiceDesc.Streams[0], "/"+replicationServiceName+"/Replicate", rpc.JSONCallOption())
+	if err != nil {
+		return err
+	}
+
+	state.lock.RLock()
+	offset := state.Offset
+	state.lock.RUnlock()
+
+	err = stream.SendMsg(&ReplicaRequest{Queue: state.Queue, Follower: global.Env().SystemConfig.NodeConfig.ID, Offset: offset})
+	if err != nil {
+		return err
+	}
+
+	return r.receive(state, stream)
+}
+
+// receive write chunks from the leader to local segments, and acknowledge the offset back
+func (r *replicationManager) receive(state *ReplicaState, stream grpc.ClientStream) (err error) {
+	dataPath := GetDataPath(state.Queue)
+	if !util.FileExists(dataPath) {
+		os.MkdirAll(dataPath, 0755)
+	}
+
+	var file *os.File
+	var fileSegment int64 = -1
+	var trimmed int64
+	//the time index is not replicated, rebuild it from the records
+	index := &DiskBasedQueue{name: state.Queue, cfg: r.module.cfg, writeSegmentNum: -1}
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+		index.closeTimeIndex()
+	}()
+
+	for {
+		chunk := &ReplicaChunk{}
+		err = stream.RecvMsg(chunk)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		state.lock.Lock()
+		state.Connected = true
+		state.Error = ""
+		state.LastSeen = &now
+		state.LeaderOffset = chunk.Latest
+		if chunk.Meta != nil {
+			state.meta = chunk.Meta
+		}
+		state.lock.Unlock()
+
+		if chunk.Queue != nil {
+			if _, ok := queue.GetConfigByUUID(chunk.Queue.ID); !ok {
+				queue.RegisterConfig(chunk.Queue)
+			}
+		}
+
+		if len(chunk.Consumers) > 0 {
+			kv.AddValue(queue.ConsumerBucket, util.UnsafeStringToBytes(state.Queue), chunk.Consumers)
+		}
+		for k, v := range chunk.ConsumerOffsets {
+			kv.AddValue(ConsumerOffsetBucket, []byte(k), []byte(v))
+		}
+
+		if chunk.First > trimmed {
+			state.lock.RLock()
+			offset := state.Offset
+			state.lock.RUnlock()
+			trimmed = r.trimReplica(state.Queue, chunk.First, offset)
+		}
+
+		if len(chunk.Data) == 0 {
+			continue
+		}
+
+		if file == nil || fileSegment != chunk.Offset.Segment {
+			if file != nil {
+				file.Sync()
+				file.Close()
+			}
+			file, err = os.OpenFile(GetFileName(state.Queue, chunk.Offset.Segment), os.O_RDWR|os.O_CREATE, 0600)
+			if err != nil {
+				return err
+			}
+			fileSegment = chunk.Offset.Segment
+		}
+
+		_, err = file.WriteAt(chunk.Data, chunk.Offset.Position)
+		if err != nil {
+			return err
+		}
+
+		offset := queue.NewOffset(chunk.Offset.Segment, chunk.Offset.Position+int64(len(chunk.Data)))
+		err = kv.AddValue(replicaOffsetBucket, util.UnsafeStringToBytes(state.Queue), []byte(offset.EncodeToString()))
+		if err != nil {
+			return err
+		}
+
+		//only index acknowledged records, so the index never points beyond the promoted offset
+		indexReplicaRecords(index, chunk.Offset.Segment, chunk.Offset.Position, chunk.Data)
+
+		state.lock.Lock()
+		state.Offset = offset
+		state.lock.Unlock()
+
+		err = stream.SendMsg(&ReplicaRequest{Queue: state.Queue, Offset: offset})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// indexReplicaRecords write the time index of mirrored records, the same way as the writer does
+func indexReplicaRecords(index *DiskBasedQueue, segment, pos int64, data []byte) {
+	if index.writeSegmentNum != segment {
+		index.closeTimeIndex()
+		index.writeSegmentNum = segment
+	}
+
+	for i := 0; i+4 <= len(data); {
+		size, versioned := parseRecordSize(binary.BigEndian.Uint32(data[i : i+4]))
+		end := i + 4 + int(size)
+		if size < 0 || end > len(data) {
+			return
+		}
+		body := data[i+4 : end]
+		if versioned && len(body) >= 9 && body[0] == recordVersion1 {
+			index.writeTimeIndex(int64(binary.BigEndian.Uint64(body[1:9])), pos+int64(i))
+		}
+		i = end
+	}
+}
+
+// PromoteReplica stop mirroring the queue from the leader, and take it over as a normal local queue
+func PromoteReplica(queueID string) error {
+	if replication == nil {
+		return errors.New("replication is not enabled")
+	}
+	r := replication
+
+	v, ok := r.replicas.Load(queueID)
+	if !ok {
+		return errors.Errorf("queue [%v] is not a replica", queueID)
+	}
+	state := v.(*ReplicaState)
+	state.cancel()
+	<-state.done
+	r.replicas.Delete(queueID)
+
+	state.lock.RLock()
+	offset := state.Offset
+	meta := state.meta
+	state.lock.RUnlock()
+
+	//drop partial writes after the last acknowledged offset
+	fileName := GetFileName(queueID, offset.Segment)
+	if util.FileExists(fileName) {
+		err := os.Truncate(fileName, offset.Position)
+		if err != nil {
+			return err
+		}
+	}
+
+	var depth int64
+	read := offset
+	if meta != nil && !meta.Read.LatestThan(offset) {
+		depth = meta.Depth
+		read = meta.Read
+	}
+	err := writeMetaData(path.Join(GetDataPath(queueID), "meta.dat"), depth, read, offset)
+	if err != nil {
+		return err
+	}
+
+	err = kv.AddValue(replicaBucket, util.UnsafeStringToBytes(queueID), []byte(replicaPromoted))
+	if err != nil {
+		return err
+	}
+	r.isReplica.Store(queueID, false)
+
+	log.Infof("queue [%v] was promoted to leader at offset: %v", queueID, offset)
+
+	return r.module.Init(queueID)
+}
+
+// GetReplicationStats return the replication status of the queue, nil if replication is not enabled
+func GetReplicationStats(queueID string) util.MapStr {
+	if replication == nil {
+		return nil
+	}
+	r := replication
+
+	if v, ok := r.replicas.Load(queueID); ok {
+		state := v.(*ReplicaState)
+		state.lock.RLock()
+		defer state.lock.RUnlock()
+		return util.MapStr{
+			"role":          "follower",
+			"leader":        state.Leader,
+			"connected":     state.Connected,
+			"last_seen":     state.LastSeen,
+			"error":         state.Error,
+			"offset":        state.Offset.String(),
+			"leader_offset": state.LeaderOffset.String(),
+			"lag":           r.lag(state.LeaderOffset, state.Offset),
+		}
+	}
+
+	cfg, ok := queue.GetConfigByUUID(queueID)
+	if !ok {
+		return nil
+	}
+	latest := r.module.LatestOffset(cfg)
+	followers := []util.MapStr{}
+	r.followers.Range(func(key, value any) bool {
+		if k := key.(string); len(k) > len(queueID) && k[:len(queueID)+1] == queueID+"/" {
+			f := value.(*FollowerState)
+			f.lock.RLock()
+			followers = append(followers, util.MapStr{
+				"id":        f.ID,
+				"address":   f.Address,
+				"offset":    f.Offset.String(),
+				"last_seen": f.LastSeen,
+				"lag":       r.lag(latest, f.Offset),
+			})
+			f.lock.RUnlock()
+		}
+		return true
+	})
+	return util.MapStr{
+		"role":      "leader",
+		"followers": followers,
+	}
+}
+
+// lag in segments, and approximate bytes as segments are rolled at max_bytes_per_file
+func (r *replicationManager) lag(leader, follower queue.Offset) util.MapStr {
+	segments := leader.Segment - follower.Segment
+	bytes := segments*r.module.cfg.MaxBytesPerFile + leader.Position - follower.Position
+	if bytes < 0 {
+		bytes = 0
+	}
+	return util.MapStr{
+		"segments": segments,
+		"bytes":    bytes,
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+func TestReadRecords(t *testing.T) {
+	cfg := &DiskQueueConfig{MinMsgSize: 1, MaxMsgSize: 1024}
+	file := path.Join(t.TempDir(), "000000000.dat")
+	positions := writeTestSegment(t, file, []int64{100, 200, 300, 400})
+	recordSize := int(positions[1] - positions[0])
+
+	//only whole records are returned, at least one record
+	data, err := readRecords(cfg, file, 0, -1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, recordSize, len(data))
+
+	data, err = readRecords(cfg, file, 0, -1, recordSize+1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2*recordSize, len(data))
+
+	//stop at the limit
+	data, err = readRecords(cfg, file, positions[1], positions[3], 1024)
+	assert.Nil(t, err)
+	assert.Equal(t, 2*recordSize, len(data))
+
+	//nothing left
+	data, err = readRecords(cfg, file, positions[3]+int64(recordSize), -1, 1024)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(data))
+}
+
+// testStream is one side of an in-process rpc stream, messages are json encoded like the rpc codec does
+type testStream struct {
+	ctx context.Context
+	in  chan []byte
+	out chan []byte
+}
+
+func newTestStreamPair(ctx context.Context) (*testStream, *testStream) {
+	a, b := make(chan []byte, 100), make(chan []byte, 100)
+	return &testStream{ctx: ctx, in: a, out: b}, &testStream{ctx: ctx, in: b, out: a}
+}
+
+func (s *testStream) SendMsg(m interface{}) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	select {
+	case s.out <- b:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *testStream) RecvMsg(m interface{}) error {
+	select {
+	case b := <-s.in:
+		return json.Unmarshal(b, m)
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *testStream) Context() context.Context     { return s.ctx }
+func (s *testStream) SetHeader(metadata.MD) error  { return nil }
+func (s *testStream) SendHeader(metadata.MD) error { return nil }
+func (s *testStream) SetTrailer(metadata.MD)       {}
+func (s *testStream) Header() (metadata.MD, error) { return nil, nil }
+func (s *testStream) Trailer() metadata.MD         { return nil }
+func (s *testStream) CloseSend() error             { return nil }
+
+func newTestReplicationModule(t *testing.T) *DiskQueue {
+	module := newTestDiskQueueModule(t)
+	replication = &replicationManager{module: module, cfg: &ReplicationConfig{
+		Leader:            "leader:8000",
+		ChunkSize:         100,
+		IdleIntervalInMs:  10,
+		RetryDelayInMs:    10,
+		SyncIntervalInSec: 1,
+	}}
+	t.Cleanup(func() {
+		replication = nil
+	})
+	return module
+}
+
+// produceTestRecords write records with timestamps from the start, one record per timestamp
+func produceTestRecords(t *testing.T, module *DiskQueue, cfg *queue.QueueConfig, start, num int) {
+	producer, err := module.AcquireProducer(cfg)
+	assert.Nil(t, err)
+	for i := start; i < start+num; i++ {
+		reqs := []queue.ProduceRequest{{Topic: cfg.ID, Timestamp: int64(i), Data: []byte(util.ToString(i) + "-hello world")}}
+		_, err = producer.Produce(&reqs)
+		assert.Nil(t, err)
+	}
+}
+
+func TestReplicateAndPromote(t *testing.T) {
+	module := newTestReplicationModule(t)
+	r := replication
+
+	leaderCfg := &queue.QueueConfig{ID: "replica_leader", Name: "replica_leader"}
+	queue.RegisterConfig(leaderCfg)
+	produceTestRecords(t, module, leaderCfg, 1, 20)
+	latest := module.LatestOffset(leaderCfg)
+	//segments are rolled
+	assert.True(t, latest.Segment > 0)
+
+	//mirror the leader queue to another queue in the same process
+	followerID := "replica_follower"
+	assert.Nil(t, kv.AddValue(replicaBucket, []byte(followerID), []byte(r.cfg.Leader)))
+	assert.True(t, module.IsReplica(followerID))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	state := &ReplicaState{Queue: followerID, Leader: r.cfg.Leader, cancel: cancel, done: make(chan struct{})}
+	r.replicas.Store(followerID, state)
+
+	leaderStream, followerStream := newTestStreamPair(ctx)
+	go r.Replicate(leaderStream)
+	assert.Nil(t, followerStream.SendMsg(&ReplicaRequest{Queue: leaderCfg.ID, Follower: "follower"}))
+	go func() {
+		defer close(state.done)
+		r.receive(state, followerStream)
+	}()
+
+	waitForReplica := func(offset queue.Offset) {
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			state.lock.RLock()
+			current := state.Offset
+			state.lock.RUnlock()
+			if current == offset {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.FailNow(t, "replica did not catch up")
+	}
+	waitForReplica(latest)
+
+	//catch up with new records
+	caught := latest
+	produceTestRecords(t, module, leaderCfg, 21, 5)
+	latest = module.LatestOffset(leaderCfg)
+	waitForReplica(latest)
+	assert.Equal(t, latest, loadReplicaOffset(followerID))
+	assert.Equal(t, latest, module.LatestOffset(&queue.QueueConfig{ID: followerID}))
+
+	//segments and time index are identical
+	for i := int64(0); i <= latest.Segment; i++ {
+		leaderData, err := os.ReadFile(GetFileName(leaderCfg.ID, i))
+		assert.Nil(t, err)
+		followerData, err := os.ReadFile(GetFileName(followerID, i))
+		assert.Nil(t, err)
+		assert.Equal(t, leaderData, followerData)
+
+		leaderIndex, err := readTimeIndex(leaderCfg.ID, i)
+		assert.Nil(t, err)
+		followerIndex, err := readTimeIndex(followerID, i)
+		assert.Nil(t, err)
+		assert.True(t, len(followerIndex) > 0)
+		assert.Equal(t, leaderIndex, followerIndex)
+	}
+
+	//segments the follower acknowledged are tracked by the leader
+	segment, ok := module.followerSegment(leaderCfg.ID)
+	assert.True(t, ok)
+	assert.Equal(t, latest.Segment, segment)
+
+	//the follower is registered on the leader
+	stats := GetReplicationStats(leaderCfg.ID)
+	assert.Equal(t, "leader", stats["role"])
+	assert.Equal(t, 1, len(stats["followers"].([]util.MapStr)))
+
+	//promote stops the mirroring and opens the queue for writes at the replicated offset
+	assert.Nil(t, PromoteReplica(followerID))
+	assert.False(t, module.IsReplica(followerID))
+	followerCfg := &queue.QueueConfig{ID: followerID, Name: followerID}
+	assert.Equal(t, latest, module.LatestOffset(followerCfg))
+	offset, err := getOffsetByTime(module.cfg, followerID, latest, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, queue.NewOffset(0, 0), offset)
+	offset, err = getOffsetByTime(module.cfg, followerID, latest, 21)
+	assert.Nil(t, err)
+	assert.Equal(t, caught, offset)
+
+	produceTestRecords(t, module, followerCfg, 26, 1)
+	offset = module.LatestOffset(followerCfg)
+	assert.True(t, offset.LatestThan(latest))
+
+	//not a replica anymore
+	assert.NotNil(t, PromoteReplica(followerID))
+}
+
+func TestReplicaRetention(t *testing.T) {
+	module := newTestReplicationModule(t)
+	r := replication
+
+	leaderCfg := &queue.QueueConfig{ID: "retention_leader", Name: "retention_leader"}
+	queue.RegisterConfig(leaderCfg)
+	produceTestRecords(t, module, leaderCfg, 1, 20)
+	latest := module.LatestOffset(leaderCfg)
+	assert.True(t, latest.Segment >= 2)
+
+	//segments not pulled by the slowest follower are protected on the leader
+	_, protectFrom, ok := module.getRetentionCandidates(leaderCfg.ID)
+	assert.True(t, ok)
+	assert.Equal(t, latest.Segment, protectFrom)
+	r.saveFollowerOffset(leaderCfg.ID, "fast", latest)
+	r.saveFollowerOffset(leaderCfg.ID, "slow", queue.NewOffset(1, 0))
+	_, protectFrom, _ = module.getRetentionCandidates(leaderCfg.ID)
+	assert.Equal(t, int64(1), protectFrom)
+
+	//segments removed on the leader are removed on the follower, unless not fully replicated
+	followerID := "retention_follower"
+	assert.Nil(t, os.MkdirAll(GetDataPath(followerID), 0755))
+	for i := int64(0); i <= latest.Segment; i++ {
+		assert.Nil(t, os.WriteFile(GetFileName(followerID, i), []byte("data"), 0600))
+	}
+	assert.Equal(t, int64(1), r.trimReplica(followerID, 2, queue.NewOffset(1, 10)))
+	assert.False(t, util.FileExists(GetFileName(followerID, 0)))
+	assert.True(t, util.FileExists(GetFileName(followerID, 1)))
+
+	assert.Equal(t, int64(2), r.trimReplica(followerID, 2, latest))
+	assert.False(t, util.FileExists(GetFileName(followerID, 1)))
+	assert.True(t, util.FileExists(GetFileName(followerID, 2)))
+}
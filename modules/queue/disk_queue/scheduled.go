@@ -0,0 +1,522 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// messages with a not_before timestamp are kept aside in the scheduled file of the queue,
+// and appended to the queue once their time arrives, so consumers never see them earlier
+//
+// scheduled file: [not_before:8][uint32 size][v1 record body]...
+// ack file:       [position:8]...
+//
+// the position of a delivered message is appended to the ack file, both files are
+// truncated once all the scheduled messages were delivered, and compacted once most of them were
+const scheduledFileName = "scheduled.dat"
+const scheduledAckFileName = "scheduled.ack"
+const scheduledEntryHeaderSize = 12
+
+// compact the scheduled file once it is larger than this, and the delivered entries exceed the ratio
+var scheduledCompactMinBytes int64 = 1024 * 1024
+
+const scheduledCompactRatio = 0.5
+
+type scheduledEntry struct {
+	NotBefore int64
+	Position  int64
+	Size      int32
+}
+
+type scheduledHeap []scheduledEntry
+
+func (h scheduledHeap) Len() int { return len(h) }
+func (h scheduledHeap) Less(i, j int) bool {
+	if h[i].NotBefore == h[j].NotBefore {
+		return h[i].Position < h[j].Position
+	}
+	return h[i].NotBefore < h[j].NotBefore
+}
+func (h scheduledHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scheduledHeap) Push(x interface{}) { *h = append(*h, x.(scheduledEntry)) }
+func (h *scheduledHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+type scheduler struct {
+	lock      sync.Mutex
+	q         *DiskBasedQueue
+	dataFile  *os.File
+	ackFile   *os.File
+	writePos  int64
+	pending   scheduledHeap
+	delivered int64
+	acked     int64 //bytes of delivered entries in the scheduled file
+	buf       bytes.Buffer
+	wakeChan  chan struct{}
+	exitChan  chan struct{}
+	exitOnce  sync.Once
+	waitGroup sync.WaitGroup
+}
+
+var schedulers = sync.Map{}
+
+func getScheduledFileName(dataPath string) string {
+	return path.Join(dataPath, scheduledFileName)
+}
+
+func getScheduledAckFileName(dataPath string) string {
+	return path.Join(dataPath, scheduledAckFileName)
+}
+
+func newScheduler(d *DiskBasedQueue) *scheduler {
+	s := &scheduler{
+		q:        d,
+		wakeChan: make(chan struct{}, 1),
+		exitChan: make(chan struct{}),
+	}
+
+	dataFileName := getScheduledFileName(d.dataPath)
+	if util.FileExists(dataFileName) {
+		entries, writePos, err := loadScheduledEntries(dataFileName, getScheduledAckFileName(d.dataPath))
+		if err != nil {
+			log.Errorf("queue [%v], failed to load scheduled messages, %v", d.name, err)
+		} else {
+			s.writePos = writePos
+			s.pending = entries
+			s.acked = writePos
+			for _, v := range entries {
+				s.acked -= scheduledEntryHeaderSize + int64(v.Size)
+			}
+			heap.Init(&s.pending)
+			if len(entries) > 0 {
+				log.Debugf("queue [%v], %v scheduled messages loaded", d.name, len(entries))
+			}
+		}
+	}
+
+	schedulers.Store(d.name, s)
+
+	s.waitGroup.Add(1)
+	go s.run()
+	return s
+}
+
+// loadScheduledEntries return the undelivered entries and the end of the last complete entry,
+// a partially written entry at the tail is ignored and will be overwritten
+func loadScheduledEntries(dataFileName, ackFileName string) ([]scheduledEntry, int64, error) {
+	acked := map[int64]struct{}{}
+	if util.FileExists(ackFileName) {
+		ackData, err := os.ReadFile(ackFileName)
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := 0; i+8 <= len(ackData); i += 8 {
+			acked[int64(binary.BigEndian.Uint64(ackData[i:i+8]))] = struct{}{}
+		}
+	}
+
+	f, err := os.Open(dataFileName)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	entries := []scheduledEntry{}
+	var pos int64
+	var header [scheduledEntryHeaderSize]byte
+	for {
+		_, err = io.ReadFull(reader, header[:])
+		if err != nil {
+			break
+		}
+		entry := scheduledEntry{
+			NotBefore: int64(binary.BigEndian.Uint64(header[0:8])),
+			Position:  pos,
+			Size:      int32(binary.BigEndian.Uint32(header[8:12])),
+		}
+		if entry.Size <= 0 {
+			break
+		}
+		_, err = reader.Discard(int(entry.Size))
+		if err != nil {
+			break
+		}
+		if _, ok := acked[pos]; !ok {
+			entries = append(entries, entry)
+		}
+		pos += scheduledEntryHeaderSize + int64(entry.Size)
+	}
+
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, 0, err
+	}
+	return entries, pos, nil
+}
+
+func (s *scheduler) openFiles() error {
+	if s.dataFile != nil {
+		return nil
+	}
+	dataFile, err := os.OpenFile(getScheduledFileName(s.q.dataPath), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	ackFile, err := os.OpenFile(getScheduledAckFileName(s.q.dataPath), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		dataFile.Close()
+		return err
+	}
+	s.dataFile = dataFile
+	s.ackFile = ackFile
+	return nil
+}
+
+func (s *scheduler) closeFiles() {
+	if s.dataFile != nil {
+		s.dataFile.Close()
+		s.dataFile = nil
+	}
+	if s.ackFile != nil {
+		s.ackFile.Close()
+		s.ackFile = nil
+	}
+}
+
+// schedule persist the record, it will be appended to the queue after notBefore
+func (s *scheduler) schedule(notBefore int64, record *Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.openFiles()
+	if err != nil {
+		return err
+	}
+
+	s.buf.Reset()
+	s.buf.Write(make([]byte, scheduledEntryHeaderSize))
+	encodeRecord(&s.buf, record)
+	data := s.buf.Bytes()
+	size := int32(len(data) - scheduledEntryHeaderSize)
+	binary.BigEndian.PutUint64(data[0:8], uint64(notBefore))
+	binary.BigEndian.PutUint32(data[8:12], uint32(size))
+
+	_, err = s.dataFile.WriteAt(data, s.writePos)
+	if err != nil {
+		return errors.Errorf("queue [%v], failed to write scheduled message, %v", s.q.name, err)
+	}
+
+	entry := scheduledEntry{NotBefore: notBefore, Position: s.writePos, Size: size}
+	s.writePos += int64(len(data))
+	heap.Push(&s.pending, entry)
+	stats.Increment("disk_queue", "scheduled")
+
+	//wake up the delivery loop if this is the earliest message
+	if s.pending[0].Position == entry.Position {
+		select {
+		case s.wakeChan <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *scheduler) run() {
+	defer s.waitGroup.Done()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		wait := s.deliverDue()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-s.exitChan:
+			return
+		case <-s.wakeChan:
+		case <-timer.C:
+		}
+	}
+}
+
+// deliverDue append all the due messages to the queue, return how long to wait for the next one
+func (s *scheduler) deliverDue() (wait time.Duration) {
+	wait = time.Hour
+
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				var v string
+				switch r.(type) {
+				case error:
+					v = r.(error).Error()
+				case runtime.Error:
+					v = r.(runtime.Error).Error()
+				case string:
+					v = r.(string)
+				}
+				log.Errorf("queue [%v], error on deliver scheduled messages, %v", s.q.name, v)
+				wait = time.Second
+			}
+		}
+	}()
+
+	for {
+		s.lock.Lock()
+		if len(s.pending) == 0 {
+			s.reset()
+			s.lock.Unlock()
+			return wait
+		}
+		s.compact()
+
+		now := time.Now().UnixNano()
+		entry := s.pending[0]
+		if entry.NotBefore > now {
+			s.lock.Unlock()
+			return time.Duration(entry.NotBefore - now)
+		}
+		heap.Pop(&s.pending)
+		s.lock.Unlock()
+
+		err := s.deliver(entry)
+		if err != nil {
+			log.Errorf("queue [%v], failed to deliver scheduled message, %v", s.q.name, err)
+			s.lock.Lock()
+			heap.Push(&s.pending, entry)
+			s.lock.Unlock()
+			return time.Second
+		}
+
+		select {
+		case <-s.exitChan:
+			return wait
+		default:
+		}
+	}
+}
+
+func (s *scheduler) deliver(entry scheduledEntry) error {
+	s.lock.Lock()
+	err := s.openFiles()
+	dataFile := s.dataFile
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	body := make([]byte, entry.Size)
+	_, err = dataFile.ReadAt(body, entry.Position+scheduledEntryHeaderSize)
+	if err != nil {
+		return err
+	}
+
+	record, err := decodeRecord(body)
+	if err != nil {
+		return err
+	}
+
+	res := s.q.PutRecord(record)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	var ack [8]byte
+	binary.BigEndian.PutUint64(ack[:], uint64(entry.Position))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.ackFile.Write(ack[:])
+	if err != nil {
+		//the message will be delivered again after restart
+		log.Errorf("queue [%v], failed to ack scheduled message, %v", s.q.name, err)
+	}
+	s.delivered++
+	s.acked += scheduledEntryHeaderSize + int64(entry.Size)
+	stats.Increment("disk_queue", "scheduled_delivered")
+	return nil
+}
+
+// reset truncate the files once nothing left to deliver, lock must be held
+func (s *scheduler) reset() {
+	if s.writePos == 0 {
+		return
+	}
+	if s.dataFile == nil {
+		err := s.openFiles()
+		if err != nil {
+			log.Errorf("queue [%v], failed to open scheduled files, %v", s.q.name, err)
+			return
+		}
+	}
+	if err := s.ackFile.Truncate(0); err != nil {
+		log.Errorf("queue [%v], failed to truncate scheduled ack file, %v", s.q.name, err)
+		return
+	}
+	if err := s.dataFile.Truncate(0); err != nil {
+		log.Errorf("queue [%v], failed to truncate scheduled file, %v", s.q.name, err)
+		return
+	}
+	s.writePos = 0
+	s.acked = 0
+}
+
+// compact rewrite the pending entries to a new scheduled file, so the files don't grow forever
+// under steady load, lock must be held, no delivery is in progress while the files are replaced
+func (s *scheduler) compact() {
+	if s.writePos < scheduledCompactMinBytes || float64(s.acked) < float64(s.writePos)*scheduledCompactRatio {
+		return
+	}
+	err := s.openFiles()
+	if err != nil {
+		log.Errorf("queue [%v], failed to open scheduled files, %v", s.q.name, err)
+		return
+	}
+
+	//keep the order of the positions, so the order of messages with the same time is unchanged
+	entries := append(scheduledHeap{}, s.pending...)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Position < entries[j].Position
+	})
+
+	fileName := getScheduledFileName(s.q.dataPath)
+	tmpFileName := fileName + ".tmp"
+	f, err := os.OpenFile(tmpFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Errorf("queue [%v], failed to compact scheduled file, %v", s.q.name, err)
+		return
+	}
+	writer := bufio.NewWriter(f)
+	var pos int64
+	for i, v := range entries {
+		data := make([]byte, scheduledEntryHeaderSize+int64(v.Size))
+		_, err = s.dataFile.ReadAt(data, v.Position)
+		if err == nil {
+			_, err = writer.Write(data)
+		}
+		if err != nil {
+			break
+		}
+		entries[i].Position = pos
+		pos += int64(len(data))
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmpFileName)
+		log.Errorf("queue [%v], failed to compact scheduled file, %v", s.q.name, err)
+		return
+	}
+
+	//acks of the old file must not apply to the new one, if it fails here the delivered messages are only sent again
+	err = s.ackFile.Truncate(0)
+	if err != nil {
+		os.Remove(tmpFileName)
+		log.Errorf("queue [%v], failed to truncate scheduled ack file, %v", s.q.name, err)
+		return
+	}
+	s.closeFiles()
+	err = util.AtomicFileRename(tmpFileName, fileName)
+	if err != nil {
+		log.Errorf("queue [%v], failed to replace scheduled file, %v", s.q.name, err)
+		return
+	}
+
+	log.Debugf("queue [%v], scheduled file compacted from %v to %v", s.q.name, util.ByteSize(uint64(s.writePos)), util.ByteSize(uint64(pos)))
+	s.pending = entries
+	heap.Init(&s.pending)
+	s.writePos = pos
+	s.acked = 0
+}
+
+func (s *scheduler) stop() {
+	s.exitOnce.Do(func() {
+		close(s.exitChan)
+		s.waitGroup.Wait()
+		s.lock.Lock()
+		s.closeFiles()
+		s.lock.Unlock()
+		schedulers.Delete(s.q.name)
+	})
+}
+
+func (s *scheduler) stats() util.MapStr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	m := util.MapStr{
+		"pending":   len(s.pending),
+		"delivered": s.delivered,
+	}
+	if len(s.pending) > 0 {
+		m["next_delivery"] = time.Unix(0, s.pending[0].NotBefore)
+	}
+	return m
+}
+
+// GetScheduledStats return the pending and delivered count of the scheduled messages of the queue
+func GetScheduledStats(queueID string) util.MapStr {
+	v, ok := schedulers.Load(queueID)
+	if !ok {
+		return nil
+	}
+	return v.(*scheduler).stats()
+}
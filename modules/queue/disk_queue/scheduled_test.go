@@ -0,0 +1,135 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadScheduledEntries(t *testing.T) {
+	dir := t.TempDir()
+	dataFile := path.Join(dir, scheduledFileName)
+	ackFile := path.Join(dir, scheduledAckFileName)
+
+	buf := bytes.Buffer{}
+	positions := []int64{}
+	for i, notBefore := range []int64{300, 100, 200} {
+		positions = append(positions, int64(buf.Len()))
+		body := bytes.Buffer{}
+		encodeRecord(&body, &Record{Timestamp: notBefore, Data: []byte{byte('a' + i)}})
+		var header [scheduledEntryHeaderSize]byte
+		binary.BigEndian.PutUint64(header[0:8], uint64(notBefore))
+		binary.BigEndian.PutUint32(header[8:12], uint32(body.Len()))
+		buf.Write(header[:])
+		buf.Write(body.Bytes())
+	}
+	end := int64(buf.Len())
+	//partially written entry
+	buf.Write([]byte{0, 0, 0, 0, 0, 0, 1})
+	assert.NoError(t, os.WriteFile(dataFile, buf.Bytes(), 0600))
+
+	var ack [8]byte
+	binary.BigEndian.PutUint64(ack[:], uint64(positions[2]))
+	assert.NoError(t, os.WriteFile(ackFile, ack[:], 0600))
+
+	entries, writePos, err := loadScheduledEntries(dataFile, ackFile)
+	assert.NoError(t, err)
+	assert.Equal(t, end, writePos)
+	assert.Equal(t, 2, len(entries))
+
+	h := scheduledHeap(entries)
+	heap.Init(&h)
+	heap.Push(&h, scheduledEntry{NotBefore: 100, Position: end})
+
+	first := heap.Pop(&h).(scheduledEntry)
+	assert.Equal(t, int64(100), first.NotBefore)
+	assert.Equal(t, positions[1], first.Position)
+	second := heap.Pop(&h).(scheduledEntry)
+	assert.Equal(t, end, second.Position)
+	third := heap.Pop(&h).(scheduledEntry)
+	assert.Equal(t, int64(300), third.NotBefore)
+}
+
+func TestCompactScheduledFile(t *testing.T) {
+	minBytes := scheduledCompactMinBytes
+	scheduledCompactMinBytes = 1
+	defer func() { scheduledCompactMinBytes = minBytes }()
+
+	dir := t.TempDir()
+	s := &scheduler{q: &DiskBasedQueue{name: t.Name(), dataPath: dir}}
+	defer s.closeFiles()
+	for i, notBefore := range []int64{100, 200, 300, 300} {
+		assert.NoError(t, s.schedule(notBefore, &Record{Timestamp: notBefore, Data: []byte{byte('a' + i)}}))
+	}
+	ack := func() {
+		entry := heap.Pop(&s.pending).(scheduledEntry)
+		var data [8]byte
+		binary.BigEndian.PutUint64(data[:], uint64(entry.Position))
+		_, err := s.ackFile.Write(data[:])
+		assert.NoError(t, err)
+		s.acked += scheduledEntryHeaderSize + int64(entry.Size)
+	}
+
+	//not compacted until most of the file was delivered
+	ack()
+	size := s.writePos
+	s.compact()
+	assert.Equal(t, size, s.writePos)
+
+	ack()
+	s.compact()
+	assert.Equal(t, size/2, s.writePos)
+	assert.Equal(t, int64(0), s.acked)
+	info, err := os.Stat(path.Join(dir, scheduledAckFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+
+	//the pending entries are kept in order, and can be loaded again
+	entries, writePos, err := loadScheduledEntries(path.Join(dir, scheduledFileName), path.Join(dir, scheduledAckFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, s.writePos, writePos)
+	assert.Equal(t, 2, len(entries))
+	assert.NoError(t, s.openFiles())
+	for i, expected := range []byte{'c', 'd'} {
+		entry := heap.Pop(&s.pending).(scheduledEntry)
+		assert.Equal(t, entries[i], entry)
+		body := make([]byte, entry.Size)
+		_, err = s.dataFile.ReadAt(body, entry.Position+scheduledEntryHeaderSize)
+		assert.NoError(t, err)
+		record, err := decodeRecord(body)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{expected}, record.Data)
+	}
+}
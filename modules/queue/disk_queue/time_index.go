@@ -0,0 +1,295 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/core/util/zstd"
+)
+
+// sparse time index for each segment, stored next to the segment file and never compressed
+// each entry is [timestamp:8][position:8], both big endian, in the order of positions,
+// timestamps are given by the producer and may go backwards, so the indexed timestamp is the max
+// timestamp of all the records up to the position, which makes a seek never skip matching records
+const timeIndexEntrySize = 16
+
+const timeIndexFileSuffix = ".tdx"
+
+func GetTimeIndexFileName(queueID string, segmentID int64) string {
+	return path.Join(GetDataPath(queueID), fmt.Sprintf("%09d%s", segmentID, timeIndexFileSuffix))
+}
+
+type timeIndexEntry struct {
+	Timestamp int64
+	Position  int64
+}
+
+// writeTimeIndex append an entry when the record is the first one of the segment,
+// or the segment grows more than time_index_interval_bytes since last entry
+func (d *DiskBasedQueue) writeTimeIndex(timestamp, pos int64) {
+	if d.timeIndexFile == nil {
+		var err error
+		fileName := GetTimeIndexFileName(d.name, d.writeSegmentNum)
+		d.timeIndexFile, err = os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			log.Errorf("diskqueue(%s) failed to open time index %v - %s", d.name, fileName, err)
+			return
+		}
+		//always index the position where we start or resume writing
+		d.lastTimeIndexPos = -1
+		if d.maxTimeIndexTimestamp == 0 {
+			d.maxTimeIndexTimestamp = lastIndexedTimestamp(d.name, d.writeSegmentNum)
+		}
+	}
+
+	if timestamp > d.maxTimeIndexTimestamp {
+		d.maxTimeIndexTimestamp = timestamp
+	}
+
+	if d.lastTimeIndexPos >= 0 && pos-d.lastTimeIndexPos < d.cfg.TimeIndexIntervalBytes {
+		return
+	}
+
+	var buf [timeIndexEntrySize]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(d.maxTimeIndexTimestamp))
+	binary.BigEndian.PutUint64(buf[8:], uint64(pos))
+	_, err := d.timeIndexFile.Write(buf[:])
+	if err != nil {
+		log.Errorf("diskqueue(%s) failed to write time index - %s", d.name, err)
+		d.closeTimeIndex()
+		return
+	}
+	d.lastTimeIndexPos = pos
+}
+
+func (d *DiskBasedQueue) closeTimeIndex() {
+	if d.timeIndexFile != nil {
+		d.timeIndexFile.Close()
+		d.timeIndexFile = nil
+	}
+}
+
+// lastIndexedTimestamp return the max timestamp indexed in the segment or the one before, to continue after restart
+func lastIndexedTimestamp(queueID string, segmentID int64) int64 {
+	for i := segmentID; i >= 0 && i >= segmentID-1; i-- {
+		entries, err := readTimeIndex(queueID, i)
+		if err == nil && len(entries) > 0 {
+			return entries[len(entries)-1].Timestamp
+		}
+	}
+	return 0
+}
+
+func readTimeIndex(queueID string, segmentID int64) ([]timeIndexEntry, error) {
+	fileName := GetTimeIndexFileName(queueID, segmentID)
+	if !util.FileExists(fileName) {
+		return nil, nil
+	}
+	data, err := util.FileGetContent(fileName)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]timeIndexEntry, 0, len(data)/timeIndexEntrySize)
+	//ignore the partial entry at the tail
+	for i := 0; i+timeIndexEntrySize <= len(data); i += timeIndexEntrySize {
+		entries = append(entries, timeIndexEntry{
+			Timestamp: int64(binary.BigEndian.Uint64(data[i : i+8])),
+			Position:  int64(binary.BigEndian.Uint64(data[i+8 : i+16])),
+		})
+	}
+	return entries, nil
+}
+
+// openSegmentReader open the local segment for read, a compressed segment is decompressed on the fly,
+// it never decompresses to disk or downloads from s3, so it is safe to be called from read-only apis
+func openSegmentReader(fileName string, pos int64) (*bufio.Reader, func(), error) {
+	f, err := os.OpenFile(fileName, os.O_RDONLY, 0600)
+	if err == nil {
+		if pos > 0 {
+			_, err = f.Seek(pos, 0)
+			if err != nil {
+				f.Close()
+				return nil, nil, err
+			}
+		}
+		return bufio.NewReader(f), func() { f.Close() }, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	f, err = os.OpenFile(fileName+compressFileSuffix, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := zstd.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	closer := func() {
+		r.Close()
+		f.Close()
+	}
+	reader := bufio.NewReader(r)
+	if pos > 0 {
+		_, err = reader.Discard(int(pos))
+		if err != nil {
+			closer()
+			return nil, nil, err
+		}
+	}
+	return reader, closer, nil
+}
+
+// scanSegmentByTime read records from the position, return the position of the first record
+// which timestamp is not before the target, legacy records without timestamp are always skipped
+func scanSegmentByTime(cfg *DiskQueueConfig, fileName string, pos int64, timestamp int64) (int64, bool, error) {
+	reader, closer, err := openSegmentReader(fileName, pos)
+	if err != nil {
+		return 0, false, err
+	}
+	defer closer()
+
+	var sizeFlag uint32
+	var header [9]byte
+	for {
+		err = binary.Read(reader, binary.BigEndian, &sizeFlag)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return pos, false, nil
+			}
+			return pos, false, err
+		}
+
+		size, versioned := parseRecordSize(sizeFlag)
+		if size < cfg.MinMsgSize || size > cfg.MaxMsgSize {
+			return pos, false, errors.Errorf("invalid message read size (%d), file: %v, pos: %v", size, fileName, pos)
+		}
+
+		remain := int64(size)
+		if versioned && size >= int32(len(header)) {
+			_, err = io.ReadFull(reader, header[:])
+			if err != nil {
+				return pos, false, nil
+			}
+			if header[0] == recordVersion1 && int64(binary.BigEndian.Uint64(header[1:9])) >= timestamp {
+				return pos, true, nil
+			}
+			remain -= int64(len(header))
+		}
+
+		_, err = reader.Discard(int(remain))
+		if err != nil {
+			//partial record at the tail of the segment
+			return pos, false, nil
+		}
+		pos += 4 + int64(size)
+	}
+}
+
+// GetOffsetByTime resolve a wall-clock time to the offset of the first message produced at or after it,
+// return the latest offset if there is no such message
+func (module *DiskQueue) GetOffsetByTime(k *queue.QueueConfig, t time.Time) (queue.Offset, error) {
+	return getOffsetByTime(module.cfg, k.ID, module.LatestOffset(k), t.UnixNano())
+}
+
+func getOffsetByTime(cfg *DiskQueueConfig, queueID string, latest queue.Offset, timestamp int64) (queue.Offset, error) {
+	//walk back to the last segment which starts before the target time,
+	//all the records before an entry with an earlier timestamp are earlier than the target too
+	segment := latest.Segment
+	for ; segment > 0; segment-- {
+		entries, err := readTimeIndex(queueID, segment)
+		if err != nil {
+			return latest, err
+		}
+		if len(entries) == 0 {
+			file := GetFileName(queueID, segment)
+			if !util.FileExists(file) && !util.FileExists(file+compressFileSuffix) {
+				if segment == latest.Segment {
+					//the segment to write may not be created yet
+					continue
+				}
+				//no more local segments
+				segment++
+				break
+			}
+			//segment without time index, scan from the beginning
+			break
+		}
+		if entries[0].Timestamp < timestamp {
+			break
+		}
+	}
+
+	for ; segment <= latest.Segment; segment++ {
+		var pos int64
+		entries, err := readTimeIndex(queueID, segment)
+		if err != nil {
+			return latest, err
+		}
+		for _, v := range entries {
+			if v.Timestamp >= timestamp {
+				break
+			}
+			pos = v.Position
+		}
+
+		//only look at local segments, segments only available on s3 are skipped
+		fileName := GetFileName(queueID, segment)
+		if !util.FileExists(fileName) && !util.FileExists(fileName+compressFileSuffix) {
+			if global.Env().IsDebug {
+				log.Debugf("segment [%v] of queue [%v] not found locally, skip", segment, queueID)
+			}
+			continue
+		}
+
+		found := false
+		pos, found, err = scanSegmentByTime(cfg, fileName, pos, timestamp)
+		if err != nil {
+			return latest, err
+		}
+		if found {
+			return queue.NewOffset(segment, pos), nil
+		}
+	}
+
+	return latest, nil
+}
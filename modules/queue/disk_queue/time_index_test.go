@@ -0,0 +1,201 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util/zstd"
+)
+
+func writeTestSegment(t *testing.T, file string, timestamps []int64) []int64 {
+	buf := bytes.Buffer{}
+	positions := []int64{}
+	for _, ts := range timestamps {
+		positions = append(positions, int64(buf.Len()))
+		body := bytes.Buffer{}
+		encodeRecord(&body, &Record{Timestamp: ts, Data: []byte("hello")})
+		binary.Write(&buf, binary.BigEndian, uint32(body.Len())|recordVersionFlag)
+		buf.Write(body.Bytes())
+	}
+	assert.Nil(t, os.WriteFile(file, buf.Bytes(), 0600))
+	return positions
+}
+
+func TestScanSegmentByTime(t *testing.T) {
+	cfg := &DiskQueueConfig{MinMsgSize: 1, MaxMsgSize: 1024}
+	file := path.Join(t.TempDir(), "000000000.dat")
+	positions := writeTestSegment(t, file, []int64{100, 200, 300, 400})
+
+	pos, found, err := scanSegmentByTime(cfg, file, 0, 50)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, positions[0], pos)
+
+	pos, found, err = scanSegmentByTime(cfg, file, 0, 250)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, positions[2], pos)
+
+	//start from an indexed position
+	pos, found, err = scanSegmentByTime(cfg, file, positions[1], 300)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, positions[2], pos)
+
+	//all messages are older
+	_, found, err = scanSegmentByTime(cfg, file, 0, 500)
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+func setupTestDataDir(t *testing.T) {
+	env1 := env.EmptyEnv()
+	env1.SystemConfig.PathConfig.Data = t.TempDir()
+	global.RegisterEnv(env1)
+}
+
+// writeTestIndexedSegment write the segment of the queue and index it the same way as the writer does
+func writeTestIndexedSegment(t *testing.T, cfg *DiskQueueConfig, queueID string, segment int64, timestamps []int64) []int64 {
+	assert.Nil(t, os.MkdirAll(GetDataPath(queueID), 0755))
+	positions := writeTestSegment(t, GetFileName(queueID, segment), timestamps)
+	d := &DiskBasedQueue{name: queueID, writeSegmentNum: segment, cfg: cfg}
+	for i, ts := range timestamps {
+		d.writeTimeIndex(ts, positions[i])
+	}
+	d.closeTimeIndex()
+	return positions
+}
+
+func TestWriteTimeIndex(t *testing.T) {
+	setupTestDataDir(t)
+	cfg := &DiskQueueConfig{MinMsgSize: 1, MaxMsgSize: 1024}
+	positions := writeTestSegment(t, path.Join(t.TempDir(), "000000000.dat"), []int64{100, 200, 300, 400, 500})
+	recordSize := positions[1] - positions[0]
+	cfg.TimeIndexIntervalBytes = 2 * recordSize
+
+	positions = writeTestIndexedSegment(t, cfg, "tdx", 0, []int64{100, 200, 300, 400, 500})
+	entries, err := readTimeIndex("tdx", 0)
+	assert.Nil(t, err)
+	//the first record, then every two records
+	assert.Equal(t, []timeIndexEntry{{100, positions[0]}, {300, positions[2]}, {500, positions[4]}}, entries)
+
+	//resume writing always index the first record
+	d := &DiskBasedQueue{name: "tdx", writeSegmentNum: 0, cfg: cfg}
+	d.writeTimeIndex(600, positions[4]+recordSize)
+	d.closeTimeIndex()
+	entries, err = readTimeIndex("tdx", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(entries))
+	assert.Equal(t, timeIndexEntry{600, positions[4] + recordSize}, entries[3])
+
+	//no index for other segments
+	entries, err = readTimeIndex("tdx", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestGetOffsetByTime(t *testing.T) {
+	setupTestDataDir(t)
+	cfg := &DiskQueueConfig{MinMsgSize: 1, MaxMsgSize: 1024, TimeIndexIntervalBytes: 1}
+	seg0 := writeTestIndexedSegment(t, cfg, "seek", 0, []int64{100, 200, 300})
+	seg1 := writeTestIndexedSegment(t, cfg, "seek", 1, []int64{400, 500, 600})
+	latest := queue.NewOffset(2, 0)
+
+	offset, err := getOffsetByTime(cfg, "seek", latest, 50)
+	assert.Nil(t, err)
+	assert.Equal(t, queue.NewOffset(0, seg0[0]), offset)
+
+	offset, err = getOffsetByTime(cfg, "seek", latest, 250)
+	assert.Nil(t, err)
+	assert.Equal(t, queue.NewOffset(0, seg0[2]), offset)
+
+	//falls into the next segment
+	offset, err = getOffsetByTime(cfg, "seek", latest, 350)
+	assert.Nil(t, err)
+	assert.Equal(t, queue.NewOffset(1, seg1[0]), offset)
+
+	offset, err = getOffsetByTime(cfg, "seek", latest, 500)
+	assert.Nil(t, err)
+	assert.Equal(t, queue.NewOffset(1, seg1[1]), offset)
+
+	//newer than all messages
+	offset, err = getOffsetByTime(cfg, "seek", latest, 700)
+	assert.Nil(t, err)
+	assert.Equal(t, latest, offset)
+
+	//compressed segment is scanned in place, never decompressed to disk
+	file := GetFileName("seek", 0)
+	assert.Nil(t, zstd.CompressFile(file, file+compressFileSuffix))
+	assert.Nil(t, os.Remove(file))
+	offset, err = getOffsetByTime(cfg, "seek", latest, 250)
+	assert.Nil(t, err)
+	assert.Equal(t, queue.NewOffset(0, seg0[2]), offset)
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+
+	//segments not available locally are skipped
+	assert.Nil(t, os.Remove(file+compressFileSuffix))
+	offset, err = getOffsetByTime(cfg, "seek", latest, 50)
+	assert.Nil(t, err)
+	assert.Equal(t, queue.NewOffset(1, seg1[0]), offset)
+}
+
+func TestGetOffsetByTimeUnordered(t *testing.T) {
+	setupTestDataDir(t)
+	cfg := &DiskQueueConfig{MinMsgSize: 1, MaxMsgSize: 1024, TimeIndexIntervalBytes: 1}
+	seg0 := writeTestIndexedSegment(t, cfg, "unordered", 0, []int64{100, 300, 200})
+	seg1 := writeTestIndexedSegment(t, cfg, "unordered", 1, []int64{150, 400})
+	latest := queue.NewOffset(2, 0)
+
+	//the index keeps the max timestamp so far, across segments
+	entries, err := readTimeIndex("unordered", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []timeIndexEntry{{300, seg1[0]}, {400, seg1[1]}}, entries)
+
+	//earlier records with a later timestamp are not skipped
+	offset, err := getOffsetByTime(cfg, "unordered", latest, 160)
+	assert.Nil(t, err)
+	assert.Equal(t, queue.NewOffset(0, seg0[1]), offset)
+
+	offset, err = getOffsetByTime(cfg, "unordered", latest, 300)
+	assert.Nil(t, err)
+	assert.Equal(t, queue.NewOffset(0, seg0[1]), offset)
+
+	offset, err = getOffsetByTime(cfg, "unordered", latest, 350)
+	assert.Nil(t, err)
+	assert.Equal(t, queue.NewOffset(1, seg1[1]), offset)
+}
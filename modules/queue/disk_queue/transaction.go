@@ -0,0 +1,284 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+// write-ahead marker of a transaction, it is written before any message of the transaction,
+// and removed after all messages and offsets are applied, transactions with marker left
+// are applied again during startup, messages already written are detected by the txn header
+type transactionMarker struct {
+	Transaction *queue.Transaction `json:"transaction"`
+	//latest offset of each queue before the transaction was applied
+	Start map[string]queue.Offset `json:"start"`
+}
+
+const transactionFileSuffix = ".txn"
+
+func getTransactionDir() string {
+	return path.Join(global.Env().GetDataDir(), "queue", "_transactions")
+}
+
+func getTransactionFileName(txnID string) string {
+	return path.Join(getTransactionDir(), txnID+transactionFileSuffix)
+}
+
+func (module *DiskQueue) CommitTransaction(txn *queue.Transaction) error {
+	marker := &transactionMarker{Transaction: txn, Start: map[string]queue.Offset{}}
+	for _, v := range txn.Produces {
+		cfg, ok := queue.GetConfigByUUID(v.Queue)
+		if !ok {
+			return errors.Errorf("queue [%v] was not found", v.Queue)
+		}
+		if module.IsReplica(cfg.ID) {
+			return errors.Errorf("queue [%v] is a replica, writes are not allowed", cfg.Name)
+		}
+		for _, req := range v.Requests {
+			msgSize := len(req.Data)
+			if int32(msgSize) < module.cfg.MinMsgSize || int32(msgSize) > module.cfg.MaxMsgSize {
+				return errors.Errorf("queue:%v, invalid message size: %v, should between: %v TO %v", cfg.ID, msgSize, module.cfg.MinMsgSize, module.cfg.MaxMsgSize)
+			}
+		}
+		marker.Start[v.Queue] = module.LatestOffset(cfg)
+	}
+
+	fileName := getTransactionFileName(txn.ID)
+	err := writeTransactionMarker(fileName, marker)
+	if err != nil {
+		return err
+	}
+
+	err = module.applyTransaction(marker, false)
+	if err != nil {
+		//keep the marker, the rest will be applied after restart
+		log.Errorf("failed to apply transaction [%v], %v", txn.ID, err)
+		return err
+	}
+
+	return os.Remove(fileName)
+}
+
+func writeTransactionMarker(fileName string, marker *transactionMarker) error {
+	dir := filepath.Dir(fileName)
+	if !util.FileExists(dir) {
+		os.MkdirAll(dir, 0755)
+	}
+
+	tmpFileName := fileName + ".tmp"
+	f, err := os.OpenFile(tmpFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(util.MustToJSONBytes(marker))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Sync()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return util.AtomicFileRename(tmpFileName, fileName)
+}
+
+func (module *DiskQueue) applyTransaction(marker *transactionMarker, recovery bool) error {
+	txn := marker.Transaction
+	for _, v := range txn.Produces {
+		err := module.Init(v.Queue)
+		if err != nil {
+			return err
+		}
+		q, ok := module.queues.Load(v.Queue)
+		if !ok {
+			return errors.Errorf("queue [%v] not found", v.Queue)
+		}
+		d := q.(*DiskBasedQueue)
+
+		requests := v.Requests
+		if recovery {
+			written, err := module.countTransactionRecords(v.Queue, marker.Start[v.Queue], d.LatestOffset(), txn.ID)
+			if err != nil {
+				return err
+			}
+			if written > len(requests) {
+				written = len(requests)
+			}
+			requests = requests[written:]
+		}
+
+		for _, req := range requests {
+			headers := make(map[string]string, len(req.Headers)+1)
+			for hk, hv := range req.Headers {
+				headers[hk] = hv
+			}
+			headers[queue.TransactionIDHeader] = txn.ID
+
+			timestamp := req.Timestamp
+			if timestamp <= 0 {
+				timestamp = util.GetLowPrecisionCurrentTime().UnixNano()
+			}
+
+			res := d.PutRecord(&Record{Timestamp: timestamp, Key: req.Key, Headers: headers, Data: req.Data})
+			if res.Error != nil {
+				return res.Error
+			}
+		}
+	}
+
+	return queue.ApplyTransactionCommits(txn)
+}
+
+// countTransactionRecords count records of the transaction between the start and end offsets
+func (module *DiskQueue) countTransactionRecords(queueID string, start, end queue.Offset, txnID string) (int, error) {
+	var count int
+	for segment := start.Segment; segment <= end.Segment; segment++ {
+		var pos int64
+		if segment == start.Segment {
+			pos = start.Position
+		}
+		fileName, _, _ := SmartGetFileName(module.cfg, queueID, segment)
+		if !util.FileExists(fileName) {
+			continue
+		}
+
+		f, err := os.OpenFile(fileName, os.O_RDONLY, 0600)
+		if err != nil {
+			return count, err
+		}
+		_, err = f.Seek(pos, 0)
+		if err != nil {
+			f.Close()
+			return count, err
+		}
+
+		reader := bufio.NewReader(f)
+		var header [4]byte
+		for {
+			if segment == end.Segment && pos >= end.Position {
+				break
+			}
+			_, err = io.ReadFull(reader, header[:])
+			if err != nil {
+				break
+			}
+			size, versioned := parseRecordSize(binary.BigEndian.Uint32(header[:]))
+			if size < module.cfg.MinMsgSize || size > module.cfg.MaxMsgSize {
+				break
+			}
+			body := make([]byte, size)
+			_, err = io.ReadFull(reader, body)
+			if err != nil {
+				break
+			}
+			pos += 4 + int64(size)
+			if !versioned {
+				continue
+			}
+			record, err := decodeRecord(body)
+			if err == nil && record.Headers[queue.TransactionIDHeader] == txnID {
+				count++
+			}
+		}
+		f.Close()
+	}
+	return count, nil
+}
+
+// recoverTransactions apply transactions interrupted by crash, should be called after queue configs are loaded,
+// markers failed to recover are renamed with the .bad suffix and left for manual check, so the node can still start
+func (module *DiskQueue) recoverTransactions() {
+	dir := getTransactionDir()
+	if !util.FileExists(dir) {
+		return
+	}
+
+	files, err := filepath.Glob(path.Join(dir, "*"+transactionFileSuffix))
+	if err != nil {
+		log.Errorf("failed to list transaction markers in %v, %v", dir, err)
+		return
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		err = module.recoverTransaction(file)
+		if err != nil {
+			badFile := file + ".bad"
+			log.Errorf("failed to recover transaction from %v, saving the marker as %v, %v", file, badFile, err)
+			err = util.AtomicFileRename(file, badFile)
+			if err != nil {
+				log.Errorf("failed to rename transaction marker %v to %v, %v", file, badFile, err)
+			}
+			continue
+		}
+		err = os.Remove(file)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+func (module *DiskQueue) recoverTransaction(file string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("%v", r)
+		}
+	}()
+
+	data, err := util.FileGetContent(file)
+	if err != nil {
+		return err
+	}
+
+	marker := &transactionMarker{}
+	err = util.FromJSONBytes(data, marker)
+	if err != nil {
+		return err
+	}
+	if marker.Transaction == nil {
+		return errors.New("invalid transaction marker")
+	}
+
+	log.Infof("recover transaction [%v]", strings.TrimSuffix(filepath.Base(file), transactionFileSuffix))
+	return module.applyTransaction(marker, true)
+}
@@ -0,0 +1,102 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/queue/queuetest"
+	"infini.sh/framework/core/util"
+)
+
+func newTestDiskQueueModule(t *testing.T) *DiskQueue {
+	setupTestDataDir(t)
+	queuetest.Setup()
+	module := &DiskQueue{cfg: &DiskQueueConfig{
+		Enabled:                true,
+		MinMsgSize:             1,
+		MaxMsgSize:             1024,
+		MaxBytesPerFile:        256,
+		WriteTimeoutInMS:       1000,
+		SyncEveryRecords:       1,
+		SyncTimeoutInMS:        1000,
+		EOFRetryDelayInMs:      10,
+		TimeIndexIntervalBytes: 1,
+	}}
+	t.Cleanup(func() {
+		module.queues.Range(func(key, value interface{}) bool {
+			value.(*DiskBasedQueue).Close()
+			return true
+		})
+	})
+	return module
+}
+
+func TestRecoverTransactions(t *testing.T) {
+	module := newTestDiskQueueModule(t)
+	cfg := &queue.QueueConfig{ID: "txn_recover", Name: "txn_recover"}
+	queue.RegisterConfig(cfg)
+
+	//interrupted before any message was written
+	applied := &queue.Transaction{ID: "txn_applied", Produces: []*queue.TransactionProduce{
+		{Queue: cfg.ID, Requests: []queue.ProduceRequest{{Data: []byte("a")}, {Data: []byte("b")}}},
+	}}
+	assert.Nil(t, writeTransactionMarker(getTransactionFileName(applied.ID), &transactionMarker{Transaction: applied, Start: map[string]queue.Offset{cfg.ID: queue.NewOffset(0, 0)}}))
+
+	//the consumer to commit is gone
+	missing := &queue.Transaction{ID: "txn_missing", Commits: []*queue.TransactionCommit{
+		{Queue: cfg.ID, Group: "group", Consumer: "missing", Offset: queue.NewOffset(0, 1)},
+	}}
+	assert.Nil(t, writeTransactionMarker(getTransactionFileName(missing.ID), &transactionMarker{Transaction: missing}))
+
+	corrupted := getTransactionFileName("txn_corrupted")
+	assert.Nil(t, os.WriteFile(corrupted, []byte("{invalid"), 0600))
+
+	assert.NotPanics(t, func() {
+		module.recoverTransactions()
+	})
+
+	assert.False(t, util.FileExists(getTransactionFileName(applied.ID)))
+	count, err := module.countTransactionRecords(cfg.ID, queue.NewOffset(0, 0), module.LatestOffset(cfg), applied.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	//failed markers are quarantined and not picked up again
+	assert.False(t, util.FileExists(getTransactionFileName(missing.ID)))
+	assert.True(t, util.FileExists(getTransactionFileName(missing.ID)+".bad"))
+	assert.False(t, util.FileExists(corrupted))
+	assert.True(t, util.FileExists(corrupted+".bad"))
+
+	module.recoverTransactions()
+	count, err = module.countTransactionRecords(cfg.ID, queue.NewOffset(0, 0), module.LatestOffset(cfg), applied.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+}
@@ -51,6 +51,8 @@ type RedisConfig struct {
 	Password string `config:"password"`
 	PoolSize int    `config:"pool_size"`
 	Db       int    `config:"db"`
+
+	Queue RedisQueueConfig `config:"queue"`
 }
 
 func (module *RedisModule) Name() string {
@@ -61,6 +63,9 @@ func (module *RedisModule) Setup() {
 	module.config = RedisConfig{
 		Db:       0,
 		PoolSize: 1000,
+		Queue: RedisQueueConfig{
+			Prefix: "queue:",
+		},
 	}
 	ok, err := env.ParseConfig("redis", &module.config)
 	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
@@ -171,6 +176,14 @@ func (module *RedisModule) Start() error {
 	//handler:=&RedisQueue{client: module.client,pubsub: map[string]int{}}
 	//queue.Register("redis",handler)
 
+	if module.config.Queue.Enabled {
+		handler := NewRedisStreamQueue(module.client, &module.config.Queue)
+		queue.Register("redis", handler)
+		if module.config.Queue.Default {
+			queue.RegisterDefaultHandler(handler)
+		}
+	}
+
 	return nil
 }
 
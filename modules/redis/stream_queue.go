@@ -0,0 +1,489 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/go-redis/redis/v8"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+// RedisStreamQueue implements queue.AdvancedQueueAPI on top of redis streams,
+// each queue is a stream, each consumer is a consumer group of the stream.
+//
+// stream id `ms-seq` is mapped to queue.Offset{Segment: ms, Position: seq},
+// an offset points to the last consumed message, messages after it will be delivered next
+type RedisStreamQueue struct {
+	client    *redis.Client
+	cfg       *RedisQueueConfig
+	queues    sync.Map
+	consumers sync.Map //q+consumer=instance
+}
+
+type RedisQueueConfig struct {
+	Enabled bool   `config:"enabled"`
+	Default bool   `config:"default"`
+	Prefix  string `config:"prefix"`
+	//trim the stream to about this many messages, 0 means no limit
+	MaxLen int64 `config:"max_len"`
+}
+
+const (
+	fieldData      = "data"
+	fieldKey       = "key"
+	fieldTimestamp = "ts"
+	fieldHeader    = "h:"
+)
+
+func NewRedisStreamQueue(client *redis.Client, cfg *RedisQueueConfig) *RedisStreamQueue {
+	return &RedisStreamQueue{client: client, cfg: cfg}
+}
+
+func (module *RedisStreamQueue) Name() string {
+	return "redis_stream_queue"
+}
+
+func (module *RedisStreamQueue) streamKey(k string) string {
+	return module.cfg.Prefix + k
+}
+
+func (module *RedisStreamQueue) offsetKey(k string) string {
+	return module.cfg.Prefix + k + ":offsets"
+}
+
+func getGroupForRedis(consumer *queue.ConsumerConfig) string {
+	return consumer.Key()
+}
+
+func streamIDToOffset(id string) queue.Offset {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		panic(errors.Errorf("invalid stream id: %v", id))
+	}
+	segment, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		panic(errors.Errorf("invalid stream id: %v", id))
+	}
+	position, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil {
+		panic(errors.Errorf("invalid stream id: %v", id))
+	}
+	return queue.NewOffset(segment, position)
+}
+
+func offsetToStreamID(offset queue.Offset) string {
+	return fmt.Sprintf("%d-%d", offset.Segment, offset.Position)
+}
+
+func (module *RedisStreamQueue) Init(k string) error {
+	module.queues.Store(k, true)
+	return nil
+}
+
+func (module *RedisStreamQueue) Close(k string) error {
+	return nil
+}
+
+func (module *RedisStreamQueue) Push(k string, v []byte) error {
+	_, _, err := module.add(k, &queue.ProduceRequest{Data: v})
+	return err
+}
+
+func (module *RedisStreamQueue) add(k string, req *queue.ProduceRequest) (string, int64, error) {
+	module.Init(k)
+
+	timestamp := req.Timestamp
+	if timestamp <= 0 {
+		timestamp = time.Now().UnixNano()
+	}
+
+	values := map[string]interface{}{
+		fieldData:      req.Data,
+		fieldTimestamp: timestamp,
+	}
+	if len(req.Key) > 0 {
+		values[fieldKey] = req.Key
+	}
+	for k, v := range req.Headers {
+		values[fieldHeader+k] = v
+	}
+
+	args := &redis.XAddArgs{
+		Stream: module.streamKey(k),
+		Values: values,
+	}
+	if module.cfg.MaxLen > 0 {
+		args.MaxLen = module.cfg.MaxLen
+		args.Approx = true
+	}
+	id, err := module.client.XAdd(ctx, args).Result()
+	return id, timestamp, err
+}
+
+func (module *RedisStreamQueue) GetStorageSize(k string) uint64 {
+	size, err := module.client.MemoryUsage(ctx, module.streamKey(k)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Errorf("get storage size for %v, error:%v", k, err)
+		}
+		return 0
+	}
+	return uint64(size)
+}
+
+func (module *RedisStreamQueue) Depth(k string) int64 {
+	c, err := module.client.XLen(ctx, module.streamKey(k)).Result()
+	if err != nil {
+		return -1
+	}
+	return c
+}
+
+func (module *RedisStreamQueue) Destroy(k string) error {
+	module.queues.Delete(k)
+	return module.client.Del(ctx, module.streamKey(k), module.offsetKey(k)).Err()
+}
+
+func (module *RedisStreamQueue) GetQueues() []string {
+	q := []string{}
+	module.queues.Range(func(key, value interface{}) bool {
+		q = append(q, util.ToString(key))
+		return true
+	})
+	return q
+}
+
+func (module *RedisStreamQueue) LatestOffset(k *queue.QueueConfig) queue.Offset {
+	msgs, err := module.client.XRevRangeN(ctx, module.streamKey(k.ID), "+", "-", 1).Result()
+	if err != nil {
+		panic(err)
+	}
+	if len(msgs) == 0 {
+		return queue.NewOffset(0, 0)
+	}
+	return streamIDToOffset(msgs[0].ID)
+}
+
+func (module *RedisStreamQueue) GetOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.Offset, error) {
+	str, err := module.client.HGet(ctx, module.offsetKey(k.ID), consumer.Key()).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return queue.NewOffset(0, 0), nil
+		}
+		return queue.NewOffset(0, 0), err
+	}
+	return queue.DecodeFromString(str), nil
+}
+
+func (module *RedisStreamQueue) DeleteOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig) error {
+	err := module.client.HDel(ctx, module.offsetKey(k.ID), consumer.Key()).Err()
+	if err != nil {
+		return err
+	}
+	err = module.client.XGroupDestroy(ctx, module.streamKey(k.ID), getGroupForRedis(consumer)).Err()
+	if err != nil && !util.ContainStr(err.Error(), "NOGROUP") && !util.ContainStr(err.Error(), "no such key") {
+		return err
+	}
+	return nil
+}
+
+func (module *RedisStreamQueue) CommitOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig, offset queue.Offset) (bool, error) {
+	err := module.client.HSet(ctx, module.offsetKey(k.ID), consumer.Key(), offset.EncodeToString()).Err()
+	if err != nil {
+		return false, err
+	}
+
+	//ack delivered messages
+	v, ok := module.consumers.Load(k.ID + consumer.Key())
+	if ok {
+		err = v.(*StreamConsumer).ack(offset)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if global.Env().IsDebug {
+		log.Debugf("commit %v[%v] offset: %v", k.Name, k.ID, offset.String())
+	}
+	return true, nil
+}
+
+// GetOffsetByTime return the offset right before the first message added at or after the time,
+// as stream ids are generated from the redis server time in milliseconds
+func (module *RedisStreamQueue) GetOffsetByTime(k *queue.QueueConfig, t time.Time) (queue.Offset, error) {
+	stream := module.streamKey(k.ID)
+	msgs, err := module.client.XRangeN(ctx, stream, fmt.Sprintf("%d-0", t.UnixMilli()), "+", 1).Result()
+	if err != nil {
+		return queue.Offset{}, err
+	}
+	if len(msgs) == 0 {
+		return module.LatestOffset(k), nil
+	}
+
+	msgs, err = module.client.XRevRangeN(ctx, stream, "("+msgs[0].ID, "-", 1).Result()
+	if err != nil {
+		return queue.Offset{}, err
+	}
+	if len(msgs) == 0 {
+		return queue.NewOffset(0, 0), nil
+	}
+	return streamIDToOffset(msgs[0].ID), nil
+}
+
+func (module *RedisStreamQueue) AcquireConsumer(k *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.ConsumerAPI, error) {
+	module.Init(k.ID)
+
+	offset, err := module.GetOffset(k, consumer)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := module.streamKey(k.ID)
+	group := getGroupForRedis(consumer)
+	start := offsetToStreamID(offset)
+	err = module.client.XGroupCreateMkStream(ctx, stream, group, start).Err()
+	if err != nil {
+		if !util.ContainStr(err.Error(), "BUSYGROUP") {
+			return nil, err
+		}
+		//rewind the group, messages delivered but not committed will be delivered again
+		err = module.client.XGroupSetID(ctx, stream, group, start).Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := &StreamConsumer{
+		qCfg:   k,
+		cCfg:   consumer,
+		queue:  module,
+		client: module.client,
+		stream: stream,
+		group:  group,
+		name:   global.Env().SystemConfig.NodeConfig.ID,
+		offset: offset,
+	}
+	module.consumers.Store(k.ID+consumer.Key(), c)
+	return c, nil
+}
+
+func (module *RedisStreamQueue) ReleaseConsumer(k *queue.QueueConfig, consumer *queue.ConsumerConfig, instance queue.ConsumerAPI) error {
+	module.consumers.Delete(k.ID + consumer.Key())
+	return instance.Close()
+}
+
+func (module *RedisStreamQueue) AcquireProducer(cfg *queue.QueueConfig) (queue.ProducerAPI, error) {
+	return &StreamProducer{cfg: cfg, queue: module}, nil
+}
+
+func (module *RedisStreamQueue) ReleaseProducer(k *queue.QueueConfig, producer queue.ProducerAPI) error {
+	return producer.Close()
+}
+
+type StreamProducer struct {
+	cfg   *queue.QueueConfig
+	queue *RedisStreamQueue
+}
+
+func (p *StreamProducer) Produce(reqs *[]queue.ProduceRequest) (*[]queue.ProduceResponse, error) {
+	if reqs == nil {
+		panic(errors.New("invalid request"))
+	}
+
+	results := []queue.ProduceResponse{}
+	for _, req := range *reqs {
+		if req.NotBefore > 0 {
+			return &results, queue.ErrDelayedDeliveryNotSupported
+		}
+		topic := p.cfg.ID
+		if req.Topic != "" {
+			topic = req.Topic
+		}
+		id, timestamp, err := p.queue.add(topic, &req)
+		if err != nil {
+			return &results, err
+		}
+		results = append(results, queue.ProduceResponse{
+			Topic:     topic,
+			Offset:    streamIDToOffset(id),
+			Timestamp: timestamp,
+		})
+	}
+	return &results, nil
+}
+
+func (p *StreamProducer) Close() error {
+	return nil
+}
+
+type StreamConsumer struct {
+	qCfg *queue.QueueConfig
+	cCfg *queue.ConsumerConfig
+
+	queue  *RedisStreamQueue
+	client *redis.Client
+	stream string
+	group  string
+	name   string
+
+	lock    sync.Mutex
+	offset  queue.Offset
+	pending []string //delivered but not acked ids
+}
+
+func (c *StreamConsumer) Close() error {
+	return nil
+}
+
+func (c *StreamConsumer) ResetOffset(segment, readPos int64) (err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	offset := queue.NewOffset(segment, readPos)
+	err = c.client.XGroupSetID(ctx, c.stream, c.group, offsetToStreamID(offset)).Err()
+	if err != nil {
+		return err
+	}
+	c.offset = offset
+	c.pending = c.pending[:0]
+	return nil
+}
+
+func (c *StreamConsumer) CommitOffset(offset queue.Offset) error {
+	_, err := c.queue.CommitOffset(c.qCfg, c.cCfg, offset)
+	return err
+}
+
+// ack acknowledge all delivered messages up to the offset
+func (c *StreamConsumer) ack(offset queue.Offset) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var i int
+	for ; i < len(c.pending); i++ {
+		o := streamIDToOffset(c.pending[i])
+		if o.Segment > offset.Segment || (o.Segment == offset.Segment && o.Position > offset.Position) {
+			break
+		}
+	}
+	if i == 0 {
+		return nil
+	}
+
+	err := c.client.XAck(ctx, c.stream, c.group, c.pending[:i]...).Err()
+	if err != nil {
+		return err
+	}
+	c.pending = c.pending[i:]
+	return nil
+}
+
+func (c *StreamConsumer) FetchMessages(ctx1 *queue.Context, numOfMessages int) (messages []queue.Message, isTimeout bool, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	count := numOfMessages
+	if c.cCfg.FetchMaxMessages > 0 && (count <= 0 || count > c.cCfg.FetchMaxMessages) {
+		count = c.cCfg.FetchMaxMessages
+	}
+
+	block := c.cCfg.GetFetchMaxWaitMs()
+	if block <= 0 {
+		block = time.Second
+	}
+
+	streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.group,
+		Consumer: c.name,
+		Streams:  []string{c.stream, ">"},
+		Count:    int64(count),
+		Block:    block,
+	}).Result()
+
+	ctx1.MessageCount = 0
+	if err != nil {
+		if err == redis.Nil {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+
+	byteSize := 0
+	ctx1.InitOffset = c.offset
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			offset := streamIDToOffset(msg.ID)
+			m := queue.Message{Offset: c.offset, NextOffset: offset}
+			for k, v := range msg.Values {
+				str := util.ToString(v)
+				switch k {
+				case fieldData:
+					m.Data = []byte(str)
+				case fieldKey:
+					m.Key = []byte(str)
+				case fieldTimestamp:
+					m.Timestamp, _ = strconv.ParseInt(str, 10, 64)
+				default:
+					if strings.HasPrefix(k, fieldHeader) {
+						if m.Headers == nil {
+							m.Headers = map[string]string{}
+						}
+						m.Headers[strings.TrimPrefix(k, fieldHeader)] = str
+					}
+				}
+			}
+			m.Size = len(m.Data)
+			messages = append(messages, m)
+			c.pending = append(c.pending, msg.ID)
+			c.offset = offset
+			byteSize += m.Size
+			ctx1.MessageCount++
+		}
+	}
+
+	if ctx1.MessageCount == 0 {
+		return nil, true, nil
+	}
+
+	ctx1.NextOffset = c.offset
+
+	if global.Env().IsDebug {
+		log.Debug(c.qCfg.Name, "[", c.qCfg.ID, "],", c.cCfg.ID, ",msg:", len(messages), ",bytes:", byteSize, ",", ctx1.String())
+	}
+
+	return messages, false, nil
+}
@@ -0,0 +1,192 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/queue"
+)
+
+func TestStreamIDToOffset(t *testing.T) {
+	offset := streamIDToOffset("1700000000123-5")
+	assert.Equal(t, queue.NewOffset(1700000000123, 5), offset)
+	assert.Equal(t, "1700000000123-5", offsetToStreamID(offset))
+
+	assert.Equal(t, "0-0", offsetToStreamID(queue.NewOffset(0, 0)))
+
+	assert.Panics(t, func() {
+		streamIDToOffset("invalid")
+	})
+}
+
+func newTestStreamQueue(t *testing.T) (*miniredis.Miniredis, *RedisStreamQueue) {
+	global.RegisterEnv(env.EmptyEnv())
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return server, NewRedisStreamQueue(client, &RedisQueueConfig{Prefix: "queue:"})
+}
+
+func produceTestMessages(t *testing.T, q *RedisStreamQueue, qCfg *queue.QueueConfig, data ...string) []queue.ProduceResponse {
+	producer, err := q.AcquireProducer(qCfg)
+	assert.Nil(t, err)
+	reqs := []queue.ProduceRequest{}
+	for _, v := range data {
+		reqs = append(reqs, queue.ProduceRequest{Data: []byte(v), Key: []byte("key-" + v), Headers: map[string]string{"h": v}})
+	}
+	resps, err := producer.Produce(&reqs)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), len(*resps))
+	return *resps
+}
+
+func fetchTestMessages(t *testing.T, consumer queue.ConsumerAPI, count int) []queue.Message {
+	ctx1 := &queue.Context{}
+	messages, _, err := consumer.FetchMessages(ctx1, count)
+	assert.Nil(t, err)
+	return messages
+}
+
+func TestStreamProduceAndFetch(t *testing.T) {
+	_, q := newTestStreamQueue(t)
+	qCfg := &queue.QueueConfig{ID: "produce", Name: "produce"}
+	cCfg := &queue.ConsumerConfig{Group: "g", Name: "c", FetchMaxWaitMs: 10}
+
+	before := time.Now().UnixNano()
+	resps := produceTestMessages(t, q, qCfg, "a", "b", "c")
+	assert.Equal(t, "produce", resps[0].Topic)
+	//timestamp is unix nano, the same as the message
+	assert.True(t, resps[0].Timestamp >= before)
+	assert.Equal(t, int64(3), q.Depth("produce"))
+	assert.Equal(t, resps[2].Offset, q.LatestOffset(qCfg))
+
+	consumer, err := q.AcquireConsumer(qCfg, cCfg)
+	assert.Nil(t, err)
+	messages := fetchTestMessages(t, consumer, 2)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "a", string(messages[0].Data))
+	assert.Equal(t, "key-a", string(messages[0].Key))
+	assert.Equal(t, "a", messages[0].Headers["h"])
+	assert.Equal(t, resps[0].Timestamp, messages[0].Timestamp)
+	//the offset points to the last consumed message
+	assert.Equal(t, queue.NewOffset(0, 0), messages[0].Offset)
+	assert.Equal(t, resps[0].Offset, messages[0].NextOffset)
+	assert.Equal(t, resps[0].Offset, messages[1].Offset)
+	assert.Equal(t, resps[1].Offset, messages[1].NextOffset)
+
+	messages = fetchTestMessages(t, consumer, 10)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "c", string(messages[0].Data))
+
+	//nothing left
+	ctx1 := &queue.Context{}
+	messages, timeout, err := consumer.FetchMessages(ctx1, 10)
+	assert.Nil(t, err)
+	assert.True(t, timeout)
+	assert.Equal(t, 0, len(messages))
+}
+
+func TestStreamCommitOffset(t *testing.T) {
+	_, q := newTestStreamQueue(t)
+	qCfg := &queue.QueueConfig{ID: "commit", Name: "commit"}
+	cCfg := &queue.ConsumerConfig{Group: "g", Name: "c", FetchMaxWaitMs: 10}
+	resps := produceTestMessages(t, q, qCfg, "a", "b", "c")
+
+	consumer, err := q.AcquireConsumer(qCfg, cCfg)
+	assert.Nil(t, err)
+	messages := fetchTestMessages(t, consumer, 3)
+	assert.Equal(t, 3, len(messages))
+
+	assert.Nil(t, consumer.CommitOffset(messages[1].NextOffset))
+	offset, err := q.GetOffset(qCfg, cCfg)
+	assert.Nil(t, err)
+	assert.Equal(t, resps[1].Offset, offset)
+
+	//messages up to the offset are acked, the rest are still pending
+	pending, err := q.client.XPending(ctx, q.streamKey("commit"), getGroupForRedis(cCfg)).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), pending.Count)
+
+	//uncommitted messages are delivered again to the new consumer,
+	//drop the group as miniredis doesn't support `XGROUP SETID`, the group is created from the committed offset
+	assert.Nil(t, q.ReleaseConsumer(qCfg, cCfg, consumer))
+	assert.Nil(t, q.client.XGroupDestroy(ctx, q.streamKey("commit"), getGroupForRedis(cCfg)).Err())
+	consumer, err = q.AcquireConsumer(qCfg, cCfg)
+	assert.Nil(t, err)
+	messages = fetchTestMessages(t, consumer, 10)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "c", string(messages[0].Data))
+
+	assert.Nil(t, q.DeleteOffset(qCfg, cCfg))
+	offset, err = q.GetOffset(qCfg, cCfg)
+	assert.Nil(t, err)
+	assert.Equal(t, queue.NewOffset(0, 0), offset)
+}
+
+func TestStreamSeekByTime(t *testing.T) {
+	server, q := newTestStreamQueue(t)
+	qCfg := &queue.QueueConfig{ID: "seek", Name: "seek"}
+	cCfg := &queue.ConsumerConfig{Group: "g", Name: "c", FetchMaxWaitMs: 10}
+
+	//stream ids are generated from the server time
+	start := time.Now().Truncate(time.Second)
+	server.SetTime(start)
+	first := produceTestMessages(t, q, qCfg, "a")
+	server.SetTime(start.Add(time.Second))
+	second := produceTestMessages(t, q, qCfg, "b", "c")
+
+	offset, err := q.GetOffsetByTime(qCfg, start.Add(-time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, queue.NewOffset(0, 0), offset)
+
+	offset, err = q.GetOffsetByTime(qCfg, start.Add(500*time.Millisecond))
+	assert.Nil(t, err)
+	assert.Equal(t, first[0].Offset, offset)
+
+	offset, err = q.GetOffsetByTime(qCfg, start.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, second[1].Offset, offset)
+
+	//consume from the offset, messages after it are delivered
+	offset, err = q.GetOffsetByTime(qCfg, start.Add(500*time.Millisecond))
+	assert.Nil(t, err)
+	_, err = q.CommitOffset(qCfg, cCfg, offset)
+	assert.Nil(t, err)
+	consumer, err := q.AcquireConsumer(qCfg, cCfg)
+	assert.Nil(t, err)
+	messages := fetchTestMessages(t, consumer, 10)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "b", string(messages[0].Data))
+}
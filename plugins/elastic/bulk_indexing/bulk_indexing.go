@@ -75,6 +75,9 @@ type BulkIndexingProcessor struct {
 	bulkStats      *elastic.BulkResult
 	statsLock      sync.Mutex
 	bulkBufferPool *elastic.BulkBufferPool
+
+	coordinator     *queue.GroupCoordinator
+	coordinatorOnce sync.Once
 }
 
 type Config struct {
@@ -82,6 +85,9 @@ type Config struct {
 	Slices               []int `config:"slices"`
 	DocumentLevelSlicing bool  `config:"document_level_slicing"`
 
+	//split each bulk request by the primary shard of the documents, and send each part to the node holding the primary
+	ShardLevelRouting bool `config:"shard_level_routing"`
+
 	enabledSlice map[int]int
 
 	IdleTimeoutInSecond  int `config:"idle_timeout_in_seconds"`
@@ -113,10 +119,17 @@ type Config struct {
 
 	WaitingAfter           []string `config:"waiting_after"`
 	RetryDelayIntervalInMs int      `config:"retry_delay_interval"`
+
+	//reschedule the throttled requests back to the queue instead of blocking the worker
+	DelayedRetry bool `config:"delayed_retry"`
+
+	//share the slices with other instances of the same consumer group
+	Coordinator queue.CoordinatorConfig `config:"coordinator"`
 }
 
 func init() {
 	pipeline.RegisterProcessorPlugin("bulk_indexing", New)
+	pipeline.RegisterSideEffectProcessor("bulk_indexing")
 }
 
 func New(c *config.Config) (pipeline.Processor, error) {
@@ -176,6 +189,11 @@ func New(c *config.Config) (pipeline.Processor, error) {
 		}
 	}
 
+	//slices are assigned by the coordinator over time, they are only picked up by the detector
+	if cfg.Coordinator.Enabled && !cfg.DetectActiveQueue {
+		return nil, errors.New("coordinator requires detect_active_queue to be enabled")
+	}
+
 	runner := BulkIndexingProcessor{
 		id:                   util.GetUUID(),
 		config:               &cfg,
@@ -206,9 +224,25 @@ func (processor *BulkIndexingProcessor) Release() error {
 		processor.pool.Release()
 		processor.pool = nil
 	}
+	if processor.coordinator != nil {
+		processor.coordinator.Stop()
+	}
 	return nil
 }
 
+// getCoordinator returns the coordinator of the consumer group, scoped by the pipeline,
+// as pipelines consuming different queues may share the same group name
+func (processor *BulkIndexingProcessor) getCoordinator(ctx *pipeline.Context) *queue.GroupCoordinator {
+	if !processor.config.Coordinator.Enabled {
+		return nil
+	}
+	processor.coordinatorOnce.Do(func() {
+		processor.coordinator = queue.NewGroupCoordinator(processor.config.Consumer.Group+"/"+ctx.Config.Name, processor.config.Coordinator)
+		processor.coordinator.Start()
+	})
+	return processor.coordinator
+}
+
 func (processor *BulkIndexingProcessor) Name() string {
 	return "bulk_indexing"
 }
@@ -453,6 +487,16 @@ func (processor *BulkIndexingProcessor) NewBulkWorker(parentContext *pipeline.Co
 		//queue-slice
 		key := fmt.Sprintf("%v-%v", qConfig.ID, sliceID)
 
+		if coordinator := processor.getCoordinator(parentContext); coordinator != nil {
+			coordinator.AddResources(key)
+			if !coordinator.IsAssigned(key) {
+				if global.Env().IsDebug {
+					log.Tracef("slice [%v] is not assigned to this instance, skipping", key)
+				}
+				continue
+			}
+		}
+
 		if processor.config.MaxWorkers > 0 && util.MapLength(&processor.inFlightQueueConfigs) > processor.config.MaxWorkers {
 			log.Debugf("reached max num of workers, skip init [%v], slice_id:%v", qConfig.Name, sliceID)
 			return
@@ -467,6 +511,11 @@ func (processor *BulkIndexingProcessor) NewBulkWorker(parentContext *pipeline.Co
 			processor.Unlock()
 			continue
 		} else {
+			if !parentContext.AcquireWorker() {
+				log.Debugf("reached max num of workers of the resource group, skip init [%v], slice_id:%v", qConfig.Name, sliceID)
+				processor.Unlock()
+				return
+			}
 			var workerID = util.GetUUID()
 			log.Debugf("starting worker:[%v], queue:[%v], slice_id:%v, host:[%v]", workerID, qConfig.Name, sliceID, preferedHost)
 
@@ -490,6 +539,7 @@ func (processor *BulkIndexingProcessor) NewBulkWorker(parentContext *pipeline.Co
 					bulkSizeInByte := ctx.MustGetInt("bulkSizeInByte")
 					qConfig := ctx.MustGet("qConfig").(*queue.QueueConfig)
 					pCtx := v[0].(*pipeline.Context)
+					defer pCtx.ReleaseWorker()
 					processor.NewSlicedBulkWorker(pCtx, key, workerID, sliceID, numOfSlices, tag, bulkSizeInByte, qConfig, host)
 				},
 				Context: ctx1,
@@ -497,6 +547,7 @@ func (processor *BulkIndexingProcessor) NewBulkWorker(parentContext *pipeline.Co
 			})
 			processor.Unlock()
 			if err != nil {
+				parentContext.ReleaseWorker()
 				panic(err)
 			}
 			processor.wg.Add(1)
@@ -542,6 +593,12 @@ func (processor *BulkIndexingProcessor) getConsumerConfig(queueID, consumerName
 	if processor.config.Consumer.FetchMaxBytes > 0 {
 		consumerConfig.FetchMaxBytes = processor.config.Consumer.FetchMaxBytes
 	}
+	if processor.config.Consumer.MaxDeliveryCount > 0 {
+		consumerConfig.MaxDeliveryCount = processor.config.Consumer.MaxDeliveryCount
+	}
+	if processor.config.Consumer.DeadLetterQueue != "" {
+		consumerConfig.DeadLetterQueue = processor.config.Consumer.DeadLetterQueue
+	}
 
 	return consumerConfig
 }
@@ -575,6 +632,15 @@ func (processor *BulkIndexingProcessor) NewSlicedBulkWorker(ctx *pipeline.Contex
 		}
 	}()
 
+	//hold the slice till the worker exits, the lease of a revoked slice is kept till then
+	if processor.coordinator != nil {
+		if !processor.coordinator.AcquireResource(key) {
+			log.Debugf("slice worker, worker:[%v], slice [%v] is not assigned to this instance, return on queue:[%v]", workerID, key, qConfig.Name)
+			return
+		}
+		defer processor.coordinator.ReleaseResource(key)
+	}
+
 	if global.Env().IsDebug {
 		log.Debugf("new slice worker, worker:[%v], %v, %v, %v, %v", workerID, key, sliceID, tag, qConfig.ID)
 	}
@@ -643,6 +709,8 @@ func (processor *BulkIndexingProcessor) NewSlicedBulkWorker(ctx *pipeline.Contex
 				log.Debugf("slice worker, worker:[%v], [%v][%v][%v][%v] submit request:%v,continue:%v,err:%v", workerID, qConfig.Name, consumerConfig.Group, consumerConfig.Name, sliceID, mainBuf.GetMessageCount(), continueNext, err)
 			}
 
+			continueNext = processor.handleBulkFailure(qConfig, consumerConfig, committedOffset, mainBuf, continueNext, err)
+
 			mainBuf.ResetData()
 			if continueNext {
 				if !offset.Equals(*committedOffset) {
@@ -835,8 +903,7 @@ READ_DOCS:
 						hashValue := int(pop.Offset.Position)
 						partitionID := hashValue % maxSlices
 						if partitionID == sliceID {
-							mainBuf.WriteMessageID(pop.Offset.String())
-							mainBuf.WriteByteBuffer(pop.Data)
+							mainBuf.Add(pop.Offset.String(), pop.Data)
 						} else {
 							//skip non-target slices
 						}
@@ -896,8 +963,7 @@ READ_DOCS:
 					}
 				} else {
 					//all messages go to the same slice
-					mainBuf.WriteMessageID(pop.Offset.String())
-					mainBuf.WriteByteBuffer(pop.Data)
+					mainBuf.Add(pop.Offset.String(), pop.Data)
 				}
 
 				if global.Env().IsDebug {
@@ -907,7 +973,12 @@ READ_DOCS:
 				msgSize := mainBuf.GetMessageSize()
 				msgCount := mainBuf.GetMessageCount()
 
-				if (bulkSizeInByte > 0 && msgSize > (bulkSizeInByte)) || (processor.config.BulkConfig.BulkMaxDocsCount > 0 && msgCount > processor.config.BulkConfig.BulkMaxDocsCount) {
+				maxDocsCount := processor.config.BulkConfig.BulkMaxDocsCount
+				if processor.config.BulkConfig.Adaptive.Enabled {
+					bulkSizeInByte, maxDocsCount = bulkProcessor.GetBatchLimits(meta, host)
+				}
+
+				if (bulkSizeInByte > 0 && msgSize > (bulkSizeInByte)) || (maxDocsCount > 0 && msgCount > maxDocsCount) {
 					if global.Env().IsDebug {
 						log.Debugf("slice worker, worker:[%v], consuming [%v], slice_id:%v, hit buffer limit, size:%v, count:%v, submit now", workerID, qConfig.Name, sliceID, msgSize, msgCount)
 					}
@@ -917,9 +988,10 @@ READ_DOCS:
 					if global.Env().IsDebug {
 						log.Tracef("slice worker, worker:[%v], [%v][%v][%v][%v] submit request:%v,continue:%v,err:%v", workerID, qConfig.Name, consumerConfig.Group, consumerConfig.Name, sliceID, mainBuf.GetMessageCount(), continueNext, err)
 					}
+					continueNext = processor.handleBulkFailure(qConfig, consumerConfig, committedOffset, mainBuf, continueNext, err)
 					if !continueNext {
 						//TODO handle 429 gracefully
-						if !util.ContainStr(err.Error(), "code 429") {
+						if !retryInPlace(err) {
 							panic(errors.Errorf("queue:[%v], slice_id:%v, offset [%v]-[%v], bulk failed (host:%v, err: %v)", qConfig.ID, sliceID, committedOffset, offset, host, err))
 						}
 						log.Errorf("error on submit bulk_requests, queue:[%v], slice_id:%v, offset [%v]-[%v], bulk failed (host: %v, err: %v)", qConfig.ID, sliceID, committedOffset, offset, host, err)
@@ -979,6 +1051,8 @@ CLEAN_BUFFER:
 			log.Tracef("slice worker, worker:[%v], [%v][%v][%v][%v] submit request:%v,continue:%v,err:%v", workerID, qConfig.Name, consumerConfig.Group, consumerConfig.Name, sliceID, mainBuf.GetMessageCount(), continueNext, err)
 		}
 
+		continueNext = processor.handleBulkFailure(qConfig, consumerConfig, committedOffset, mainBuf, continueNext, err)
+
 		if !continueNext {
 			log.Errorf("queue:[%v], slice_id:%v, offset [%v]-[%v], bulk failed (host: %v, err: %v)", qConfig.ID, sliceID, committedOffset, offset, host, err)
 		}
@@ -1005,7 +1079,7 @@ CLEAN_BUFFER:
 		} else {
 			//logging failure offset boundry
 			//TODO handle 429 gracefully
-			if !util.ContainStr(err.Error(), "429") {
+			if !retryInPlace(err) {
 				panic(errors.Errorf("queue:[%v], slice_id:%v, offset [%v]-[%v], bulk failed (host: %v, err: %v)", qConfig.ID, sliceID, committedOffset, offset, host, err))
 			}
 
@@ -1030,6 +1104,11 @@ CLEAN_BUFFER:
 		return
 	}
 
+	if processor.coordinator != nil && !processor.coordinator.IsAssigned(key) {
+		log.Debugf("slice worker, worker:[%v], slice [%v] was revoked, return on queue:[%v]", workerID, key, qConfig.Name)
+		return
+	}
+
 	if !ctx.IsCanceled() {
 		goto READ_DOCS
 	}
@@ -1051,45 +1130,158 @@ func (processor *BulkIndexingProcessor) submitBulkRequest(ctx *pipeline.Context,
 	}
 
 	if count > 0 && size > 0 {
-
-		if global.Env().IsDebug {
-			log.Infof("submit bulk request, count: %v, size:%v", count, util.ByteSize(uint64(size)))
+		if processor.config.ShardLevelRouting {
+			return processor.submitShardRoutedBulkRequests(ctx, qConfig, tag, esClusterID, meta, host, bulkProcessor, mainBuf)
 		}
+		return processor.doBulk(ctx, qConfig, tag, esClusterID, meta, host, bulkProcessor, mainBuf)
+	}
 
-		start := time.Now()
-		continueRequest, statsMap, bulkResult, err := bulkProcessor.Bulk(ctx.Context, tag, meta, host, mainBuf)
-		if global.Env().IsDebug {
-			stats.Timing("elasticsearch."+esClusterID+".bulk", "elapsed_ms", time.Since(start).Milliseconds())
-		}
+	return true, nil
+}
 
-		total := 0
-		for k, v := range statsMap {
-			stats.IncrementBy("queue", qConfig.ID+".docs_status_code."+util.ToString(k), int64(v))
-			total += v
+// submitShardRoutedBulkRequests splits the buffer by primary shard and sends each part to its node in parallel,
+// documents without routing info are sent to the worker's host, only the failed parts are left in the buffer
+func (processor *BulkIndexingProcessor) submitShardRoutedBulkRequests(ctx *pipeline.Context, qConfig *queue.QueueConfig, tag, esClusterID string, meta *elastic.ElasticsearchMetadata, host string, bulkProcessor elastic.BulkProcessor, mainBuf *elastic.BulkBuffer) (bool, error) {
+	mainBuf.SafetyEndWithNewline()
+	buffers := elastic.GetBulkShardRouter(meta).Split(mainBuf, processor.bulkBufferPool)
+	defer func() {
+		for _, buf := range buffers {
+			processor.bulkBufferPool.ReturnBulkBuffer(buf)
 		}
+	}()
 
-		stats.IncrementBy("queue", qConfig.ID+".docs_fetched_from_queue", int64(total))
+	if global.Env().IsDebug {
+		log.Debugf("split bulk request of queue [%v] to %v nodes", qConfig.Name, len(buffers))
+	}
 
-		if err != nil && processor.config.LogBulkError {
-			var msg elastic.BulkDetail
-			if bulkResult != nil {
-				msg = bulkResult.Detail
+	type partResult struct {
+		buf *elastic.BulkBuffer
+		ok  bool
+		err error
+	}
+	var wg sync.WaitGroup
+	results := make([]partResult, 0, len(buffers))
+	var lock sync.Mutex
+	for nodeHost, buf := range buffers {
+		buf.Queue = mainBuf.Queue
+		if nodeHost == "" {
+			nodeHost = host
+		}
+		wg.Add(1)
+		go func(nodeHost string, buf *elastic.BulkBuffer) {
+			defer wg.Done()
+			ok, err := processor.doBulk(ctx, qConfig, tag, esClusterID, meta, nodeHost, bulkProcessor, buf)
+			lock.Lock()
+			defer lock.Unlock()
+			results = append(results, partResult{buf: buf, ok: ok, err: err})
+		}(nodeHost, buf)
+	}
+	wg.Wait()
+
+	continueNext := true
+	var lastErr, failedErr error
+	var rejectedErr *elastic.BulkRejectedError
+	var docsErr *elastic.BulkDocumentsError
+	failed := []partResult{}
+	for _, r := range results {
+		rejected, isRejected := elastic.GetBulkRejectedError(r.err)
+		docs, isDocs := elastic.GetBulkDocumentsError(r.err)
+		if r.ok && !isRejected && !isDocs {
+			if r.err != nil {
+				lastErr = r.err
 			}
-			log.Warnf("elasticsearch [%v], stats:%v, detail: %v, err:%v", meta.Config.Name, statsMap, msg, err)
+			continue
 		}
 
-		if global.Env().IsDebug {
-			log.Debug(tag, ", ", meta.Config.Name, ", ", host, ", stats: ", statsMap, ", count: ", count, ", size: ", util.ByteSize(uint64(size)), ", elapsed: ", time.Since(start), ", continue: ", continueRequest, ", bulkResult: ", bulkResult)
-		} else {
-			if processor.config.VerboseBulkResult {
-				log.Info("queue:", qConfig.Name, ", ", meta.Config.Name, ", ", host, ", stats: ", statsMap, ", count: ", count, ", size: ", util.ByteSize(uint64(size)), ", elapsed: ", time.Since(start), ", continue: ", continueRequest)
-			}
+		failed = append(failed, r)
+		continueNext = continueNext && r.ok
+		switch {
+		case isRejected && rejectedErr == nil:
+			rejectedErr = rejected
+		case isRejected:
+			rejectedErr.Merge(rejected)
+		case isDocs && docsErr == nil:
+			docsErr = docs
+		case isDocs:
+			docsErr.Merge(docs)
+		case r.err != nil:
+			failedErr = r.err
+		default:
+			failedErr = errors.Errorf("bulk request to [%v] failed", meta.Config.Name)
 		}
-		processor.updateContext(ctx, bulkResult)
-		return continueRequest, err
+	}
+	if len(failed) == 0 {
+		return continueNext, lastErr
 	}
 
-	return true, nil
+	//parts accepted by other nodes are not sent again
+	mainBuf.ResetData()
+	for _, r := range failed {
+		mainBuf.Append(r.buf)
+	}
+
+	switch {
+	case failedErr != nil:
+		return false, failedErr
+	case rejectedErr != nil && docsErr != nil:
+		//only the rejected documents are retried, the invalid ones are handled on their own
+		return false, &shardBulkError{rejected: rejectedErr, docs: docsErr}
+	case rejectedErr != nil:
+		return false, rejectedErr
+	}
+	return continueNext, docsErr
+}
+
+// shardBulkError is returned when some parts of a shard routed bulk request were rejected,
+// while documents of the other parts failed with non-retryable errors
+type shardBulkError struct {
+	rejected *elastic.BulkRejectedError
+	docs     *elastic.BulkDocumentsError
+}
+
+func (e *shardBulkError) Error() string {
+	return fmt.Sprintf("%v, %v", e.rejected, e.docs)
+}
+
+func (processor *BulkIndexingProcessor) doBulk(ctx *pipeline.Context, qConfig *queue.QueueConfig, tag, esClusterID string, meta *elastic.ElasticsearchMetadata, host string, bulkProcessor elastic.BulkProcessor, mainBuf *elastic.BulkBuffer) (bool, error) {
+	count := mainBuf.GetMessageCount()
+	size := mainBuf.GetMessageSize()
+
+	if global.Env().IsDebug {
+		log.Infof("submit bulk request, count: %v, size:%v", count, util.ByteSize(uint64(size)))
+	}
+
+	start := time.Now()
+	continueRequest, statsMap, bulkResult, err := bulkProcessor.Bulk(ctx.Context, tag, meta, host, mainBuf)
+	if global.Env().IsDebug {
+		stats.Timing("elasticsearch."+esClusterID+".bulk", "elapsed_ms", time.Since(start).Milliseconds())
+	}
+
+	total := 0
+	for k, v := range statsMap {
+		stats.IncrementBy("queue", qConfig.ID+".docs_status_code."+util.ToString(k), int64(v))
+		total += v
+	}
+
+	stats.IncrementBy("queue", qConfig.ID+".docs_fetched_from_queue", int64(total))
+
+	if err != nil && processor.config.LogBulkError {
+		var msg elastic.BulkDetail
+		if bulkResult != nil {
+			msg = bulkResult.Detail
+		}
+		log.Warnf("elasticsearch [%v], stats:%v, detail: %v, err:%v", meta.Config.Name, statsMap, msg, err)
+	}
+
+	if global.Env().IsDebug {
+		log.Debug(tag, ", ", meta.Config.Name, ", ", host, ", stats: ", statsMap, ", count: ", count, ", size: ", util.ByteSize(uint64(size)), ", elapsed: ", time.Since(start), ", continue: ", continueRequest, ", bulkResult: ", bulkResult)
+	} else {
+		if processor.config.VerboseBulkResult {
+			log.Info("queue:", qConfig.Name, ", ", meta.Config.Name, ", ", host, ", stats: ", statsMap, ", count: ", count, ", size: ", util.ByteSize(uint64(size)), ", elapsed: ", time.Since(start), ", continue: ", continueRequest)
+		}
+	}
+	processor.updateContext(ctx, bulkResult)
+	return continueRequest, err
 }
 
 func (processor *BulkIndexingProcessor) updateContext(ctx *pipeline.Context, bulkResult *elastic.BulkResult) {
@@ -1119,6 +1311,98 @@ func (processor *BulkIndexingProcessor) updateContext(ctx *pipeline.Context, bul
 	ctx.PutValue("bulk_indexing.detail.invalid", processor.bulkStats.Detail.Invalid)
 }
 
+// handleBulkFailure return true if the worker can move on after the bulk request,
+// documents failed with non-retryable errors are moved to the dead letter queue after max delivery count,
+// before that only they are kept in the buffer to be submitted again, documents rejected with 429 are rescheduled
+// if delayed retry is enabled, connection failures and 5xx are never counted as delivery failures
+func (processor *BulkIndexingProcessor) handleBulkFailure(qConfig *queue.QueueConfig, consumerConfig *queue.ConsumerConfig, offset *queue.Offset, mainBuf *elastic.BulkBuffer, continueNext bool, err error) bool {
+	if err == nil {
+		return continueNext
+	}
+
+	if shardErr, ok := err.(*shardBulkError); ok {
+		docsDone := processor.handleBulkFailure(qConfig, consumerConfig, offset, mainBuf, true, shardErr.docs)
+		var docs []byte
+		var ids []string
+		if !docsDone {
+			docs = append(docs, mainBuf.GetMessageBytes()...)
+			ids = append(ids, mainBuf.MessageIDs...)
+		}
+		rejectedDone := processor.handleBulkFailure(qConfig, consumerConfig, offset, mainBuf, false, shardErr.rejected)
+		if rejectedDone {
+			mainBuf.ResetData()
+		}
+		if !docsDone {
+			mainBuf.WriteByteBuffer(docs)
+			mainBuf.MessageIDs = append(mainBuf.MessageIDs, ids...)
+		}
+		return docsDone && rejectedDone
+	}
+
+	if docErr, ok := elastic.GetBulkDocumentsError(err); ok && consumerConfig.DeadLetterEnabled() {
+		msg := queue.Message{Data: docErr.Data}
+		if offset != nil {
+			msg.Offset = *offset
+		}
+		if processor.onDeliveryFailure(qConfig, consumerConfig, []queue.Message{msg}, err) {
+			return true
+		}
+		mainBuf.ResetData()
+		mainBuf.WriteByteBuffer(docErr.Data)
+		mainBuf.MessageIDs = append(mainBuf.MessageIDs, docErr.IDs...)
+		return false
+	}
+
+	if rejectedErr, ok := elastic.GetBulkRejectedError(err); ok && !continueNext {
+		if processor.config.DelayedRetry && processor.retryLater(qConfig, rejectedErr.Data, err) {
+			return true
+		}
+		//documents accepted before the rejection are not sent again
+		mainBuf.ResetData()
+		mainBuf.WriteByteBuffer(rejectedErr.Data)
+		mainBuf.MessageIDs = append(mainBuf.MessageIDs, rejectedErr.IDs...)
+		return false
+	}
+	return continueNext
+}
+
+// retryInPlace return true if the documents left in the buffer can be submitted again by the same worker
+func retryInPlace(err error) bool {
+	if elastic.IsBulkRejectedError(err) {
+		return true
+	}
+	if _, ok := err.(*shardBulkError); ok {
+		return true
+	}
+	_, ok := elastic.GetBulkDocumentsError(err)
+	return ok
+}
+
+// onDeliveryFailure return true if the failed messages were moved to the dead letter queue
+func (processor *BulkIndexingProcessor) onDeliveryFailure(qConfig *queue.QueueConfig, consumerConfig *queue.ConsumerConfig, messages []queue.Message, reason error) bool {
+	moved, err := queue.OnDeliveryFailure(qConfig, consumerConfig, messages, reason)
+	if err != nil {
+		log.Errorf("queue:[%v], consumer:[%v], failed to move messages to dead letter queue, %v", qConfig.Name, consumerConfig.Key(), err)
+		return false
+	}
+	return moved
+}
+
+// retryLater return true if the rejected documents were rescheduled to the queue after the retry delay,
+// they only belong to the worker's own slice, and documents already accepted are not included
+func (processor *BulkIndexingProcessor) retryLater(qConfig *queue.QueueConfig, docs []byte, reason error) bool {
+	if len(docs) == 0 {
+		return false
+	}
+	err := queue.RetryLater(qConfig, []queue.Message{{Data: docs}}, time.Duration(processor.config.RetryDelayIntervalInMs)*time.Millisecond)
+	if err != nil {
+		log.Errorf("queue:[%v], failed to reschedule rejected documents, %v", qConfig.Name, err)
+		return false
+	}
+	log.Debugf("queue:[%v], rejected documents rescheduled after %vms, reason: %v", qConfig.Name, processor.config.RetryDelayIntervalInMs, reason)
+	return true
+}
+
 func appendStrArr(arr []string, size int, elems []string) []string {
 	if len(arr) >= size {
 		return arr
@@ -30,6 +30,14 @@ package bulk_indexing
 import (
 	"github.com/OneOfOne/xxhash"
 	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/elastic/elastictest"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/queue/queuetest"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"strings"
 	"testing"
 )
 
@@ -84,3 +92,165 @@ func TestXXHash(t *testing.T) {
 	}
 
 }
+
+func TestHandleBulkRejected(t *testing.T) {
+	q := queuetest.Setup()
+	qConfig := &queue.QueueConfig{ID: "bulk_rejected", Name: "bulk_rejected"}
+	consumerConfig := &queue.ConsumerConfig{Group: "group", Name: "consumer"}
+	processor := &BulkIndexingProcessor{config: &Config{}}
+
+	pool := elastic.NewBulkBufferPool("test", 1024*1024, 1000)
+	mainBuf := pool.AcquireBulkBuffer()
+	defer pool.ReturnBulkBuffer(mainBuf)
+	mainBuf.Add("1", []byte("{\"index\":{\"_index\":\"test\",\"_id\":\"1\"}}\n{\"name\":\"a\"}\n"))
+	mainBuf.Add("2", []byte("{\"index\":{\"_index\":\"test\",\"_id\":\"2\"}}\n{\"name\":\"b\"}\n"))
+
+	rejected := []byte("{\"index\":{\"_index\":\"test\",\"_id\":\"2\"}}\n{\"name\":\"b\"}\n")
+	err := &elastic.BulkRejectedError{IDs: []string{"2"}, Data: rejected}
+
+	//only the rejected documents are kept to be submitted again
+	continueNext := processor.handleBulkFailure(qConfig, consumerConfig, nil, mainBuf, false, err)
+	assert.False(t, continueNext)
+	assert.Equal(t, string(rejected), string(mainBuf.GetMessageBytes()))
+	assert.Equal(t, []string{"2"}, mainBuf.MessageIDs)
+	assert.Equal(t, 0, len(q.Messages(qConfig.ID)))
+
+	//only the rejected documents are rescheduled
+	processor.config.DelayedRetry = true
+	continueNext = processor.handleBulkFailure(qConfig, consumerConfig, nil, mainBuf, false, err)
+	assert.True(t, continueNext)
+	messages := q.Messages(qConfig.ID)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, string(rejected), string(messages[0].Data))
+	assert.Equal(t, "1", messages[0].Headers[queue.RetryCountHeader])
+}
+
+func TestHandleShardBulkFailure(t *testing.T) {
+	q := queuetest.Setup()
+	qConfig := queue.GetOrInitConfig(t.Name())
+	consumerConfig := &queue.ConsumerConfig{Group: "group", Name: "consumer", MaxDeliveryCount: 2, DeadLetterQueue: t.Name() + "-dlq"}
+	processor := &BulkIndexingProcessor{config: &Config{}}
+
+	pool := elastic.NewBulkBufferPool("test", 1024*1024, 1000)
+	mainBuf := pool.AcquireBulkBuffer()
+	defer pool.ReturnBulkBuffer(mainBuf)
+	accepted := "{\"index\":{\"_index\":\"test\"}}\n{\"name\":\"accepted\"}\n"
+	rejected := "{\"index\":{\"_index\":\"test\",\"_id\":\"2\"}}\n{\"name\":\"b\"}\n"
+	invalid := "{\"create\":{\"_index\":\"test\",\"_id\":\"3\"}}\n{\"name\":1}\n"
+	mainBuf.Add("1", []byte(accepted+rejected))
+	mainBuf.Add("2", []byte(invalid))
+
+	offset := queue.NewOffset(0, 10)
+	err := &shardBulkError{
+		rejected: &elastic.BulkRejectedError{IDs: []string{"1"}, Data: []byte(rejected)},
+		docs:     &elastic.BulkDocumentsError{IDs: []string{"2"}, Data: []byte(invalid)},
+	}
+	assert.True(t, retryInPlace(err))
+
+	//the accepted documents are never sent again
+	continueNext := processor.handleBulkFailure(qConfig, consumerConfig, &offset, mainBuf, false, err)
+	assert.False(t, continueNext)
+	assert.Equal(t, rejected+invalid, string(mainBuf.GetMessageBytes()))
+	assert.Equal(t, []string{"1", "2"}, mainBuf.MessageIDs)
+
+	//the invalid documents are moved to the dead letter queue, the rejected ones are kept
+	continueNext = processor.handleBulkFailure(qConfig, consumerConfig, &offset, mainBuf, false, err)
+	assert.False(t, continueNext)
+	assert.Equal(t, rejected, string(mainBuf.GetMessageBytes()))
+	assert.Equal(t, []string{"1"}, mainBuf.MessageIDs)
+	assert.Equal(t, 1, len(q.Messages(queue.GetDeadLetterQueueConfig(consumerConfig).ID)))
+
+	//the rejected documents are rescheduled, nothing is left
+	processor.config.DelayedRetry = true
+	consumerConfig.DeadLetterQueue = ""
+	continueNext = processor.handleBulkFailure(qConfig, consumerConfig, &offset, mainBuf, false, err)
+	assert.True(t, continueNext)
+	messages := q.Messages(qConfig.ID)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, rejected, string(messages[0].Data))
+}
+
+func TestSubmitShardRoutedBulkRequests(t *testing.T) {
+	node1 := elastictest.NewServer("7.10.2")
+	defer node1.Close()
+	node2 := elastictest.NewServer("7.10.2")
+	defer node2.Close()
+	node2.Intercept(func(ctx *fasthttp.RequestCtx) bool {
+		if !strings.Contains(string(ctx.Path()), "_bulk") {
+			return false
+		}
+		ctx.SetStatusCode(429)
+		ctx.SetBody([]byte(`{"error":{"type":"es_rejected_execution_exception","reason":"rejected execution"},"status":429}`))
+		return true
+	})
+
+	cfg := elastic.ElasticsearchConfig{Name: t.Name(), Enabled: true, Version: "7.10.2", Endpoint: node1.URL()}
+	cfg.ID = t.Name()
+	meta := elastic.InitMetadata(&cfg, true)
+	nodes := map[string]elastic.NodesInfo{}
+	for id, server := range map[string]*elastictest.Server{"node1": node1, "node2": node2} {
+		info := elastic.NodesInfo{}
+		info.Http.PublishAddress = server.Host()
+		nodes[id] = info
+	}
+	meta.Nodes = &nodes
+	meta.IndexSettings = map[string]*util.MapStr{"test": {"index.number_of_shards": "2"}}
+	shards := map[string][]elastic.IndexShardRouting{
+		"0": {{State: "STARTED", Primary: true, Node: "node1", Shard: 0, Index: "test"}},
+		"1": {{State: "STARTED", Primary: true, Node: "node2", Shard: 1, Index: "test"}},
+	}
+	meta.ClusterState = &elastic.ClusterState{Version: 1, RoutingTable: &elastic.ClusterRoutingTable{Indices: map[string]struct {
+		Shards map[string][]elastic.IndexShardRouting `json:"shards"`
+	}{"test": {Shards: shards}}}}
+
+	bulkProcessor := elastic.NewBulkProcessor(t.Name(), cfg.ID, elastic.BulkProcessorConfig{
+		RequestTimeoutInSecond: 10,
+		RetryRules:             elastic.RetryRules{Retry429: true, Default: true},
+	})
+	processor := &BulkIndexingProcessor{
+		config:         &Config{ShardLevelRouting: true},
+		bulkStats:      &elastic.BulkResult{},
+		bulkBufferPool: elastic.NewBulkBufferPool(t.Name(), 1024*1024, 1000),
+	}
+	qConfig := &queue.QueueConfig{ID: t.Name(), Name: t.Name()}
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: t.Name()})
+
+	doc := func(id string) string {
+		return "{\"index\":{\"_index\":\"test\",\"_id\":\"" + id + "\"}}\n{\"id\":\"" + id + "\"}\n"
+	}
+	ids := []string{"1", "2", "3", "4", "5", "6"}
+	rejected := map[string]bool{}
+	mainBuf := processor.bulkBufferPool.AcquireBulkBuffer()
+	defer processor.bulkBufferPool.ReturnBulkBuffer(mainBuf)
+	for _, id := range ids {
+		mainBuf.Add("msg"+id, []byte(doc(id)))
+		rejected[id] = elastic.GetShardID(7, []byte(id), 2) == 1
+	}
+	assert.True(t, countRejected(rejected) > 0 && countRejected(rejected) < len(ids))
+	//documents without id are sent to the worker's host
+	mainBuf.Add("auto", []byte("{\"index\":{\"_index\":\"test\"}}\n{\"id\":\"auto\"}\n"))
+
+	for i := 0; i < 2; i++ {
+		continueNext, err := processor.submitBulkRequest(ctx, qConfig, t.Name(), cfg.ID, meta, node1.Host(), bulkProcessor, mainBuf)
+		assert.False(t, continueNext)
+		assert.True(t, elastic.IsBulkRejectedError(err))
+
+		//only the part rejected by node2 is left, documents accepted by node1 are not sent again
+		assert.Equal(t, len(ids)-countRejected(rejected)+1, node1.Count("test"))
+		for _, id := range ids {
+			assert.Equal(t, rejected[id], strings.Contains(string(mainBuf.GetMessageBytes()), doc(id)))
+			assert.Equal(t, rejected[id], util.ContainStr(strings.Join(mainBuf.MessageIDs, ","), "msg"+id))
+		}
+		assert.NotContains(t, string(mainBuf.GetMessageBytes()), "auto")
+	}
+}
+
+func countRejected(rejected map[string]bool) int {
+	count := 0
+	for _, v := range rejected {
+		if v {
+			count++
+		}
+	}
+	return count
+}
@@ -96,6 +96,7 @@ type Config struct {
 
 func init() {
 	pipeline.RegisterProcessorPlugin("indexing_merge", New)
+	pipeline.RegisterSideEffectProcessor("indexing_merge")
 }
 
 func New(c *config.Config) (pipeline.Processor, error) {
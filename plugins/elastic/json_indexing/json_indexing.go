@@ -86,6 +86,7 @@ type Config struct {
 
 func init() {
 	pipeline.RegisterProcessorPlugin("json_indexing", New)
+	pipeline.RegisterSideEffectProcessor("json_indexing")
 }
 
 func New(c *config.Config) (pipeline.Processor, error) {
@@ -29,6 +29,7 @@ package merge_to_bulk
 import (
 	"fmt"
 	log "github.com/cihub/seelog"
+	"github.com/savsgio/gotils/bytes"
 	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/elastic"
 	"infini.sh/framework/core/errors"
@@ -73,6 +74,7 @@ type Config struct {
 
 func init() {
 	pipeline.RegisterProcessorPlugin("merge_to_bulk", New)
+	pipeline.RegisterSideEffectProcessor("merge_to_bulk")
 }
 
 func New(c *config.Config) (pipeline.Processor, error) {
@@ -165,6 +167,12 @@ func (processor *IndexingMergeToBulkProcessor) Process(ctx *pipeline.Context) er
 		mainBuf := bytebufferpool.Get("index_merge_main")
 		defer bytebufferpool.Put("index_merge_main", mainBuf)
 
+		//produce through the transaction of the consumer if there is one
+		var txn *queue.Transaction
+		if v := ctx.Get(queue.TransactionKey); v != nil {
+			txn, _ = v.(*queue.Transaction)
+		}
+
 		lastOffset := len(messages) - 1
 		for i, message := range messages {
 			if processor.config.TypeName != "" {
@@ -185,8 +193,15 @@ func (processor *IndexingMergeToBulkProcessor) Process(ctx *pipeline.Context) er
 				data := mainBuf.Bytes()
 				//push to output queue
 				r := queue.ProduceRequest{Topic: processor.outputQueueConfig.ID, Data: data}
-				res := []queue.ProduceRequest{r}
-				_, err := processor.producer.Produce(&res)
+				var err error
+				if txn != nil {
+					//copy the data, the buffer will be reused
+					r.Data = bytes.Copy(data)
+					err = txn.Produce(processor.outputQueueConfig, r)
+				} else {
+					res := []queue.ProduceRequest{r}
+					_, err = processor.producer.Produce(&res)
+				}
 				if err != nil {
 					panic(errors.Errorf("failed to push message to output queue: %v, %s, offset:%v, size:%v, err:%v", processor.outputQueueConfig.Name, processor.outputQueueConfig.ID, message.Offset.String(), len(data), err))
 				}
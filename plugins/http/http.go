@@ -94,6 +94,7 @@ type Config struct {
 
 func init() {
 	pipeline.RegisterProcessorPlugin("http", New)
+	pipeline.RegisterSideEffectProcessor("http")
 }
 
 func New(c *config.Config) (pipeline.Processor, error) {
@@ -52,6 +52,9 @@ type QueueConsumerProcessor struct {
 
 	processors *pipeline.Processors
 	onCleanup  func() bool
+
+	coordinator     *queue.GroupCoordinator
+	coordinatorOnce sync.Once
 }
 
 type MessageHandlerAPI interface {
@@ -85,12 +88,19 @@ type Config struct {
 	WaitingAfter           []string `config:"waiting_after"`
 	RetryDelayIntervalInMs int      `config:"retry_delay_interval"`
 	AutoCommitOffset       bool     `config:"auto_commit_offset"`
+
+	//produce messages and commit offset in one transaction, processors need to produce through the transaction
+	Transactional bool `config:"transactional"`
+
+	//share the slices with other instances of the same consumer group
+	Coordinator queue.CoordinatorConfig `config:"coordinator"`
 }
 
 const name = "consumer"
 
 func init() {
 	pipeline.RegisterProcessorPlugin(name, New)
+	pipeline.RegisterSideEffectProcessor(name)
 }
 
 func New(c *config.Config) (pipeline.Processor, error) {
@@ -149,6 +159,11 @@ func New(c *config.Config) (pipeline.Processor, error) {
 		}
 	}
 
+	//slices are assigned by the coordinator over time, they are only picked up by the detector
+	if cfg.Coordinator.Enabled && !cfg.DetectActiveQueue {
+		return nil, errors.New("coordinator requires detect_active_queue to be enabled")
+	}
+
 	runner := QueueConsumerProcessor{
 		id:                   util.GetUUID(),
 		config:               &cfg,
@@ -184,9 +199,25 @@ func (processor *QueueConsumerProcessor) Release() error {
 		processor.pool.Release()
 		processor.pool = nil
 	}
+	if processor.coordinator != nil {
+		processor.coordinator.Stop()
+	}
 	return nil
 }
 
+// getCoordinator returns the coordinator of the consumer group, scoped by the pipeline,
+// as pipelines consuming different queues may share the same group name
+func (processor *QueueConsumerProcessor) getCoordinator(ctx *pipeline.Context) *queue.GroupCoordinator {
+	if !processor.config.Coordinator.Enabled {
+		return nil
+	}
+	processor.coordinatorOnce.Do(func() {
+		processor.coordinator = queue.NewGroupCoordinator(processor.config.Consumer.Group+"/"+ctx.Config.Name, processor.config.Coordinator)
+		processor.coordinator.Start()
+	})
+	return processor.coordinator
+}
+
 func (processor *QueueConsumerProcessor) Name() string {
 	return name
 }
@@ -345,6 +376,14 @@ func (processor *QueueConsumerProcessor) HandleQueueConfig(qConfig *queue.QueueC
 		//queue-slice
 		key := fmt.Sprintf("%v-%v", qConfig.ID, sliceID)
 
+		if coordinator := processor.getCoordinator(ctx); coordinator != nil {
+			coordinator.AddResources(key)
+			if !coordinator.IsAssigned(key) {
+				log.Tracef("slice [%v] is not assigned to this instance, skipping", key)
+				continue
+			}
+		}
+
 		if processor.config.MaxWorkers > 0 && util.MapLength(&processor.inFlightQueueConfigs) > processor.config.MaxWorkers {
 			log.Debugf("reached max num of workers, skip init [%v], slice_id:%v", qConfig.Name, sliceID)
 			return nil
@@ -357,14 +396,21 @@ func (processor *QueueConsumerProcessor) HandleQueueConfig(qConfig *queue.QueueC
 			processor.Unlock()
 			continue
 		} else {
+			if !ctx.AcquireWorker() {
+				log.Debugf("reached max num of workers of the resource group, skip init [%v], slice_id:%v", qConfig.Name, sliceID)
+				processor.Unlock()
+				return nil
+			}
 			var workerID = util.GetUUID()
 			log.Debugf("starting worker:[%v], queue:[%v], slice_id:%v", workerID, qConfig.Name, sliceID)
 
 			processor.wg.Add(1)
 			contextForWorker := pipeline.Context{}
 			contextForWorker.ResetContext()
+			pCtx := ctx
 			err := processor.pool.Submit(&pipeline.Task{
 				Handler: func(ctx *pipeline.Context, v ...interface{}) {
+					defer pCtx.ReleaseWorker()
 					processor.NewSlicedWorker(ctx, v...)
 					//if slice worker failed, add to failed queue
 					if ctx.IsFailed() || ctx.HasError() {
@@ -381,6 +427,7 @@ func (processor *QueueConsumerProcessor) HandleQueueConfig(qConfig *queue.QueueC
 			})
 			processor.Unlock()
 			if err != nil {
+				ctx.ReleaseWorker()
 				panic(err)
 			}
 		}
@@ -429,6 +476,15 @@ func (processor *QueueConsumerProcessor) NewSlicedWorker(ctx *pipeline.Context,
 		log.Tracef("exit slice_worker, queue:%v, slice_id:%v, key:%v", qConfig.ID, sliceID, key)
 	}()
 
+	//hold the slice till the worker exits, the lease of a revoked slice is kept till then
+	if processor.coordinator != nil {
+		if !processor.coordinator.AcquireResource(key) {
+			log.Debugf("slice [%v] is not assigned to this instance, return on queue:[%v], slice_id:%v", key, qConfig.Name, sliceID)
+			return
+		}
+		defer processor.coordinator.ReleaseResource(key)
+	}
+
 	var initOffset queue.Offset
 	var offset queue.Offset
 	var groupName = processor.config.Consumer.Group
@@ -460,6 +516,12 @@ func (processor *QueueConsumerProcessor) NewSlicedWorker(ctx *pipeline.Context,
 	if processor.config.Consumer.FetchMaxBytes > 0 {
 		consumerConfig.FetchMaxBytes = processor.config.Consumer.FetchMaxBytes
 	}
+	if processor.config.Consumer.MaxDeliveryCount > 0 {
+		consumerConfig.MaxDeliveryCount = processor.config.Consumer.MaxDeliveryCount
+	}
+	if processor.config.Consumer.DeadLetterQueue != "" {
+		consumerConfig.DeadLetterQueue = processor.config.Consumer.DeadLetterQueue
+	}
 
 	//skip empty queue
 	if processor.config.SkipEmptyQueue && !queue.ConsumerHasLag(qConfig, consumerConfig) {
@@ -627,36 +689,66 @@ READ_DOCS:
 
 		if len(messages) > 0 {
 
-			newCtx := pipeline.Context{}
-			newCtx.ParentContext = ctx
-			newCtx.Context = ctx.Context
-			newCtx.Data = ctx.CloneData()
-
-			_, err := newCtx.PutValue(processor.config.QueueField, qConfig.Name)
-			if err != nil {
-				panic(err)
-			}
-
-			_, err = newCtx.PutValue("QUEUE_CONFIG", qConfig)
-			if err != nil {
-				panic(err)
-			}
-
-			_, err = newCtx.PutValue("CONSUMER_CONFIG", consumerConfig)
-			if err != nil {
-				panic(err)
+			//the batch starting here failed before, handle messages one by one to find out the bad ones
+			var isolate bool
+			if consumerConfig.DeadLetterEnabled() {
+				count, err := queue.GetDeliveryCount(qConfig, consumerConfig, messages[0].Offset)
+				if err != nil {
+					panic(err)
+				}
+				isolate = count > 0
 			}
 
-			_, err = newCtx.PutValue(processor.config.MessageField, messages)
-			if err != nil {
-				panic(err)
-			}
+			if isolate {
+				for _, m := range messages {
+					err := processor.processMessages(ctx, qConfig, consumerConfig, []queue.Message{m})
+					if err == nil {
+						continue
+					}
+					moved, err1 := queue.OnDeliveryFailure(qConfig, consumerConfig, []queue.Message{m}, err)
+					if err1 != nil {
+						log.Errorf("failed to handle delivery failure, queue:%v, offset:%v, %v", qConfig.Name, m.Offset, err1)
+					}
+					if !moved {
+						//commit handled messages, the failed one will be delivered again
+						if processor.config.AutoCommitOffset && !m.Offset.Equals(initOffset) {
+							ok, err1 := queue.CommitOffset(qConfig, consumerConfig, m.Offset)
+							if !ok || err1 != nil {
+								panic(err1)
+							}
+							initOffset = m.Offset
+						}
+						panic(err)
+					}
+				}
+				err := queue.ResetDeliveryState(qConfig, consumerConfig)
+				if err != nil {
+					log.Error(err)
+				}
+			} else {
+				//log.Error("start processing message:",len(messages),",",qConfig.Name)
+				err := processor.processMessages(ctx, qConfig, consumerConfig, messages)
+				//log.Error("end processing message:",len(messages),",",qConfig.Name,",",err)
+				if err != nil {
+					if !consumerConfig.DeadLetterEnabled() {
+						panic(err)
+					}
 
-			//log.Error("start processing message:",len(messages),",",qConfig.Name)
-			err = processor.processors.Process(&newCtx)
-			//log.Error("end processing message:",len(messages),",",qConfig.Name,",",err)
-			if err != nil {
-				panic(err)
+					//some messages may be already handled, don't run them again here,
+					//record the failure and the batch will be delivered one by one next time
+					if processor.config.AutoCommitOffset && !messages[0].Offset.Equals(initOffset) {
+						ok, err1 := queue.CommitOffset(qConfig, consumerConfig, messages[0].Offset)
+						if !ok || err1 != nil {
+							panic(err1)
+						}
+						initOffset = messages[0].Offset
+					}
+					_, err1 := queue.IncreaseDeliveryCount(qConfig, consumerConfig, messages[0].Offset, err)
+					if err1 != nil {
+						log.Errorf("failed to handle delivery failure, queue:%v, offset:%v, %v", qConfig.Name, messages[0].Offset, err1)
+					}
+					panic(err)
+				}
 			}
 			offset = ctx1.NextOffset //TODO
 			messages = nil
@@ -716,8 +808,64 @@ CLEAN_BUFFER:
 		return
 	}
 
+	if processor.coordinator != nil && !processor.coordinator.IsAssigned(key) {
+		log.Debugf("slice [%v] was revoked, return on queue:[%v], slice_id:%v", key, qConfig.Name, sliceID)
+		return
+	}
+
 	log.Tracef("slice_worker, goto READ_DOCS, return on queue:[%v], slice_id:%v", qConfig.Name, sliceID)
 	if !ctx.IsCanceled() && !(parentContext != nil && (parentContext.IsFailed() || parentContext.IsCanceled())) {
 		goto READ_DOCS
 	}
 }
+
+func (processor *QueueConsumerProcessor) processMessages(ctx *pipeline.Context, qConfig *queue.QueueConfig, consumerConfig *queue.ConsumerConfig, messages []queue.Message) error {
+	newCtx := pipeline.Context{}
+	newCtx.ParentContext = ctx
+	newCtx.Context = ctx.Context
+	newCtx.Data = ctx.CloneData()
+
+	_, err := newCtx.PutValue(processor.config.QueueField, qConfig.Name)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = newCtx.PutValue("QUEUE_CONFIG", qConfig)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = newCtx.PutValue("CONSUMER_CONFIG", consumerConfig)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = newCtx.PutValue(processor.config.MessageField, messages)
+	if err != nil {
+		panic(err)
+	}
+
+	if !processor.config.Transactional {
+		return processor.processors.Process(&newCtx)
+	}
+
+	txn := queue.BeginTransaction()
+	_, err = newCtx.PutValue(queue.TransactionKey, txn)
+	if err != nil {
+		panic(err)
+	}
+
+	err = processor.processors.Process(&newCtx)
+	if err != nil {
+		txn.Abort()
+		return err
+	}
+
+	//commit the consumed offset together with the produced messages
+	err = txn.CommitOffset(qConfig, consumerConfig, messages[len(messages)-1].NextOffset)
+	if err != nil {
+		txn.Abort()
+		return err
+	}
+	return txn.End()
+}
@@ -141,7 +141,13 @@ func (this *Consumer) FetchMessages(ctx *queue.Context, numOfMessages int) (mess
 			}
 			nextOffset = nextOffsetStr
 			size := len(r.Value)
-			m := queue.Message{Offset: offsetStr, NextOffset: nextOffsetStr, Data: r.Value, Size: size, Timestamp: r.Timestamp.Unix()}
+			m := queue.Message{Offset: offsetStr, NextOffset: nextOffsetStr, Data: r.Value, Size: size, Timestamp: r.Timestamp.UnixNano(), Key: r.Key}
+			if len(r.Headers) > 0 {
+				m.Headers = make(map[string]string, len(r.Headers))
+				for _, h := range r.Headers {
+					m.Headers[h.Key] = string(h.Value)
+				}
+			}
 			msgs = append(msgs, m)
 			ctx.MessageCount++
 			byteSize += size
@@ -497,6 +497,25 @@ func (this *KafkaQueue) GetOffset(k *queue.QueueConfig, consumer *queue.Consumer
 	return str, nil
 }
 
+func (this *KafkaQueue) GetOffsetByTime(k *queue.QueueConfig, t time.Time) (queue.Offset, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*60))
+	defer cancel()
+
+	os, err := this.adminClient.ListOffsetsAfterMilli(ctx, t.UnixMilli(), k.ID)
+	if err != nil {
+		return queue.Offset{}, err
+	}
+
+	res, ok := os.Lookup(k.ID, 0)
+	if !ok {
+		return queue.Offset{}, errors.Errorf("no offset found for queue [%v]", k.ID)
+	}
+	if res.Err != nil {
+		return queue.Offset{}, res.Err
+	}
+	return queue.NewOffset(0, res.Offset), nil
+}
+
 func (this *KafkaQueue) GetStorageSize(k string) uint64 {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*60))
 	defer cancel()
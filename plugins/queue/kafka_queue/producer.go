@@ -50,14 +50,28 @@ func (p *Producer) Produce(reqs *[]queue.ProduceRequest) (*[]queue.ProduceRespon
 
 	messages := []*kgo.Record{}
 	for _, req := range *reqs {
+		if req.NotBefore > 0 {
+			return nil, queue.ErrDelayedDeliveryNotSupported
+		}
 		msg := &kgo.Record{}
 		if req.Topic != "" {
 			msg.Topic = req.Topic
 		} else {
 			msg.Topic = p.cfg.ID
 		}
-		msg.Timestamp = time.Now()
-		msg.Key = util.UnsafeStringToBytes(util.GetUUID())
+		if req.Timestamp > 0 {
+			msg.Timestamp = time.Unix(0, req.Timestamp)
+		} else {
+			msg.Timestamp = time.Now()
+		}
+		if len(req.Key) > 0 {
+			msg.Key = req.Key
+		} else {
+			msg.Key = util.UnsafeStringToBytes(util.GetUUID())
+		}
+		for k, v := range req.Headers {
+			msg.Headers = append(msg.Headers, kgo.RecordHeader{Key: k, Value: []byte(v)})
+		}
 		msg.Value = req.Data
 		messages = append(messages, msg)
 	}
@@ -74,7 +88,7 @@ func (p *Producer) Produce(reqs *[]queue.ProduceRequest) (*[]queue.ProduceRespon
 			if r.Record != nil {
 				result := queue.ProduceResponse{}
 				result.Offset = queue.Offset{Segment: int64(r.Record.Partition), Position: r.Record.Offset}
-				result.Timestamp = r.Record.Timestamp.Unix()
+				result.Timestamp = r.Record.Timestamp.UnixNano()
 				result.Topic = r.Record.Topic
 				result.Partition = int64(r.Record.Partition)
 				results = append(results, result)
@@ -0,0 +1,233 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package priority_queue
+
+import (
+	"time"
+
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/queue"
+)
+
+type laneInstance struct {
+	cfg      *queue.QueueConfig
+	cCfg     *queue.ConsumerConfig
+	handler  queue.AdvancedQueueAPI
+	consumer queue.ConsumerAPI
+}
+
+// fetchedOffset maps the offset handed out to the caller back to the real offset of the lane
+type fetchedOffset struct {
+	lane       int
+	offset     queue.Offset
+	laneOffset queue.Offset
+}
+
+// encodeOffset keep the lane in the segment of the offsets handed out by the priority consumer,
+// segment = lane segment * num of lanes + lane, so that the offsets of different lanes are never equal
+func encodeOffset(lane, numOfLanes int, o queue.Offset) queue.Offset {
+	return queue.NewOffsetWithVersion(o.Segment*int64(numOfLanes)+int64(lane), o.Position, o.Version)
+}
+
+func decodeOffset(numOfLanes int, o queue.Offset) (int, queue.Offset) {
+	lane := int(o.Segment % int64(numOfLanes))
+	return lane, queue.NewOffsetWithVersion(o.Segment/int64(numOfLanes), o.Position, o.Version)
+}
+
+// Consumer drains the lanes by weighted priority, the offsets of the returned messages
+// carry the lane in the segment, see encodeOffset,
+// committing one of them commits all the messages fetched before it, lane by lane
+//
+// NOTE: Consumer is not thread-safe
+type Consumer struct {
+	qCfg    *queue.QueueConfig
+	cCfg    *queue.ConsumerConfig
+	lanes   []*laneInstance
+	weights []int
+	pending []fetchedOffset
+}
+
+// allocateQuota split n messages across lanes by weight, every lane with weight gets at least one
+// while there is room, the remainder goes to the higher priority lanes first
+func allocateQuota(weights []int, n int) []int {
+	quotas := make([]int, len(weights))
+	var total int
+	for _, w := range weights {
+		if w > 0 {
+			total += w
+		}
+	}
+	if total == 0 || n <= 0 {
+		return quotas
+	}
+
+	assigned := 0
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		q := n * w / total
+		if q == 0 {
+			q = 1
+		}
+		if assigned+q > n {
+			q = n - assigned
+		}
+		quotas[i] = q
+		assigned += q
+	}
+
+	for i := 0; assigned < n && i < len(weights); i++ {
+		if weights[i] > 0 {
+			quotas[i]++
+			assigned++
+		}
+	}
+	return quotas
+}
+
+func (c *Consumer) fetchLane(i int, num int) ([]queue.Message, error) {
+	ctx := &queue.Context{}
+	messages, _, err := c.lanes[i].consumer.FetchMessages(ctx, num)
+	for j := range messages {
+		laneOffset := messages[j].NextOffset
+		messages[j].Offset = encodeOffset(i, len(c.lanes), messages[j].Offset)
+		messages[j].NextOffset = encodeOffset(i, len(c.lanes), laneOffset)
+		c.pending = append(c.pending, fetchedOffset{lane: i, offset: messages[j].NextOffset, laneOffset: laneOffset})
+	}
+	return messages, err
+}
+
+func (c *Consumer) FetchMessages(ctx *queue.Context, numOfMessages int) (messages []queue.Message, isTimeout bool, err error) {
+	messages = []queue.Message{}
+	quotas := allocateQuota(c.weights, numOfMessages)
+	start := time.Now()
+
+	for {
+		drained := make([]bool, len(c.lanes))
+
+		//take the share of each lane
+		for i := range c.lanes {
+			if quotas[i] <= 0 {
+				continue
+			}
+			msgs, laneErr := c.fetchLane(i, quotas[i])
+			if laneErr != nil {
+				err = laneErr
+			}
+			drained[i] = len(msgs) < quotas[i]
+			messages = append(messages, msgs...)
+		}
+
+		//fill the spare room, from the highest priority
+		for i := range c.lanes {
+			remaining := numOfMessages - len(messages)
+			if remaining <= 0 {
+				break
+			}
+			if drained[i] {
+				continue
+			}
+			msgs, _ := c.fetchLane(i, remaining)
+			messages = append(messages, msgs...)
+		}
+
+		if len(messages) > 0 || global.ShuttingDown() {
+			break
+		}
+
+		if err != nil {
+			return messages, false, err
+		}
+
+		if time.Since(start) >= c.cCfg.GetFetchMaxWaitMs() {
+			isTimeout = true
+			break
+		}
+
+		if c.cCfg.EOFRetryDelayInMs > 0 {
+			time.Sleep(time.Duration(c.cCfg.EOFRetryDelayInMs) * time.Millisecond)
+		} else {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	ctx.MessageCount = len(messages)
+	if len(messages) > 0 {
+		ctx.InitOffset = messages[0].Offset
+		ctx.NextOffset = messages[len(messages)-1].NextOffset
+	}
+	return messages, isTimeout, nil
+}
+
+// CommitOffset commit the lanes up to the message with this next offset
+func (c *Consumer) CommitOffset(offset queue.Offset) error {
+	idx := -1
+	for i := len(c.pending) - 1; i >= 0; i-- {
+		if c.pending[i].offset == offset {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil
+	}
+
+	latest := map[int]queue.Offset{}
+	for _, v := range c.pending[:idx+1] {
+		latest[v.lane] = v.laneOffset
+	}
+
+	for lane, v := range latest {
+		err := c.lanes[lane].consumer.CommitOffset(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.pending = c.pending[idx+1:]
+	return nil
+}
+
+func (c *Consumer) ResetOffset(segment, readPos int64) error {
+	return errors.Errorf("queue [%v] is a priority queue, reset the offset of each lane instead", c.qCfg.Name)
+}
+
+func (c *Consumer) Close() error {
+	var err error
+	for _, v := range c.lanes {
+		e := v.handler.ReleaseConsumer(v.cfg, v.cCfg, v.consumer)
+		if e != nil {
+			err = e
+		}
+	}
+	c.lanes = nil
+	c.pending = nil
+	return err
+}
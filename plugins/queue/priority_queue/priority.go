@@ -0,0 +1,364 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package priority_queue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/module"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+func init() {
+	module.RegisterSystemModule(&PriorityQueue{})
+}
+
+// PriorityHeader selects the lane of the produced message, 0 is the highest priority,
+// messages without it go to the lowest lane
+const PriorityHeader = "priority"
+
+type Config struct {
+	Enabled bool `config:"enabled"`
+	//queue type of the internal lanes
+	LaneType   string `config:"lane_type"`
+	NumOfLanes int    `config:"num_of_lanes"`
+	//share of each fetch for each lane, from the highest priority to the lowest
+	Weights []int `config:"weights"`
+}
+
+// PriorityQueue implements queue.AdvancedQueueAPI for queues of type `priority`,
+// each logical queue fans out to N internal lanes, and each lane keeps its own consumer offsets
+type PriorityQueue struct {
+	cfg       *Config
+	queues    sync.Map //queue id=lanes
+	consumers sync.Map //queue id+consumer key=active consumer
+}
+
+func (this *PriorityQueue) Name() string {
+	return "priority_queue"
+}
+
+func (this *PriorityQueue) Setup() {
+	this.queues = sync.Map{}
+	this.consumers = sync.Map{}
+	this.cfg = &Config{
+		Enabled:    false,
+		LaneType:   "disk",
+		NumOfLanes: 3,
+		Weights:    []int{6, 3, 1},
+	}
+
+	ok, err := env.ParseConfig("priority_queue", this.cfg)
+	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+		panic(err)
+	}
+
+	if !this.cfg.Enabled {
+		return
+	}
+
+	if this.cfg.NumOfLanes <= 0 {
+		panic(errors.Errorf("invalid num_of_lanes: %v", this.cfg.NumOfLanes))
+	}
+
+	//pad or trim weights to match the lanes
+	weights := make([]int, this.cfg.NumOfLanes)
+	for i := range weights {
+		if i < len(this.cfg.Weights) {
+			weights[i] = this.cfg.Weights[i]
+		} else {
+			weights[i] = 1
+		}
+	}
+	this.cfg.Weights = weights
+
+	queue.Register("priority", this)
+}
+
+func (this *PriorityQueue) Start() error {
+	return nil
+}
+
+func (this *PriorityQueue) Stop() error {
+	return nil
+}
+
+func getLaneID(queueID string, lane int) string {
+	return fmt.Sprintf("%v-lane-%d", queueID, lane)
+}
+
+// getLanes return the config of the internal lanes, lanes are registered on first use
+func (this *PriorityQueue) getLanes(queueID string) []*queue.QueueConfig {
+	if v, ok := this.queues.Load(queueID); ok {
+		return v.([]*queue.QueueConfig)
+	}
+
+	cfg, ok := queue.SmartGetConfig(queueID)
+	if !ok {
+		panic(errors.Errorf("queue [%v] was not found", queueID))
+	}
+
+	lanes := make([]*queue.QueueConfig, this.cfg.NumOfLanes)
+	for i := range lanes {
+		laneID := getLaneID(cfg.ID, i)
+		lane, ok := queue.GetConfigByUUID(laneID)
+		if !ok {
+			lane = &queue.QueueConfig{
+				ID:     laneID,
+				Name:   getLaneID(cfg.Name, i),
+				Type:   this.cfg.LaneType,
+				Source: "priority_queue",
+				Labels: util.MapStr{
+					"priority_queue": cfg.ID,
+					"lane":           i,
+				},
+			}
+			_, err := queue.RegisterConfig(lane)
+			if err != nil {
+				panic(err)
+			}
+		}
+		lanes[i] = lane
+	}
+
+	v, _ := this.queues.LoadOrStore(cfg.ID, lanes)
+	return v.([]*queue.QueueConfig)
+}
+
+func (this *PriorityQueue) getLaneHandler(lane *queue.QueueConfig) queue.AdvancedQueueAPI {
+	handler, ok := queue.GetHandlerByType(lane.Type).(queue.AdvancedQueueAPI)
+	if !ok {
+		panic(errors.Errorf("queue type [%v] of lane [%v] doesn't support consumers", lane.Type, lane.Name))
+	}
+	return handler
+}
+
+// getLaneByHeaders return the lane index for the message
+func (this *PriorityQueue) getLaneByHeaders(headers map[string]string) int {
+	lowest := this.cfg.NumOfLanes - 1
+	v, ok := headers[PriorityHeader]
+	if !ok {
+		return lowest
+	}
+	priority, err := util.ToInt(v)
+	if err != nil || priority < 0 {
+		return lowest
+	}
+	if priority > lowest {
+		return lowest
+	}
+	return priority
+}
+
+func (this *PriorityQueue) Init(q string) error {
+	for _, lane := range this.getLanes(q) {
+		queue.IniQueue(lane)
+	}
+	return nil
+}
+
+func (this *PriorityQueue) Close(q string) error {
+	v, ok := this.queues.Load(q)
+	if !ok {
+		return nil
+	}
+	for _, lane := range v.([]*queue.QueueConfig) {
+		err := queue.Close(lane)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *PriorityQueue) GetStorageSize(q string) uint64 {
+	var size uint64
+	for _, lane := range this.getLanes(q) {
+		size += queue.GetStorageSize(lane.ID)
+	}
+	return size
+}
+
+func (this *PriorityQueue) Destroy(q string) error {
+	for _, lane := range this.getLanes(q) {
+		err := queue.Destroy(lane)
+		if err != nil {
+			return err
+		}
+	}
+	this.queues.Delete(q)
+	return nil
+}
+
+func (this *PriorityQueue) GetQueues() []string {
+	result := []string{}
+	this.queues.Range(func(key, value any) bool {
+		result = append(result, key.(string))
+		return true
+	})
+	return result
+}
+
+func (this *PriorityQueue) Push(q string, data []byte) error {
+	lanes := this.getLanes(q)
+	return queue.Push(lanes[len(lanes)-1], data)
+}
+
+// LatestOffset return the latest offset of the lowest lane in the form of the priority offsets,
+// it is only meant to be compared with GetOffset to tell whether the consumer has lag
+func (this *PriorityQueue) LatestOffset(k *queue.QueueConfig) queue.Offset {
+	lanes := this.getLanes(k.ID)
+	lowest := len(lanes) - 1
+	return encodeOffset(lowest, len(lanes), queue.LatestOffset(lanes[lowest]))
+}
+
+// GetOffset return LatestOffset once the consumer caught up with every lane, the committed lane offsets
+// can't be told by a single offset, so the zero offset is returned otherwise, it never looks ahead of a pending message
+func (this *PriorityQueue) GetOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.Offset, error) {
+	for _, lane := range this.getLanes(k.ID) {
+		latest := queue.LatestOffset(lane)
+		laneConsumer, ok := queue.GetConsumerConfig(lane.ID, consumer.Group, consumer.Name)
+		if !ok {
+			if !latest.Equals(queue.Offset{}) {
+				return queue.Offset{}, nil
+			}
+			continue
+		}
+		o, err := queue.GetOffset(lane, laneConsumer)
+		if err != nil {
+			return queue.Offset{}, err
+		}
+		if !latest.Equals(o) {
+			return queue.Offset{}, nil
+		}
+	}
+	return this.LatestOffset(k), nil
+}
+
+func (this *PriorityQueue) DeleteOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig) error {
+	for _, lane := range this.getLanes(k.ID) {
+		laneConsumer, ok := queue.GetConsumerConfig(lane.ID, consumer.Group, consumer.Name)
+		if !ok {
+			continue
+		}
+		err := queue.DeleteOffset(lane, laneConsumer)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CommitOffset commit through the active consumer, which knows the lane offsets of the messages fetched before,
+// without it, only the lane of the offset is committed
+func (this *PriorityQueue) CommitOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig, offset queue.Offset) (bool, error) {
+	if v, ok := this.consumers.Load(k.ID + consumer.Key()); ok {
+		err := v.(*Consumer).CommitOffset(offset)
+		return err == nil, err
+	}
+
+	lanes := this.getLanes(k.ID)
+	lane, laneOffset := decodeOffset(len(lanes), offset)
+	laneConsumer := queue.GetOrInitConsumerConfig(lanes[lane].ID, consumer.Group, consumer.Name)
+	return queue.CommitOffset(lanes[lane], laneConsumer, laneOffset)
+}
+
+func (this *PriorityQueue) GetOffsetByTime(k *queue.QueueConfig, t time.Time) (queue.Offset, error) {
+	return queue.Offset{}, errors.Errorf("queue [%v] is a priority queue, seek each lane instead", k.Name)
+}
+
+func (this *PriorityQueue) AcquireConsumer(k *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.ConsumerAPI, error) {
+	lanes := this.getLanes(k.ID)
+	output := &Consumer{
+		qCfg:    k,
+		cCfg:    consumer,
+		weights: this.cfg.Weights,
+	}
+
+	for i, lane := range lanes {
+		laneConsumer := queue.GetOrInitConsumerConfig(lane.ID, consumer.Group, consumer.Name)
+		laneConsumer.FetchMinBytes = consumer.FetchMinBytes
+		laneConsumer.FetchMaxBytes = consumer.FetchMaxBytes
+		laneConsumer.FetchMaxMessages = consumer.FetchMaxMessages
+		laneConsumer.FetchMaxWaitMs = consumer.FetchMaxWaitMs
+		laneConsumer.ClientExpiredInSeconds = consumer.ClientExpiredInSeconds
+		laneConsumer.ConsumeTimeoutInSeconds = consumer.ConsumeTimeoutInSeconds
+		//the priority consumer waits on behalf of all lanes
+		laneConsumer.EOFRetryDelayInMs = 0
+		laneConsumer.EOFMaxRetryTimes = 1
+
+		handler := this.getLaneHandler(lane)
+		instance, err := handler.AcquireConsumer(lane, laneConsumer)
+		if err != nil {
+			output.Close()
+			return nil, errors.Errorf("failed to acquire consumer of lane [%v], %v", i, err)
+		}
+		output.lanes = append(output.lanes, &laneInstance{
+			cfg:      lane,
+			cCfg:     laneConsumer,
+			handler:  handler,
+			consumer: instance,
+		})
+	}
+
+	this.consumers.Store(k.ID+consumer.Key(), output)
+
+	if global.Env().IsDebug {
+		log.Debugf("acquired priority consumer: %v, %v, lanes: %v", k.Name, consumer.Key(), len(lanes))
+	}
+	return output, nil
+}
+
+func (this *PriorityQueue) ReleaseConsumer(k *queue.QueueConfig, consumer *queue.ConsumerConfig, instance queue.ConsumerAPI) error {
+	key := k.ID + consumer.Key()
+	if v, ok := this.consumers.Load(key); ok && v == instance {
+		this.consumers.Delete(key)
+	}
+	if instance != nil {
+		return instance.Close()
+	}
+	return nil
+}
+
+func (this *PriorityQueue) AcquireProducer(cfg *queue.QueueConfig) (queue.ProducerAPI, error) {
+	if cfg == nil || cfg.ID == "" {
+		panic("queue config is nil")
+	}
+	return &Producer{q: this, cfg: cfg, lanes: this.getLanes(cfg.ID)}, nil
+}
+
+func (this *PriorityQueue) ReleaseProducer(k *queue.QueueConfig, producer queue.ProducerAPI) error {
+	return nil
+}
@@ -0,0 +1,214 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package priority_queue
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/queue/queuetest"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/plugins/queue/consumer"
+)
+
+func TestAllocateQuota(t *testing.T) {
+	assert.Equal(t, []int{60, 30, 10}, allocateQuota([]int{6, 3, 1}, 100))
+	assert.Equal(t, []int{6, 3, 1}, allocateQuota([]int{6, 3, 1}, 10))
+	//remainder goes to the highest lane
+	assert.Equal(t, []int{3, 1, 1}, allocateQuota([]int{6, 3, 1}, 5))
+	//not enough room for every lane
+	assert.Equal(t, []int{1, 1, 0}, allocateQuota([]int{1, 1, 1}, 2))
+	assert.Equal(t, []int{0, 0, 0}, allocateQuota([]int{1, 1, 1}, 0))
+}
+
+type mockLaneConsumer struct {
+	messages  []queue.Message
+	committed []queue.Offset
+}
+
+func (c *mockLaneConsumer) Close() error { return nil }
+
+func (c *mockLaneConsumer) ResetOffset(segment, readPos int64) error { return nil }
+
+func (c *mockLaneConsumer) FetchMessages(ctx *queue.Context, numOfMessages int) ([]queue.Message, bool, error) {
+	if numOfMessages > len(c.messages) {
+		numOfMessages = len(c.messages)
+	}
+	messages := c.messages[:numOfMessages]
+	c.messages = c.messages[numOfMessages:]
+	return messages, false, nil
+}
+
+func (c *mockLaneConsumer) CommitOffset(offset queue.Offset) error {
+	c.committed = append(c.committed, offset)
+	return nil
+}
+
+func newMockMessages(n int) []queue.Message {
+	messages := []queue.Message{}
+	for i := 0; i < n; i++ {
+		messages = append(messages, queue.Message{
+			Offset:     queue.Offset{Position: int64(i * 10)},
+			NextOffset: queue.Offset{Position: int64((i + 1) * 10)},
+		})
+	}
+	return messages
+}
+
+func TestConsumerCommitPerLane(t *testing.T) {
+	high := &mockLaneConsumer{messages: newMockMessages(5)}
+	low := &mockLaneConsumer{messages: newMockMessages(5)}
+	c := &Consumer{
+		qCfg:    &queue.QueueConfig{Name: "test"},
+		cCfg:    &queue.ConsumerConfig{},
+		weights: []int{3, 1},
+		lanes:   []*laneInstance{{consumer: high}, {consumer: low}},
+	}
+
+	ctx := &queue.Context{}
+	messages, _, err := c.FetchMessages(ctx, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(messages))
+	//the same positions in different lanes are told apart by the segment
+	assert.Equal(t, queue.NewOffset(0, 10), messages[0].NextOffset)
+	assert.Equal(t, queue.NewOffset(1, 10), messages[3].NextOffset)
+	assert.False(t, messages[0].NextOffset.Equals(messages[3].NextOffset))
+	assert.Equal(t, messages[3].NextOffset, ctx.NextOffset)
+
+	//commit in the middle of the batch only touches the high lane
+	assert.NoError(t, c.CommitOffset(messages[1].NextOffset))
+	assert.Equal(t, []queue.Offset{{Position: 20}}, high.committed)
+	assert.Equal(t, 0, len(low.committed))
+
+	assert.NoError(t, c.CommitOffset(ctx.NextOffset))
+	assert.Equal(t, queue.Offset{Position: 30}, high.committed[1])
+	assert.Equal(t, []queue.Offset{{Position: 10}}, low.committed)
+
+	//unknown offsets are ignored
+	assert.NoError(t, c.CommitOffset(queue.Offset{Position: 1000}))
+	assert.Equal(t, 0, len(c.pending))
+
+	//spare room goes to the lane with messages left
+	messages, _, err = c.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(messages))
+}
+
+func TestEncodeOffset(t *testing.T) {
+	o := encodeOffset(2, 3, queue.NewOffset(4, 100))
+	assert.Equal(t, queue.NewOffset(14, 100), o)
+	lane, laneOffset := decodeOffset(3, o)
+	assert.Equal(t, 2, lane)
+	assert.Equal(t, queue.NewOffset(4, 100), laneOffset)
+}
+
+type collectProcessor struct {
+	lock     *sync.Mutex
+	messages *[]queue.Message
+}
+
+func (p *collectProcessor) Name() string {
+	return "priority_collector"
+}
+
+func (p *collectProcessor) Process(ctx *pipeline.Context) error {
+	v, err := ctx.GetValue("messages")
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	*p.messages = append(*p.messages, v.([]queue.Message)...)
+	return nil
+}
+
+func TestConsumerProcessorOnPriorityQueue(t *testing.T) {
+	queuetest.Setup()
+
+	pq := &PriorityQueue{cfg: &Config{LaneType: "memory", NumOfLanes: 2, Weights: []int{3, 1}}}
+	queue.Register("priority", pq)
+
+	qCfg := &queue.QueueConfig{Name: "priority-consumer-test", Type: "priority"}
+	_, err := queue.RegisterConfig(qCfg)
+	assert.NoError(t, err)
+
+	producer, err := pq.AcquireProducer(qCfg)
+	assert.NoError(t, err)
+	reqs := []queue.ProduceRequest{}
+	for i := 0; i < 10; i++ {
+		req := queue.ProduceRequest{Topic: qCfg.ID, Data: []byte(util.ToString(i))}
+		if i%2 == 0 {
+			req.Headers = map[string]string{PriorityHeader: "0"}
+		}
+		reqs = append(reqs, req)
+	}
+	_, err = producer.Produce(&reqs)
+	assert.NoError(t, err)
+
+	lock := &sync.Mutex{}
+	collected := []queue.Message{}
+	pipeline.RegisterProcessorPlugin("priority_collector", func(c *config.Config) (pipeline.Processor, error) {
+		return &collectProcessor{lock: lock, messages: &collected}, nil
+	})
+
+	cfg, err := config.NewConfigFrom(util.MapStr{
+		"detect_active_queue": false,
+		"queue_selector":      util.MapStr{"ids": []string{qCfg.ID}},
+		"consumer": util.MapStr{
+			"group":              "group-001",
+			"name":               "consumer-001",
+			"fetch_max_messages": 4,
+			"fetch_max_wait_ms":  100,
+		},
+		"processor": []util.MapStr{{"priority_collector": util.MapStr{}}},
+	})
+	assert.NoError(t, err)
+	processor, err := consumer.New(cfg)
+	assert.NoError(t, err)
+	defer processor.(pipeline.Releaser).Release()
+
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{})
+	assert.NoError(t, processor.Process(ctx))
+
+	//every message is delivered once, and every lane is committed to the end
+	assert.Equal(t, 10, len(collected))
+	cCfg, ok := queue.GetConsumerConfig(qCfg.ID, "group-001", "consumer-001")
+	assert.True(t, ok)
+	assert.False(t, queue.ConsumerHasLag(qCfg, cCfg))
+	for _, lane := range pq.getLanes(qCfg.ID) {
+		laneConsumer, ok := queue.GetConsumerConfig(lane.ID, "group-001", "consumer-001")
+		assert.True(t, ok)
+		offset, err := queue.GetOffset(lane, laneConsumer)
+		assert.NoError(t, err)
+		assert.Equal(t, queue.LatestOffset(lane), offset)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package priority_queue
+
+import (
+	"infini.sh/framework/core/queue"
+)
+
+type Producer struct {
+	q     *PriorityQueue
+	cfg   *queue.QueueConfig
+	lanes []*queue.QueueConfig
+}
+
+// Produce route each message to the lane by its priority header,
+// the partition of the response is the lane of the message, and the offset is in the form of the priority offsets
+func (p *Producer) Produce(reqs *[]queue.ProduceRequest) (*[]queue.ProduceResponse, error) {
+	results := make([]queue.ProduceResponse, len(*reqs))
+
+	//group by lane, keep the order within each lane
+	laneReqs := make([][]queue.ProduceRequest, len(p.lanes))
+	laneIndexes := make([][]int, len(p.lanes))
+	for i, req := range *reqs {
+		lane := p.q.getLaneByHeaders(req.Headers)
+		req.Topic = p.lanes[lane].ID
+		laneReqs[lane] = append(laneReqs[lane], req)
+		laneIndexes[lane] = append(laneIndexes[lane], i)
+	}
+
+	for lane, v := range laneReqs {
+		if len(v) == 0 {
+			continue
+		}
+		producer, err := queue.AcquireProducer(p.lanes[lane])
+		if err != nil {
+			return &results, err
+		}
+		res, err := producer.Produce(&v)
+		producer.Close()
+		if res != nil {
+			for i, r := range *res {
+				r.Topic = p.cfg.ID
+				r.Partition = int64(lane)
+				if !r.Scheduled {
+					r.Offset = encodeOffset(lane, len(p.lanes), r.Offset)
+				}
+				results[laneIndexes[lane][i]] = r
+			}
+		}
+		if err != nil {
+			return &results, err
+		}
+	}
+	return &results, nil
+}
+
+func (p *Producer) Close() error {
+	return nil
+}
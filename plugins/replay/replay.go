@@ -62,6 +62,7 @@ var signalChannel = make(chan bool, 1)
 
 func init() {
 	pipeline.RegisterProcessorPlugin("replay", New)
+	pipeline.RegisterSideEffectProcessor("replay")
 }
 
 func New(c *config.Config) (pipeline.Processor, error) {
@@ -154,11 +155,28 @@ func (processor *ReplayProcessor) Process(ctx *pipeline.Context) error {
 		defer processor.HTTPPool.ReleaseRequest(req)
 		defer processor.HTTPPool.ReleaseResponse(res)
 
-		count, err, done = ReplayLines(req, res, ctx, lines, processor.config.Schema, processor.config.Host, processor.config.Username, processor.config.Password)
+		//resume from the last executed request
+		checkpoint := replayCheckpoint{}
+		ok, err := ctx.UnpackCheckpoint(checkpointKey, &checkpoint)
+		if err != nil {
+			log.Warnf("invalid replay checkpoint, %v", err)
+		}
+		if ok && checkpoint.Filename == filename && checkpoint.Line > 0 && checkpoint.Line < len(lines) {
+			log.Infof("resume replay [%v] from line [%v]", filename, checkpoint.Line)
+		} else {
+			checkpoint = replayCheckpoint{Filename: filename}
+		}
+
+		count, err, done = replayLinesFrom(req, res, ctx, lines, checkpoint, processor.config.Schema, processor.config.Host, processor.config.Username, processor.config.Password)
 		if done {
 			return err
 		}
 
+		err = ctx.ClearCheckpoint(checkpointKey)
+		if err != nil {
+			log.Error(err)
+		}
+
 		progress.Stop()
 	}
 
@@ -169,14 +187,30 @@ func (processor *ReplayProcessor) Process(ctx *pipeline.Context) error {
 	return nil
 }
 
+const checkpointKey = "replay"
+
+type replayCheckpoint struct {
+	Filename string `json:"filename"`
+	//the line of the next request to replay
+	Line int `json:"line"`
+}
+
 func ReplayLines(req *fasthttp.Request, res *fasthttp.Response, ctx *pipeline.Context, lines []string, schema, host, username, password string) (int, error, bool) {
+	return replayLinesFrom(req, res, ctx, lines, replayCheckpoint{}, schema, host, username, password)
+}
+
+func replayLinesFrom(req *fasthttp.Request, res *fasthttp.Response, ctx *pipeline.Context, lines []string, checkpoint replayCheckpoint, schema, host, username, password string) (int, error, bool) {
 
 	var buffer = bytebufferpool.Get("replay")
 	defer bytebufferpool.Put("replay", buffer)
 
 	var requestIsSet bool
+	var lastSaved time2.Time
 	count := 0
-	for _, line := range lines {
+	for i, line := range lines {
+		if i < checkpoint.Line {
+			continue
+		}
 		count++
 		if ctx.IsCanceled() {
 			return 0, nil, true
@@ -208,6 +242,15 @@ func ReplayLines(req *fasthttp.Request, res *fasthttp.Response, ctx *pipeline.Co
 					}
 					buffer.Reset()
 					requestIsSet = false
+
+					if checkpoint.Filename != "" && time2.Since(lastSaved) > time2.Second {
+						checkpoint.Line = i
+						err = ctx.SaveCheckpoint(checkpointKey, checkpoint)
+						if err != nil {
+							log.Error("failed to save replay checkpoint: ", err)
+						}
+						lastSaved = time2.Now()
+					}
 				}
 
 				//prepare new request
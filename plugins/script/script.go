@@ -0,0 +1,318 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/stdlib"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// ScriptProcessor runs a sandboxed tengo script, eg:
+//
+//	script:
+//	  source: |
+//	    text := import("text")
+//	    count := ctx.get("count") || 0
+//	    ctx.set("count", count + 1)
+//	    if count > 100 {
+//	      queue.push("alerts", text.format_int(count, 10))
+//	    }
+//	  imports: [text]
+//	  max_execution_time: 1s
+//	  max_allocs: 100000
+type ScriptProcessor struct {
+	config   *Config
+	compiled *tengo.Compiled
+}
+
+type Config struct {
+	Source string `config:"source"`
+	File   string `config:"file"`
+	//stdlib modules the script may import, os is never allowed
+	Imports []string `config:"imports"`
+	//abort the script if it runs longer than this
+	MaxExecutionTime time.Duration `config:"max_execution_time"`
+	//max num of objects the script can allocate during a single run
+	MaxAllocs int64 `config:"max_allocs"`
+}
+
+// max size of a single string or bytes value in scripts, max_allocs only limits the num of objects,
+// tengo checks these limits on every string or bytes it creates, they are global to the process
+const (
+	maxStringLen = 10 * 1024 * 1024
+	maxBytesLen  = 10 * 1024 * 1024
+)
+
+// modules without access to the file system, network or processes
+var safeModules = map[string]bool{
+	"math":   true,
+	"text":   true,
+	"times":  true, //without sleep, which is not aborted on timeout
+	"rand":   true,
+	"fmt":    true,
+	"json":   true,
+	"base64": true,
+	"hex":    true,
+	"enum":   true,
+}
+
+func init() {
+	tengo.MaxStringLen = maxStringLen
+	tengo.MaxBytesLen = maxBytesLen
+	pipeline.RegisterProcessorPlugin("script", New)
+}
+
+func getModuleMap(names []string) *tengo.ModuleMap {
+	modules := stdlib.GetModuleMap(names...)
+	for _, name := range names {
+		if name != "times" {
+			continue
+		}
+		attrs := map[string]tengo.Object{}
+		for k, v := range stdlib.BuiltinModules[name] {
+			if k != "sleep" {
+				attrs[k] = v
+			}
+		}
+		modules.AddBuiltinModule(name, attrs)
+	}
+	return modules
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := Config{
+		MaxExecutionTime: 5 * time.Second,
+		MaxAllocs:        100000,
+	}
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unpack the configuration of script processor: %s", err)
+	}
+
+	if cfg.File != "" {
+		if cfg.Source != "" {
+			return nil, errors.New("source and file can't be set at the same time")
+		}
+		b, err := os.ReadFile(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read script file: %v, %v", cfg.File, err)
+		}
+		cfg.Source = string(b)
+	}
+	if strings.TrimSpace(cfg.Source) == "" {
+		return nil, errors.New("script source can't be empty")
+	}
+
+	compiled, err := getCompiled(&cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScriptProcessor{config: &cfg, compiled: compiled}, nil
+}
+
+func (processor *ScriptProcessor) Name() string {
+	return "script"
+}
+
+// compiled scripts shared by the processors with the same source and limits
+var compiledScripts = sync.Map{}
+
+func getCompiled(cfg *Config) (*tengo.Compiled, error) {
+	key := util.MD5digest(fmt.Sprintf("%v|%v|%v", cfg.Source, strings.Join(cfg.Imports, ","), cfg.MaxAllocs))
+	if v, ok := compiledScripts.Load(key); ok {
+		return v.(*tengo.Compiled), nil
+	}
+
+	for _, v := range cfg.Imports {
+		if !safeModules[v] {
+			return nil, errors.Errorf("module [%v] is not allowed to import in script", v)
+		}
+	}
+
+	s := tengo.NewScript([]byte(cfg.Source))
+	s.SetImports(getModuleMap(cfg.Imports))
+	s.SetMaxAllocs(cfg.MaxAllocs)
+	//placeholders, bound to the pipeline context on each run
+	_ = s.Add("ctx", tengo.UndefinedValue)
+	_ = s.Add("queue", tengo.UndefinedValue)
+
+	compiled, err := s.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile script, %v", err)
+	}
+	v, _ := compiledScripts.LoadOrStore(key, compiled)
+	return v.(*tengo.Compiled), nil
+}
+
+func (processor *ScriptProcessor) Process(ctx *pipeline.Context) error {
+	compiled := processor.compiled.Clone()
+	if err := compiled.Set("ctx", newContextObject(ctx)); err != nil {
+		return err
+	}
+	if err := compiled.Set("queue", newQueueObject(ctx)); err != nil {
+		return err
+	}
+
+	var parent context.Context = context.Background()
+	if ctx.Context != nil {
+		parent = ctx.Context
+	}
+	runCtx, cancel := context.WithTimeout(parent, processor.config.MaxExecutionTime)
+	defer cancel()
+
+	start := time.Now()
+	err := compiled.RunContext(runCtx)
+	stats.Timing("script", "run", time.Since(start).Milliseconds())
+	if err != nil {
+		stats.Increment("script", "error")
+		if err == context.DeadlineExceeded {
+			return errors.Errorf("script exceeded the max execution time of %v", processor.config.MaxExecutionTime)
+		}
+		return errors.Errorf("failed to run script, %v", err)
+	}
+	return nil
+}
+
+func newContextObject(ctx *pipeline.Context) tengo.Object {
+	return &tengo.ImmutableMap{Value: map[string]tengo.Object{
+		"get": &tengo.UserFunction{Name: "get", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			key, err := stringArg(args, 0, "key", 1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := ctx.GetValue(key)
+			if err != nil || v == nil {
+				return tengo.UndefinedValue, nil
+			}
+			return toObject(v)
+		}},
+		"has": &tengo.UserFunction{Name: "has", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			key, err := stringArg(args, 0, "key", 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := ctx.GetValue(key); err != nil {
+				return tengo.FalseValue, nil
+			}
+			return tengo.TrueValue, nil
+		}},
+		"set": &tengo.UserFunction{Name: "set", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			key, err := stringArg(args, 0, "key", 2)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := ctx.PutValue(key, tengo.ToInterface(args[1])); err != nil {
+				return &tengo.Error{Value: &tengo.String{Value: err.Error()}}, nil
+			}
+			return tengo.TrueValue, nil
+		}},
+		"delete": &tengo.UserFunction{Name: "delete", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			key, err := stringArg(args, 0, "key", 1)
+			if err != nil {
+				return nil, err
+			}
+			if err := ctx.Delete(key); err != nil {
+				return tengo.FalseValue, nil
+			}
+			return tengo.TrueValue, nil
+		}},
+	}}
+}
+
+func newQueueObject(ctx *pipeline.Context) tengo.Object {
+	return &tengo.ImmutableMap{Value: map[string]tengo.Object{
+		"push": &tengo.UserFunction{Name: "push", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			name, err := stringArg(args, 0, "queue", 2)
+			if err != nil {
+				return nil, err
+			}
+			var data []byte
+			switch v := args[1].(type) {
+			case *tengo.Bytes:
+				data = v.Value
+			case *tengo.String:
+				data = []byte(v.Value)
+			default:
+				data = util.MustToJSONBytes(tengo.ToInterface(v))
+			}
+
+			if ctx.IsSimulation() {
+				ctx.AddSideEffect(pipeline.SideEffect{
+					Processor: "script",
+					Config:    map[string]interface{}{"queue": name, "message": string(data)},
+				})
+				return tengo.TrueValue, nil
+			}
+
+			if err := queue.Push(queue.GetOrInitConfig(name), data); err != nil {
+				log.Errorf("failed to push message to queue [%v] from script, %v", name, err)
+				return &tengo.Error{Value: &tengo.String{Value: err.Error()}}, nil
+			}
+			return tengo.TrueValue, nil
+		}},
+	}}
+}
+
+func stringArg(args []tengo.Object, idx int, name string, expected int) (string, error) {
+	if len(args) != expected {
+		return "", tengo.ErrWrongNumArguments
+	}
+	v, ok := tengo.ToString(args[idx])
+	if !ok {
+		return "", tengo.ErrInvalidArgumentType{Name: name, Expected: "string", Found: args[idx].TypeName()}
+	}
+	return v, nil
+}
+
+// toObject convert the context value to script object, values of custom types are converted through json
+func toObject(v interface{}) (tengo.Object, error) {
+	if o, err := tengo.FromInterface(v); err == nil {
+		return o, nil
+	}
+	var x interface{}
+	if err := json.Unmarshal(util.MustToJSONBytes(v), &x); err != nil {
+		return nil, err
+	}
+	return tengo.FromInterface(x)
+}
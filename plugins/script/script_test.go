@@ -0,0 +1,128 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package script
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/util"
+)
+
+func newProcessor(t *testing.T, cfg map[string]interface{}) (*ScriptProcessor, error) {
+	c, err := config.NewConfigFrom(cfg)
+	assert.NoError(t, err)
+	p, err := New(c)
+	if err != nil {
+		return nil, err
+	}
+	return p.(*ScriptProcessor), nil
+}
+
+func TestScriptProcessor(t *testing.T) {
+	source := `
+text := import("text")
+count := ctx.get("count") || 0
+ctx.set("count", count + 1)
+ctx.set("doc.name", text.to_upper(ctx.get("doc.name")))
+ctx.delete("tmp")
+`
+	p, err := newProcessor(t, map[string]interface{}{"source": source, "imports": []string{"text"}})
+	assert.NoError(t, err)
+
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "test"})
+	ctx.Set("doc", util.MapStr{"name": "infini"})
+	ctx.Set("tmp", true)
+	assert.NoError(t, p.Process(ctx))
+	assert.NoError(t, p.Process(ctx))
+
+	v, _ := ctx.GetValue("count")
+	assert.Equal(t, int64(2), v)
+	v, _ = ctx.GetValue("doc.name")
+	assert.Equal(t, "INFINI", v)
+	assert.False(t, ctx.Has("tmp"))
+
+	//compiled script is cached
+	p2, err := newProcessor(t, map[string]interface{}{"source": source, "imports": []string{"text"}})
+	assert.NoError(t, err)
+	assert.True(t, p.compiled == p2.compiled)
+}
+
+func TestScriptLimits(t *testing.T) {
+	_, err := newProcessor(t, map[string]interface{}{"source": `os := import("os")`, "imports": []string{"os"}})
+	assert.Error(t, err)
+
+	_, err = newProcessor(t, map[string]interface{}{"source": `os := import("os")`})
+	assert.Error(t, err)
+
+	p, err := newProcessor(t, map[string]interface{}{"source": `for {}`, "max_execution_time": "100ms"})
+	assert.NoError(t, err)
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "test"})
+	assert.Error(t, p.Process(ctx))
+
+	p, err = newProcessor(t, map[string]interface{}{"source": `a := []; for i := 0; i < 1000; i++ { a = append(a, [i]) }`, "max_allocs": 100})
+	assert.NoError(t, err)
+	assert.Error(t, p.Process(ctx))
+
+	//a single large string is rejected, it is only one allocation
+	p, err = newProcessor(t, map[string]interface{}{"source": `text := import("text"); s := text.repeat("x", 1<<30)`, "imports": []string{"text"}})
+	assert.NoError(t, err)
+	err = p.Process(ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeding string size limit")
+
+	p, err = newProcessor(t, map[string]interface{}{"source": `b := bytes(1<<30)`})
+	assert.NoError(t, err)
+	assert.Error(t, p.Process(ctx))
+
+	//sleep is not available, it blocks the script past the max execution time
+	p, err = newProcessor(t, map[string]interface{}{"source": `times := import("times"); times.sleep(10 * times.second)`, "imports": []string{"times"}, "max_execution_time": "100ms"})
+	assert.NoError(t, err)
+	start := time.Now()
+	assert.Error(t, p.Process(ctx))
+	assert.Less(t, time.Since(start), time.Second)
+	p, err = newProcessor(t, map[string]interface{}{"source": `times := import("times"); n := times.now()`, "imports": []string{"times"}})
+	assert.NoError(t, err)
+	assert.NoError(t, p.Process(ctx))
+}
+
+func TestScriptSimulation(t *testing.T) {
+	result, err := pipeline.SimulateYAML(`
+- script:
+    source: |
+      queue.push("alerts", {level: ctx.get("level")})
+`, util.MapStr{"level": "warn"})
+	assert.NoError(t, err)
+	assert.True(t, result.Success)
+	assert.Equal(t, 1, len(result.SideEffects))
+	assert.Equal(t, "alerts", result.SideEffects[0].Config["queue"])
+	assert.Equal(t, `{"level":"warn"}`, result.SideEffects[0].Config["message"])
+}
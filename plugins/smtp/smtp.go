@@ -106,6 +106,7 @@ type Attachment struct {
 
 func init() {
 	pipeline.RegisterProcessorPlugin("smtp", New)
+	pipeline.RegisterSideEffectProcessor("smtp")
 }
 
 func New(c *config.Config) (pipeline.Processor, error) {